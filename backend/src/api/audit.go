@@ -0,0 +1,193 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"dmarc-report-analyzer/backend/src/db"
+	"dmarc-report-analyzer/backend/src/util"
+)
+
+// recordAudit appends an audit log entry for an action performed through the
+// API by the authenticated user. details is stored as JSON. Failures are
+// logged but never fail the request.
+func recordAudit(dbRepo *db.Repository, r *http.Request, action, target string, details interface{}) {
+	actor := "anonymous"
+	if user := UserFromContext(r.Context()); user != nil {
+		actor = user.Username
+	}
+	recordAuditAs(dbRepo, r, actor, action, target, details)
+}
+
+// recordAuditAs is recordAudit for requests that are not authenticated as
+// the actor, such as logins.
+func recordAuditAs(dbRepo *db.Repository, r *http.Request, actor, action, target string, details interface{}) {
+	entry := &db.AuditEntry{
+		Actor:     actor,
+		Action:    action,
+		Target:    target,
+		SourceIP:  clientIP(r),
+		RequestID: RequestIDFromContext(r.Context()),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		UserAgent: r.UserAgent(),
+	}
+	if details != nil {
+		encoded, err := json.Marshal(details)
+		if err != nil {
+			log.Printf("Failed to encode audit details for %s: %v", action, err)
+		} else {
+			entry.Details = string(encoded)
+		}
+	}
+
+	if err := dbRepo.AddAuditEntry(entry); err != nil {
+		log.Printf("Failed to record audit entry for %s on %s: %v", action, target, err)
+	}
+}
+
+// clientIP returns the IP address of the client that sent r.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// AuditAPI handles the API endpoints for reading the audit log.
+type AuditAPI struct {
+	DBRepo *db.Repository
+}
+
+// NewAuditAPI creates a new AuditAPI instance.
+func NewAuditAPI(dbRepo *db.Repository) *AuditAPI {
+	return &AuditAPI{DBRepo: dbRepo}
+}
+
+// RegisterAuditRoutes registers the audit log API routes.
+func RegisterAuditRoutes(router *mux.Router, api *AuditAPI) {
+	router.HandleFunc("/api/audit", requireRole(db.RoleAdmin, handle(api.GetAuditLog))).Methods("GET")
+}
+
+// auditEntryResponse is the representation of an audit log entry in API
+// responses.
+type auditEntryResponse struct {
+	ID        int64           `json:"id"`
+	Timestamp int64           `json:"timestamp"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	Target    string          `json:"target"`
+	Details   json.RawMessage `json:"details"`
+	SourceIP  string          `json:"source_ip"`
+	RequestID string          `json:"request_id"`
+	Method    string          `json:"method"`
+	Path      string          `json:"path"`
+	UserAgent string          `json:"user_agent"`
+}
+
+// parseAuditFilter reads the audit log filter query parameters: start_date,
+// end_date (YYYY-MM-DD), actor, action and target.
+func parseAuditFilter(r *http.Request) (db.AuditFilter, error) {
+	query := r.URL.Query()
+
+	begin, end, err := util.ParseDateRange(query.Get("start_date"), query.Get("end_date"))
+	if err != nil {
+		return db.AuditFilter{}, err
+	}
+	return db.AuditFilter{
+		Begin:  begin,
+		End:    end,
+		Actor:  strings.TrimSpace(query.Get("actor")),
+		Action: strings.TrimSpace(query.Get("action")),
+		Target: strings.TrimSpace(query.Get("target")),
+	}, nil
+}
+
+// GetAuditLog returns a page of the audit log, newest entries first. With
+// format=csv, jsonl or xlsx, every matching entry is exported instead.
+func (api *AuditAPI) GetAuditLog(w http.ResponseWriter, r *http.Request) error {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		return util.BadRequest(err.Error())
+	}
+	format, err := exportFormat(r)
+	if err != nil {
+		return util.BadRequest(err.Error())
+	}
+	if format != "" {
+		return api.exportAuditLog(w, r, filter, format)
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50 // Default limit
+	}
+	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0 // Default offset
+	}
+	page, err := parsePageParams(r, offset)
+	if err != nil {
+		return util.BadRequest(err.Error())
+	}
+
+	// Fetch one extra entry to find out whether there is a next page.
+	entries, err := api.DBRepo.GetAuditEntries(db.AuditQuery{
+		Filter: filter,
+		Limit:  limit + 1,
+		Offset: offset,
+		After:  page.after,
+	})
+	if errors.Is(err, db.ErrInvalidCursor) {
+		return util.BadRequest(err.Error())
+	}
+	if err != nil {
+		return util.Internal("Failed to retrieve audit log", err)
+	}
+
+	response := map[string]interface{}{}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		response["nextCursor"] = db.AuditCursor(&entries[limit-1]).Encode()
+	}
+	items := make([]auditEntryResponse, 0, len(entries))
+	for _, e := range entries {
+		item := auditEntryResponse{
+			ID:        e.ID,
+			Timestamp: e.Timestamp,
+			Actor:     e.Actor,
+			Action:    e.Action,
+			Target:    e.Target,
+			Details:   json.RawMessage("null"),
+			SourceIP:  e.SourceIP,
+			RequestID: e.RequestID,
+			Method:    e.Method,
+			Path:      e.Path,
+			UserAgent: e.UserAgent,
+		}
+		if json.Valid([]byte(e.Details)) {
+			item.Details = json.RawMessage(e.Details)
+		}
+		items = append(items, item)
+	}
+	response["entries"] = items
+	if page.includeTotal {
+		totalCount, err := api.DBRepo.CountAuditEntries(filter)
+		if err != nil {
+			return util.Internal("Failed to count audit entries", err)
+		}
+		response["totalCount"] = totalCount
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	return nil
+}
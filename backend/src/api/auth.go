@@ -2,13 +2,17 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 
 	"dmarc-report-analyzer/backend/src/auth"
 	"dmarc-report-analyzer/backend/src/db"
+	"dmarc-report-analyzer/backend/src/util"
 )
 
 // AuthAPI handles authentication related API endpoints.
@@ -27,11 +31,39 @@ func NewAuthAPI(authService *auth.AuthService, dbRepo *db.Repository) *AuthAPI {
 
 // RegisterAuthRoutes registers the authentication API routes.
 func RegisterAuthRoutes(router *mux.Router, api *AuthAPI) {
-	router.HandleFunc("/api/auth/login", api.Login).Methods("POST")
+	router.HandleFunc("/api/auth/login", handle(api.Login)).Methods("POST")
+	router.HandleFunc("/api/auth/login/totp", handle(api.LoginTOTP)).Methods("POST")
+	router.HandleFunc("/api/auth/refresh", handle(api.Refresh)).Methods("POST")
+	router.HandleFunc("/api/auth/logout", handle(api.Logout)).Methods("POST")
+	router.HandleFunc("/api/auth/logout-all", handle(api.LogoutAll)).Methods("POST")
+	router.HandleFunc("/api/auth/sessions", handle(api.ListSessions)).Methods("GET")
+	router.HandleFunc("/api/auth/sessions/{id}", handle(api.RevokeSession)).Methods("DELETE")
 }
 
-// Login handles user login and JWT generation.
-func (api *AuthAPI) Login(w http.ResponseWriter, r *http.Request) {
+// writeSessionTokens writes the tokens of a started or refreshed session.
+func (api *AuthAPI) writeSessionTokens(w http.ResponseWriter, user *db.User, tokens *auth.SessionTokens) {
+	response := map[string]interface{}{
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    int(auth.AccessTokenLifetime / time.Second),
+		"user": map[string]interface{}{
+			"username":                user.Username,
+			"role":                    user.Role,
+			"must_change_password":    user.MustChangePassword,
+			"totp_enabled":            user.TOTPEnabled,
+			"totp_enrolment_required": api.AuthService.TOTPEnrolmentRequired(user),
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Login handles user login and starts a session on the client's device. For
+// users with TOTP enabled it only checks the password and returns an
+// mfa_token for LoginTOTP instead. Repeated failures are throttled per
+// account and per client IP address.
+func (api *AuthAPI) Login(w http.ResponseWriter, r *http.Request) error {
 	var creds struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
@@ -39,45 +71,277 @@ func (api *AuthAPI) Login(w http.ResponseWriter, r *http.Request) {
 
 	err := json.NewDecoder(r.Body).Decode(&creds)
 	if err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
-		return
+		return util.BadRequest("Invalid request payload")
+	}
+	if api.AuthService.PasswordLoginDisabled {
+		recordLoginFailure(api.DBRepo, r, creds.Username, "password_login_disabled")
+		return util.Forbidden("Password login is disabled; use single sign-on")
 	}
 
 	log.Printf("Login attempt for user: %s", creds.Username)
+	if err := checkThrottle(w, r, api.AuthService, creds.Username); err != nil {
+		recordLoginFailure(api.DBRepo, r, creds.Username, "throttled")
+		return err
+	}
+
+	user, err := api.AuthService.AuthenticatePassword(r.Context(), creds.Username, creds.Password)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			log.Printf("Invalid credentials for user: %s", creds.Username)
+			recordLoginFailure(api.DBRepo, r, creds.Username, "invalid_credentials")
+			recordThrottledFailure(api.DBRepo, r, api.AuthService, creds.Username)
+			return util.Unauthorized("Invalid credentials")
+		}
+		if errors.Is(err, auth.ErrLoginDenied) {
+			log.Printf("Login denied for user %s: %v", creds.Username, err)
+			recordLoginFailure(api.DBRepo, r, creds.Username, "denied")
+			return util.Forbidden("Your account is not allowed to use this application")
+		}
+		return util.Internal("Failed to verify credentials", err)
+	}
+
+	if user.Disabled {
+		recordLoginFailure(api.DBRepo, r, user.Username, "account_disabled")
+		return util.Forbidden("Account is disabled")
+	}
+
+	// With TOTP the session starts in LoginTOTP, after the second factor
+	if user.TOTPEnabled {
+		mfaToken, err := api.AuthService.GenerateMFAToken(user.Username)
+		if err != nil {
+			return util.Internal("Failed to generate token", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+		})
+		return nil
+	}
+
+	log.Printf("Password match for user: %s. Starting session.", creds.Username)
+	tokens, err := api.AuthService.StartSession(user, r.UserAgent(), clientIP(r))
+	if err != nil {
+		return util.Internal("Failed to generate token", err)
+	}
+	api.AuthService.Throttle.Success(creds.Username)
+	recordLogin(api.DBRepo, r, user, tokens, false)
+
+	api.writeSessionTokens(w, user, tokens)
+	return nil
+}
+
+// recordLogin records a successful login in the audit log.
+func recordLogin(dbRepo *db.Repository, r *http.Request, user *db.User, tokens *auth.SessionTokens, secondFactor bool) {
+	recordAuditAs(dbRepo, r, user.Username, "auth.login", "user:"+user.Username, map[string]interface{}{
+		"auth_source":   user.AuthSource,
+		"second_factor": secondFactor,
+		"session_id":    tokens.Session.ID,
+	})
+}
+
+// recordLoginFailure records a failed login of username in the audit log.
+// reason is a short code such as invalid_credentials.
+func recordLoginFailure(dbRepo *db.Repository, r *http.Request, username, reason string) {
+	recordAuditAs(dbRepo, r, username, "auth.login_failed", "user:"+username, map[string]string{"reason": reason})
+}
+
+// LoginTOTP completes the login of a user with TOTP enabled, with the
+// mfa_token from Login and a TOTP code or recovery code. Failures count
+// towards the same throttling as wrong passwords.
+func (api *AuthAPI) LoginTOTP(w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" || req.Code == "" {
+		return util.BadRequest("An mfa_token and a code are required")
+	}
+
+	username, err := api.AuthService.ValidateMFAToken(req.MFAToken)
+	if err != nil {
+		return util.Unauthorized("Invalid or expired login attempt; log in again")
+	}
+	user, err := api.DBRepo.GetUserByUsername(username)
+	if err != nil {
+		return util.Internal("Internal server error", err)
+	}
+	if user == nil || !user.TOTPEnabled {
+		return util.Unauthorized("Invalid or expired login attempt; log in again")
+	}
+	if user.Disabled {
+		recordLoginFailure(api.DBRepo, r, user.Username, "account_disabled")
+		return util.Forbidden("Account is disabled")
+	}
+	if err := checkThrottle(w, r, api.AuthService, user.Username); err != nil {
+		recordLoginFailure(api.DBRepo, r, user.Username, "throttled")
+		return err
+	}
+
+	ok, err := api.AuthService.VerifySecondFactor(user, req.Code)
+	if err != nil {
+		return util.Internal("Failed to verify code", err)
+	}
+	if !ok {
+		log.Printf("Invalid second factor for user: %s", user.Username)
+		recordLoginFailure(api.DBRepo, r, user.Username, "invalid_second_factor")
+		recordThrottledFailure(api.DBRepo, r, api.AuthService, user.Username)
+		return util.Unauthorized("Invalid code")
+	}
+
+	tokens, err := api.AuthService.StartSession(user, r.UserAgent(), clientIP(r))
+	if err != nil {
+		return util.Internal("Failed to generate token", err)
+	}
+	api.AuthService.Throttle.Success(user.Username)
+	recordLogin(api.DBRepo, r, user, tokens, true)
+	api.writeSessionTokens(w, user, tokens)
+	return nil
+}
 
-	user, err := api.DBRepo.GetUserByUsername(creds.Username)
+// refreshTokenRequest is the body of requests that present a refresh token.
+type refreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh
+// token. The presented refresh token stops working; presenting it again ends
+// the session.
+func (api *AuthAPI) Refresh(w http.ResponseWriter, r *http.Request) error {
+	var req refreshTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		return util.BadRequest("A refresh_token is required")
+	}
+
+	user, tokens, err := api.AuthService.RefreshSession(req.RefreshToken, r.UserAgent(), clientIP(r))
 	if err != nil {
-		log.Printf("Error getting user %s from DB: %v", creds.Username, err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+		if errors.Is(err, auth.ErrRefreshTokenReused) {
+			log.Printf("Revoked session from %s: %v", clientIP(r), err)
+		}
+		if errors.Is(err, auth.ErrInvalidToken) {
+			return util.Unauthorized("Invalid or expired refresh token")
+		}
+		return util.Internal("Failed to refresh session", err)
 	}
 
+	api.writeSessionTokens(w, user, tokens)
+	return nil
+}
+
+// Logout ends the session of a refresh token. It needs no access token, so
+// that clients can log out after it expired, and succeeds for sessions that
+// have already ended.
+func (api *AuthAPI) Logout(w http.ResponseWriter, r *http.Request) error {
+	var req refreshTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		return util.BadRequest("A refresh_token is required")
+	}
+
+	session, err := api.AuthService.EndSession(req.RefreshToken)
+	if err != nil {
+		return util.Internal("Failed to log out", err)
+	}
+	if session != nil {
+		recordAudit(api.DBRepo, r, "session.logout", "session:"+strconv.FormatInt(session.ID, 10), map[string]int64{
+			"user_id": session.UserID,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "Logged out.",
+	})
+	return nil
+}
+
+// LogoutAll ends every session of the authenticated user, including the
+// current one.
+func (api *AuthAPI) LogoutAll(w http.ResponseWriter, r *http.Request) error {
+	user := UserFromContext(r.Context())
 	if user == nil {
-		log.Printf("User %s not found in DB.", creds.Username)
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
-		return
+		return util.Unauthorized("Authentication required")
 	}
 
-	log.Printf("User %s found in DB. Stored hash: %s", creds.Username, user.PasswordHash)
+	n, err := api.DBRepo.DeleteUserSessions(user.ID, 0)
+	if err != nil {
+		return util.Internal("Failed to log out", err)
+	}
+	recordAudit(api.DBRepo, r, "session.logout_all", "user:"+user.Username, map[string]int64{"sessions": n})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "Logged out of all sessions.",
+	})
+	return nil
+}
+
+// sessionResponse is the representation of a session in API responses.
+type sessionResponse struct {
+	ID         int64  `json:"id"`
+	UserAgent  string `json:"user_agent"`
+	SourceIP   string `json:"source_ip"`
+	CreatedAt  int64  `json:"created_at"`
+	LastUsedAt int64  `json:"last_used_at"`
+	ExpiresAt  int64  `json:"expires_at"`
+	Current    bool   `json:"current"`
+}
 
-	if !api.AuthService.CheckPasswordHash(creds.Password, user.PasswordHash) {
-		log.Printf("Password mismatch for user: %s", creds.Username)
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
-		return
+// ListSessions returns the authenticated user's active sessions, marking the
+// one the request was made in.
+func (api *AuthAPI) ListSessions(w http.ResponseWriter, r *http.Request) error {
+	user := UserFromContext(r.Context())
+	if user == nil {
+		return util.Unauthorized("Authentication required")
 	}
 
-	log.Printf("Password match for user: %s. Generating JWT.", creds.Username)
-	tokenString, err := api.AuthService.GenerateJWT(user.Username)
+	sessions, err := api.DBRepo.ListSessions(user.ID, time.Now().Unix())
 	if err != nil {
-		log.Printf("Error generating JWT for user %s: %v", user.Username, err)
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
-		return
+		return util.Internal("Failed to list sessions", err)
+	}
+	current := SessionIDFromContext(r.Context())
+	response := make([]sessionResponse, 0, len(sessions))
+	for _, s := range sessions {
+		response = append(response, sessionResponse{
+			ID:         s.ID,
+			UserAgent:  s.UserAgent,
+			SourceIP:   s.SourceIP,
+			CreatedAt:  s.CreatedAt,
+			LastUsedAt: s.LastUsedAt,
+			ExpiresAt:  s.ExpiresAt,
+			Current:    s.ID == current,
+		})
 	}
 
-	response := map[string]string{
-		"token": tokenString,
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"sessions": response})
+	return nil
+}
+
+// RevokeSession ends one of the authenticated user's sessions.
+func (api *AuthAPI) RevokeSession(w http.ResponseWriter, r *http.Request) error {
+	user := UserFromContext(r.Context())
+	if user == nil {
+		return util.Unauthorized("Authentication required")
 	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return util.BadRequest("Invalid session ID")
+	}
+
+	if err := api.DBRepo.DeleteSession(user.ID, id); err != nil {
+		if errors.Is(err, db.ErrSessionNotFound) {
+			return util.NotFound("Session not found")
+		}
+		return util.Internal("Failed to revoke session", err)
+	}
+	recordAudit(api.DBRepo, r, "session.revoke", "session:"+strconv.FormatInt(id, 10), nil)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "Session revoked.",
+	})
+	return nil
 }
\ No newline at end of file
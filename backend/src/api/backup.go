@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"dmarc-report-analyzer/backend/src/db"
+	"dmarc-report-analyzer/backend/src/util"
+)
+
+// maxRestoreUploadSize limits the size of an uploaded snapshot.
+const maxRestoreUploadSize = 2 << 30 // 2 GB
+
+// BackupAPI handles database backup and restore API endpoints.
+type BackupAPI struct {
+	DBRepo    *db.Repository
+	BackupDir string // Directory for snapshots written on the server
+}
+
+// NewBackupAPI creates a new BackupAPI instance.
+func NewBackupAPI(dbRepo *db.Repository, backupDir string) *BackupAPI {
+	return &BackupAPI{
+		DBRepo:    dbRepo,
+		BackupDir: backupDir,
+	}
+}
+
+// RegisterBackupRoutes registers the backup and restore API routes.
+func RegisterBackupRoutes(router *mux.Router, api *BackupAPI) {
+	router.HandleFunc("/api/admin/backup", requireRole(db.RoleAdmin, handle(api.DownloadBackup))).Methods("GET")
+	router.HandleFunc("/api/admin/backup", requireRole(db.RoleAdmin, handle(api.CreateBackup))).Methods("POST")
+	router.HandleFunc("/api/admin/restore", requireRole(db.RoleAdmin, handle(api.RestoreBackup))).Methods("POST")
+}
+
+// DownloadBackup streams a consistent snapshot of the live database.
+// Pass compress=false to receive an uncompressed SQLite file.
+func (api *BackupAPI) DownloadBackup(w http.ResponseWriter, r *http.Request) error {
+	compress := r.URL.Query().Get("compress") != "false"
+
+	filename := backupFileName(time.Now(), compress)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	if err := api.DBRepo.WriteSnapshot(r.Context(), w, compress); err != nil {
+		// Headers may already be sent; all we can do is log and abort the stream.
+		log.Printf("Error streaming database backup: %v", err)
+		recordAudit(api.DBRepo, r, "backup.download", filename, map[string]bool{"complete": false})
+		return nil
+	}
+	recordAudit(api.DBRepo, r, "backup.download", filename, map[string]bool{"complete": true})
+	log.Printf("Database backup streamed as %s", filename)
+	return nil
+}
+
+// CreateBackup writes a compressed snapshot into the server's backup directory.
+func (api *BackupAPI) CreateBackup(w http.ResponseWriter, r *http.Request) error {
+	filename := backupFileName(time.Now(), true)
+	destPath := filepath.Join(api.BackupDir, filename)
+
+	if err := api.DBRepo.BackupToFile(r.Context(), destPath, true); err != nil {
+		return util.Internal("Failed to create backup", err)
+	}
+
+	info, err := os.Stat(destPath)
+	if err != nil {
+		return util.Internal("Failed to create backup", err)
+	}
+	log.Printf("Database backup written to %s", destPath)
+	recordAudit(api.DBRepo, r, "backup.create", filename, map[string]int64{"size": info.Size()})
+
+	response := map[string]interface{}{
+		"status":   "success",
+		"message":  "Backup created.",
+		"filename": filename,
+		"size":     info.Size(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	return nil
+}
+
+// RestoreBackup restores the database from an uploaded snapshot (field "file").
+// The snapshot is verified before it replaces the live data.
+func (api *BackupAPI) RestoreBackup(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRestoreUploadSize)
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return requestBodyError(fmt.Sprintf("Failed to read uploaded snapshot: %v", err), err)
+	}
+	defer file.Close()
+
+	log.Printf("Restoring database from uploaded snapshot %s", header.Filename)
+	if err := api.DBRepo.RestoreFromReader(r.Context(), file); err != nil {
+		if errors.Is(err, db.ErrInvalidSnapshot) {
+			log.Printf("Rejected snapshot %s: %v", header.Filename, err)
+			return util.BadRequest(fmt.Sprintf("Snapshot rejected: %v", err))
+		}
+		return util.Internal("Failed to restore snapshot", fmt.Errorf("failed to restore database from %s: %w", header.Filename, err))
+	}
+	log.Printf("Database restored from %s", header.Filename)
+	// Recorded after the entries carried over from the live audit log
+	recordAudit(api.DBRepo, r, "backup.restore", header.Filename, nil)
+
+	response := map[string]string{
+		"status":  "success",
+		"message": "Database restored.",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	return nil
+}
+
+// backupFileName returns the file name used for a snapshot taken at t.
+func backupFileName(t time.Time, compress bool) string {
+	name := fmt.Sprintf("dmarc_reports_%s.db", t.UTC().Format("20060102-150405"))
+	if compress {
+		name += ".gz"
+	}
+	return name
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"dmarc-report-analyzer/backend/src/core/archive"
+	"dmarc-report-analyzer/backend/src/db"
+	"dmarc-report-analyzer/backend/src/util"
+)
+
+// maxDataImportSize limits the size of an uploaded data archive.
+const maxDataImportSize = 1 << 30 // 1 GB
+
+// DataAPI handles portable data export and import API endpoints.
+type DataAPI struct {
+	Archiver *archive.Archiver
+}
+
+// NewDataAPI creates a new DataAPI instance.
+func NewDataAPI(archiver *archive.Archiver) *DataAPI {
+	return &DataAPI{Archiver: archiver}
+}
+
+// RegisterDataRoutes registers the data export and import API routes.
+func RegisterDataRoutes(router *mux.Router, api *DataAPI) {
+	router.HandleFunc("/api/data/export", handle(api.ExportData)).Methods("GET")
+	router.HandleFunc("/api/data/import", requireRole(db.RoleAnalyst, handle(api.ImportData))).Methods("POST")
+}
+
+// ExportData streams a ZIP data archive. The optional start_date and end_date
+// (YYYY-MM-DD) parameters restrict it to reports beginning in that range.
+func (api *DataAPI) ExportData(w http.ResponseWriter, r *http.Request) error {
+	begin, end, err := util.ParseDateRange(r.URL.Query().Get("start_date"), r.URL.Query().Get("end_date"))
+	if err != nil {
+		return util.BadRequest(err.Error())
+	}
+
+	filename := fmt.Sprintf("dmarc-analyzer-data_%s.zip", time.Now().Format(util.DateLayout))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	summary, err := api.Archiver.Export(w, begin, end)
+	if err != nil {
+		// Headers are already sent; all we can do is log and abort the stream.
+		log.Printf("Error exporting data archive: %v", err)
+		recordAudit(api.Archiver.DBRepo, r, "data.export", filename, map[string]interface{}{"query": r.URL.RawQuery, "complete": false})
+		return nil
+	}
+	recordAudit(api.Archiver.DBRepo, r, "data.export", filename, map[string]interface{}{"query": r.URL.RawQuery, "complete": true, "summary": summary})
+	log.Printf("Exported data archive %s: %d reports, %d records, %d IP entries", filename, summary.Reports, summary.Records, summary.IPInfo)
+	return nil
+}
+
+// ImportData merges an uploaded ZIP data archive (field "file") into the
+// database and returns a summary of what was imported and skipped.
+func (api *DataAPI) ImportData(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxDataImportSize)
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return requestBodyError(fmt.Sprintf("Failed to read uploaded archive: %v", err), err)
+	}
+	defer file.Close()
+
+	log.Printf("Importing data archive %s", header.Filename)
+	summary, err := api.Archiver.Import(file, header.Size)
+	if err != nil {
+		log.Printf("Error importing data archive %s: %v", header.Filename, err)
+		recordAudit(api.Archiver.DBRepo, r, "data.import", header.Filename, map[string]string{"error": err.Error()})
+		return util.BadRequest(fmt.Sprintf("Failed to import archive: %v", err))
+	}
+	recordAudit(api.Archiver.DBRepo, r, "data.import", header.Filename, summary)
+	log.Printf("Imported data archive %s: %d reports imported, %d skipped, %d failed",
+		header.Filename, summary.ReportsImported, summary.ReportsSkipped, summary.ReportsFailed)
+
+	response := map[string]interface{}{
+		"status":  "success",
+		"message": "Data import completed.",
+		"summary": summary,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	return nil
+}
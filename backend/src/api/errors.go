@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"dmarc-report-analyzer/backend/src/util"
+)
+
+// ErrorResponse is the body of every API error response.
+type ErrorResponse struct {
+	Status    string `json:"status"` // Always "error"
+	Message   string `json:"message"`
+	Code      string `json:"code"` // One of the util.ErrCode* constants
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// handlerFunc is an HTTP handler that reports failures by returning an error
+// instead of writing the response itself.
+type handlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+// handle adapts h to an http.HandlerFunc that renders returned errors with
+// writeError.
+func handle(h handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h(w, r); err != nil {
+			writeError(w, r, err)
+		}
+	}
+}
+
+// writeError renders err as an ErrorResponse. A *util.AppError anywhere in
+// the chain selects the status, message and code; any other error is an
+// internal error whose details are logged but not sent to the client.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var appErr *util.AppError
+	if !errors.As(err, &appErr) {
+		appErr = util.Internal("Internal server error", err)
+	}
+
+	requestID := RequestIDFromContext(r.Context())
+	if appErr.Code >= http.StatusInternalServerError && appErr.Err != nil {
+		log.Printf("Request %s %s %s failed: %v", requestID, r.Method, r.URL.Path, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(appErr.Code)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Status:    "error",
+		Message:   appErr.Message,
+		Code:      appErr.ErrorCode(),
+		RequestID: requestID,
+	})
+}
+
+// RegisterErrorHandlers makes router answer requests that match no route with
+// an ErrorResponse: 405 if a route matches the path but not the method, 404
+// otherwise. Routes that match every path, such as the frontend's, have to
+// exclude /api/ for these to apply to it, and do so before matching the path:
+// a route whose path matches clears the method mismatch of earlier routes.
+func RegisterErrorHandlers(router *mux.Router) {
+	router.NotFoundHandler = http.HandlerFunc(NotFound)
+	router.MethodNotAllowedHandler = http.HandlerFunc(MethodNotAllowed)
+}
+
+// NotFound renders the error response for unknown API routes.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, util.NotFound("No API route matches "+r.Method+" "+r.URL.Path))
+}
+
+// MethodNotAllowed renders the error response for API routes that do not
+// accept the request method.
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, &util.AppError{
+		Message: "Method " + r.Method + " is not allowed for " + r.URL.Path,
+		Code:    http.StatusMethodNotAllowed,
+		Kind:    util.ErrCodeMethodNotAllowed,
+	})
+}
+
+// requestBodyError maps an error from reading a request body limited by
+// http.MaxBytesReader to an AppError, using message for other failures.
+func requestBodyError(message string, err error) *util.AppError {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return util.NewAppError("Request body too large", http.StatusRequestEntityTooLarge, err)
+	}
+	return util.NewAppError(message, http.StatusBadRequest, err)
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"dmarc-report-analyzer/backend/src/util"
+)
+
+func TestUnmatchedRoutes(t *testing.T) {
+	// As in main, with a frontend route for every path outside /api/
+	router := newTestRouter()
+	RegisterErrorHandlers(router)
+	router.MatcherFunc(func(r *http.Request, _ *mux.RouteMatch) bool {
+		return !strings.HasPrefix(r.URL.Path, "/api/")
+	}).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("frontend"))
+	})
+
+	tests := []struct {
+		method, path string
+		status       int
+		code         string
+	}{
+		{"GET", "/api/unknown", http.StatusNotFound, util.ErrCodeNotFound},
+		{"POST", "/api/reports/1/unknown", http.StatusNotFound, util.ErrCodeNotFound},
+		{"PUT", "/api/records", http.StatusMethodNotAllowed, util.ErrCodeMethodNotAllowed},
+		{"DELETE", "/api/auth/login", http.StatusMethodNotAllowed, util.ErrCodeMethodNotAllowed},
+		{"GET", "/dashboard", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s %s status = %d, want %d", tt.method, tt.path, rec.Code, tt.status)
+			continue
+		}
+		if tt.code == "" {
+			if rec.Body.String() != "frontend" {
+				t.Errorf("%s %s was not served by the frontend route", tt.method, tt.path)
+			}
+			continue
+		}
+		var body ErrorResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Errorf("%s %s body is not an error response: %v", tt.method, tt.path, err)
+			continue
+		}
+		if body.Status != "error" || body.Code != tt.code || body.Message == "" {
+			t.Errorf("%s %s body = %+v, want an error with code %s", tt.method, tt.path, body, tt.code)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"dmarc-report-analyzer/backend/src/core/events"
+	"dmarc-report-analyzer/backend/src/util"
+)
+
+// eventsKeepAlive is how often an idle event stream sends a comment, so
+// that proxies do not close it.
+const eventsKeepAlive = 25 * time.Second
+
+// EventsAPI handles the live event stream.
+type EventsAPI struct {
+	Hub *events.Hub
+}
+
+// NewEventsAPI creates a new EventsAPI instance.
+func NewEventsAPI(hub *events.Hub) *EventsAPI {
+	return &EventsAPI{Hub: hub}
+}
+
+// RegisterEventRoutes registers the event stream API routes.
+func RegisterEventRoutes(router *mux.Router, api *EventsAPI) {
+	router.HandleFunc("/api/events", handle(api.StreamEvents)).Methods("GET")
+}
+
+// StreamEvents streams ingestion events as Server-Sent Events until the
+// client disconnects. Each event is sent with its ID, its type as the event
+// name and its JSON encoding as data. A reconnecting EventSource sends
+// Last-Event-ID and first receives the retained events it missed.
+func (api *EventsAPI) StreamEvents(w http.ResponseWriter, r *http.Request) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return util.Internal("Streaming is not supported", fmt.Errorf("response writer %T cannot flush", w))
+	}
+	lastID, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+
+	missed, ch, unsubscribe := api.Hub.Subscribe(lastID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable proxy buffering
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 5000\n\n")
+	for _, event := range missed {
+		if err := writeEvent(w, event); err != nil {
+			return nil
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case event := <-ch:
+			if err := writeEvent(w, event); err != nil {
+				return nil // Client went away
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes event in the Server-Sent Events format.
+func writeEvent(w http.ResponseWriter, event events.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode %s event %d: %v", event.Type, event.ID, err)
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
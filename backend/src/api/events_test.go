@@ -0,0 +1,74 @@
+package api
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"dmarc-report-analyzer/backend/src/core/events"
+)
+
+// readSSEEvent reads the lines of the next Server-Sent Event, skipping
+// comments and the retry field.
+func readSSEEvent(t *testing.T, r *bufio.Reader) []string {
+	t.Helper()
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read event stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && len(lines) > 0:
+			return lines
+		case line == "", strings.HasPrefix(line, ":"), strings.HasPrefix(line, "retry:"):
+		default:
+			lines = append(lines, line)
+		}
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	hub := events.NewHub()
+	router := mux.NewRouter()
+	RegisterEventRoutes(router, NewEventsAPI(hub))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	hub.Publish(events.TypeIngestionStarted, events.FileEvent{Filename: "a.xml"})
+	hub.Publish(events.TypeIngestionError, events.IngestionIssue{Filename: "a.xml", ErrorType: "parse", Message: "bad XML"})
+
+	// Resume after the first event, as a reconnecting EventSource does
+	req, err := http.NewRequest("GET", server.URL+"/api/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", got)
+	}
+	stream := bufio.NewReader(resp.Body)
+
+	replayed := readSSEEvent(t, stream)
+	if len(replayed) != 3 || replayed[0] != "id: 2" || replayed[1] != "event: "+events.TypeIngestionError ||
+		!strings.Contains(replayed[2], `"message":"bad XML"`) {
+		t.Errorf("replayed event = %q, want event 2 with its data", replayed)
+	}
+
+	hub.Publish(events.TypeReportStored, events.ReportStored{Filename: "b.xml", ID: 7})
+	live := readSSEEvent(t, stream)
+	if len(live) != 3 || live[0] != "id: 3" || live[1] != "event: "+events.TypeReportStored ||
+		!strings.HasPrefix(live[2], `data: {"id":3,"type":"report.stored"`) {
+		t.Errorf("live event = %q, want event 3 with its data", live)
+	}
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"dmarc-report-analyzer/backend/src/core/export"
+	"dmarc-report-analyzer/backend/src/db"
+	"dmarc-report-analyzer/backend/src/util"
+)
+
+// exportFormat returns the export format requested with the format query
+// parameter, or "" for the interactive JSON response.
+func exportFormat(r *http.Request) (string, error) {
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" || format == "json" {
+		return "", nil
+	}
+	if !export.IsValidFormat(format) {
+		return "", fmt.Errorf("invalid format %q, expected csv, jsonl or xlsx", format)
+	}
+	return format, nil
+}
+
+// startExport sends the download headers and returns a writer for the table.
+func startExport(w http.ResponseWriter, format, name string, columns []string) (export.Writer, error) {
+	filename := fmt.Sprintf("dmarc-%s_%s.%s", name, time.Now().Format(util.DateLayout), format)
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	return export.NewWriter(format, w, columns, name)
+}
+
+// finishExport closes the export writer, logs the outcome and records the
+// export in the audit log. Headers are already sent when an export fails, so
+// all that can be done is to log it and leave the download truncated.
+func finishExport(dbRepo *db.Repository, r *http.Request, name string, writer export.Writer, rows int, err error) {
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	recordAudit(dbRepo, r, "data.export_table", name, map[string]interface{}{
+		"format":   r.URL.Query().Get("format"),
+		"query":    r.URL.RawQuery,
+		"rows":     rows,
+		"complete": err == nil,
+	})
+	if err != nil {
+		log.Printf("Error exporting %s: %v", name, err)
+		return
+	}
+	log.Printf("Exported %d %s rows", rows, name)
+}
+
+// exportReports streams the reports matching the report filter parameters.
+// Errors are only returned while nothing has been written yet.
+func (api *ReportsAPI) exportReports(w http.ResponseWriter, r *http.Request, format string) error {
+	filter, err := parseReportFilter(r)
+	if err != nil {
+		return util.BadRequest(err.Error())
+	}
+
+	writer, err := startExport(w, format, "reports", export.ReportColumns)
+	if err != nil {
+		log.Printf("Error starting reports export: %v", err)
+		return nil
+	}
+	rows := 0
+	err = api.DBRepo.ForEachReport(filter, r.URL.Query().Get("sortBy"), r.URL.Query().Get("sortOrder"), func(report *db.Report) error {
+		rows++
+		return writer.WriteRow(export.ReportValues(report))
+	})
+	finishExport(api.DBRepo, r, "reports", writer, rows, err)
+	return nil
+}
+
+// exportRecords streams every row of the records view selected by q,
+// ignoring its paging.
+func (api *RecordsAPI) exportRecords(w http.ResponseWriter, r *http.Request, q db.RecordQuery, format string) error {
+	q.Limit, q.Offset = 0, 0
+
+	writer, err := startExport(w, format, "records", export.RecordColumns(q.Aggregate))
+	if err != nil {
+		log.Printf("Error starting records export: %v", err)
+		return nil
+	}
+	rows := 0
+	err = api.DBRepo.ForEachRecordRow(q, func(row *db.RecordRow) error {
+		rows++
+		return writer.WriteRow(export.RecordValues(row, q.Aggregate))
+	})
+	finishExport(api.DBRepo, r, "records", writer, rows, err)
+	return nil
+}
+
+// summarySection returns the summary section to export, from the section
+// query parameter.
+func summarySection(r *http.Request) (string, error) {
+	section := r.URL.Query().Get("section")
+	if !slices.Contains(export.SummarySections, section) {
+		return "", fmt.Errorf("query parameter section is required for summary exports: one of %s", strings.Join(export.SummarySections, ", "))
+	}
+	return section, nil
+}
+
+// exportSummary writes one section of a dashboard summary.
+func (api *ReportsAPI) exportSummary(w http.ResponseWriter, r *http.Request, summary *db.DashboardSummary, section, format string) error {
+	columns, rows, err := export.SummaryTable(summary, section)
+	if err != nil {
+		return util.BadRequest(err.Error())
+	}
+
+	name := "summary-" + strings.ReplaceAll(section, "_", "-")
+	writer, err := startExport(w, format, name, columns)
+	if err != nil {
+		log.Printf("Error starting summary export: %v", err)
+		return nil
+	}
+	for _, row := range rows {
+		if err = writer.WriteRow(row); err != nil {
+			break
+		}
+	}
+	finishExport(api.DBRepo, r, name, writer, len(rows), err)
+	return nil
+}
+
+// exportAuditLog streams every audit log entry matching filter.
+func (api *AuditAPI) exportAuditLog(w http.ResponseWriter, r *http.Request, filter db.AuditFilter, format string) error {
+	writer, err := startExport(w, format, "audit", export.AuditColumns)
+	if err != nil {
+		log.Printf("Error starting audit log export: %v", err)
+		return nil
+	}
+	rows := 0
+	err = api.DBRepo.ForEachAuditEntry(db.AuditQuery{Filter: filter}, func(entry *db.AuditEntry) error {
+		rows++
+		return writer.WriteRow(export.AuditValues(entry))
+	})
+	finishExport(api.DBRepo, r, "audit", writer, rows, err)
+	return nil
+}
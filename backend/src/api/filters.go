@@ -0,0 +1,72 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"dmarc-report-analyzer/backend/src/db"
+	"dmarc-report-analyzer/backend/src/util"
+)
+
+// parseReportFilter reads the report filter query parameters: start_date,
+// end_date (YYYY-MM-DD), org_name and domain.
+func parseReportFilter(r *http.Request) (db.ReportFilter, error) {
+	query := r.URL.Query()
+
+	begin, end, err := util.ParseDateRange(query.Get("start_date"), query.Get("end_date"))
+	if err != nil {
+		return db.ReportFilter{}, err
+	}
+	return db.ReportFilter{
+		Begin:   begin,
+		End:     end,
+		OrgName: query.Get("org_name"),
+		Domain:  query.Get("domain"),
+	}, nil
+}
+
+// parseRecordFilter reads the record filter query parameters shared by the
+// dashboard and record endpoints: start_date, end_date, keyword, disposition,
+// dmarc_result, spf_result, dkim_result, as_name, country_code and domain.
+func parseRecordFilter(r *http.Request) (db.RecordFilter, error) {
+	query := r.URL.Query()
+
+	begin, end, err := util.ParseDateRange(query.Get("start_date"), query.Get("end_date"))
+	if err != nil {
+		return db.RecordFilter{}, err
+	}
+
+	filter := db.RecordFilter{
+		Begin:       begin,
+		End:         end,
+		ASName:      strings.TrimSpace(query.Get("as_name")),
+		CountryCode: strings.TrimSpace(query.Get("country_code")),
+		Domain:      strings.TrimSpace(query.Get("domain")),
+		Keyword:     strings.TrimSpace(query.Get("keyword")),
+	}
+
+	enums := []struct {
+		param   string
+		dest    *string
+		allowed []string
+	}{
+		{"disposition", &filter.Disposition, []string{db.DispositionNone, db.DispositionQuarantine, db.DispositionReject}},
+		{"dmarc_result", &filter.DMARCResult, []string{db.ResultPass, db.ResultFail}},
+		{"spf_result", &filter.SPFResult, []string{db.ResultPass, db.ResultFail}},
+		{"dkim_result", &filter.DKIMResult, []string{db.ResultPass, db.ResultFail}},
+	}
+	for _, e := range enums {
+		value := strings.ToLower(strings.TrimSpace(query.Get(e.param)))
+		if value == "" || value == db.FilterAny {
+			continue
+		}
+		if !slices.Contains(e.allowed, value) {
+			return db.RecordFilter{}, fmt.Errorf("invalid %s %q, expected %s or %s", e.param, value, strings.Join(e.allowed, ", "), db.FilterAny)
+		}
+		*e.dest = value
+	}
+
+	return filter, nil
+}
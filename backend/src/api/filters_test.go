@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"dmarc-report-analyzer/backend/src/db"
+)
+
+func TestParseRecordFilter(t *testing.T) {
+	tests := []struct {
+		query string
+		want  db.RecordFilter
+	}{
+		{"", db.RecordFilter{}},
+		{
+			"start_date=2024-01-01&end_date=2024-01-02",
+			db.RecordFilter{Begin: 1704067200, End: 1704239999},
+		},
+		{
+			"disposition=Reject&dmarc_result=fail&spf_result=any&dkim_result=PASS",
+			db.RecordFilter{Disposition: db.DispositionReject, DMARCResult: db.ResultFail, DKIMResult: db.ResultPass},
+		},
+		{
+			"as_name=+Google+LLC+&country_code=US&domain=example.com&keyword=%20mail%20",
+			db.RecordFilter{ASName: "Google LLC", CountryCode: "US", Domain: "example.com", Keyword: "mail"},
+		},
+	}
+	for _, tt := range tests {
+		got, err := parseRecordFilter(httptest.NewRequest("GET", "/api/records?"+tt.query, nil))
+		if err != nil {
+			t.Errorf("parseRecordFilter(%q) error: %v", tt.query, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseRecordFilter(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+
+	for _, query := range []string{
+		"disposition=accept",
+		"dmarc_result=none",
+		"spf_result=softfail",
+		"dkim_result=neutral",
+		"start_date=2024-13-01",
+		"start_date=2024-01-02&end_date=2024-01-01",
+	} {
+		if _, err := parseRecordFilter(httptest.NewRequest("GET", "/api/records?"+query, nil)); err == nil {
+			t.Errorf("parseRecordFilter(%q) accepted an invalid filter", query)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"dmarc-report-analyzer/backend/src/core/parser"
+	"dmarc-report-analyzer/backend/src/db"
+	"dmarc-report-analyzer/backend/src/util"
+)
+
+// IPInfoAPI handles IP enrichment API endpoints.
+type IPInfoAPI struct {
+	DBRepo    *db.Repository
+	Processor *parser.ReportProcessor
+}
+
+// NewIPInfoAPI creates a new IPInfoAPI instance.
+func NewIPInfoAPI(dbRepo *db.Repository, processor *parser.ReportProcessor) *IPInfoAPI {
+	return &IPInfoAPI{DBRepo: dbRepo, Processor: processor}
+}
+
+// RegisterIPInfoRoutes registers the IP enrichment API routes.
+func RegisterIPInfoRoutes(router *mux.Router, api *IPInfoAPI) {
+	router.HandleFunc("/api/ips/{ip}", handle(api.GetIPInfo)).Methods("GET")
+	router.HandleFunc("/api/ips/{ip}/history", handle(api.GetIPInfoHistory)).Methods("GET")
+	router.HandleFunc("/api/admin/ip-db/reload", requireRole(db.RoleAdmin, handle(api.ReloadIPDatabases))).Methods("POST")
+}
+
+// parseIPVar reads and validates the {ip} path variable.
+func parseIPVar(r *http.Request) (string, error) {
+	ip := net.ParseIP(mux.Vars(r)["ip"])
+	if ip == nil {
+		return "", util.BadRequest("Invalid IP address")
+	}
+	return ip.String(), nil
+}
+
+// GetIPInfo returns the IP information of an address as it was on the date
+// given by the optional at parameter (YYYY-MM-DD, end of day), or now.
+func (api *IPInfoAPI) GetIPInfo(w http.ResponseWriter, r *http.Request) error {
+	ip, err := parseIPVar(r)
+	if err != nil {
+		return err
+	}
+
+	at := time.Now().Unix()
+	if date := r.URL.Query().Get("at"); date != "" {
+		_, end, err := util.ParseDateRange("", date)
+		if err != nil {
+			return util.BadRequest(err.Error())
+		}
+		at = end
+	}
+
+	snapshot, err := api.DBRepo.GetIPInfoAt(ip, at)
+	if err != nil {
+		return util.Internal("Failed to retrieve IP info", err)
+	}
+	if snapshot == nil {
+		return util.NotFound("No IP info found for that address and time")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshot)
+	return nil
+}
+
+// GetIPInfoHistory returns every recorded snapshot of an address's PTR, ASN
+// and location data, oldest first.
+func (api *IPInfoAPI) GetIPInfoHistory(w http.ResponseWriter, r *http.Request) error {
+	ip, err := parseIPVar(r)
+	if err != nil {
+		return err
+	}
+
+	history, err := api.DBRepo.GetIPInfoHistory(ip)
+	if err != nil {
+		return util.Internal("Failed to retrieve IP info history", err)
+	}
+	if len(history) == 0 {
+		return util.NotFound("No IP info found for that address")
+	}
+
+	response := map[string]interface{}{
+		"ip_address": ip,
+		"history":    history,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	return nil
+}
+
+// ReloadIPDatabases reloads the IP geolocation databases from disk, so that
+// replaced database files take effect without a restart.
+func (api *IPInfoAPI) ReloadIPDatabases(w http.ResponseWriter, r *http.Request) error {
+	if err := api.Processor.ReloadIPDatabases(); err != nil {
+		return util.Internal("Failed to reload IP databases", err)
+	}
+	recordAudit(api.DBRepo, r, "ipdb.reload", "ip_db", nil)
+	response := map[string]string{
+		"status":  "success",
+		"message": "IP databases reloaded.",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	return nil
+}
@@ -0,0 +1,363 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"dmarc-report-analyzer/backend/src/auth"
+	"dmarc-report-analyzer/backend/src/db"
+	"dmarc-report-analyzer/backend/src/util"
+)
+
+// requestIDHeader carries the request ID in requests and responses.
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID matches client-supplied request IDs that are safe to log
+// and echo back.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestIDKey is the context key of the request ID.
+type requestIDKey struct{}
+
+// RequestID assigns every request an ID, taken from the X-Request-ID header
+// if the client sent a valid one, and returns it in the same header.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// RequestIDFromContext returns the request ID set by RequestID, or "".
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID returns a random request ID.
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Recover turns a panicking handler into an internal error response instead
+// of a dropped connection, and logs the stack trace.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				panic(rec) // Deliberate abort of a streamed response
+			}
+			log.Printf("Request %s %s %s panicked: %v\n%s", RequestIDFromContext(r.Context()), r.Method, r.URL.Path, rec, debug.Stack())
+			writeError(w, r, util.Internal("Internal server error", nil))
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// publicRoutes are the API routes that can be used without authentication,
+// as "METHOD path". Paths outside /api/ (the frontend) are always public.
+var publicRoutes = map[string]bool{
+	"POST /api/auth/login":        true,
+	"POST /api/auth/refresh":      true,
+	"POST /api/auth/logout":       true,
+	"POST /api/auth/login/totp":   true,
+	"GET /api/auth/oidc":          true,
+	"GET /api/auth/oidc/login":    true,
+	"GET /api/auth/oidc/callback": true,
+	"GET /api/openapi.json":       true,
+}
+
+// passwordChangeRoutes are the routes a user who must change their password
+// may still use.
+var passwordChangeRoutes = map[string]bool{
+	"POST /api/users/change-password": true,
+	"POST /api/auth/logout-all":       true,
+}
+
+// totpEnrolmentRoutes are the routes, besides passwordChangeRoutes, that an
+// admin who must enable TOTP may still use.
+var totpEnrolmentRoutes = map[string]bool{
+	"GET /api/auth/totp":          true,
+	"POST /api/auth/totp/enrol":   true,
+	"POST /api/auth/totp/confirm": true,
+	"POST /api/auth/logout-all":   true,
+}
+
+// tokenDataRoutes are the routes that every API token may use, whatever its
+// scopes. Path segments in braces match any value.
+var tokenDataRoutes = map[string]bool{
+	"GET /api/reports":              true,
+	"GET /api/reports/summary":      true,
+	"GET /api/reports/{id}":         true,
+	"GET /api/records":              true,
+	"GET /api/records/{id}/analyze": true,
+	"GET /api/search":               true,
+	"GET /api/ips/{ip}":             true,
+	"GET /api/ips/{ip}/history":     true,
+	"GET /api/events":               true,
+	"GET /api/data/export":          true,
+}
+
+// uploadScopeRoutes are the routes beyond tokenDataRoutes that API tokens with
+// the upload scope may use.
+var uploadScopeRoutes = map[string]bool{
+	"POST /api/reports/upload": true,
+}
+
+// selfServiceRoutes manage the authenticated user's own credentials and
+// sessions. API tokens may not use them, even with the admin scope, so that a
+// leaked token cannot be used to take over the account.
+var selfServiceRoutes = map[string]bool{
+	"POST /api/auth/logout-all":          true,
+	"GET /api/auth/sessions":             true,
+	"DELETE /api/auth/sessions/{id}":     true,
+	"GET /api/auth/totp":                 true,
+	"POST /api/auth/totp/enrol":          true,
+	"POST /api/auth/totp/confirm":        true,
+	"POST /api/auth/totp/disable":        true,
+	"POST /api/auth/totp/recovery-codes": true,
+	"GET /api/tokens":                    true,
+	"POST /api/tokens":                   true,
+	"DELETE /api/tokens/{id}":            true,
+	"POST /api/users/change-password":    true,
+}
+
+// queryTokenRoutes may pass the token in the access_token query parameter,
+// because browsers cannot set headers on EventSource requests.
+var queryTokenRoutes = map[string]bool{
+	"GET /api/events": true,
+}
+
+// userKey is the context key of the authenticated user.
+type userKey struct{}
+
+// sessionKey is the context key of the ID of the session a request was
+// authenticated in.
+type sessionKey struct{}
+
+// apiTokenKey is the context key of the API token a request was
+// authenticated with.
+type apiTokenKey struct{}
+
+// Authenticate returns middleware that requires a valid bearer token, a JWT
+// or an API token, on every API route except publicRoutes, and puts the
+// token's user in the request context. For API tokens the user's role is
+// limited to the token's scopes. Tokens of users that no longer exist or are
+// disabled are rejected, and users who must change their password or enable
+// TOTP may only do that. API tokens may only use the routes their scopes
+// allow, see tokenAllows. Routes are open to every role unless they are
+// wrapped in requireRole.
+func Authenticate(authService *auth.AuthService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := r.Method + " " + r.URL.Path
+			if !strings.HasPrefix(r.URL.Path, "/api/") || publicRoutes[route] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok && queryTokenRoutes[route] {
+				token = r.URL.Query().Get("access_token")
+			}
+			if token == "" {
+				unauthorized(w, r, "Authentication required")
+				return
+			}
+			var user *db.User
+			var apiToken *db.APIToken
+			var sessionID int64
+			var err error
+			if auth.IsAPIToken(token) {
+				apiToken, err = authService.ValidateAPIToken(token)
+				if err != nil {
+					if errors.Is(err, auth.ErrInvalidAPIToken) {
+						unauthorized(w, r, "Invalid or expired token")
+					} else {
+						writeError(w, r, util.Internal("Internal server error", err))
+					}
+					return
+				}
+				user, err = authService.DBRepo.GetUserByID(apiToken.UserID)
+				if err != nil {
+					writeError(w, r, util.Internal("Internal server error", err))
+					return
+				}
+				if user != nil {
+					// The request acts with the token's scopes, not the user's role
+					user.Role = auth.TokenRole(user.Role, apiToken.Scopes)
+				}
+			} else {
+				claims, err := authService.ValidateJWT(token)
+				if err != nil {
+					if errors.Is(err, auth.ErrInvalidToken) {
+						unauthorized(w, r, "Invalid or expired token")
+					} else {
+						writeError(w, r, util.Internal("Internal server error", err))
+					}
+					return
+				}
+				sessionID, _ = strconv.ParseInt(claims.ID, 10, 64)
+				user, err = authService.DBRepo.GetUserByUsername(claims.Username)
+				if err != nil {
+					writeError(w, r, util.Internal("Internal server error", err))
+					return
+				}
+			}
+			if user == nil {
+				unauthorized(w, r, "Invalid or expired token")
+				return
+			}
+			if user.Disabled {
+				unauthorized(w, r, "Account is disabled")
+				return
+			}
+			if apiToken != nil && !tokenAllows(apiToken, r.Method, r.URL.Path) {
+				writeError(w, r, util.Forbidden("This action is not allowed with the scopes of this API token"))
+				return
+			}
+			if user.MustChangePassword && !passwordChangeRoutes[route] {
+				writeError(w, r, &util.AppError{
+					Message: "The password must be changed first",
+					Code:    http.StatusForbidden,
+					Kind:    util.ErrCodePasswordChangeRequired,
+				})
+				return
+			}
+			if authService.TOTPEnrolmentRequired(user) && !totpEnrolmentRoutes[route] && !passwordChangeRoutes[route] {
+				writeError(w, r, &util.AppError{
+					Message: "Two-factor authentication must be enabled first",
+					Code:    http.StatusForbidden,
+					Kind:    util.ErrCodeTOTPEnrolmentRequired,
+				})
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), userKey{}, user)
+			if apiToken != nil {
+				ctx = context.WithValue(ctx, apiTokenKey{}, apiToken)
+			} else {
+				ctx = context.WithValue(ctx, sessionKey{}, sessionID)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// unauthorized renders a 401 response asking for a bearer token.
+func unauthorized(w http.ResponseWriter, r *http.Request, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="dmarc-report-analyzer"`)
+	writeError(w, r, util.Unauthorized(message))
+}
+
+// requireRole wraps h so that it only runs for users with at least the given
+// role; others receive 403. It must run behind Authenticate, which limits the
+// role of API tokens to their scopes.
+func requireRole(role string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := UserFromContext(r.Context())
+		if user == nil {
+			unauthorized(w, r, "Authentication required")
+			return
+		}
+		if !auth.HasRole(user.Role, role) {
+			writeError(w, r, util.Forbidden("This action requires the "+role+" role"))
+			return
+		}
+		h(w, r)
+	}
+}
+
+// tokenAllows reports whether the scopes of token allow a request. Every
+// token may use tokenDataRoutes, the upload scope also uploadScopeRoutes and
+// the admin scope every route but selfServiceRoutes. requireRole still checks
+// the role that Authenticate derives from the scopes.
+func tokenAllows(token *db.APIToken, method, path string) bool {
+	if matchRoute(selfServiceRoutes, method, path) {
+		return false
+	}
+	if matchRoute(tokenDataRoutes, method, path) {
+		return true
+	}
+	for _, scope := range token.Scopes {
+		switch {
+		case scope == db.ScopeAdmin:
+			return true
+		case scope == db.ScopeUpload && matchRoute(uploadScopeRoutes, method, path):
+			return true
+		}
+	}
+	return false
+}
+
+// matchRoute reports whether routes, as "METHOD path", contain the request's
+// method and path. Path segments in braces match any non-empty segment.
+func matchRoute(routes map[string]bool, method, path string) bool {
+	if routes[method+" "+path] {
+		return true
+	}
+	segments := strings.Split(path, "/")
+	for route := range routes {
+		routeMethod, template, _ := strings.Cut(route, " ")
+		if routeMethod != method || !strings.Contains(template, "{") {
+			continue
+		}
+		templateSegments := strings.Split(template, "/")
+		if len(templateSegments) != len(segments) {
+			continue
+		}
+		matched := true
+		for i, segment := range templateSegments {
+			if strings.HasPrefix(segment, "{") {
+				matched = segments[i] != ""
+			} else {
+				matched = segment == segments[i]
+			}
+			if !matched {
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// UserFromContext returns the user authenticated by Authenticate, or nil.
+func UserFromContext(ctx context.Context) *db.User {
+	user, _ := ctx.Value(userKey{}).(*db.User)
+	return user
+}
+
+// APITokenFromContext returns the API token the request was authenticated
+// with, or nil for JWTs.
+func APITokenFromContext(ctx context.Context) *db.APIToken {
+	token, _ := ctx.Value(apiTokenKey{}).(*db.APIToken)
+	return token
+}
+
+// SessionIDFromContext returns the ID of the session the request was
+// authenticated in, or 0 for API tokens.
+func SessionIDFromContext(ctx context.Context) int64 {
+	id, _ := ctx.Value(sessionKey{}).(int64)
+	return id
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"dmarc-report-analyzer/backend/src/auth"
+	"dmarc-report-analyzer/backend/src/db"
+)
+
+func TestAPITokenScopesLimitRoutes(t *testing.T) {
+	database, err := db.InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	repo := db.NewRepository(database)
+	authService := auth.NewAuthService(repo, "scope-test-secret-0123456789abcdef")
+	if _, err := repo.CreateUser("alice", "password", db.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	user, err := repo.GetUserByUsername("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A handler that is reached panics on its missing dependencies, so any
+	// status but 403 means the request was let through.
+	router := Recover(Authenticate(authService)(newTestRouter()))
+	tests := []struct {
+		scopes  []string
+		method  string
+		path    string
+		allowed bool
+	}{
+		// Data routes are open to every token
+		{[]string{db.ScopeRead}, "GET", "/api/reports", true},
+		{[]string{db.ScopeRead}, "GET", "/api/reports/7", true},
+		{[]string{db.ScopeRead}, "GET", "/api/records/7/analyze", true},
+		{[]string{db.ScopeRead}, "GET", "/api/ips/192.0.2.1/history", true},
+		{[]string{db.ScopeRead}, "GET", "/api/data/export", true},
+		{[]string{db.ScopeRead}, "GET", "/api/reports/7/unknown", false},
+		// Upload and other analyst routes
+		{[]string{db.ScopeUpload}, "POST", "/api/reports/upload", true},
+		{[]string{db.ScopeUpload}, "GET", "/api/reports", true},
+		{[]string{db.ScopeUpload}, "POST", "/api/data/import", false},
+		{[]string{db.ScopeUpload}, "GET", "/api/audit", false},
+		{[]string{db.ScopeRead, db.ScopeUpload}, "POST", "/api/reports/upload", true},
+		{[]string{db.ScopeRead, db.ScopeUpload}, "POST", "/api/data/import", false},
+		{[]string{db.ScopeRead}, "POST", "/api/reports/upload", false},
+		// Admin routes
+		{[]string{db.ScopeAdmin}, "POST", "/api/data/import", true},
+		{[]string{db.ScopeUpload, db.ScopeAdmin}, "GET", "/api/audit", true},
+		{[]string{db.ScopeAdmin}, "DELETE", "/api/reports/7", true},
+		{[]string{db.ScopeRead}, "DELETE", "/api/reports/7", false},
+		// Self-service credential and session routes are closed to every token
+		{[]string{db.ScopeAdmin}, "POST", "/api/auth/totp/enrol", false},
+		{[]string{db.ScopeAdmin}, "POST", "/api/auth/totp/confirm", false},
+		{[]string{db.ScopeAdmin}, "POST", "/api/auth/totp/disable", false},
+		{[]string{db.ScopeAdmin}, "POST", "/api/auth/logout-all", false},
+		{[]string{db.ScopeAdmin}, "POST", "/api/users/change-password", false},
+		{[]string{db.ScopeAdmin}, "GET", "/api/auth/sessions", false},
+		{[]string{db.ScopeAdmin}, "DELETE", "/api/auth/sessions/3", false},
+		{[]string{db.ScopeAdmin}, "POST", "/api/tokens", false},
+		{[]string{db.ScopeAdmin}, "DELETE", "/api/tokens/3", false},
+		{[]string{db.ScopeRead}, "DELETE", "/api/tokens/3", false},
+	}
+	for _, tt := range tests {
+		token, _, err := authService.CreateAPIToken(user, "test", tt.scopes, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if allowed := rec.Code != http.StatusForbidden; allowed != tt.allowed {
+			t.Errorf("%s %s with scopes %v: status = %d, want allowed %t", tt.method, tt.path, tt.scopes, rec.Code, tt.allowed)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
+
+	"dmarc-report-analyzer/backend/src/auth"
+	"dmarc-report-analyzer/backend/src/db"
+	"dmarc-report-analyzer/backend/src/util"
+)
+
+// oidcLoginCookie keeps the signed state of an OIDC login in the browser
+// between the redirect to the provider and the callback.
+const oidcLoginCookie = "dra_oidc_login"
+
+// oidcCookiePath limits the login cookie to the OIDC routes.
+const oidcCookiePath = "/api/auth/oidc"
+
+// OIDCAPI handles the API endpoints for single sign-on through OpenID Connect.
+type OIDCAPI struct {
+	AuthService *auth.AuthService
+	DBRepo      *db.Repository
+}
+
+// NewOIDCAPI creates a new OIDCAPI instance.
+func NewOIDCAPI(authService *auth.AuthService, dbRepo *db.Repository) *OIDCAPI {
+	return &OIDCAPI{
+		AuthService: authService,
+		DBRepo:      dbRepo,
+	}
+}
+
+// RegisterOIDCRoutes registers the OIDC API routes.
+func RegisterOIDCRoutes(router *mux.Router, api *OIDCAPI) {
+	router.HandleFunc("/api/auth/oidc", handle(api.Status)).Methods("GET")
+	router.HandleFunc("/api/auth/oidc/login", handle(api.Login)).Methods("GET")
+	router.HandleFunc("/api/auth/oidc/callback", handle(api.Callback)).Methods("GET")
+}
+
+// Status tells the login page which login methods are available.
+func (api *OIDCAPI) Status(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{
+		"enabled":        api.AuthService.OIDC != nil,
+		"password_login": !api.AuthService.PasswordLoginDisabled,
+	})
+	return nil
+}
+
+// Login redirects the browser to the provider, remembering the state of the
+// login in a cookie.
+func (api *OIDCAPI) Login(w http.ResponseWriter, r *http.Request) error {
+	if api.AuthService.OIDC == nil {
+		return util.NotFound("Single sign-on is not configured")
+	}
+	authURL, login, err := api.AuthService.OIDC.AuthCodeURL(r.Context())
+	if err != nil {
+		log.Printf("Failed to start single sign-on login: %v", err)
+		redirectOIDCError(w, r, "The identity provider is unavailable")
+		return nil
+	}
+	signed, err := api.AuthService.SignOIDCLogin(login)
+	if err != nil {
+		return util.Internal("Failed to start single sign-on login", err)
+	}
+	setOIDCLoginCookie(w, r, signed, int(auth.OIDCLoginLifetime.Seconds()))
+	http.Redirect(w, r, authURL, http.StatusFound)
+	return nil
+}
+
+// Callback completes a login at the provider's redirect. It provisions the
+// user on their first login, starts a session and sends the browser back to
+// the dashboard with the session's refresh token in the URL fragment, which
+// the dashboard exchanges at once. Failures are passed on in the fragment.
+func (api *OIDCAPI) Callback(w http.ResponseWriter, r *http.Request) error {
+	if api.AuthService.OIDC == nil {
+		return util.NotFound("Single sign-on is not configured")
+	}
+	query := r.URL.Query()
+	cookie, cookieErr := r.Cookie(oidcLoginCookie)
+	setOIDCLoginCookie(w, r, "", -1) // Each login state is used once
+
+	if providerErr := query.Get("error"); providerErr != "" {
+		message := query.Get("error_description")
+		if message == "" {
+			message = providerErr
+		}
+		redirectOIDCError(w, r, "The identity provider refused the login: "+message)
+		return nil
+	}
+	if cookieErr != nil {
+		redirectOIDCError(w, r, "The login has expired; please try again")
+		return nil
+	}
+	login, err := api.AuthService.VerifyOIDCLogin(cookie.Value)
+	if err != nil || query.Get("state") != login.State {
+		redirectOIDCError(w, r, "The login has expired; please try again")
+		return nil
+	}
+
+	identity, err := api.AuthService.OIDC.Exchange(r.Context(), login, query.Get("code"))
+	if err != nil {
+		if errors.Is(err, auth.ErrLoginDenied) {
+			log.Printf("Single sign-on login denied: %v", err)
+			recordAuditAs(api.DBRepo, r, "anonymous", "auth.login_failed", "oidc", map[string]string{"reason": "denied"})
+			redirectOIDCError(w, r, "Your account is not allowed to use this application")
+		} else {
+			log.Printf("Single sign-on login failed: %v", err)
+			redirectOIDCError(w, r, "The login could not be completed")
+		}
+		return nil
+	}
+	user, err := api.AuthService.ProvisionExternalUser(db.AuthSourceOIDC, identity)
+	if err != nil {
+		if errors.Is(err, auth.ErrLoginDenied) {
+			log.Printf("Single sign-on login denied: %v", err)
+			recordLoginFailure(api.DBRepo, r, identity.Username, "denied")
+			redirectOIDCError(w, r, "Your account is not allowed to use this application")
+		} else {
+			log.Printf("Failed to provision single sign-on user %s: %v", identity.Username, err)
+			redirectOIDCError(w, r, "The login could not be completed")
+		}
+		return nil
+	}
+	if user.Disabled {
+		recordLoginFailure(api.DBRepo, r, user.Username, "account_disabled")
+		redirectOIDCError(w, r, "Account is disabled")
+		return nil
+	}
+
+	tokens, err := api.AuthService.StartSession(user, r.UserAgent(), clientIP(r))
+	if err != nil {
+		log.Printf("Failed to start session for single sign-on user %s: %v", user.Username, err)
+		redirectOIDCError(w, r, "The login could not be completed")
+		return nil
+	}
+	recordLogin(api.DBRepo, r, user, tokens, false)
+	redirectOIDCResult(w, r, url.Values{"oidc_refresh_token": {tokens.RefreshToken}})
+	return nil
+}
+
+// setOIDCLoginCookie sets the login cookie, or deletes it if maxAge is negative.
+func setOIDCLoginCookie(w http.ResponseWriter, r *http.Request, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcLoginCookie,
+		Value:    value,
+		Path:     oidcCookiePath,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode, // Sent on the provider's top-level redirect
+	})
+}
+
+// redirectOIDCError sends the browser back to the dashboard with an error
+// message for the login page.
+func redirectOIDCError(w http.ResponseWriter, r *http.Request, message string) {
+	redirectOIDCResult(w, r, url.Values{"oidc_error": {message}})
+}
+
+// redirectOIDCResult sends the browser back to the dashboard with values in
+// the URL fragment, which browsers do not send to servers.
+func redirectOIDCResult(w http.ResponseWriter, r *http.Request, values url.Values) {
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, "/#"+values.Encode(), http.StatusFound)
+}
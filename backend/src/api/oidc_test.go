@@ -0,0 +1,354 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+
+	"dmarc-report-analyzer/backend/src/auth"
+	"dmarc-report-analyzer/backend/src/db"
+)
+
+const (
+	testClientID     = "dmarc-analyzer"
+	testClientSecret = "client-secret"
+)
+
+// mockOIDCProvider is an OpenID Connect provider that authorizes every
+// request at once, as the user whose claims are set.
+type mockOIDCProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	claims map[string]interface{}
+	codes  map[string]mockAuthorization
+}
+
+// mockAuthorization is an issued authorization code.
+type mockAuthorization struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockOIDCProvider{t: t, key: key, codes: make(map[string]mockAuthorization)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", p.jwks)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// setClaims sets the claims of the next logins, besides the standard ones.
+func (p *mockOIDCProvider) setClaims(claims map[string]interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.claims = claims
+}
+
+func (p *mockOIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                p.server.URL,
+		"authorization_endpoint":                p.server.URL + "/authorize",
+		"token_endpoint":                        p.server.URL + "/token",
+		"jwks_uri":                              p.server.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *mockOIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != testClientID || q.Get("response_type") != "code" {
+		http.Error(w, "bad client", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "PKCE required", http.StatusBadRequest)
+		return
+	}
+	if !strings.Contains(" "+q.Get("scope")+" ", " openid ") {
+		http.Error(w, "openid scope required", http.StatusBadRequest)
+		return
+	}
+	code := rand.Text()
+	p.mu.Lock()
+	p.codes[code] = mockAuthorization{redirectURI: q.Get("redirect_uri"), challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	p.mu.Unlock()
+	http.Redirect(w, r, q.Get("redirect_uri")+"?"+url.Values{"code": {code}, "state": {q.Get("state")}}.Encode(), http.StatusFound)
+}
+
+func (p *mockOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	if clientID != testClientID || clientSecret != testClientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+	p.mu.Lock()
+	authz, ok := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+	claims := jwt.MapClaims{}
+	for name, value := range p.claims {
+		claims[name] = value
+	}
+	p.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || authz.redirectURI != r.PostFormValue("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(verifier[:]) != authz.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	now := time.Now()
+	claims["iss"] = p.server.URL
+	claims["aud"] = testClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(time.Hour).Unix()
+	claims["nonce"] = authz.nonce
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = "test"
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		p.t.Errorf("failed to sign ID token: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signed,
+	})
+}
+
+func (p *mockOIDCProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+// oidcTestApp is the application configured for the mock provider.
+type oidcTestApp struct {
+	server *httptest.Server
+	repo   *db.Repository
+	client *http.Client
+}
+
+func newOIDCTestApp(t *testing.T, provider *mockOIDCProvider) *oidcTestApp {
+	database, err := db.InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	repo := db.NewRepository(database)
+	authService := auth.NewAuthService(repo, "oidc-test-secret-0123456789abcdef")
+
+	router := mux.NewRouter()
+	RegisterAuthRoutes(router, NewAuthAPI(authService, repo))
+	RegisterOIDCRoutes(router, NewOIDCAPI(authService, repo))
+	server := httptest.NewServer(Recover(Authenticate(authService)(router)))
+	t.Cleanup(server.Close)
+
+	authService.OIDC = auth.NewOIDCProvider(auth.OIDCConfig{
+		Issuer:        provider.server.URL,
+		ClientID:      testClientID,
+		ClientSecret:  testClientSecret,
+		RedirectURL:   server.URL + "/api/auth/oidc/callback",
+		Scopes:        []string{"profile"},
+		UsernameClaim: "preferred_username",
+		RoleClaim:     "groups",
+		RoleMap:       map[string]string{"dmarc-admins": db.RoleAdmin, "dmarc-analysts": db.RoleAnalyst},
+	})
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{
+		Jar: jar,
+		// Stop at the redirect back to the dashboard to read its fragment
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.URL.Host == strings.TrimPrefix(server.URL, "http://") && req.URL.Path == "/" {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+	return &oidcTestApp{server: server, repo: repo, client: client}
+}
+
+// login goes through the login at the provider and returns the values in
+// the fragment of the redirect back to the dashboard.
+func (a *oidcTestApp) login(t *testing.T) url.Values {
+	t.Helper()
+	resp, err := a.client.Get(a.server.URL + "/api/auth/oidc/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("login ended with status %d, want a redirect to the dashboard", resp.StatusCode)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := url.ParseQuery(location.EscapedFragment())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+// refresh exchanges a refresh token from the callback and returns the user
+// in the response.
+func (a *oidcTestApp) refresh(t *testing.T, refreshToken string) map[string]interface{} {
+	t.Helper()
+	resp, err := a.client.Post(a.server.URL+"/api/auth/refresh", "application/json",
+		strings.NewReader(`{"refresh_token":"`+refreshToken+`"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("refresh status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	var body struct {
+		User map[string]interface{} `json:"user"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body.User
+}
+
+func TestOIDCLoginProvisionsUser(t *testing.T) {
+	provider := newMockOIDCProvider(t)
+	app := newOIDCTestApp(t, provider)
+
+	provider.setClaims(map[string]interface{}{"sub": "u-1", "preferred_username": "alice", "groups": []string{"staff", "dmarc-analysts"}})
+	result := app.login(t)
+	if result.Get("oidc_error") != "" {
+		t.Fatalf("login failed: %s", result.Get("oidc_error"))
+	}
+	user := app.refresh(t, result.Get("oidc_refresh_token"))
+	if user["username"] != "alice" || user["role"] != db.RoleAnalyst {
+		t.Errorf("user = %v, want alice with role analyst", user)
+	}
+	stored, err := app.repo.GetUserByUsername("alice")
+	if err != nil || stored == nil {
+		t.Fatalf("user was not provisioned: %v", err)
+	}
+	if stored.AuthSource != db.AuthSourceOIDC || stored.ExternalID != "u-1" {
+		t.Errorf("stored identity = %s/%s, want oidc/u-1", stored.AuthSource, stored.ExternalID)
+	}
+
+	// The role follows the provider on later logins
+	provider.setClaims(map[string]interface{}{"sub": "u-1", "preferred_username": "alice", "groups": []string{"dmarc-admins", "dmarc-analysts"}})
+	user = app.refresh(t, app.login(t).Get("oidc_refresh_token"))
+	if user["role"] != db.RoleAdmin {
+		t.Errorf("role after second login = %v, want admin", user["role"])
+	}
+	users, err := app.repo.ListUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 {
+		t.Errorf("%d users after two logins, want 1", len(users))
+	}
+}
+
+func TestOIDCLoginDenied(t *testing.T) {
+	provider := newMockOIDCProvider(t)
+	app := newOIDCTestApp(t, provider)
+	if _, err := app.repo.CreateUser("bob", "password", db.RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+	}{
+		{"no mapped group", map[string]interface{}{"sub": "u-2", "preferred_username": "carol", "groups": []string{"staff"}}},
+		{"no username", map[string]interface{}{"sub": "u-3", "groups": []string{"dmarc-admins"}}},
+		{"local username", map[string]interface{}{"sub": "u-4", "preferred_username": "bob", "groups": []string{"dmarc-admins"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider.setClaims(tt.claims)
+			result := app.login(t)
+			if result.Get("oidc_error") == "" || result.Get("oidc_refresh_token") != "" {
+				t.Errorf("login result = %v, want an error", result)
+			}
+		})
+	}
+
+	bob, err := app.repo.GetUserByUsername("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bob.AuthSource != db.AuthSourceLocal || bob.Role != db.RoleViewer {
+		t.Errorf("local user bob was changed: %+v", bob)
+	}
+}
+
+func TestOIDCCallbackRejectsForeignState(t *testing.T) {
+	provider := newMockOIDCProvider(t)
+	app := newOIDCTestApp(t, provider)
+	provider.setClaims(map[string]interface{}{"sub": "u-1", "preferred_username": "alice", "groups": []string{"dmarc-admins"}})
+
+	// A callback without the login cookie, as in a login CSRF attempt
+	resp, err := app.client.Get(app.server.URL + "/api/auth/oidc/callback?code=stolen&state=forged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, _ := url.ParseQuery(location.EscapedFragment())
+	if values.Get("oidc_error") == "" {
+		t.Errorf("callback redirected to %q, want an error", location)
+	}
+}
@@ -0,0 +1,26 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// openAPIDocument is the OpenAPI 3 description of every API route. It is
+// maintained by hand; TestOpenAPIDocumentCoversRoutes fails when a registered
+// route is missing from it.
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// RegisterOpenAPIRoutes registers the route serving the OpenAPI document.
+func RegisterOpenAPIRoutes(router *mux.Router) {
+	router.HandleFunc("/api/openapi.json", GetOpenAPIDocument).Methods("GET")
+}
+
+// GetOpenAPIDocument serves the OpenAPI document.
+func GetOpenAPIDocument(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDocument)
+}
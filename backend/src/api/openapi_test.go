@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"dmarc-report-analyzer/backend/src/db"
+)
+
+// openAPIMethods are the operation keys of an OpenAPI path item, upper-cased.
+var openAPIMethods = map[string]bool{
+	"GET": true, "PUT": true, "POST": true, "DELETE": true, "OPTIONS": true, "HEAD": true, "PATCH": true, "TRACE": true,
+}
+
+// newTestRouter registers every API route, as main does. Handlers are never
+// called, so the APIs need no dependencies.
+func newTestRouter() *mux.Router {
+	router := mux.NewRouter()
+	RegisterReportRoutes(router, &ReportsAPI{})
+	RegisterAuthRoutes(router, &AuthAPI{})
+	RegisterUserRoutes(router, &UsersAPI{})
+	RegisterTokenRoutes(router, &TokensAPI{})
+	RegisterTOTPRoutes(router, &TOTPAPI{})
+	RegisterOIDCRoutes(router, &OIDCAPI{})
+	RegisterBackupRoutes(router, &BackupAPI{})
+	RegisterDataRoutes(router, &DataAPI{})
+	RegisterSearchRoutes(router, &SearchAPI{})
+	RegisterRecordRoutes(router, &RecordsAPI{})
+	RegisterIPInfoRoutes(router, &IPInfoAPI{})
+	RegisterEventRoutes(router, &EventsAPI{})
+	RegisterSettingsRoutes(router, &SettingsAPI{})
+	RegisterAuditRoutes(router, &AuditAPI{})
+	RegisterOpenAPIRoutes(router)
+	return router
+}
+
+func TestOpenAPIDocumentCoversRoutes(t *testing.T) {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPIDocument, &doc); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+
+	registered := make(map[string]bool)
+	err := newTestRouter().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil // Routes without a path, such as subrouter matchers
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			t.Errorf("route %s has no methods", path)
+			return nil
+		}
+		for _, method := range methods {
+			registered[method+" "+path] = true
+			if _, ok := doc.Paths[path][strings.ToLower(method)]; !ok {
+				t.Errorf("route %s %s is missing from openapi.json", method, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk routes: %v", err)
+	}
+	if len(registered) == 0 {
+		t.Fatal("no routes were registered")
+	}
+
+	for path, item := range doc.Paths {
+		for key := range item {
+			method := strings.ToUpper(key)
+			if !openAPIMethods[method] {
+				continue // Path-level fields such as parameters
+			}
+			if !registered[method+" "+path] {
+				t.Errorf("openapi.json documents %s %s, which is not registered", method, path)
+			}
+		}
+	}
+}
+
+func TestOpenAPIRequiredRolesAreEnforced(t *testing.T) {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPIDocument, &doc); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+	pathParams := strings.NewReplacer("{id}", "1", "{ip}", "192.0.2.1")
+
+	// A handler that is wrongly reached panics on its missing dependencies.
+	router := Recover(newTestRouter())
+	checked := 0
+	for path, item := range doc.Paths {
+		for key, raw := range item {
+			if !openAPIMethods[strings.ToUpper(key)] {
+				continue
+			}
+			var op struct {
+				RequiredRole string `json:"x-required-role"`
+			}
+			if err := json.Unmarshal(raw, &op); err != nil {
+				t.Fatalf("%s %s is not a valid operation: %v", strings.ToUpper(key), path, err)
+			}
+			if op.RequiredRole == "" {
+				continue
+			}
+			if !db.IsValidRole(op.RequiredRole) {
+				t.Errorf("%s %s requires unknown role %q", strings.ToUpper(key), path, op.RequiredRole)
+				continue
+			}
+			// Every role below the required one must be rejected before the
+			// handler runs.
+			for _, role := range db.Roles {
+				if role == op.RequiredRole {
+					break
+				}
+				req := httptest.NewRequest(strings.ToUpper(key), pathParams.Replace(path), nil)
+				req = req.WithContext(context.WithValue(req.Context(), userKey{}, &db.User{Username: "test", Role: role}))
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, req)
+				if rec.Code != http.StatusForbidden {
+					t.Errorf("%s %s as %s: status = %d, want %d", req.Method, path, role, rec.Code, http.StatusForbidden)
+				}
+				checked++
+			}
+		}
+	}
+	if checked == 0 {
+		t.Fatal("no operations with x-required-role were checked")
+	}
+}
+
+func TestGetOpenAPIDocument(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newTestRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("response is not valid JSON: %v", err)
+	}
+	if doc["openapi"] == nil {
+		t.Error("response has no openapi version")
+	}
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"dmarc-report-analyzer/backend/src/db"
+)
+
+// pageParams are the keyset paging parameters shared by the list endpoints.
+type pageParams struct {
+	after        *db.Cursor // From the cursor parameter
+	includeTotal bool       // Whether to count all matching rows
+}
+
+// parsePageParams reads the cursor and include_total parameters. A cursor
+// replaces offset paging, so both cannot be combined. The total count is
+// included by default for offset paging, which has always returned it, and
+// only on request with a cursor.
+func parsePageParams(r *http.Request, offset int) (pageParams, error) {
+	query := r.URL.Query()
+	var p pageParams
+
+	if value := query.Get("cursor"); value != "" {
+		if offset > 0 {
+			return pageParams{}, fmt.Errorf("cursor and offset cannot be combined")
+		}
+		cursor, err := db.DecodeCursor(value)
+		if err != nil {
+			return pageParams{}, err
+		}
+		p.after = cursor
+	}
+
+	p.includeTotal = p.after == nil
+	if value := query.Get("include_total"); value != "" {
+		includeTotal, err := strconv.ParseBool(value)
+		if err != nil {
+			return pageParams{}, fmt.Errorf("invalid include_total %q, expected true or false", value)
+		}
+		p.includeTotal = includeTotal
+	}
+	return p, nil
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"dmarc-report-analyzer/backend/src/core/advice"
+	"dmarc-report-analyzer/backend/src/db"
+	"dmarc-report-analyzer/backend/src/util"
+)
+
+// Page size limits for the records view.
+const (
+	defaultRecordsLimit = 500
+	maxRecordsLimit     = 500
+)
+
+// RecordsAPI handles DMARC record API endpoints.
+type RecordsAPI struct {
+	DBRepo *db.Repository
+}
+
+// NewRecordsAPI creates a new RecordsAPI instance.
+func NewRecordsAPI(dbRepo *db.Repository) *RecordsAPI {
+	return &RecordsAPI{DBRepo: dbRepo}
+}
+
+// RegisterRecordRoutes registers the DMARC record API routes.
+func RegisterRecordRoutes(router *mux.Router, api *RecordsAPI) {
+	router.HandleFunc("/api/records", handle(api.GetRecords)).Methods("GET")
+	router.HandleFunc("/api/records/{id}/analyze", handle(api.AnalyzeRecord)).Methods("GET")
+}
+
+// parseRecordQuery reads the records view parameters: the record filter plus
+// aggregate (default true), sort_by, sort_order, limit and offset.
+func parseRecordQuery(r *http.Request) (db.RecordQuery, error) {
+	filter, err := parseRecordFilter(r)
+	if err != nil {
+		return db.RecordQuery{}, err
+	}
+	query := r.URL.Query()
+
+	q := db.RecordQuery{Filter: filter, Aggregate: true}
+	if value := query.Get("aggregate"); value != "" {
+		if q.Aggregate, err = strconv.ParseBool(value); err != nil {
+			return db.RecordQuery{}, fmt.Errorf("invalid aggregate %q, expected true or false", value)
+		}
+	}
+
+	if q.SortBy = query.Get("sort_by"); q.SortBy != "" && !db.IsValidRecordSort(q.SortBy, q.Aggregate) {
+		return db.RecordQuery{}, fmt.Errorf("invalid sort_by %q", q.SortBy)
+	}
+	switch q.SortOrder = strings.ToLower(query.Get("sort_order")); q.SortOrder {
+	case "", "asc", "desc":
+	default:
+		return db.RecordQuery{}, fmt.Errorf("invalid sort_order %q, expected asc or desc", q.SortOrder)
+	}
+
+	q.Limit, err = strconv.Atoi(query.Get("limit"))
+	if err != nil || q.Limit <= 0 {
+		q.Limit = defaultRecordsLimit
+	}
+	if q.Limit > maxRecordsLimit {
+		q.Limit = maxRecordsLimit
+	}
+	q.Offset, err = strconv.Atoi(query.Get("offset"))
+	if err != nil || q.Offset < 0 {
+		q.Offset = 0 // Default offset
+	}
+	return q, nil
+}
+
+// GetRecords handles the retrieval of the records view: individual records,
+// or with aggregate=true (the default) records combined across reports by
+// source IP, disposition, DKIM and SPF result and header From domain. With
+// format=csv, jsonl or xlsx, all matching rows are exported instead of a page.
+// Pages are selected by offset or, for deep paging, by the nextCursor of the
+// previous page passed as cursor (see parsePageParams).
+func (api *RecordsAPI) GetRecords(w http.ResponseWriter, r *http.Request) error {
+	q, err := parseRecordQuery(r)
+	if err != nil {
+		return util.BadRequest(err.Error())
+	}
+	format, err := exportFormat(r)
+	if err != nil {
+		return util.BadRequest(err.Error())
+	}
+	if format != "" {
+		return api.exportRecords(w, r, q, format)
+	}
+
+	page, err := parsePageParams(r, q.Offset)
+	if err != nil {
+		return util.BadRequest(err.Error())
+	}
+
+	// Fetch one extra row to find out whether there is a next page.
+	limit := q.Limit
+	q.Limit, q.After = limit+1, page.after
+	records, err := api.DBRepo.GetRecords(q)
+	if errors.Is(err, db.ErrInvalidCursor) {
+		return util.BadRequest(err.Error())
+	}
+	if err != nil {
+		return util.Internal("Failed to retrieve records", err)
+	}
+	q.Limit = limit
+
+	response := map[string]interface{}{
+		"aggregate": q.Aggregate,
+		"limit":     q.Limit,
+		"offset":    q.Offset,
+	}
+	if len(records) > limit {
+		records = records[:limit]
+		response["nextCursor"] = db.RecordCursor(&records[limit-1], q).Encode()
+	}
+	response["records"] = records
+	if page.includeTotal {
+		totalCount, err := api.DBRepo.CountRecords(q)
+		if err != nil {
+			return util.Internal("Failed to count records", err)
+		}
+		response["totalCount"] = totalCount
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	return nil
+}
+
+// AnalyzeRecord handles the retrieval of a record's analysis: the record with
+// its evaluated and raw auth results and IP information, the publishing
+// report's policy and, for an aggregated record ID, the contributing reports.
+// For aggregated records the record filter parameters select the reports to
+// combine, as in GetRecords. The response includes improvement advice in the
+// language given by lang or Accept-Language (en or ja).
+func (api *RecordsAPI) AnalyzeRecord(w http.ResponseWriter, r *http.Request) error {
+	recordID, key, err := db.ParseRecordID(mux.Vars(r)["id"])
+	if err != nil {
+		return util.BadRequest(err.Error())
+	}
+
+	var analysis *db.RecordAnalysis
+	if key != nil {
+		var filter db.RecordFilter
+		if filter, err = parseRecordFilter(r); err != nil {
+			return util.BadRequest(err.Error())
+		}
+		analysis, err = api.DBRepo.AnalyzeAggregatedRecord(*key, filter)
+	} else {
+		analysis, err = api.DBRepo.AnalyzeRecord(recordID)
+	}
+	if err != nil {
+		return util.Internal("Failed to retrieve record analysis", err)
+	}
+	if analysis == nil {
+		return util.NotFound("Record not found")
+	}
+
+	lang := advice.NegotiateLanguage(r.URL.Query().Get("lang"), r.Header.Get("Accept-Language"))
+	response := struct {
+		*db.RecordAnalysis
+		Language string           `json:"language"`
+		Advice   []advice.Finding `json:"advice"`
+	}{analysis, lang, advice.Evaluate(advice.InputFromAnalysis(analysis), lang)}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	return nil
+}
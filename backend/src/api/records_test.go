@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"dmarc-report-analyzer/backend/src/db"
+)
+
+func TestParseRecordQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  db.RecordQuery
+	}{
+		{"", db.RecordQuery{Aggregate: true, Limit: defaultRecordsLimit}},
+		{
+			"aggregate=false&sort_by=report_org_name&sort_order=ASC&limit=20&offset=40",
+			db.RecordQuery{SortBy: "report_org_name", SortOrder: "asc", Limit: 20, Offset: 40},
+		},
+		{
+			"sort_by=report_count&limit=100000&offset=-5&disposition=none",
+			db.RecordQuery{
+				Filter:    db.RecordFilter{Disposition: db.DispositionNone},
+				Aggregate: true, SortBy: "report_count", Limit: maxRecordsLimit,
+			},
+		},
+	}
+	for _, tt := range tests {
+		got, err := parseRecordQuery(httptest.NewRequest("GET", "/api/records?"+tt.query, nil))
+		if err != nil {
+			t.Errorf("parseRecordQuery(%q) error: %v", tt.query, err)
+			continue
+		}
+		if got.Filter != tt.want.Filter || got.Aggregate != tt.want.Aggregate || got.SortBy != tt.want.SortBy ||
+			got.SortOrder != tt.want.SortOrder || got.Limit != tt.want.Limit || got.Offset != tt.want.Offset {
+			t.Errorf("parseRecordQuery(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+
+	for _, query := range []string{
+		"aggregate=maybe",
+		"sort_by=unknown",
+		"sort_by=report_count&aggregate=false", // Aggregated rows only
+		"sort_by=report_org_name",              // Individual rows only
+		"sort_order=up",
+		"dmarc_result=unknown",
+	} {
+		if _, err := parseRecordQuery(httptest.NewRequest("GET", "/api/records?"+query, nil)); err == nil {
+			t.Errorf("parseRecordQuery(%q) accepted an invalid query", query)
+		}
+	}
+}
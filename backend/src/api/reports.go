@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -12,6 +13,7 @@ import (
 
 	"dmarc-report-analyzer/backend/src/core/parser"
 	"dmarc-report-analyzer/backend/src/db"
+	"dmarc-report-analyzer/backend/src/util"
 )
 
 // ReportsAPI handles DMARC report related API endpoints.
@@ -30,14 +32,27 @@ func NewReportsAPI(processor *parser.ReportProcessor, dbRepo *db.Repository) *Re
 
 // RegisterReportRoutes registers the DMARC report API routes.
 func RegisterReportRoutes(router *mux.Router, api *ReportsAPI) {
-	router.HandleFunc("/api/reports/upload", api.UploadReports).Methods("POST")
-	router.HandleFunc("/api/reports", api.GetReports).Methods("GET")
-	router.HandleFunc("/api/reports/{id}", api.GetReport).Methods("GET")
-	// TODO: Add other report related routes (e.g., /api/reports/summary)
+	router.HandleFunc("/api/reports/upload", requireRole(db.RoleAnalyst, handle(api.UploadReports))).Methods("POST")
+	router.HandleFunc("/api/reports/summary", handle(api.GetSummary)).Methods("GET")
+	router.HandleFunc("/api/reports", handle(api.GetReports)).Methods("GET")
+	router.HandleFunc("/api/reports", requireRole(db.RoleAdmin, handle(api.DeleteReports))).Methods("DELETE")
+	router.HandleFunc("/api/reports/{id}", handle(api.GetReport)).Methods("GET")
+	router.HandleFunc("/api/reports/{id}", requireRole(db.RoleAdmin, handle(api.DeleteReport))).Methods("DELETE")
 }
 
-// GetReports handles the retrieval of DMARC aggregate reports.
-func (api *ReportsAPI) GetReports(w http.ResponseWriter, r *http.Request) {
+// GetReports handles the retrieval of DMARC aggregate reports. With
+// format=csv, jsonl or xlsx, all reports matching the report filter parameters
+// are exported instead of a page being returned. Pages are selected by offset
+// or by the nextCursor of the previous page passed as cursor.
+func (api *ReportsAPI) GetReports(w http.ResponseWriter, r *http.Request) error {
+	format, err := exportFormat(r)
+	if err != nil {
+		return util.BadRequest(err.Error())
+	}
+	if format != "" {
+		return api.exportReports(w, r, format)
+	}
+
 	limitStr := r.URL.Query().Get("limit")
 	offsetStr := r.URL.Query().Get("offset")
 	sortBy := r.URL.Query().Get("sortBy")
@@ -60,68 +75,198 @@ func (api *ReportsAPI) GetReports(w http.ResponseWriter, r *http.Request) {
 		sortOrder = "desc" // Default sort order
 	}
 
-	reports, totalCount, err := api.DBRepo.GetReports(limit, offset, sortBy, sortOrder)
+	page, err := parsePageParams(r, offset)
 	if err != nil {
-		log.Printf("Error getting reports: %v", err)
-		http.Error(w, "Failed to retrieve reports", http.StatusInternalServerError)
-		return
+		return util.BadRequest(err.Error())
 	}
 
-	response := map[string]interface{}{
-		"reports": reports,
-		"totalCount": totalCount,
+	// Fetch one extra report to find out whether there is a next page.
+	reports, err := api.DBRepo.GetReports(db.ReportQuery{
+		SortBy:    sortBy,
+		SortOrder: sortOrder,
+		Limit:     limit + 1,
+		Offset:    offset,
+		After:     page.after,
+	})
+	if errors.Is(err, db.ErrInvalidCursor) {
+		return util.BadRequest(err.Error())
+	}
+	if err != nil {
+		return util.Internal("Failed to retrieve reports", err)
+	}
+
+	response := map[string]interface{}{}
+	if len(reports) > limit {
+		reports = reports[:limit]
+		response["nextCursor"] = db.ReportCursor(&reports[limit-1], sortBy, sortOrder).Encode()
+	}
+	response["reports"] = reports
+	if page.includeTotal {
+		totalCount, err := api.DBRepo.CountReports()
+		if err != nil {
+			return util.Internal("Failed to count reports", err)
+		}
+		response["totalCount"] = totalCount
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
+	return nil
+}
+
+// GetSummary handles the retrieval of the dashboard aggregates (totals,
+// disposition breakdown, timeseries and source IP, country, AS and domain
+// summaries) over the records matching the record filter parameters. With
+// format=csv, jsonl or xlsx, the summary section given by section is exported.
+func (api *ReportsAPI) GetSummary(w http.ResponseWriter, r *http.Request) error {
+	filter, err := parseRecordFilter(r)
+	if err != nil {
+		return util.BadRequest(err.Error())
+	}
+	format, err := exportFormat(r)
+	if err != nil {
+		return util.BadRequest(err.Error())
+	}
+	var section string
+	if format != "" {
+		if section, err = summarySection(r); err != nil {
+			return util.BadRequest(err.Error())
+		}
+	}
+
+	summary, err := api.DBRepo.GetDashboardSummary(filter)
+	if err != nil {
+		return util.Internal("Failed to retrieve dashboard summary", err)
+	}
+	if format != "" {
+		return api.exportSummary(w, r, summary, section, format)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+	return nil
 }
 
 // GetReport handles the retrieval of a single DMARC aggregate report by ID.
-func (api *ReportsAPI) GetReport(w http.ResponseWriter, r *http.Request) {
+func (api *ReportsAPI) GetReport(w http.ResponseWriter, r *http.Request) error {
 	vars := mux.Vars(r)
 	idStr := vars["id"]
 
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid report ID", http.StatusBadRequest)
-		return
+		return util.BadRequest("Invalid report ID")
 	}
 
 	report, err := api.DBRepo.GetReportByID(id)
 	if err != nil {
-		log.Printf("Error getting report by ID %d: %v", id, err)
-		http.Error(w, "Failed to retrieve report", http.StatusInternalServerError)
-		return
+		return util.Internal("Failed to retrieve report", err)
 	}
 
 	if report == nil {
-		http.Error(w, "Report not found", http.StatusNotFound)
-		return
+		return util.NotFound("Report not found")
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(report)
+	return nil
+}
+
+// DeleteReport handles the deletion of a single DMARC report with all of its records.
+func (api *ReportsAPI) DeleteReport(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	idStr := vars["id"]
+
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return util.BadRequest("Invalid report ID")
+	}
+
+	result, err := api.DBRepo.DeleteReport(id)
+	if err != nil {
+		return util.Internal("Failed to delete report", err)
+	}
+
+	if result == nil {
+		return util.NotFound("Report not found")
+	}
+
+	log.Printf("Deleted report %d (%d records)", id, result.Records)
+	recordAudit(api.DBRepo, r, "report.delete", fmt.Sprintf("report:%d", id), result)
+
+	response := map[string]interface{}{
+		"status":  "success",
+		"message": "Report deleted.",
+		"deleted": result,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	return nil
+}
+
+// DeleteReports handles bulk deletion of the reports matching the start_date,
+// end_date (YYYY-MM-DD), org_name and domain filters. At least one filter is
+// required. With dry_run=true the matching rows are only counted.
+func (api *ReportsAPI) DeleteReports(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+
+	filter, err := parseReportFilter(r)
+	if err != nil {
+		return util.BadRequest(err.Error())
+	}
+	if filter.IsEmpty() {
+		return util.BadRequest("At least one filter (start_date, end_date, org_name, domain) is required")
+	}
+	dryRun := query.Get("dry_run") == "true"
+
+	result, err := api.DBRepo.DeleteReports(filter, dryRun)
+	if err != nil {
+		return util.Internal("Failed to delete reports", err)
+	}
+
+	message := "Reports deleted."
+	if dryRun {
+		message = "Dry run: nothing was deleted."
+	} else {
+		log.Printf("Bulk deleted %d reports (%d records)", result.Reports, result.Records)
+		recordAudit(api.DBRepo, r, "report.bulk_delete", "reports", map[string]interface{}{
+			"filter": map[string]string{
+				"start_date": query.Get("start_date"),
+				"end_date":   query.Get("end_date"),
+				"org_name":   filter.OrgName,
+				"domain":     filter.Domain,
+			},
+			"deleted": result,
+		})
+	}
+
+	response := map[string]interface{}{
+		"status":  "success",
+		"message": message,
+		"dry_run": dryRun,
+		"deleted": result,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	return nil
 }
 
 // UploadReports handles the upload of DMARC aggregate reports.
-func (api *ReportsAPI) UploadReports(w http.ResponseWriter, r *http.Request) {
+func (api *ReportsAPI) UploadReports(w http.ResponseWriter, r *http.Request) error {
 	// Limit the size of the request body to prevent abuse
 	r.Body = http.MaxBytesReader(w, r.Body, 100<<20) // 100 MB limit
 
 	reader, err := r.MultipartReader()
 	if err != nil {
-		if err.Error() == "http: request body too large" {
-			http.Error(w, "Request body too large. Max 100MB.", http.StatusRequestEntityTooLarge)
-			return
-		}
-		http.Error(w, fmt.Sprintf("Failed to read multipart form: %v", err), http.StatusBadRequest)
-		return
+		return util.BadRequest(fmt.Sprintf("Failed to read multipart form: %v", err))
 	}
 
 	var totalProcessed int
 	var totalSkipped int
 	var totalFailed int
 	var fileErrors []map[string]string
+	var filenames []string
 
 	for {
 		part, err := reader.NextPart()
@@ -129,8 +274,7 @@ func (api *ReportsAPI) UploadReports(w http.ResponseWriter, r *http.Request) {
 			break // All parts read
 		}
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to read next part: %v", err), http.StatusInternalServerError)
-			return
+			return requestBodyError(fmt.Sprintf("Failed to read next part: %v", err), err)
 		}
 
 		if part.FileName() == "" {
@@ -138,6 +282,7 @@ func (api *ReportsAPI) UploadReports(w http.ResponseWriter, r *http.Request) {
 		}
 
 		log.Printf("Processing uploaded file: %s", part.FileName())
+		filenames = append(filenames, part.FileName())
 
 		// Process the file using the parser
 		ingestionErrors := api.Processor.ProcessUploadedFile(part, part.FileName())
@@ -166,6 +311,13 @@ func (api *ReportsAPI) UploadReports(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	recordAudit(api.DBRepo, r, "report.upload", "reports", map[string]interface{}{
+		"files":     filenames,
+		"processed": totalProcessed,
+		"skipped":   totalSkipped,
+		"failed":    totalFailed,
+	})
+
 	response := map[string]interface{}{
 		"status":           "success",
 		"message":          "Reports processing completed.",
@@ -177,4 +329,5 @@ func (api *ReportsAPI) UploadReports(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
+	return nil
 }
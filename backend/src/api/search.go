@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"dmarc-report-analyzer/backend/src/db"
+	"dmarc-report-analyzer/backend/src/util"
+)
+
+// maxSearchLimit caps the number of hits returned per entity type.
+const maxSearchLimit = 100
+
+// SearchAPI handles full-text search API endpoints.
+type SearchAPI struct {
+	DBRepo *db.Repository
+}
+
+// NewSearchAPI creates a new SearchAPI instance.
+func NewSearchAPI(dbRepo *db.Repository) *SearchAPI {
+	return &SearchAPI{DBRepo: dbRepo}
+}
+
+// RegisterSearchRoutes registers the search API routes.
+func RegisterSearchRoutes(router *mux.Router, api *SearchAPI) {
+	router.HandleFunc("/api/search", handle(api.Search)).Methods("GET")
+}
+
+// Search handles substring search over records, IP enrichment data and
+// reporters. Query parameters: q (required), type (record, ip or report;
+// optional), limit and offset (applied per entity type).
+func (api *SearchAPI) Search(w http.ResponseWriter, r *http.Request) error {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		return util.BadRequest("Query parameter q is required")
+	}
+
+	entityType := r.URL.Query().Get("type")
+	switch entityType {
+	case "", db.SearchEntityRecord, db.SearchEntityIP, db.SearchEntityReport:
+	default:
+		return util.BadRequest("Invalid type, expected record, ip or report")
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 20 // Default limit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0 // Default offset
+	}
+
+	groups, err := api.DBRepo.Search(query, entityType, limit, offset)
+	if err != nil {
+		return util.Internal("Failed to search", err)
+	}
+
+	response := map[string]interface{}{
+		"query":  query,
+		"limit":  limit,
+		"offset": offset,
+		"groups": groups,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	return nil
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"dmarc-report-analyzer/backend/src/core/settings"
+	"dmarc-report-analyzer/backend/src/db"
+	"dmarc-report-analyzer/backend/src/util"
+)
+
+// maxSettingsRequestSize limits the size of settings update requests.
+const maxSettingsRequestSize = 64 << 10 // 64 KB
+
+// SettingsAPI handles the application settings API endpoints.
+type SettingsAPI struct {
+	Settings *settings.Service
+	DBRepo   *db.Repository
+}
+
+// NewSettingsAPI creates a new SettingsAPI instance.
+func NewSettingsAPI(settingsService *settings.Service, dbRepo *db.Repository) *SettingsAPI {
+	return &SettingsAPI{Settings: settingsService, DBRepo: dbRepo}
+}
+
+// RegisterSettingsRoutes registers the settings API routes.
+func RegisterSettingsRoutes(router *mux.Router, api *SettingsAPI) {
+	router.HandleFunc("/api/settings", requireRole(db.RoleAdmin, handle(api.GetSettings))).Methods("GET")
+	router.HandleFunc("/api/settings", requireRole(db.RoleAdmin, handle(api.UpdateSettings))).Methods("PUT")
+}
+
+// GetSettings returns the current application settings.
+func (api *SettingsAPI) GetSettings(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(api.Settings.Get())
+	return nil
+}
+
+// UpdateSettings changes the settings given in the request body and returns
+// the resulting settings. Settings that are omitted keep their values.
+func (api *SettingsAPI) UpdateSettings(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxSettingsRequestSize)
+	updated := api.Settings.Get()
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&updated); err != nil {
+		return requestBodyError("Invalid request payload: "+err.Error(), err)
+	}
+
+	if err := api.Settings.Update(updated); err != nil {
+		if errors.Is(err, settings.ErrInvalid) {
+			return util.BadRequest(err.Error())
+		}
+		return util.Internal("Failed to save settings", err)
+	}
+	recordAudit(api.DBRepo, r, "settings.update", "settings", updated)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+	return nil
+}
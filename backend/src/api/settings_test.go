@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dmarc-report-analyzer/backend/src/core/settings"
+	"dmarc-report-analyzer/backend/src/db"
+	"dmarc-report-analyzer/backend/src/util"
+)
+
+func TestUpdateSettings(t *testing.T) {
+	database, err := db.InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	repo := db.NewRepository(database)
+	service, err := settings.NewService(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := handle(NewSettingsAPI(service, repo).UpdateSettings)
+
+	put := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		update(rec, httptest.NewRequest("PUT", "/api/settings", strings.NewReader(body)))
+		return rec
+	}
+
+	// Omitted settings keep their values
+	rec := put(`{"retention_days": 90}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	want := settings.Defaults()
+	want.RetentionDays = 90
+	var got settings.Settings
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != want || service.Get() != want {
+		t.Errorf("settings = %+v (response %+v), want %+v", service.Get(), got, want)
+	}
+
+	for _, body := range []string{
+		`{"dns_timeout_seconds": 0}`,
+		`{"retention_days": -1}`,
+		`{"retention_days": "90"}`,
+		`{"unknown_setting": true}`,
+		`not JSON`,
+	} {
+		rec := put(body)
+		var resp ErrorResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if rec.Code != http.StatusBadRequest || resp.Code != util.ErrCodeInvalidRequest {
+			t.Errorf("PUT %s: status = %d, code = %q, want 400 %s", body, rec.Code, resp.Code, util.ErrCodeInvalidRequest)
+		}
+		if service.Get() != want {
+			t.Errorf("PUT %s changed the settings to %+v", body, service.Get())
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"dmarc-report-analyzer/backend/src/auth"
+	"dmarc-report-analyzer/backend/src/db"
+	"dmarc-report-analyzer/backend/src/util"
+)
+
+// checkThrottle returns a 429 error, and sets Retry-After, if the client has
+// to wait before it may try the credentials of username again.
+func checkThrottle(w http.ResponseWriter, r *http.Request, s *auth.AuthService, username string) error {
+	wait := s.Throttle.Check(clientIP(r), username)
+	if wait <= 0 {
+		return nil
+	}
+	seconds := int((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	return util.TooManyRequests(fmt.Sprintf("Too many failed attempts; try again in %s", time.Duration(seconds)*time.Second))
+}
+
+// recordThrottledFailure counts a failed attempt with the credentials of
+// username and records the lockout of the account it may start.
+func recordThrottledFailure(dbRepo *db.Repository, r *http.Request, s *auth.AuthService, username string) {
+	if !s.Throttle.Failure(clientIP(r), username) {
+		return
+	}
+	log.Printf("Account %s locked after repeated failed attempts", username)
+	recordAuditAs(dbRepo, r, username, "auth.lockout", "user:"+username, nil)
+}
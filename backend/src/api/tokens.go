@@ -0,0 +1,191 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"dmarc-report-analyzer/backend/src/auth"
+	"dmarc-report-analyzer/backend/src/db"
+	"dmarc-report-analyzer/backend/src/util"
+)
+
+// TokensAPI handles the API endpoints for personal API tokens.
+type TokensAPI struct {
+	AuthService *auth.AuthService
+	DBRepo      *db.Repository
+}
+
+// NewTokensAPI creates a new TokensAPI instance.
+func NewTokensAPI(authService *auth.AuthService, dbRepo *db.Repository) *TokensAPI {
+	return &TokensAPI{
+		AuthService: authService,
+		DBRepo:      dbRepo,
+	}
+}
+
+// RegisterTokenRoutes registers the API token routes.
+func RegisterTokenRoutes(router *mux.Router, api *TokensAPI) {
+	router.HandleFunc("/api/tokens", handle(api.ListTokens)).Methods("GET")
+	router.HandleFunc("/api/tokens", handle(api.CreateToken)).Methods("POST")
+	router.HandleFunc("/api/tokens/{id}", handle(api.RevokeToken)).Methods("DELETE")
+	router.HandleFunc("/api/users/{id}/tokens", requireRole(db.RoleAdmin, handle(api.ListUserTokens))).Methods("GET")
+}
+
+// tokenResponse is the representation of an API token in API responses. The
+// token itself is only returned when it is created.
+type tokenResponse struct {
+	ID         int64    `json:"id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  int64    `json:"created_at"`
+	ExpiresAt  int64    `json:"expires_at"`
+	LastUsedAt *int64   `json:"last_used_at"`
+	Token      string   `json:"token,omitempty"`
+}
+
+// newTokenResponse converts an API token for a response, leaving out its hash.
+func newTokenResponse(token *db.APIToken) tokenResponse {
+	response := tokenResponse{
+		ID:        token.ID,
+		Name:      token.Name,
+		Prefix:    token.Prefix,
+		Scopes:    token.Scopes,
+		CreatedAt: token.CreatedAt,
+		ExpiresAt: token.ExpiresAt,
+	}
+	if token.LastUsedAt != 0 {
+		response.LastUsedAt = &token.LastUsedAt
+	}
+	return response
+}
+
+// writeTokens writes the API tokens of a user.
+func (api *TokensAPI) writeTokens(w http.ResponseWriter, userID int64) error {
+	tokens, err := api.DBRepo.ListAPITokens(userID)
+	if err != nil {
+		return util.Internal("Failed to list API tokens", err)
+	}
+
+	response := make([]tokenResponse, 0, len(tokens))
+	for i := range tokens {
+		response = append(response, newTokenResponse(&tokens[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"tokens": response})
+	return nil
+}
+
+// ListTokens returns the authenticated user's API tokens.
+func (api *TokensAPI) ListTokens(w http.ResponseWriter, r *http.Request) error {
+	user := UserFromContext(r.Context())
+	if user == nil {
+		return util.Unauthorized("Authentication required")
+	}
+	return api.writeTokens(w, user.ID)
+}
+
+// ListUserTokens returns the API tokens of any user.
+func (api *TokensAPI) ListUserTokens(w http.ResponseWriter, r *http.Request) error {
+	id, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+	user, err := api.DBRepo.GetUserByID(id)
+	if err != nil {
+		return util.Internal("Failed to list API tokens", err)
+	}
+	if user == nil {
+		return util.NotFound("User not found")
+	}
+	return api.writeTokens(w, user.ID)
+}
+
+// CreateToken creates an API token for the authenticated user. Tokens cannot
+// be created with another API token, so that a leaked token cannot be used to
+// outlive its revocation.
+func (api *TokensAPI) CreateToken(w http.ResponseWriter, r *http.Request) error {
+	user := UserFromContext(r.Context())
+	if user == nil {
+		return util.Unauthorized("Authentication required")
+	}
+	if APITokenFromContext(r.Context()) != nil {
+		return util.Forbidden("API tokens cannot be used to create API tokens")
+	}
+
+	req := struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}{ExpiresInDays: auth.DefaultAPITokenDays}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return util.BadRequest("Invalid request payload")
+	}
+
+	token, record, err := api.AuthService.CreateAPIToken(user, req.Name, req.Scopes, req.ExpiresInDays)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidTokenRequest) {
+			return util.BadRequest(err.Error())
+		}
+		return util.Internal("Failed to create API token", err)
+	}
+	recordAudit(api.DBRepo, r, "token.create", "user:"+user.Username, map[string]interface{}{
+		"token_id":   record.ID,
+		"name":       record.Name,
+		"scopes":     record.Scopes,
+		"expires_at": record.ExpiresAt,
+	})
+
+	response := newTokenResponse(record)
+	response.Token = token
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+	return nil
+}
+
+// RevokeToken deletes an API token. Users may revoke their own tokens and
+// admins any token.
+func (api *TokensAPI) RevokeToken(w http.ResponseWriter, r *http.Request) error {
+	user := UserFromContext(r.Context())
+	if user == nil {
+		return util.Unauthorized("Authentication required")
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return util.BadRequest("Invalid token ID")
+	}
+
+	token, err := api.DBRepo.GetAPITokenByID(id)
+	if err != nil {
+		return util.Internal("Failed to revoke API token", err)
+	}
+	// Other users' tokens are reported as missing rather than forbidden, so
+	// that their IDs are not disclosed.
+	if token == nil || (token.UserID != user.ID && !auth.HasRole(user.Role, db.RoleAdmin)) {
+		return util.NotFound("API token not found")
+	}
+
+	if err := api.DBRepo.DeleteAPIToken(id); err != nil {
+		if errors.Is(err, db.ErrAPITokenNotFound) {
+			return util.NotFound("API token not found")
+		}
+		return util.Internal("Failed to revoke API token", err)
+	}
+	recordAudit(api.DBRepo, r, "token.revoke", "token:"+strconv.FormatInt(id, 10), map[string]interface{}{
+		"user_id": token.UserID,
+		"name":    token.Name,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "API token revoked.",
+	})
+	return nil
+}
@@ -0,0 +1,239 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"dmarc-report-analyzer/backend/src/auth"
+	"dmarc-report-analyzer/backend/src/db"
+	"dmarc-report-analyzer/backend/src/util"
+)
+
+// TOTPAPI handles the API endpoints for TOTP two-factor authentication.
+type TOTPAPI struct {
+	AuthService *auth.AuthService
+	DBRepo      *db.Repository
+}
+
+// NewTOTPAPI creates a new TOTPAPI instance.
+func NewTOTPAPI(authService *auth.AuthService, dbRepo *db.Repository) *TOTPAPI {
+	return &TOTPAPI{
+		AuthService: authService,
+		DBRepo:      dbRepo,
+	}
+}
+
+// RegisterTOTPRoutes registers the TOTP API routes. The second login step is
+// registered with the other authentication routes.
+func RegisterTOTPRoutes(router *mux.Router, api *TOTPAPI) {
+	router.HandleFunc("/api/auth/totp", handle(api.Status)).Methods("GET")
+	router.HandleFunc("/api/auth/totp/enrol", handle(api.Enrol)).Methods("POST")
+	router.HandleFunc("/api/auth/totp/confirm", handle(api.Confirm)).Methods("POST")
+	router.HandleFunc("/api/auth/totp/disable", handle(api.Disable)).Methods("POST")
+	router.HandleFunc("/api/auth/totp/recovery-codes", handle(api.RegenerateRecoveryCodes)).Methods("POST")
+	router.HandleFunc("/api/users/{id}/totp", requireRole(db.RoleAdmin, handle(api.ResetUserTOTP))).Methods("DELETE")
+}
+
+// checkPassword reads a body with the authenticated user's password and
+// checks it, for changes that a stolen access token must not allow. Wrong
+// passwords count towards the login throttling of the user.
+func (api *TOTPAPI) checkPassword(w http.ResponseWriter, r *http.Request, user *db.User) error {
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return util.BadRequest("Invalid request payload")
+	}
+	if err := checkThrottle(w, r, api.AuthService, user.Username); err != nil {
+		return err
+	}
+	if !api.AuthService.CheckPasswordHash(req.Password, user.PasswordHash) {
+		recordThrottledFailure(api.DBRepo, r, api.AuthService, user.Username)
+		return util.BadRequest("Password is incorrect")
+	}
+	return nil
+}
+
+// writeRecoveryCodes writes newly issued recovery codes.
+func writeRecoveryCodes(w http.ResponseWriter, codes []string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"recovery_codes": codes})
+}
+
+// Status returns whether the authenticated user has TOTP enabled, whether
+// they must enable it, and how many recovery codes they have left.
+func (api *TOTPAPI) Status(w http.ResponseWriter, r *http.Request) error {
+	user := UserFromContext(r.Context())
+	if user == nil {
+		return util.Unauthorized("Authentication required")
+	}
+	remaining := 0
+	if user.TOTPEnabled {
+		codes, err := api.DBRepo.UnusedRecoveryCodes(user.ID)
+		if err != nil {
+			return util.Internal("Failed to read TOTP status", err)
+		}
+		remaining = len(codes)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":                  user.TOTPEnabled,
+		"enrolment_required":       api.AuthService.TOTPEnrolmentRequired(user),
+		"recovery_codes_remaining": remaining,
+	})
+	return nil
+}
+
+// Enrol starts TOTP enrolment for the authenticated user and returns the
+// secret as a provisioning URI and QR code. It requires their password. TOTP
+// is enabled once a code is confirmed; enrolling again before that replaces
+// the secret.
+func (api *TOTPAPI) Enrol(w http.ResponseWriter, r *http.Request) error {
+	user := UserFromContext(r.Context())
+	if user == nil {
+		return util.Unauthorized("Authentication required")
+	}
+	if user.UsesSingleSignOn() {
+		return util.BadRequest("Two-factor authentication of this account is managed by the identity provider")
+	}
+	if err := api.checkPassword(w, r, user); err != nil {
+		return err
+	}
+	if user.TOTPEnabled {
+		return util.NewAppError("Two-factor authentication is already enabled; disable it first", http.StatusConflict, nil)
+	}
+
+	enrolment, err := api.AuthService.BeginTOTPEnrolment(user)
+	if err != nil {
+		return util.Internal("Failed to start TOTP enrolment", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":           enrolment.Secret,
+		"provisioning_uri": enrolment.ProvisioningURI,
+		"qr_code":          enrolment.QRCode,
+	})
+	return nil
+}
+
+// Confirm enables TOTP for the authenticated user with a code from their
+// authenticator, and returns their recovery codes. They are only shown once.
+func (api *TOTPAPI) Confirm(w http.ResponseWriter, r *http.Request) error {
+	user := UserFromContext(r.Context())
+	if user == nil {
+		return util.Unauthorized("Authentication required")
+	}
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return util.BadRequest("Invalid request payload")
+	}
+	if user.TOTPEnabled {
+		return util.NewAppError("Two-factor authentication is already enabled", http.StatusConflict, nil)
+	}
+	if user.TOTPSecret == "" {
+		return util.BadRequest("Start enrolment first")
+	}
+
+	codes, err := api.AuthService.ConfirmTOTP(user, req.Code)
+	if err != nil {
+		return util.Internal("Failed to enable TOTP", err)
+	}
+	if codes == nil {
+		return util.BadRequest("Invalid code")
+	}
+	recordAudit(api.DBRepo, r, "totp.enable", "user:"+user.Username, nil)
+
+	writeRecoveryCodes(w, codes)
+	return nil
+}
+
+// Disable turns off TOTP for the authenticated user. It requires their
+// password, and is refused to admins while TOTP is required for them.
+func (api *TOTPAPI) Disable(w http.ResponseWriter, r *http.Request) error {
+	user := UserFromContext(r.Context())
+	if user == nil {
+		return util.Unauthorized("Authentication required")
+	}
+	if err := api.checkPassword(w, r, user); err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return util.BadRequest("Two-factor authentication is not enabled")
+	}
+	disabled := *user
+	disabled.TOTPEnabled = false
+	if api.AuthService.TOTPEnrolmentRequired(&disabled) {
+		return util.Forbidden("Two-factor authentication is required for admins")
+	}
+
+	if err := api.DBRepo.DisableTOTP(user.ID); err != nil {
+		return util.Internal("Failed to disable TOTP", err)
+	}
+	recordAudit(api.DBRepo, r, "totp.disable", "user:"+user.Username, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "Two-factor authentication disabled.",
+	})
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the authenticated user's recovery codes.
+// It requires their password.
+func (api *TOTPAPI) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) error {
+	user := UserFromContext(r.Context())
+	if user == nil {
+		return util.Unauthorized("Authentication required")
+	}
+	if err := api.checkPassword(w, r, user); err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return util.BadRequest("Two-factor authentication is not enabled")
+	}
+
+	codes, err := api.AuthService.RegenerateRecoveryCodes(user)
+	if err != nil {
+		return util.Internal("Failed to generate recovery codes", err)
+	}
+	recordAudit(api.DBRepo, r, "totp.recovery_codes", "user:"+user.Username, nil)
+
+	writeRecoveryCodes(w, codes)
+	return nil
+}
+
+// ResetUserTOTP turns off TOTP for any user, for example after they lost
+// their authenticator and recovery codes. If TOTP is required for them, they
+// have to enrol again at their next login.
+func (api *TOTPAPI) ResetUserTOTP(w http.ResponseWriter, r *http.Request) error {
+	id, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+	user, err := api.DBRepo.GetUserByID(id)
+	if err != nil {
+		return util.Internal("Failed to reset TOTP", err)
+	}
+	if user == nil {
+		return util.NotFound("User not found")
+	}
+
+	if err := api.DBRepo.DisableTOTP(user.ID); err != nil {
+		return util.Internal("Failed to reset TOTP", err)
+	}
+	recordAudit(api.DBRepo, r, "totp.reset", "user:"+user.Username, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "Two-factor authentication reset.",
+	})
+	return nil
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dmarc-report-analyzer/backend/src/auth"
+	"dmarc-report-analyzer/backend/src/db"
+
+	"github.com/gorilla/mux"
+)
+
+func TestEnrolRequiresPassword(t *testing.T) {
+	database, err := db.InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	repo := db.NewRepository(database)
+	authService := auth.NewAuthService(repo, "totp-test-secret-0123456789abcdef")
+	user, err := repo.CreateUser("alice", "password-1234", db.RoleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := authService.StartSession(user, "agent", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	RegisterTOTPRoutes(router, NewTOTPAPI(authService, repo))
+	handler := Recover(Authenticate(authService)(router))
+	enrol := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/auth/totp/enrol", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, body := range []string{"", `{"password": "wrong"}`} {
+		if rec := enrol(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("enrol with %q: status = %d, want 400", body, rec.Code)
+		}
+	}
+	// The wrong password counts towards the login throttling
+	if rec := enrol(`{"password": "password-1234"}`); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("enrol right after a wrong password: status = %d, want 429", rec.Code)
+	}
+	if stored, err := repo.GetUserByID(user.ID); err != nil || stored.TOTPSecret != "" {
+		t.Fatalf("TOTP secret after refused enrolments = %q, %v, want none", stored.TOTPSecret, err)
+	}
+
+	authService.Throttle = auth.NewLoginThrottle(auth.DefaultThrottleConfig) // Forget the failure
+	rec := enrol(`{"password": "password-1234"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("enrol with the password: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var enrolment struct {
+		Secret string `json:"secret"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&enrolment); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := repo.GetUserByID(user.ID); err != nil || stored.TOTPSecret != enrolment.Secret || stored.TOTPEnabled {
+		t.Errorf("user after enrolment = %+v, %v, want the returned secret stored and TOTP still disabled", stored, err)
+	}
+}
@@ -2,13 +2,18 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 
 	"dmarc-report-analyzer/backend/src/auth"
 	"dmarc-report-analyzer/backend/src/db"
+	"dmarc-report-analyzer/backend/src/util"
 )
 
 // UsersAPI handles user related API endpoints.
@@ -27,54 +32,325 @@ func NewUsersAPI(authService *auth.AuthService, dbRepo *db.Repository) *UsersAPI
 
 // RegisterUserRoutes registers the user API routes.
 func RegisterUserRoutes(router *mux.Router, api *UsersAPI) {
-	// This route should be protected by authentication middleware
-	router.HandleFunc("/api/users/change-password", api.ChangePassword).Methods("POST")
+	router.HandleFunc("/api/users/change-password", handle(api.ChangePassword)).Methods("POST")
+	router.HandleFunc("/api/users", requireRole(db.RoleAdmin, handle(api.ListUsers))).Methods("GET")
+	router.HandleFunc("/api/users", requireRole(db.RoleAdmin, handle(api.CreateUser))).Methods("POST")
+	router.HandleFunc("/api/users/{id}", requireRole(db.RoleAdmin, handle(api.UpdateUser))).Methods("PATCH")
+	router.HandleFunc("/api/users/{id}", requireRole(db.RoleAdmin, handle(api.DeleteUser))).Methods("DELETE")
+	router.HandleFunc("/api/users/{id}/reset-password", requireRole(db.RoleAdmin, handle(api.ResetPassword))).Methods("POST")
 }
 
-// ChangePassword handles changing a user's own password.
-func (api *UsersAPI) ChangePassword(w http.ResponseWriter, r *http.Request) {
-	// TODO: Implement authentication middleware to get the authenticated user's ID/username
-	// For now, we'll assume the username is passed in the request body for simplicity
+// userResponse is the representation of a user in API responses.
+type userResponse struct {
+	ID                 int64  `json:"id"`
+	Username           string `json:"username"`
+	Role               string `json:"role"`
+	Disabled           bool   `json:"disabled"`
+	MustChangePassword bool   `json:"must_change_password"`
+	TOTPEnabled        bool   `json:"totp_enabled"`
+	AuthSource         string `json:"auth_source"`
+	CreatedAt          int64  `json:"created_at"`
+}
+
+// newUserResponse converts a user for a response, leaving out the password hash.
+func newUserResponse(user *db.User) userResponse {
+	return userResponse{
+		ID:                 user.ID,
+		Username:           user.Username,
+		Role:               user.Role,
+		Disabled:           user.Disabled,
+		MustChangePassword: user.MustChangePassword,
+		TOTPEnabled:        user.TOTPEnabled,
+		AuthSource:         user.AuthSource,
+		CreatedAt:          user.CreatedAt,
+	}
+}
+
+// parseUserID reads the {id} path variable.
+func parseUserID(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return 0, util.BadRequest("Invalid user ID")
+	}
+	return id, nil
+}
+
+// userChangeError maps the errors of user changes to API errors.
+func userChangeError(err error) error {
+	switch {
+	case errors.Is(err, db.ErrUserNotFound):
+		return util.NotFound("User not found")
+	case errors.Is(err, db.ErrLastAdmin):
+		return util.NewAppError("The last enabled admin cannot be removed, disabled or demoted", http.StatusConflict, nil)
+	case errors.Is(err, db.ErrUsernameTaken):
+		return util.NewAppError("Username already exists", http.StatusConflict, nil)
+	}
+	var appErr *util.AppError
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	return util.Internal("Failed to change user", err)
+}
+
+// weakPasswordError returns the error of a password rejected by the password
+// policy with err.
+func weakPasswordError(err error) error {
+	message := err.Error()
+	return &util.AppError{
+		Message: strings.ToUpper(message[:1]) + message[1:],
+		Code:    http.StatusBadRequest,
+		Kind:    util.ErrCodeWeakPassword,
+	}
+}
+
+// validRoleError returns a BadRequest error unless role is a valid role.
+func validRoleError(role string) error {
+	if !db.IsValidRole(role) {
+		return util.BadRequest("Role must be one of " + strings.Join(db.Roles, ", "))
+	}
+	return nil
+}
+
+// ListUsers returns every user.
+func (api *UsersAPI) ListUsers(w http.ResponseWriter, r *http.Request) error {
+	users, err := api.DBRepo.ListUsers()
+	if err != nil {
+		return util.Internal("Failed to list users", err)
+	}
+
+	response := make([]userResponse, 0, len(users))
+	for i := range users {
+		response = append(response, newUserResponse(&users[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"users": response})
+	return nil
+}
+
+// CreateUser creates a user. With must_change_password the user has to
+// choose a new password after logging in.
+func (api *UsersAPI) CreateUser(w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		Username           string `json:"username"`
+		Password           string `json:"password"`
+		Role               string `json:"role"`
+		MustChangePassword bool   `json:"must_change_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return util.BadRequest("Invalid request payload")
+	}
+	if strings.TrimSpace(req.Username) == "" || req.Password == "" {
+		return util.BadRequest("Username and password are required")
+	}
+	if err := validRoleError(req.Role); err != nil {
+		return err
+	}
+	if err := api.AuthService.PasswordPolicy.Check(req.Username, req.Password); err != nil {
+		return weakPasswordError(err)
+	}
+
+	user, err := api.DBRepo.CreateUser(req.Username, req.Password, req.Role)
+	if err != nil {
+		return userChangeError(err)
+	}
+	if req.MustChangePassword {
+		user.MustChangePassword = true
+		if err := api.DBRepo.UpdateUser(user); err != nil {
+			return util.Internal("Failed to create user", err)
+		}
+	}
+	recordAudit(api.DBRepo, r, "user.create", "user:"+user.Username, map[string]interface{}{
+		"role":                 user.Role,
+		"must_change_password": user.MustChangePassword,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newUserResponse(user))
+	return nil
+}
+
+// UpdateUser changes the role of a user or disables or enables them. Fields
+// that are omitted keep their values.
+func (api *UsersAPI) UpdateUser(w http.ResponseWriter, r *http.Request) error {
+	id, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+	var req struct {
+		Role     *string `json:"role,omitempty"`
+		Disabled *bool   `json:"disabled,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return util.BadRequest("Invalid request payload")
+	}
+	if req.Role != nil {
+		if err := validRoleError(*req.Role); err != nil {
+			return err
+		}
+	}
+
+	user, err := api.DBRepo.ChangeUser(id, func(user *db.User) error {
+		if req.Role != nil {
+			user.Role = *req.Role
+		}
+		if req.Disabled != nil {
+			user.Disabled = *req.Disabled
+		}
+		return nil
+	})
+	if err != nil {
+		return userChangeError(err)
+	}
+	if user.Disabled {
+		api.revokeSessions(user, 0)
+	}
+	recordAudit(api.DBRepo, r, "user.update", "user:"+user.Username, req)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newUserResponse(user))
+	return nil
+}
+
+// ResetPassword sets a new password for a user. Unless must_change_password
+// is false, the user has to choose a new password after logging in.
+func (api *UsersAPI) ResetPassword(w http.ResponseWriter, r *http.Request) error {
+	id, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+	req := struct {
+		Password           string `json:"password"`
+		MustChangePassword bool   `json:"must_change_password"`
+	}{MustChangePassword: true}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return util.BadRequest("Invalid request payload")
+	}
+	if req.Password == "" {
+		return util.BadRequest("Password is required")
+	}
+
+	hashedPassword, err := api.AuthService.HashPassword(req.Password)
+	if err != nil {
+		return util.Internal("Failed to reset password", err)
+	}
+	user, err := api.DBRepo.ChangeUser(id, func(user *db.User) error {
+		if !user.IsLocal() {
+			return util.BadRequest("The password of this account is managed by its identity provider or directory")
+		}
+		if err := api.AuthService.PasswordPolicy.Check(user.Username, req.Password); err != nil {
+			return weakPasswordError(err)
+		}
+		user.PasswordHash = hashedPassword
+		user.MustChangePassword = req.MustChangePassword
+		return nil
+	})
+	if err != nil {
+		return userChangeError(err)
+	}
+	api.revokeSessions(user, 0)
+	recordAudit(api.DBRepo, r, "user.reset_password", "user:"+user.Username, map[string]bool{
+		"must_change_password": user.MustChangePassword,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newUserResponse(user))
+	return nil
+}
+
+// DeleteUser deletes a user.
+func (api *UsersAPI) DeleteUser(w http.ResponseWriter, r *http.Request) error {
+	id, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+	user, err := api.DBRepo.GetUserByID(id)
+	if err != nil {
+		return util.Internal("Failed to delete user", err)
+	}
+	if user == nil {
+		return util.NotFound("User not found")
+	}
+
+	if err := api.DBRepo.DeleteUser(id); err != nil {
+		return userChangeError(err)
+	}
+	recordAudit(api.DBRepo, r, "user.delete", "user:"+user.Username, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "User deleted.",
+	})
+	return nil
+}
+
+// revokeSessions logs a user out of every session except keep (0 for all),
+// after a password change or when they are disabled. API tokens are revoked
+// separately. A failure is logged; the change itself has been made.
+func (api *UsersAPI) revokeSessions(user *db.User, keep int64) {
+	if _, err := api.DBRepo.DeleteUserSessions(user.ID, keep); err != nil {
+		log.Printf("Failed to revoke sessions of user %s: %v", user.Username, err)
+	}
+}
+
+// ChangePassword handles changing the authenticated user's own password. It
+// also clears a pending forced password change and ends the user's other
+// sessions.
+func (api *UsersAPI) ChangePassword(w http.ResponseWriter, r *http.Request) error {
+	user := UserFromContext(r.Context())
+	if user == nil {
+		return util.Unauthorized("Authentication required")
+	}
 
 	var req struct {
-		Username    string `json:"username"`
 		OldPassword string `json:"old_password"`
 		NewPassword string `json:"new_password"`
 	}
 
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
-		return
+		return util.BadRequest("Invalid request payload")
 	}
-
-	user, err := api.DBRepo.GetUserByUsername(req.Username)
-	if err != nil {
-		log.Printf("Error getting user %s: %v", req.Username, err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+	if !user.IsLocal() {
+		return util.BadRequest("The password of this account is managed by its identity provider or directory")
+	}
+	if req.NewPassword == "" {
+		return util.BadRequest("New password must not be empty")
 	}
 
-	if user == nil || !api.AuthService.CheckPasswordHash(req.OldPassword, user.PasswordHash) {
-		http.Error(w, "Invalid username or old password", http.StatusUnauthorized)
-		return
+	if err := checkThrottle(w, r, api.AuthService, user.Username); err != nil {
+		return err
+	}
+	if !api.AuthService.CheckPasswordHash(req.OldPassword, user.PasswordHash) {
+		recordThrottledFailure(api.DBRepo, r, api.AuthService, user.Username)
+		return util.BadRequest("Old password is incorrect")
+	}
+	if req.NewPassword == req.OldPassword {
+		return util.BadRequest("New password must differ from the old password")
+	}
+	if err := api.AuthService.PasswordPolicy.Check(user.Username, req.NewPassword); err != nil {
+		return weakPasswordError(err)
 	}
 
 	hashedNewPassword, err := api.AuthService.HashPassword(req.NewPassword)
 	if err != nil {
-		log.Printf("Error hashing new password for user %s: %v", req.Username, err)
-		http.Error(w, "Failed to change password", http.StatusInternalServerError)
-		return
+		return util.Internal("Failed to change password", fmt.Errorf("failed to hash new password for user %s: %w", user.Username, err))
 	}
 
-	user.PasswordHash = hashedNewPassword
-	err = api.DBRepo.UpdateUser(user)
+	_, err = api.DBRepo.ChangeUser(user.ID, func(user *db.User) error {
+		user.PasswordHash = hashedNewPassword
+		user.MustChangePassword = false
+		return nil
+	})
 	if err != nil {
-		log.Printf("Error updating password for user %s: %v", req.Username, err)
-		http.Error(w, "Failed to change password", http.StatusInternalServerError)
-		return
+		return util.Internal("Failed to change password", fmt.Errorf("failed to update password for user %s: %w", user.Username, err))
 	}
+	api.revokeSessions(user, SessionIDFromContext(r.Context()))
+	recordAudit(api.DBRepo, r, "user.change_password", "user:"+user.Username, nil)
 
-	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Password changed successfully"})
+	return nil
 }
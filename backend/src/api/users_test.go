@@ -0,0 +1,64 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dmarc-report-analyzer/backend/src/auth"
+	"dmarc-report-analyzer/backend/src/db"
+
+	"github.com/gorilla/mux"
+)
+
+func TestChangePasswordEndsOtherSessions(t *testing.T) {
+	database, err := db.InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	repo := db.NewRepository(database)
+	authService := auth.NewAuthService(repo, "password-test-secret-0123456789abcdef")
+	user, err := repo.CreateUser("alice", "old-password-1234", db.RoleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	RegisterUserRoutes(router, NewUsersAPI(authService, repo))
+	handler := Recover(Authenticate(authService)(router))
+
+	current, err := authService.StartSession(user, "current", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := authService.StartSession(user, "other", "192.0.2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"old_password": "old-password-1234", "new_password": "new-password-5678"}`
+	req := httptest.NewRequest("POST", "/api/users/change-password", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+current.AccessToken)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	if _, err := authService.ValidateJWT(current.AccessToken); err != nil {
+		t.Errorf("access token of the current session: %v", err)
+	}
+	if _, _, err := authService.RefreshSession(current.RefreshToken, "current", "192.0.2.1"); err != nil {
+		t.Errorf("refresh of the current session: %v", err)
+	}
+	if _, err := authService.ValidateJWT(other.AccessToken); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("access token of another session: error = %v, want ErrInvalidToken", err)
+	}
+	if _, _, err := authService.RefreshSession(other.RefreshToken, "other", "192.0.2.2"); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("refresh of another session: error = %v, want ErrInvalidToken", err)
+	}
+}
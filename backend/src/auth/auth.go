@@ -1,8 +1,10 @@
 package auth
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -11,9 +13,15 @@ import (
 	"dmarc-report-analyzer/backend/src/db"
 )
 
-// Claims defines the JWT claims structure.
+// ErrInvalidToken is returned for access tokens that are malformed, expired
+// or belong to a session that has ended.
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// Claims defines the JWT claims structure. The JWT ID (jti) is the ID of the
+// session the token was issued for.
 type Claims struct {
 	Username string `json:"username"`
+	Role     string `json:"role"` // Informational; authorization uses the stored role
 	jwt.RegisteredClaims
 }
 
@@ -21,14 +29,31 @@ type Claims struct {
 type AuthService struct {
 	DBRepo *db.Repository
 	JWTSecret []byte
+
+	// OIDC is the single sign-on provider, nil if none is configured.
+	OIDC *OIDCProvider
+	// PasswordLoginDisabled refuses password logins, for installations
+	// that require single sign-on.
+	PasswordLoginDisabled bool
+	// Throttle slows down password and second factor guessing.
+	Throttle *LoginThrottle
+	// PasswordPolicy decides which passwords local accounts may have.
+	PasswordPolicy *PasswordPolicy
+
+	adminTOTPRequired atomic.Bool             // See SetAdminTOTPRequired
+	authenticators    []PasswordAuthenticator // See AddAuthenticator
 }
 
 // NewAuthService creates a new AuthService instance.
 func NewAuthService(dbRepo *db.Repository, jwtSecret string) *AuthService {
-	return &AuthService{
+	s := &AuthService{
 		DBRepo: dbRepo,
 		JWTSecret: []byte(jwtSecret),
+		Throttle: NewLoginThrottle(DefaultThrottleConfig),
+		PasswordPolicy: NewPasswordPolicy(DefaultPasswordMinLength),
 	}
+	s.AddAuthenticator(&LocalAuthenticator{auth: s})
+	return s
 }
 
 // HashPassword hashes a plain text password using bcrypt.
@@ -42,19 +67,16 @@ func (s *AuthService) HashPassword(password string) (string, error) {
 
 // CheckPasswordHash compares a plain text password with a hashed password.
 func (s *AuthService) CheckPasswordHash(password, hash string) bool {
-	log.Printf("Comparing password (plain): %s with hash: %s", password, hash)
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	if err != nil {
-		log.Printf("Password comparison failed: %v", err)
-	}
-	return err == nil
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
 }
 
-// GenerateJWT generates a new JWT token for a given username.
-func (s *AuthService) GenerateJWT(username string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour) // Token valid for 24 hours
+// GenerateJWT generates a new access token for a given username and role in
+// the given session. It is valid for AccessTokenLifetime.
+func (s *AuthService) GenerateJWT(username, role string, sessionID int64) (string, error) {
+	expirationTime := time.Now().Add(AccessTokenLifetime)
 	claims := &Claims{
 		Username: username,
+		Role:     role,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -62,6 +84,7 @@ func (s *AuthService) GenerateJWT(username string) (string, error) {
 			Issuer:    "dmarc-report-analyzer",
 			Subject:   username,
 			Audience:  []string{"users"},
+			ID:        strconv.FormatInt(sessionID, 10),
 		},
 	}
 
@@ -74,21 +97,49 @@ func (s *AuthService) GenerateJWT(username string) (string, error) {
 	return tokenString, nil
 }
 
-// ValidateJWT validates a JWT token and returns the claims if valid.
+// ValidateJWT validates a JWT token and returns the claims if valid. Tokens
+// whose session has ended, by logout or revocation, fail with ErrInvalidToken
+// like expired ones; other errors are internal.
 func (s *AuthService) ValidateJWT(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
 		return s.JWTSecret, nil
-	})
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer("dmarc-report-analyzer"),
+		jwt.WithAudience("users"))
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse token: %w", err)
+		return nil, fmt.Errorf("%w: failed to parse token: %v", ErrInvalidToken, err)
 	}
 
 	if !token.Valid {
-		return nil, fmt.Errorf("invalid token")
+		return nil, ErrInvalidToken
+	}
+
+	sessionID, err := strconv.ParseInt(claims.ID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: no session ID", ErrInvalidToken)
+	}
+	active, err := s.DBRepo.IsSessionActive(sessionID, claims.Username, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, fmt.Errorf("%w: session has ended", ErrInvalidToken)
 	}
 
 	return claims, nil
-}
\ No newline at end of file
+}
+
+// roleRanks orders the roles by privilege.
+var roleRanks = map[string]int{
+	db.RoleViewer:  1,
+	db.RoleAnalyst: 2,
+	db.RoleAdmin:   3,
+}
+
+// HasRole reports whether a user with role may act with the required role.
+// Unknown roles have no privileges.
+func HasRole(role, required string) bool {
+	return roleRanks[role] > 0 && roleRanks[role] >= roleRanks[required]
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"dmarc-report-analyzer/backend/src/db"
+)
+
+var (
+	// ErrInvalidCredentials is returned for wrong passwords and unknown users.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrLoginDenied is returned for logins of identities that may not use
+	// the application, for example because no role is mapped to them.
+	ErrLoginDenied = errors.New("login denied")
+)
+
+// Identity is a user authenticated by an identity provider or directory.
+type Identity struct {
+	Subject  string // Stable ID at the provider, stored as the user's external ID
+	Username string
+	Role     string
+}
+
+// PasswordAuthenticator verifies passwords for the users of one
+// authentication source.
+type PasswordAuthenticator interface {
+	// Source is the auth_source of the users it authenticates.
+	Source() string
+	// Authenticate verifies the password of username and returns their
+	// identity. Wrong passwords and unknown users fail with
+	// ErrInvalidCredentials; users it may not let in with ErrLoginDenied.
+	Authenticate(ctx context.Context, username, password string) (*Identity, error)
+}
+
+// LocalAuthenticator verifies passwords against the bcrypt hashes of local
+// users.
+type LocalAuthenticator struct {
+	auth *AuthService
+}
+
+// Source implements PasswordAuthenticator.
+func (a *LocalAuthenticator) Source() string {
+	return db.AuthSourceLocal
+}
+
+// Authenticate implements PasswordAuthenticator.
+func (a *LocalAuthenticator) Authenticate(ctx context.Context, username, password string) (*Identity, error) {
+	user, err := a.auth.DBRepo.GetUserByUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || !user.IsLocal() || !a.auth.CheckPasswordHash(password, user.PasswordHash) {
+		return nil, ErrInvalidCredentials
+	}
+	return &Identity{Username: user.Username, Role: user.Role}, nil
+}
+
+// AddAuthenticator adds an authenticator for users of another source. Users
+// who are not known yet are tried against the authenticators in the order
+// they were added.
+func (s *AuthService) AddAuthenticator(a PasswordAuthenticator) {
+	s.authenticators = append(s.authenticators, a)
+}
+
+// AuthenticatePassword verifies a password login and returns the user.
+// Known users are verified only by the authenticator of their source, so a
+// local account is never reached through a directory or vice versa; unknown
+// users are provisioned by the first other authenticator that accepts them.
+func (s *AuthService) AuthenticatePassword(ctx context.Context, username, password string) (*db.User, error) {
+	user, err := s.DBRepo.GetUserByUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	if user != nil {
+		a := s.authenticator(user.AuthSource)
+		if a == nil {
+			return nil, ErrInvalidCredentials // For example single sign-on users
+		}
+		identity, err := a.Authenticate(ctx, username, password)
+		if err != nil {
+			return nil, err
+		}
+		if user.IsLocal() {
+			return user, nil
+		}
+		return s.ProvisionExternalUser(a.Source(), identity)
+	}
+
+	for _, a := range s.authenticators {
+		if a.Source() == db.AuthSourceLocal {
+			continue
+		}
+		identity, err := a.Authenticate(ctx, username, password)
+		if errors.Is(err, ErrInvalidCredentials) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return s.ProvisionExternalUser(a.Source(), identity)
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// authenticator returns the authenticator for users of source, or nil.
+func (s *AuthService) authenticator(source string) PasswordAuthenticator {
+	for _, a := range s.authenticators {
+		if a.Source() == source {
+			return a
+		}
+	}
+	return nil
+}
+
+// ProvisionExternalUser returns the local user of an identity from source,
+// creating it on the first login. The role is taken from the identity on
+// every login, except that the last enabled admin is not demoted. A user of
+// another source with the same username is never taken over; such logins
+// fail with ErrLoginDenied.
+func (s *AuthService) ProvisionExternalUser(source string, identity *Identity) (*db.User, error) {
+	user, err := s.DBRepo.GetUserByExternalID(source, identity.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		user, err = s.DBRepo.CreateExternalUser(identity.Username, identity.Role, source, identity.Subject)
+		if errors.Is(err, db.ErrUsernameTaken) {
+			return nil, fmt.Errorf("%w: username %s belongs to another account", ErrLoginDenied, identity.Username)
+		}
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Provisioned %s user %s with role %s", source, user.Username, user.Role)
+		return user, nil
+	}
+
+	if user.Role == identity.Role {
+		return user, nil
+	}
+	changed, err := s.DBRepo.ChangeUser(user.ID, func(u *db.User) error {
+		u.Role = identity.Role
+		return nil
+	})
+	if errors.Is(err, db.ErrLastAdmin) {
+		log.Printf("Keeping role admin of user %s: they are the last enabled admin", user.Username)
+		return user, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Changed role of %s user %s from %s to %s", source, user.Username, user.Role, changed.Role)
+	return changed, nil
+}
+
+// mapRole returns the most privileged role that roleMap maps one of values
+// to, or defaultRole.
+func mapRole(values []string, roleMap map[string]string, defaultRole string) string {
+	role := defaultRole
+	for _, value := range values {
+		if mapped, ok := roleMap[value]; ok && roleRanks[mapped] > roleRanks[role] {
+			role = mapped
+		}
+	}
+	return role
+}
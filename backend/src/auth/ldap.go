@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"dmarc-report-analyzer/backend/src/db"
+)
+
+// ldapTimeout bounds each LDAP connection attempt and request.
+const ldapTimeout = 10 * time.Second
+
+// LDAPConfig configures password verification against an LDAP directory,
+// such as Active Directory. Users are found either by putting the username
+// into BindDNTemplate and binding directly, or by searching for them with
+// the service account BindDN and then binding with the found entry.
+type LDAPConfig struct {
+	URL      string // ldap://host:389 or ldaps://host:636
+	StartTLS bool   // Upgrade ldap:// connections with StartTLS
+
+	BindDNTemplate string // e.g. uid=%s,ou=people,dc=example,dc=com; enables direct bind
+
+	BindDN       string // Service account for search-then-bind, empty for anonymous search
+	BindPassword string
+	BaseDN       string // Subtree to search for users
+	UserFilter   string // e.g. (sAMAccountName=%s)
+
+	GroupAttribute string            // Attribute listing the user's groups, e.g. memberOf
+	RoleMap        map[string]string // Group CNs to roles; the most privileged match wins
+	DefaultRole    string            // Role of users without a match; empty denies them
+}
+
+// LDAPAuthenticator verifies passwords with LDAP simple binds. It opens a
+// connection per login.
+type LDAPAuthenticator struct {
+	config  LDAPConfig
+	roleMap map[string]string // RoleMap with lower-case keys
+}
+
+// NewLDAPAuthenticator creates an LDAPAuthenticator.
+func NewLDAPAuthenticator(config LDAPConfig) *LDAPAuthenticator {
+	roleMap := make(map[string]string, len(config.RoleMap))
+	for group, role := range config.RoleMap {
+		roleMap[strings.ToLower(group)] = role
+	}
+	return &LDAPAuthenticator{config: config, roleMap: roleMap}
+}
+
+// Source implements PasswordAuthenticator.
+func (a *LDAPAuthenticator) Source() string {
+	return db.AuthSourceLDAP
+}
+
+// Authenticate implements PasswordAuthenticator. The subject of the
+// identity is the user's DN.
+func (a *LDAPAuthenticator) Authenticate(ctx context.Context, username, password string) (*Identity, error) {
+	// An empty password would be an unauthenticated bind, which succeeds
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	conn, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var entry *ldap.Entry
+	if a.config.BindDNTemplate != "" {
+		userDN := fmt.Sprintf(a.config.BindDNTemplate, ldap.EscapeDN(username))
+		if err := bind(conn, userDN, password); err != nil {
+			return nil, err
+		}
+		entry, err = a.search(conn, userDN, ldap.ScopeBaseObject, "(objectClass=*)")
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if a.config.BindDN != "" {
+			if err := conn.Bind(a.config.BindDN, a.config.BindPassword); err != nil {
+				return nil, fmt.Errorf("failed to bind to LDAP as %s: %w", a.config.BindDN, err)
+			}
+		}
+		filter := fmt.Sprintf(a.config.UserFilter, ldap.EscapeFilter(username))
+		entry, err = a.search(conn, a.config.BaseDN, ldap.ScopeWholeSubtree, filter)
+		if err != nil {
+			return nil, err
+		}
+		if err := bind(conn, entry.DN, password); err != nil {
+			return nil, err
+		}
+	}
+
+	var groups []string
+	for _, value := range entry.GetAttributeValues(a.config.GroupAttribute) {
+		groups = append(groups, strings.ToLower(groupName(value)))
+	}
+	role := mapRole(groups, a.roleMap, a.config.DefaultRole)
+	if role == "" {
+		return nil, fmt.Errorf("%w: no role is mapped to %s", ErrLoginDenied, username)
+	}
+	return &Identity{Subject: strings.ToLower(entry.DN), Username: username, Role: role}, nil
+}
+
+// dial connects to the directory, upgrading the connection with StartTLS if
+// configured.
+func (a *LDAPAuthenticator) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(a.config.URL, ldap.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP server %s: %w", a.config.URL, err)
+	}
+	conn.SetTimeout(ldapTimeout)
+	if a.config.StartTLS {
+		u, err := url.Parse(a.config.URL)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("invalid LDAP URL %s: %w", a.config.URL, err)
+		}
+		if err := conn.StartTLS(&tls.Config{ServerName: u.Hostname()}); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to start TLS with LDAP server %s: %w", a.config.URL, err)
+		}
+	}
+	return conn, nil
+}
+
+// search returns the single entry matching filter. Finding no entry or
+// several fails with ErrInvalidCredentials.
+func (a *LDAPAuthenticator) search(conn *ldap.Conn, baseDN string, scope int, filter string) (*ldap.Entry, error) {
+	result, err := conn.Search(ldap.NewSearchRequest(baseDN, scope, ldap.NeverDerefAliases, 2, int(ldapTimeout.Seconds()), false,
+		filter, []string{a.config.GroupAttribute}, nil))
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) || ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("failed to search LDAP: %w", err)
+	}
+	if len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	return result.Entries[0], nil
+}
+
+// bind binds as userDN. Wrong passwords and unknown DNs fail with
+// ErrInvalidCredentials.
+func bind(conn *ldap.Conn, userDN, password string) error {
+	err := conn.Bind(userDN, password)
+	var ldapErr *ldap.Error
+	if errors.As(err, &ldapErr) && (ldapErr.ResultCode == ldap.LDAPResultInvalidCredentials || ldapErr.ResultCode == ldap.LDAPResultNoSuchObject) {
+		return ErrInvalidCredentials
+	}
+	if err != nil {
+		return fmt.Errorf("failed to bind to LDAP as %s: %w", userDN, err)
+	}
+	return nil
+}
+
+// groupName returns the CN of a group DN such as
+// cn=dmarc-admins,ou=groups,dc=example,dc=com, or the value itself if it is
+// not a DN.
+func groupName(value string) string {
+	dn, err := ldap.ParseDN(value)
+	if err != nil || len(dn.RDNs) == 0 || len(dn.RDNs[0].Attributes) == 0 {
+		return value
+	}
+	return dn.RDNs[0].Attributes[0].Value
+}
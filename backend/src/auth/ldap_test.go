@@ -0,0 +1,316 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+
+	"dmarc-report-analyzer/backend/src/db"
+)
+
+const (
+	testPeopleDN  = "ou=people,dc=example,dc=com"
+	testServiceDN = "cn=dmarc,ou=services,dc=example,dc=com"
+)
+
+// ldapEntry is an entry of the test directory.
+type ldapEntry struct {
+	password   string
+	attributes map[string][]string
+}
+
+// testLDAPServer is an in-process stand-in for an LDAP server. It speaks
+// just enough of the protocol for simple binds and searches with equality,
+// presence and AND filters.
+type testLDAPServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	entries  map[string]ldapEntry // By lower-case DN
+}
+
+func newTestLDAPServer(t *testing.T) *testLDAPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testLDAPServer{listener: listener, entries: map[string]ldapEntry{
+		testServiceDN: {password: "service-secret"},
+		"uid=alice," + testPeopleDN: {password: "alice-secret", attributes: map[string][]string{
+			"uid":      {"alice"},
+			"memberOf": {"cn=DMARC-Admins,ou=groups,dc=example,dc=com", "cn=staff,ou=groups,dc=example,dc=com"},
+		}},
+		"uid=bob," + testPeopleDN: {password: "bob-secret", attributes: map[string][]string{
+			"uid":      {"bob"},
+			"memberOf": {"cn=dmarc-users,ou=groups,dc=example,dc=com"},
+		}},
+		"uid=carol," + testPeopleDN: {password: "carol-secret", attributes: map[string][]string{
+			"uid": {"carol"},
+		}},
+	}}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *testLDAPServer) url() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *testLDAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	bound := false
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID, err := ber.ParseInt64(packet.Children[0].Data.Bytes())
+		if err != nil {
+			return
+		}
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := strings.ToLower(op.Children[1].Data.String())
+			password := op.Children[2].Data.String()
+			s.mu.Lock()
+			entry, ok := s.entries[dn]
+			s.mu.Unlock()
+			code := ldap.LDAPResultSuccess
+			if !ok || password == "" || entry.password != password {
+				code = ldap.LDAPResultInvalidCredentials
+			}
+			bound = code == ldap.LDAPResultSuccess
+			writeLDAPResult(conn, messageID, ldap.ApplicationBindResponse, code)
+		case ldap.ApplicationSearchRequest:
+			if !bound {
+				writeLDAPResult(conn, messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights)
+				continue
+			}
+			s.search(conn, messageID, op)
+		case ldap.ApplicationUnbindRequest:
+			return
+		default:
+			writeLDAPResult(conn, messageID, ldap.ApplicationExtendedResponse, ldap.LDAPResultUnwillingToPerform)
+		}
+	}
+}
+
+func (s *testLDAPServer) search(conn net.Conn, messageID int64, op *ber.Packet) {
+	baseDN := strings.ToLower(op.Children[0].Data.String())
+	scope, _ := ber.ParseInt64(op.Children[1].Data.Bytes())
+	filter := op.Children[6]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[baseDN]; !ok && scope == ldap.ScopeBaseObject {
+		writeLDAPResult(conn, messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultNoSuchObject)
+		return
+	}
+	for dn, entry := range s.entries {
+		inScope := dn == baseDN || (scope == ldap.ScopeWholeSubtree && strings.HasSuffix(dn, ","+baseDN))
+		if !inScope || !matchFilter(filter, entry) {
+			continue
+		}
+		result := ldapMessage(messageID)
+		resultEntry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+		resultEntry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, ""))
+		attributes := ber.NewSequence("")
+		for name, values := range entry.attributes {
+			attribute := ber.NewSequence("")
+			attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+			set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+			for _, value := range values {
+				set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+			}
+			attribute.AppendChild(set)
+			attributes.AppendChild(attribute)
+		}
+		resultEntry.AppendChild(attributes)
+		result.AppendChild(resultEntry)
+		conn.Write(result.Bytes())
+	}
+	writeLDAPResult(conn, messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess)
+}
+
+// matchFilter evaluates the AND, equality and presence filters that the
+// authenticator sends.
+func matchFilter(filter *ber.Packet, entry ldapEntry) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !matchFilter(child, entry) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterEqualityMatch:
+		name, value := filter.Children[0].Data.String(), filter.Children[1].Data.String()
+		for attribute, values := range entry.attributes {
+			if !strings.EqualFold(attribute, name) {
+				continue
+			}
+			for _, v := range values {
+				if strings.EqualFold(v, value) {
+					return true
+				}
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		return strings.EqualFold(filter.Data.String(), "objectClass")
+	}
+	return false
+}
+
+func ldapMessage(messageID int64) *ber.Packet {
+	message := ber.NewSequence("")
+	message.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, ""))
+	return message
+}
+
+func writeLDAPResult(conn net.Conn, messageID int64, tag ber.Tag, code int) {
+	message := ldapMessage(messageID)
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), ""))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	message.AppendChild(result)
+	conn.Write(message.Bytes())
+}
+
+var testLDAPRoleMap = map[string]string{"dmarc-admins": db.RoleAdmin, "dmarc-users": db.RoleViewer}
+
+func TestLDAPDirectBind(t *testing.T) {
+	server := newTestLDAPServer(t)
+	a := NewLDAPAuthenticator(LDAPConfig{
+		URL:            server.url(),
+		BindDNTemplate: "uid=%s," + testPeopleDN,
+		GroupAttribute: "memberOf",
+		RoleMap:        testLDAPRoleMap,
+	})
+
+	identity, err := a.Authenticate(context.Background(), "alice", "alice-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Username != "alice" || identity.Role != db.RoleAdmin || identity.Subject != "uid=alice,"+testPeopleDN {
+		t.Errorf("identity = %+v", identity)
+	}
+
+	for _, creds := range [][2]string{{"alice", "wrong"}, {"alice", ""}, {"mallory", "alice-secret"}, {"alice,ou=people", "alice-secret"}} {
+		if _, err := a.Authenticate(context.Background(), creds[0], creds[1]); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Authenticate(%q, %q) error = %v, want ErrInvalidCredentials", creds[0], creds[1], err)
+		}
+	}
+}
+
+func TestLDAPSearchThenBind(t *testing.T) {
+	server := newTestLDAPServer(t)
+	config := LDAPConfig{
+		URL:            server.url(),
+		BindDN:         testServiceDN,
+		BindPassword:   "service-secret",
+		BaseDN:         "dc=example,dc=com",
+		UserFilter:     "(uid=%s)",
+		GroupAttribute: "memberOf",
+		RoleMap:        testLDAPRoleMap,
+	}
+	a := NewLDAPAuthenticator(config)
+
+	identity, err := a.Authenticate(context.Background(), "bob", "bob-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Role != db.RoleViewer || identity.Subject != "uid=bob,"+testPeopleDN {
+		t.Errorf("identity = %+v", identity)
+	}
+
+	for _, creds := range [][2]string{{"bob", "wrong"}, {"*", "bob-secret"}, {"mallory", "bob-secret"}} {
+		if _, err := a.Authenticate(context.Background(), creds[0], creds[1]); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Authenticate(%q, %q) error = %v, want ErrInvalidCredentials", creds[0], creds[1], err)
+		}
+	}
+	if _, err := a.Authenticate(context.Background(), "carol", "carol-secret"); !errors.Is(err, ErrLoginDenied) {
+		t.Errorf("Authenticate(carol) error = %v, want ErrLoginDenied", err)
+	}
+
+	config.DefaultRole = db.RoleViewer
+	if identity, err := NewLDAPAuthenticator(config).Authenticate(context.Background(), "carol", "carol-secret"); err != nil || identity.Role != db.RoleViewer {
+		t.Errorf("Authenticate(carol) with default role = %+v, %v", identity, err)
+	}
+
+	config.BindPassword = "wrong"
+	_, err = NewLDAPAuthenticator(config).Authenticate(context.Background(), "bob", "bob-secret")
+	if err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate with a wrong service password error = %v, want a server error", err)
+	}
+}
+
+func TestAuthenticatePasswordProvisionsLDAPUsers(t *testing.T) {
+	database, err := db.InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	repo := db.NewRepository(database)
+	s := NewAuthService(repo, "ldap-test-secret-0123456789abcdef")
+	server := newTestLDAPServer(t)
+	s.AddAuthenticator(NewLDAPAuthenticator(LDAPConfig{
+		URL:            server.url(),
+		BindDNTemplate: "uid=%s," + testPeopleDN,
+		GroupAttribute: "memberOf",
+		RoleMap:        testLDAPRoleMap,
+	}))
+	ctx := context.Background()
+
+	user, err := s.AuthenticatePassword(ctx, "alice", "alice-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.AuthSource != db.AuthSourceLDAP || user.Role != db.RoleAdmin || user.ExternalID != "uid=alice,"+testPeopleDN {
+		t.Errorf("provisioned user = %+v", user)
+	}
+	again, err := s.AuthenticatePassword(ctx, "alice", "alice-secret")
+	if err != nil || again.ID != user.ID {
+		t.Errorf("second login = %+v, %v; want user %d", again, err, user.ID)
+	}
+	if _, err := s.AuthenticatePassword(ctx, "alice", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("wrong password error = %v, want ErrInvalidCredentials", err)
+	}
+
+	// A local account of the same name is only reachable with its own password
+	if _, err := repo.CreateUser("bob", "local-secret", db.RoleAnalyst); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AuthenticatePassword(ctx, "bob", "bob-secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("directory password of local user error = %v, want ErrInvalidCredentials", err)
+	}
+	local, err := s.AuthenticatePassword(ctx, "bob", "local-secret")
+	if err != nil || local.AuthSource != db.AuthSourceLocal || local.Role != db.RoleAnalyst {
+		t.Errorf("local login = %+v, %v", local, err)
+	}
+
+	// Directory users without a mapped role are not let in
+	if _, err := s.AuthenticatePassword(ctx, "carol", "carol-secret"); !errors.Is(err, ErrLoginDenied) {
+		t.Errorf("unmapped user error = %v, want ErrLoginDenied", err)
+	}
+	if _, err := s.AuthenticatePassword(ctx, "mallory", "secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("unknown user error = %v, want ErrInvalidCredentials", err)
+	}
+}
@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// OIDCLoginLifetime bounds the time a user may spend at the provider.
+const OIDCLoginLifetime = 10 * time.Minute
+
+// oidcAudience is the audience of OIDC login state tokens.
+const oidcAudience = "oidc"
+
+// OIDCConfig configures login through an OpenID Connect provider.
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string // Must point to /api/auth/oidc/callback
+	Scopes       []string
+
+	UsernameClaim string            // Claim with the local username
+	RoleClaim     string            // Claim with the user's groups or roles, a string or a list
+	RoleMap       map[string]string // Values of RoleClaim to roles; the most privileged match wins
+	DefaultRole   string            // Role of users without a match; empty denies them
+}
+
+// OIDCProvider logs users in with the authorization code flow and PKCE.
+// The provider's discovery document is fetched on first use, so that the
+// server starts while the provider is unreachable.
+type OIDCProvider struct {
+	config OIDCConfig
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+// OIDCLogin is the state of a login in progress, kept by the browser in a
+// signed cookie between the redirect to the provider and the callback.
+type OIDCLogin struct {
+	State    string
+	Nonce    string
+	Verifier string // PKCE code verifier
+}
+
+// oidcLoginClaims are the claims of a signed OIDCLogin.
+type oidcLoginClaims struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.RegisteredClaims
+}
+
+// NewOIDCProvider creates an OIDCProvider. The openid scope is added to the
+// configured scopes if missing.
+func NewOIDCProvider(config OIDCConfig) *OIDCProvider {
+	hasOpenID := false
+	for _, scope := range config.Scopes {
+		hasOpenID = hasOpenID || scope == oidc.ScopeOpenID
+	}
+	if !hasOpenID {
+		config.Scopes = append([]string{oidc.ScopeOpenID}, config.Scopes...)
+	}
+	return &OIDCProvider{config: config}
+}
+
+// discover returns the provider, fetching its discovery document on the
+// first call. Failures are retried on the next call.
+func (p *OIDCProvider) discover(ctx context.Context) (*oidc.Provider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.provider == nil {
+		provider, err := oidc.NewProvider(ctx, p.config.Issuer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover OIDC provider %s: %w", p.config.Issuer, err)
+		}
+		p.provider = provider
+	}
+	return p.provider, nil
+}
+
+// oauth2Config returns the OAuth 2.0 client configuration for provider.
+func (p *OIDCProvider) oauth2Config(provider *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  p.config.RedirectURL,
+		Scopes:       p.config.Scopes,
+	}
+}
+
+// AuthCodeURL starts a login and returns the provider URL to send the user
+// to, and the login state to present with the callback.
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context) (string, *OIDCLogin, error) {
+	provider, err := p.discover(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	state, err := newSecret()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate OIDC state: %w", err)
+	}
+	nonce, err := newSecret()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate OIDC nonce: %w", err)
+	}
+	login := &OIDCLogin{State: state, Nonce: nonce, Verifier: oauth2.GenerateVerifier()}
+	url := p.oauth2Config(provider).AuthCodeURL(state, oauth2.S256ChallengeOption(login.Verifier), oidc.Nonce(nonce))
+	return url, login, nil
+}
+
+// Exchange redeems the authorization code of the callback of login, verifies
+// the ID token and returns the identity in it. Identities without a username
+// or role fail with ErrLoginDenied.
+func (p *OIDCProvider) Exchange(ctx context.Context, login *OIDCLogin, code string) (*Identity, error) {
+	provider, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	oauth2Token, err := p.oauth2Config(provider).Exchange(ctx, code, oauth2.VerifierOption(login.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to redeem OIDC authorization code: %w", err)
+	}
+	rawIDToken, ok := oauth2Token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("OIDC token response has no ID token")
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: p.config.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify OIDC ID token: %w", err)
+	}
+	if idToken.Nonce != login.Nonce {
+		return nil, errors.New("OIDC ID token nonce does not match")
+	}
+
+	claims := map[string]interface{}{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to decode OIDC ID token claims: %w", err)
+	}
+	// Providers may leave groups and profile claims to the userinfo endpoint
+	if claims[p.config.UsernameClaim] == nil || (p.config.RoleClaim != "" && claims[p.config.RoleClaim] == nil) {
+		if userInfo, err := provider.UserInfo(ctx, oauth2.StaticTokenSource(oauth2Token)); err == nil && userInfo.Subject == idToken.Subject {
+			extra := map[string]interface{}{}
+			if err := userInfo.Claims(&extra); err == nil {
+				for name, value := range extra {
+					if claims[name] == nil {
+						claims[name] = value
+					}
+				}
+			}
+		}
+	}
+
+	username, _ := claims[p.config.UsernameClaim].(string)
+	if strings.TrimSpace(username) == "" {
+		return nil, fmt.Errorf("%w: the identity has no %s claim", ErrLoginDenied, p.config.UsernameClaim)
+	}
+	role := p.mapRole(claims[p.config.RoleClaim])
+	if role == "" {
+		return nil, fmt.Errorf("%w: no role is mapped to %s", ErrLoginDenied, username)
+	}
+	return &Identity{Subject: idToken.Subject, Username: username, Role: role}, nil
+}
+
+// mapRole returns the role of the values of the role claim, a string or a
+// list of strings.
+func (p *OIDCProvider) mapRole(claim interface{}) string {
+	var values []string
+	switch v := claim.(type) {
+	case string:
+		values = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	return mapRole(values, p.config.RoleMap, p.config.DefaultRole)
+}
+
+// SignOIDCLogin signs login for the browser to keep until the callback.
+func (s *AuthService) SignOIDCLogin(login *OIDCLogin) (string, error) {
+	now := time.Now()
+	claims := &oidcLoginClaims{
+		State:    login.State,
+		Nonce:    login.Nonce,
+		Verifier: login.Verifier,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(OIDCLoginLifetime)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    "dmarc-report-analyzer",
+			Audience:  []string{oidcAudience},
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.JWTSecret)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign OIDC login state: %w", err)
+	}
+	return token, nil
+}
+
+// VerifyOIDCLogin verifies a login signed by SignOIDCLogin. Invalid and
+// expired logins fail with ErrInvalidToken.
+func (s *AuthService) VerifyOIDCLogin(tokenString string) (*OIDCLogin, error) {
+	claims := &oidcLoginClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return s.JWTSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer("dmarc-report-analyzer"),
+		jwt.WithAudience(oidcAudience))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return &OIDCLogin{State: claims.State, Nonce: claims.Nonce, Verifier: claims.Verifier}, nil
+}
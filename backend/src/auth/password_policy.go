@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrWeakPassword is returned for passwords that do not satisfy the
+// PasswordPolicy. The wrapping error tells the user why.
+var ErrWeakPassword = errors.New("password does not satisfy the password policy")
+
+// DefaultPasswordMinLength is the MinLength of new AuthServices.
+const DefaultPasswordMinLength = 12
+
+// passwordMaxBytes is the length bcrypt limits passwords to.
+const passwordMaxBytes = 72
+
+// PasswordPolicy decides which passwords local accounts may have.
+type PasswordPolicy struct {
+	// MinLength is the minimum number of characters.
+	MinLength int
+
+	breached map[[sha1.Size]byte]struct{} // See LoadBreachedPasswords
+}
+
+// NewPasswordPolicy creates a PasswordPolicy with the given minimum length
+// and no breached passwords.
+func NewPasswordPolicy(minLength int) *PasswordPolicy {
+	return &PasswordPolicy{MinLength: minLength}
+}
+
+// LoadBreachedPasswords reads the file at path into the list of passwords
+// that are refused because they are known from breaches. Each line is a
+// password or, as in the Pwned Passwords downloads, the hexadecimal SHA-1
+// hash of one, optionally followed by ":" and a count. Empty lines and lines
+// starting with "#" are ignored. It returns the number of entries read.
+func (p *PasswordPolicy) LoadBreachedPasswords(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open breached password list: %w", err)
+	}
+	defer file.Close()
+
+	breached := make(map[[sha1.Size]byte]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		breached[breachedKey(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read breached password list: %w", err)
+	}
+
+	p.breached = breached
+	return len(breached), nil
+}
+
+// breachedKey returns the SHA-1 hash of a line of a breached password list.
+func breachedKey(line string) [sha1.Size]byte {
+	hash := line
+	if i := strings.IndexByte(hash, ':'); i == 2*sha1.Size {
+		hash = hash[:i]
+	}
+	var key [sha1.Size]byte
+	if len(hash) == 2*sha1.Size {
+		if _, err := hex.Decode(key[:], []byte(hash)); err == nil {
+			return key
+		}
+	}
+	return sha1.Sum([]byte(line))
+}
+
+// Check returns an error wrapping ErrWeakPassword if password may not be
+// the password of the user with the given username.
+func (p *PasswordPolicy) Check(username, password string) error {
+	if n := utf8.RuneCountInString(password); n < p.MinLength {
+		return fmt.Errorf("%w: it must be at least %d characters long", ErrWeakPassword, p.MinLength)
+	}
+	if len(password) > passwordMaxBytes {
+		return fmt.Errorf("%w: it must be at most %d bytes long", ErrWeakPassword, passwordMaxBytes)
+	}
+	if username != "" && strings.EqualFold(password, username) {
+		return fmt.Errorf("%w: it must not be the username", ErrWeakPassword)
+	}
+	if _, ok := p.breached[sha1.Sum([]byte(password))]; ok {
+		return fmt.Errorf("%w: it is known from a data breach", ErrWeakPassword)
+	}
+	return nil
+}
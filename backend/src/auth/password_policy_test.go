@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "breached.txt")
+	content := strings.Join([]string{
+		"# Plain passwords and SHA-1 hashes with counts",
+		"correcthorsebattery",
+		"",
+		"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:3730471", // "password"
+		"d8b51a43b6a7ed6eec6f0ce2d9c6e2a1a77e6bb4",         // A hash without a count
+	}, "\r\n")
+	if err := os.WriteFile(list, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := NewPasswordPolicy(8)
+	n, err := policy.LoadBreachedPasswords(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("LoadBreachedPasswords read %d entries, want 3", n)
+	}
+
+	tests := []struct {
+		password string
+		reason   string // Expected in the error; empty if the password is accepted
+	}{
+		{"a good passphrase", ""},
+		{"passwor", "at least 8 characters"},
+		{"pässwörd", ""}, // Eight characters in ten bytes
+		{"päss", "at least 8 characters"},
+		{strings.Repeat("x", 72), ""},
+		{strings.Repeat("x", 73), "at most 72 bytes"},
+		{"Alice.Example", "not be the username"},
+		{"correcthorsebattery", "data breach"},
+		{"password", "data breach"},
+		{"Password", ""},
+	}
+	for _, tt := range tests {
+		err := policy.Check("alice.example", tt.password)
+		if tt.reason == "" {
+			if err != nil {
+				t.Errorf("Check(%q) = %v, want nil", tt.password, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrWeakPassword) || !strings.Contains(err.Error(), tt.reason) {
+			t.Errorf("Check(%q) = %v, want ErrWeakPassword with %q", tt.password, err, tt.reason)
+		}
+	}
+}
+
+func TestPasswordPolicyWithoutBreachedList(t *testing.T) {
+	policy := NewPasswordPolicy(DefaultPasswordMinLength)
+	if err := policy.Check("admin", "password1234"); err != nil {
+		t.Errorf("Check without a breached list = %v, want nil", err)
+	}
+	if err := policy.Check("admin", "password123"); !errors.Is(err, ErrWeakPassword) {
+		t.Errorf("Check of 11 characters = %v, want ErrWeakPassword", err)
+	}
+	if _, err := policy.LoadBreachedPasswords(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("LoadBreachedPasswords of a missing file succeeded")
+	}
+}
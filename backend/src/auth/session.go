@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"dmarc-report-analyzer/backend/src/db"
+)
+
+// Token lifetimes. Access tokens are short-lived JWTs; the refresh token of a
+// session replaces them and is itself replaced on every use. A session ends
+// after SessionLifetime without a refresh.
+const (
+	AccessTokenLifetime = 15 * time.Minute
+	SessionLifetime     = 30 * 24 * time.Hour
+)
+
+// maxUserAgentLength bounds the stored user agent of a session.
+const maxUserAgentLength = 256
+
+// ErrRefreshTokenReused is returned when a refresh token is presented after
+// it was exchanged. It wraps ErrInvalidToken.
+var ErrRefreshTokenReused = fmt.Errorf("%w: refresh token reused", ErrInvalidToken)
+
+// SessionTokens are the tokens issued when a session starts or is refreshed.
+type SessionTokens struct {
+	AccessToken  string
+	RefreshToken string
+	Session      *db.Session
+}
+
+// StartSession starts a session for user on the device with the given user
+// agent and IP address, and issues its first tokens.
+func (s *AuthService) StartSession(user *db.User, userAgent, sourceIP string) (*SessionTokens, error) {
+	refreshToken, err := newSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	now := time.Now()
+	session := &db.Session{
+		UserID:      user.ID,
+		RefreshHash: hashSecret(refreshToken),
+		UserAgent:   truncate(userAgent, maxUserAgentLength),
+		SourceIP:    sourceIP,
+		CreatedAt:   now.Unix(),
+		LastUsedAt:  now.Unix(),
+		ExpiresAt:   now.Add(SessionLifetime).Unix(),
+	}
+	if err := s.DBRepo.CreateSession(session); err != nil {
+		return nil, err
+	}
+	accessToken, err := s.GenerateJWT(user.Username, user.Role, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &SessionTokens{AccessToken: accessToken, RefreshToken: refreshToken, Session: session}, nil
+}
+
+// RefreshSession exchanges a refresh token for new tokens of the same session
+// and returns them with the session's user. The refresh token can only be
+// used once: presenting it again, which means it was copied, revokes the
+// whole session and fails with ErrRefreshTokenReused. Unknown or expired
+// refresh tokens, and those of disabled users, fail with ErrInvalidToken.
+func (s *AuthService) RefreshSession(refreshToken, userAgent, sourceIP string) (*db.User, *SessionTokens, error) {
+	oldHash := hashSecret(refreshToken)
+	session, err := s.DBRepo.GetSessionByRefreshHash(oldHash)
+	if err != nil {
+		return nil, nil, err
+	}
+	if session == nil {
+		return nil, nil, s.revokeReusedSession(oldHash)
+	}
+	now := time.Now()
+	if session.ExpiresAt <= now.Unix() {
+		return nil, nil, ErrInvalidToken
+	}
+	user, err := s.DBRepo.GetUserByID(session.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil || user.Disabled {
+		return nil, nil, ErrInvalidToken
+	}
+
+	newRefreshToken, err := newSecret()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	session.RefreshHash = hashSecret(newRefreshToken)
+	session.UserAgent = truncate(userAgent, maxUserAgentLength)
+	session.SourceIP = sourceIP
+	session.LastUsedAt = now.Unix()
+	session.ExpiresAt = now.Add(SessionLifetime).Unix()
+	if err := s.DBRepo.RotateSession(session, oldHash); err != nil {
+		if errors.Is(err, db.ErrSessionNotFound) {
+			return nil, nil, s.revokeReusedSession(oldHash) // Used by a concurrent refresh
+		}
+		return nil, nil, err
+	}
+
+	accessToken, err := s.GenerateJWT(user.Username, user.Role, session.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, &SessionTokens{AccessToken: accessToken, RefreshToken: newRefreshToken, Session: session}, nil
+}
+
+// revokeReusedSession ends the session that the refresh token with the given
+// hash was already used in. It returns ErrRefreshTokenReused if there was one,
+// and ErrInvalidToken for unknown refresh tokens.
+func (s *AuthService) revokeReusedSession(hash string) error {
+	session, err := s.DBRepo.RevokeSessionByUsedRefreshHash(hash)
+	if err != nil {
+		return err
+	}
+	if session == nil {
+		return ErrInvalidToken
+	}
+	return fmt.Errorf("%w (session %d)", ErrRefreshTokenReused, session.ID)
+}
+
+// EndSession ends the session with the given refresh token and returns it, or
+// nil if there is no such session.
+func (s *AuthService) EndSession(refreshToken string) (*db.Session, error) {
+	return s.DBRepo.DeleteSessionByRefreshHash(hashSecret(refreshToken))
+}
+
+// truncate shortens s to at most n bytes.
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"dmarc-report-analyzer/backend/src/db"
+)
+
+// newTestAuthService returns an AuthService on a new database with the user
+// alice.
+func newTestAuthService(t *testing.T) (*AuthService, *db.User) {
+	t.Helper()
+	database, err := db.InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	repo := db.NewRepository(database)
+	user, err := repo.CreateUser("alice", "password", db.RoleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewAuthService(repo, "session-test-secret-0123456789abcdef"), user
+}
+
+func TestRefreshSessionRotation(t *testing.T) {
+	s, user := newTestAuthService(t)
+	first, err := s.StartSession(user, "agent", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refreshedUser, second, err := s.RefreshSession(first.RefreshToken, "agent/2", "192.0.2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshedUser.ID != user.ID || second.Session.ID != first.Session.ID {
+		t.Errorf("refresh = user %d, session %d, want user %d, session %d", refreshedUser.ID, second.Session.ID, user.ID, first.Session.ID)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Error("refresh returned the same refresh token")
+	}
+	if second.Session.UserAgent != "agent/2" || second.Session.SourceIP != "192.0.2.2" {
+		t.Errorf("refreshed session = %+v, want the new user agent and IP address", second.Session)
+	}
+	if _, err := s.ValidateJWT(second.AccessToken); err != nil {
+		t.Errorf("access token after refresh: %v", err)
+	}
+	_, third, err := s.RefreshSession(second.RefreshToken, "agent/2", "192.0.2.2")
+	if err != nil {
+		t.Fatalf("second refresh: %v", err)
+	}
+
+	// Presenting a rotated refresh token again ends the whole session
+	if _, _, err := s.RefreshSession(first.RefreshToken, "agent", "192.0.2.3"); !errors.Is(err, ErrRefreshTokenReused) || !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("reused refresh token: error = %v, want ErrRefreshTokenReused", err)
+	}
+	if _, _, err := s.RefreshSession(third.RefreshToken, "agent/2", "192.0.2.2"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("current refresh token after reuse: error = %v, want ErrInvalidToken", err)
+	}
+	if _, err := s.ValidateJWT(third.AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("access token after reuse: error = %v, want ErrInvalidToken", err)
+	}
+	sessions, err := s.DBRepo.ListSessions(user.ID, first.Session.CreatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 0 {
+		t.Errorf("sessions after reuse = %+v, want none", sessions)
+	}
+
+	if _, _, err := s.RefreshSession("unknown", "agent", "192.0.2.1"); !errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrRefreshTokenReused) {
+		t.Errorf("unknown refresh token: error = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestRefreshSessionReuseKeepsOtherSessions(t *testing.T) {
+	s, user := newTestAuthService(t)
+	stolen, err := s.StartSession(user, "agent", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.StartSession(user, "other", "192.0.2.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.RefreshSession(stolen.RefreshToken, "agent", "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.RefreshSession(stolen.RefreshToken, "attacker", "198.51.100.1"); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reused refresh token: error = %v, want ErrRefreshTokenReused", err)
+	}
+	if _, _, err := s.RefreshSession(other.RefreshToken, "other", "192.0.2.9"); err != nil {
+		t.Errorf("refresh of another session after reuse: %v", err)
+	}
+}
+
+func TestRefreshSessionDisabledUser(t *testing.T) {
+	s, user := newTestAuthService(t)
+	tokens, err := s.StartSession(user, "agent", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.DBRepo.ChangeUser(user.ID, func(user *db.User) error { user.Disabled = true; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.RefreshSession(tokens.RefreshToken, "agent", "192.0.2.1"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("refresh of a disabled user: error = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestValidateJWTChecksSession(t *testing.T) {
+	s, user := newTestAuthService(t)
+	tokens, err := s.StartSession(user, "agent", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := s.ValidateJWT(tokens.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Username != "alice" {
+		t.Errorf("claims username = %q, want alice", claims.Username)
+	}
+
+	// A token naming another user is not valid for the session
+	bob, err := s.DBRepo.CreateUser("bob", "password", db.RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := s.GenerateJWT(bob.Username, bob.Role, tokens.Session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ValidateJWT(forged); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("token of another user's session: error = %v, want ErrInvalidToken", err)
+	}
+
+	// Ending the session invalidates its access tokens before they expire
+	if session, err := s.EndSession(tokens.RefreshToken); err != nil || session == nil {
+		t.Fatalf("EndSession = %+v, %v", session, err)
+	}
+	if _, err := s.ValidateJWT(tokens.AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("access token of an ended session: error = %v, want ErrInvalidToken", err)
+	}
+}
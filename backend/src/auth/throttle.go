@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// ThrottleConfig configures a LoginThrottle.
+type ThrottleConfig struct {
+	// AccountLockoutThreshold is the number of failures that lock an account
+	// for LockoutDuration. Until then every failure doubles the wait before
+	// the next attempt, starting at BaseDelay. 0 disables per-account limits.
+	AccountLockoutThreshold int
+	// IPLockoutThreshold is the number of failures that block an IP address
+	// for LockoutDuration. An IP address may fail AccountLockoutThreshold
+	// times without waiting; further failures double the wait as for
+	// accounts. 0 disables per-IP limits.
+	IPLockoutThreshold int
+	// LockoutDuration is how long lockouts last and failures are remembered.
+	LockoutDuration time.Duration
+	// BaseDelay is the wait after the first counted failure.
+	BaseDelay time.Duration
+}
+
+// DefaultThrottleConfig is the ThrottleConfig of new AuthServices.
+var DefaultThrottleConfig = ThrottleConfig{
+	AccountLockoutThreshold: 5,
+	IPLockoutThreshold:      50,
+	LockoutDuration:         15 * time.Minute,
+	BaseDelay:               time.Second,
+}
+
+// throttleMaxEntries is the number of tracked accounts or IP addresses above
+// which forgotten entries are pruned.
+const throttleMaxEntries = 10000
+
+// throttleState is the recent failures of one account or IP address.
+type throttleState struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// LoginThrottle slows down password and second factor guessing. It counts
+// recent failures per account and per client IP address in memory, so
+// counts start over when the server restarts. Accounts are tracked by the
+// username that was tried, whether or not it exists, so that lockouts do
+// not reveal which usernames exist.
+type LoginThrottle struct {
+	config ThrottleConfig
+	now    func() time.Time // For tests
+
+	mu       sync.Mutex
+	accounts map[string]*throttleState
+	ips      map[string]*throttleState
+}
+
+// NewLoginThrottle creates a LoginThrottle.
+func NewLoginThrottle(config ThrottleConfig) *LoginThrottle {
+	return &LoginThrottle{
+		config:   config,
+		now:      time.Now,
+		accounts: make(map[string]*throttleState),
+		ips:      make(map[string]*throttleState),
+	}
+}
+
+// Check returns how long the client at ip has to wait before it may try
+// the credentials of username again, or 0.
+func (t *LoginThrottle) Check(ip, username string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+
+	var wait time.Duration
+	for _, state := range []*throttleState{t.accounts[accountKey(username)], t.ips[ip]} {
+		if state != nil && state.blockedUntil.After(now) && state.blockedUntil.Sub(now) > wait {
+			wait = state.blockedUntil.Sub(now)
+		}
+	}
+	return wait
+}
+
+// Failure records a failed attempt of the client at ip with the credentials
+// of username. It reports whether the failure locked the account.
+func (t *LoginThrottle) Failure(ip, username string) (lockedOut bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+
+	if t.config.AccountLockoutThreshold > 0 {
+		state := t.record(t.accounts, accountKey(username), now)
+		lockedOut = t.block(state, now, 0, t.config.AccountLockoutThreshold)
+	}
+	if t.config.IPLockoutThreshold > 0 && ip != "" {
+		state := t.record(t.ips, ip, now)
+		t.block(state, now, t.config.AccountLockoutThreshold, t.config.IPLockoutThreshold)
+	}
+	return lockedOut
+}
+
+// Success forgets the failures of username. Failures of the client's IP
+// address are kept, so that logging in to one account does not allow
+// guessing the passwords of others.
+func (t *LoginThrottle) Success(username string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.accounts, accountKey(username))
+}
+
+// record counts a failure of key in states, starting over if its previous
+// failures are forgotten.
+func (t *LoginThrottle) record(states map[string]*throttleState, key string, now time.Time) *throttleState {
+	if len(states) >= throttleMaxEntries {
+		for k, state := range states {
+			if t.forgotten(state, now) {
+				delete(states, k)
+			}
+		}
+	}
+	state := states[key]
+	if state == nil || t.forgotten(state, now) {
+		state = &throttleState{}
+		states[key] = state
+	}
+	state.failures++
+	state.lastFailure = now
+	return state
+}
+
+// block sets how long state is blocked after its latest failure: the
+// lockout once threshold failures are reached, otherwise a delay that
+// doubles with every failure after the first free ones. It reports whether
+// the failure started the lockout.
+func (t *LoginThrottle) block(state *throttleState, now time.Time, free, threshold int) bool {
+	if state.failures >= threshold {
+		state.blockedUntil = now.Add(t.config.LockoutDuration)
+		return state.failures == threshold
+	}
+	if state.failures <= free {
+		return false
+	}
+	// Doubled step by step, as shifting could overflow
+	delay := t.config.BaseDelay
+	for i := free + 1; i < state.failures && delay < t.config.LockoutDuration; i++ {
+		delay *= 2
+	}
+	if delay > t.config.LockoutDuration {
+		delay = t.config.LockoutDuration
+	}
+	state.blockedUntil = now.Add(delay)
+	return false
+}
+
+// forgotten reports whether the failures of state are old enough to be
+// forgotten.
+func (t *LoginThrottle) forgotten(state *throttleState, now time.Time) bool {
+	return !state.blockedUntil.After(now) && now.Sub(state.lastFailure) >= t.config.LockoutDuration
+}
+
+// accountKey returns the key of username's failures. Usernames differing
+// only in case share it.
+func accountKey(username string) string {
+	return strings.ToLower(username)
+}
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testClock is the time of a LoginThrottle under test.
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// newTestThrottle returns a LoginThrottle whose time only moves when the
+// returned clock is advanced.
+func newTestThrottle(config ThrottleConfig) (*LoginThrottle, *testClock) {
+	clock := &testClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	throttle := NewLoginThrottle(config)
+	throttle.now = func() time.Time { return clock.now }
+	return throttle, clock
+}
+
+func TestThrottleAccountBackoffAndLockout(t *testing.T) {
+	throttle, clock := newTestThrottle(ThrottleConfig{
+		AccountLockoutThreshold: 5,
+		LockoutDuration:         15 * time.Minute,
+		BaseDelay:               time.Second,
+	})
+
+	if wait := throttle.Check("192.0.2.1", "alice"); wait != 0 {
+		t.Fatalf("wait before any failure = %v, want 0", wait)
+	}
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		if throttle.Failure("192.0.2.1", "alice") {
+			t.Errorf("failure %d reported a lockout", i+1)
+		}
+		// The account is throttled from every address and in any case
+		if wait := throttle.Check("198.51.100.7", "ALICE"); wait != want {
+			t.Errorf("wait after failure %d = %v, want %v", i+1, wait, want)
+		}
+		clock.advance(throttle.Check("192.0.2.1", "alice"))
+	}
+
+	if !throttle.Failure("192.0.2.1", "alice") {
+		t.Error("failure 5 did not report the lockout")
+	}
+	if wait := throttle.Check("192.0.2.1", "alice"); wait != 15*time.Minute {
+		t.Errorf("wait after the lockout = %v, want 15m", wait)
+	}
+	if throttle.Failure("192.0.2.1", "alice") {
+		t.Error("failure 6 reported the lockout again")
+	}
+	if wait := throttle.Check("192.0.2.1", "bob"); wait != 0 {
+		t.Errorf("wait of another account = %v, want 0", wait)
+	}
+
+	clock.advance(15 * time.Minute)
+	if wait := throttle.Check("192.0.2.1", "alice"); wait != 0 {
+		t.Errorf("wait after the lockout ended = %v, want 0", wait)
+	}
+}
+
+func TestThrottleDelayIsCappedAtLockoutDuration(t *testing.T) {
+	throttle, _ := newTestThrottle(ThrottleConfig{
+		AccountLockoutThreshold: 100,
+		LockoutDuration:         time.Hour,
+		BaseDelay:               time.Minute,
+	})
+
+	// Far beyond the cap, where shifting the base delay would overflow
+	want := time.Minute
+	for i := 1; i < 100; i++ {
+		throttle.Failure("192.0.2.1", "alice")
+		if wait := throttle.Check("192.0.2.1", "alice"); wait != want {
+			t.Fatalf("wait after failure %d = %v, want %v", i, wait, want)
+		}
+		if want *= 2; want > time.Hour {
+			want = time.Hour
+		}
+	}
+}
+
+func TestThrottleIPFreeFailures(t *testing.T) {
+	throttle, _ := newTestThrottle(ThrottleConfig{
+		AccountLockoutThreshold: 3,
+		IPLockoutThreshold:      6,
+		LockoutDuration:         15 * time.Minute,
+		BaseDelay:               time.Second,
+	})
+
+	// One failure each for many usernames only counts against the address.
+	for i, want := range []time.Duration{0, 0, 0, time.Second, 2 * time.Second, 15 * time.Minute} {
+		throttle.Failure("192.0.2.1", fmt.Sprintf("user%d", i))
+		if wait := throttle.Check("192.0.2.1", "someone"); wait != want {
+			t.Errorf("wait of the address after failure %d = %v, want %v", i+1, wait, want)
+		}
+	}
+	if wait := throttle.Check("198.51.100.7", "someone"); wait != 0 {
+		t.Errorf("wait of another address = %v, want 0", wait)
+	}
+}
+
+func TestThrottleSuccessOnlyClearsTheAccount(t *testing.T) {
+	throttle, _ := newTestThrottle(ThrottleConfig{
+		AccountLockoutThreshold: 2,
+		IPLockoutThreshold:      4,
+		LockoutDuration:         15 * time.Minute,
+		BaseDelay:               time.Second,
+	})
+
+	for i := 0; i < 2; i++ {
+		throttle.Failure("192.0.2.1", "alice")
+		throttle.Failure("192.0.2.1", "bob")
+	}
+	if wait := throttle.Check("198.51.100.7", "alice"); wait != 15*time.Minute {
+		t.Fatalf("wait of the locked account = %v, want 15m", wait)
+	}
+
+	throttle.Success("Alice")
+	if wait := throttle.Check("198.51.100.7", "alice"); wait != 0 {
+		t.Errorf("wait of the account after a success = %v, want 0", wait)
+	}
+	if wait := throttle.Check("192.0.2.1", "alice"); wait != 15*time.Minute {
+		t.Errorf("wait of the address after a success = %v, want its lockout of 15m", wait)
+	}
+}
+
+func TestThrottleForgetsOldFailures(t *testing.T) {
+	throttle, clock := newTestThrottle(ThrottleConfig{
+		AccountLockoutThreshold: 5,
+		LockoutDuration:         15 * time.Minute,
+		BaseDelay:               time.Second,
+	})
+
+	for i := 0; i < 3; i++ {
+		throttle.Failure("192.0.2.1", "alice")
+	}
+	clock.advance(15*time.Minute - time.Second)
+	throttle.Failure("192.0.2.1", "alice")
+	if wait := throttle.Check("192.0.2.1", "alice"); wait != 8*time.Second {
+		t.Errorf("wait after a failure within the lockout duration = %v, want 8s", wait)
+	}
+
+	clock.advance(15 * time.Minute)
+	throttle.Failure("192.0.2.1", "alice")
+	if wait := throttle.Check("192.0.2.1", "alice"); wait != time.Second {
+		t.Errorf("wait after failures older than the lockout duration = %v, want 1s", wait)
+	}
+}
+
+func TestThrottlePrunesForgottenEntries(t *testing.T) {
+	throttle, clock := newTestThrottle(ThrottleConfig{
+		AccountLockoutThreshold: 2,
+		LockoutDuration:         time.Minute,
+		BaseDelay:               time.Second,
+	})
+
+	for i := 1; i < throttleMaxEntries; i++ {
+		throttle.Failure("192.0.2.1", fmt.Sprintf("user%d", i))
+	}
+	clock.advance(30 * time.Second)
+	throttle.Failure("192.0.2.1", "locked")
+	throttle.Failure("192.0.2.1", "locked")
+	if n := len(throttle.accounts); n != throttleMaxEntries {
+		t.Fatalf("tracked accounts = %d, want %d", n, throttleMaxEntries)
+	}
+
+	// The others are forgotten a minute after their failure, the locked
+	// account only once its lockout has ended.
+	clock.advance(31 * time.Second)
+	throttle.Failure("192.0.2.1", "new")
+	if n := len(throttle.accounts); n != 2 {
+		t.Errorf("tracked accounts after pruning = %d, want 2", n)
+	}
+}
+
+func TestThrottleDisabledLimits(t *testing.T) {
+	throttle, _ := newTestThrottle(ThrottleConfig{LockoutDuration: time.Minute, BaseDelay: time.Second})
+
+	for i := 0; i < 100; i++ {
+		if throttle.Failure("192.0.2.1", "alice") {
+			t.Fatal("failure reported a lockout with limits disabled")
+		}
+	}
+	if wait := throttle.Check("192.0.2.1", "alice"); wait != 0 {
+		t.Errorf("wait with limits disabled = %v, want 0", wait)
+	}
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"dmarc-report-analyzer/backend/src/db"
+)
+
+// APITokenPrefix starts every API token, which tells them apart from JWTs.
+const APITokenPrefix = "dra_"
+
+// API token lifetimes in days.
+const (
+	DefaultAPITokenDays = 90
+	MaxAPITokenDays     = 3650
+)
+
+var (
+	// ErrInvalidAPIToken is returned for API tokens that are unknown or expired.
+	ErrInvalidAPIToken = errors.New("invalid or expired API token")
+	// ErrInvalidTokenRequest is returned for API tokens that cannot be created
+	// as requested.
+	ErrInvalidTokenRequest = errors.New("invalid API token request")
+)
+
+// scopeRoles maps each API token scope to the role it acts with. The api
+// package further limits the upload scope to uploading reports.
+var scopeRoles = map[string]string{
+	db.ScopeRead:   db.RoleViewer,
+	db.ScopeUpload: db.RoleAnalyst,
+	db.ScopeAdmin:  db.RoleAdmin,
+}
+
+// IsAPIToken reports whether token looks like an API token rather than a JWT.
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
+
+// TokenRole returns the role a request authenticated with an API token acts
+// with: the role of its most privileged scope, but never more than the role
+// of the token's user.
+func TokenRole(userRole string, scopes []string) string {
+	role := ""
+	for _, scope := range scopes {
+		if r := scopeRoles[scope]; roleRanks[r] > roleRanks[role] {
+			role = r
+		}
+	}
+	if roleRanks[userRole] < roleRanks[role] {
+		return userRole
+	}
+	return role
+}
+
+// CreateAPIToken creates an API token for user that expires after the given
+// number of days. It returns the token, which is not stored and cannot be
+// shown again, and its stored record.
+func (s *AuthService) CreateAPIToken(user *db.User, name string, scopes []string, days int) (string, *db.APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 100 {
+		return "", nil, fmt.Errorf("%w: name must be 1 to 100 characters", ErrInvalidTokenRequest)
+	}
+	if len(scopes) == 0 {
+		return "", nil, fmt.Errorf("%w: at least one scope is required", ErrInvalidTokenRequest)
+	}
+	for _, scope := range scopes {
+		if !db.IsValidScope(scope) {
+			return "", nil, fmt.Errorf("%w: scopes must be among %s", ErrInvalidTokenRequest, strings.Join(db.Scopes, ", "))
+		}
+		if !HasRole(user.Role, scopeRoles[scope]) {
+			return "", nil, fmt.Errorf("%w: the %s scope requires the %s role", ErrInvalidTokenRequest, scope, scopeRoles[scope])
+		}
+	}
+	if days < 1 || days > MaxAPITokenDays {
+		return "", nil, fmt.Errorf("%w: expires_in_days must be between 1 and %d", ErrInvalidTokenRequest, MaxAPITokenDays)
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate API token: %w", err)
+	}
+	token := APITokenPrefix + secret
+
+	now := time.Now()
+	record := &db.APIToken{
+		UserID:    user.ID,
+		Name:      name,
+		TokenHash: hashSecret(token),
+		Prefix:    token[:len(APITokenPrefix)+6],
+		Scopes:    scopes,
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.AddDate(0, 0, days).Unix(),
+	}
+	if err := s.DBRepo.CreateAPIToken(record); err != nil {
+		return "", nil, err
+	}
+	return token, record, nil
+}
+
+// ValidateAPIToken looks up an API token and records its use. It fails with
+// ErrInvalidAPIToken if the token is unknown, revoked or expired.
+func (s *AuthService) ValidateAPIToken(token string) (*db.APIToken, error) {
+	record, err := s.DBRepo.GetAPITokenByHash(hashSecret(token))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	if record == nil || record.ExpiresAt <= now {
+		return nil, ErrInvalidAPIToken
+	}
+	if err := s.DBRepo.TouchAPIToken(record.ID, now); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// newSecret returns a random 256-bit secret, encoded for use in tokens.
+func newSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// hashSecret returns the stored form of a token made from newSecret. The
+// secrets are random, so a fast hash suffices and allows looking them up.
+func hashSecret(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"fmt"
+	"image/png"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"golang.org/x/crypto/bcrypt"
+
+	"dmarc-report-analyzer/backend/src/db"
+)
+
+// TOTP parameters. These are the defaults of authenticator apps (RFC 6238
+// with SHA-1, 6 digits and 30-second steps); codes of the neighbouring steps
+// are accepted to allow for clock drift.
+const (
+	totpIssuer = "DMARC Report Analyzer"
+	totpPeriod = 30
+	totpSkew   = 1
+)
+
+// RecoveryCodeCount is the number of recovery codes issued at a time.
+const RecoveryCodeCount = 10
+
+// mfaTokenLifetime bounds the time between the password and the TOTP step of
+// a login.
+const mfaTokenLifetime = 5 * time.Minute
+
+// mfaAudience is the audience of MFA tokens, which keeps them from being
+// accepted as access tokens and vice versa.
+const mfaAudience = "mfa"
+
+// totpOpts are the options of generated TOTP codes.
+var totpOpts = totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+
+// TOTPEnrolment is a TOTP secret waiting to be confirmed with a code.
+type TOTPEnrolment struct {
+	Secret          string
+	ProvisioningURI string // otpauth:// URI for authenticator apps
+	QRCode          string // PNG image of the URI as a data: URL
+}
+
+// BeginTOTPEnrolment generates a new TOTP secret for user and stores it
+// until ConfirmTOTP. The user's TOTP stays disabled meanwhile.
+func (s *AuthService) BeginTOTPEnrolment(user *db.User) (*TOTPEnrolment, error) {
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: user.Username})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+	img, err := key.Image(256, 256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render TOTP QR code: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode TOTP QR code: %w", err)
+	}
+	if err := s.DBRepo.SetTOTPSecret(user.ID, key.Secret()); err != nil {
+		return nil, err
+	}
+	return &TOTPEnrolment{
+		Secret:          key.Secret(),
+		ProvisioningURI: key.URL(),
+		QRCode:          "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, nil
+}
+
+// ConfirmTOTP enables TOTP for user if code matches the secret stored by
+// BeginTOTPEnrolment, and returns new recovery codes. It returns nil codes if
+// the code does not match.
+func (s *AuthService) ConfirmTOTP(user *db.User, code string) ([]string, error) {
+	if user.TOTPSecret == "" {
+		return nil, nil
+	}
+	step, ok := matchTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, nil
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.DBRepo.EnableTOTP(user.ID, step, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// VerifySecondFactor checks a TOTP code or an unused recovery code of user,
+// and uses it up so that it cannot be replayed.
+func (s *AuthService) VerifySecondFactor(user *db.User, code string) (bool, error) {
+	if !user.TOTPEnabled {
+		return false, nil
+	}
+	code = strings.TrimSpace(code)
+	if step, ok := matchTOTP(user.TOTPSecret, code, time.Now()); ok {
+		return s.DBRepo.UseTOTPStep(user.ID, step)
+	}
+
+	codes, err := s.DBRepo.UnusedRecoveryCodes(user.ID)
+	if err != nil {
+		return false, err
+	}
+	normalized := normalizeRecoveryCode(code)
+	for _, c := range codes {
+		if bcrypt.CompareHashAndPassword([]byte(c.CodeHash), []byte(normalized)) == nil {
+			return s.DBRepo.UseRecoveryCode(c.ID)
+		}
+	}
+	return false, nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of user with new ones.
+func (s *AuthService) RegenerateRecoveryCodes(user *db.User) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.DBRepo.ReplaceRecoveryCodes(user.ID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// SetAdminTOTPRequired sets whether admins must have TOTP enabled.
+func (s *AuthService) SetAdminTOTPRequired(required bool) {
+	s.adminTOTPRequired.Store(required)
+}
+
+// TOTPEnrolmentRequired reports whether user must enable TOTP before doing
+// anything else. Single sign-on users are exempt; their second factor is the
+// identity provider's business.
+func (s *AuthService) TOTPEnrolmentRequired(user *db.User) bool {
+	return s.adminTOTPRequired.Load() && user.Role == db.RoleAdmin && !user.TOTPEnabled && !user.UsesSingleSignOn()
+}
+
+// GenerateMFAToken issues the token that proves the password step of a login
+// for username, to be presented with the TOTP code.
+func (s *AuthService) GenerateMFAToken(username string) (string, error) {
+	now := time.Now()
+	claims := &jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(now.Add(mfaTokenLifetime)),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		Issuer:    "dmarc-report-analyzer",
+		Subject:   username,
+		Audience:  []string{mfaAudience},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.JWTSecret)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign MFA token: %w", err)
+	}
+	return token, nil
+}
+
+// ValidateMFAToken validates a token from GenerateMFAToken and returns its
+// username. Invalid and expired tokens fail with ErrInvalidToken.
+func (s *AuthService) ValidateMFAToken(tokenString string) (string, error) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return s.JWTSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer("dmarc-report-analyzer"),
+		jwt.WithAudience(mfaAudience))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return claims.Subject, nil
+}
+
+// matchTOTP reports whether code is the TOTP code of secret at now or a
+// neighbouring time step, and returns the matching step.
+func matchTOTP(secret, code string, now time.Time) (int64, bool) {
+	if len(code) != int(otp.DigitsSix) {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), totpOpts)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// newRecoveryCodes returns RecoveryCodeCount random recovery codes of the
+// form xxxxx-xxxxx and their bcrypt hashes.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, RecoveryCodeCount)
+	hashes := make([]string, 0, RecoveryCodeCount)
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		code := strings.ToLower(encoding.EncodeToString(raw)[:10])
+		hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to hash recovery code: %w", err)
+		}
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, string(hash))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode removes the formatting of a recovery code as typed.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors,
+// "12345678901234567890", in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestMatchTOTPVectors(t *testing.T) {
+	// The SHA-1 vectors of RFC 6238 appendix B, cut to six digits
+	vectors := []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, v := range vectors {
+		step, ok := matchTOTP(rfc6238Secret, v.code, time.Unix(v.time, 0))
+		if !ok || step != v.time/totpPeriod {
+			t.Errorf("matchTOTP(%s at %d) = %d, %t, want step %d", v.code, v.time, step, ok, v.time/totpPeriod)
+		}
+	}
+
+	// Codes of the neighbouring steps are accepted, others are not
+	at := time.Unix(1111111111, 0)
+	if step, ok := matchTOTP(rfc6238Secret, "050471", at.Add(totpPeriod*time.Second)); !ok || step != 1111111111/totpPeriod {
+		t.Errorf("code of the previous step = %d, %t, want its step", step, ok)
+	}
+	for _, tt := range []struct {
+		code string
+		at   time.Time
+	}{
+		{"050471", at.Add(3 * totpPeriod * time.Second)},
+		{"050472", at},
+		{"50471", at},
+		{"0504711", at},
+		{"", at},
+	} {
+		if _, ok := matchTOTP(rfc6238Secret, tt.code, tt.at); ok {
+			t.Errorf("matchTOTP(%q at %d) accepted the code", tt.code, tt.at.Unix())
+		}
+	}
+}
+
+func TestVerifySecondFactorRejectsReplay(t *testing.T) {
+	s, user := newTestAuthService(t)
+	if _, err := s.BeginTOTPEnrolment(user); err != nil {
+		t.Fatal(err)
+	}
+	user, err := s.DBRepo.GetUserByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	code := func(offset int) string {
+		t.Helper()
+		code, err := totp.GenerateCodeCustom(user.TOTPSecret, now.Add(time.Duration(offset*totpPeriod)*time.Second), totpOpts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	confirmed := code(-1)
+	codes, err := s.ConfirmTOTP(user, confirmed)
+	if err != nil || len(codes) != RecoveryCodeCount {
+		t.Fatalf("ConfirmTOTP = %d codes, %v", len(codes), err)
+	}
+	user, err = s.DBRepo.GetUserByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The code that confirmed the enrolment cannot be used to log in
+	if ok, err := s.VerifySecondFactor(user, confirmed); err != nil || ok {
+		t.Errorf("confirmation code = %t, %v, want rejected", ok, err)
+	}
+	next := code(1)
+	if ok, err := s.VerifySecondFactor(user, next); err != nil || !ok {
+		t.Fatalf("code of a later step = %t, %v, want accepted", ok, err)
+	}
+	// Neither the same code nor one of an earlier step is accepted afterwards
+	for _, replayed := range []string{next, code(0)} {
+		if ok, err := s.VerifySecondFactor(user, replayed); err != nil || ok {
+			t.Errorf("code %s after step %s = %t, %v, want rejected", replayed, next, ok, err)
+		}
+	}
+
+	// Recovery codes work once each
+	if ok, err := s.VerifySecondFactor(user, codes[0]); err != nil || !ok {
+		t.Errorf("recovery code = %t, %v, want accepted", ok, err)
+	}
+	if ok, err := s.VerifySecondFactor(user, codes[0]); err != nil || ok {
+		t.Errorf("used recovery code = %t, %v, want rejected", ok, err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // Config holds all application configurations.
@@ -16,10 +17,65 @@ type Config struct {
 	DataDir        string
 	IPGeoDBPath    string
 	ImportIPDBFile string // Path to MMDB file for manual import via CLI
+	BackupDir      string // Directory for snapshots created through the API
+	BackupAPI      bool   // Serve the backup and restore API routes
+
+	// CLI options for database backup and restore
+	BackupFile  string // Path to write a database snapshot to (.gz suffix compresses)
+	RestoreFile string // Path to a database snapshot to restore from
+
+	// CLI options for portable data archives
+	ExportDataFile string // Path to write a ZIP data archive to
+	ExportFrom     string // Optional YYYY-MM-DD start date for the export
+	ExportTo       string // Optional YYYY-MM-DD end date for the export
+	ImportDataFile string // Path to a ZIP data archive to merge in
 
 	// CLI options for user management
 	CreateUserUsername string
 	CreateUserPassword string
+	CreateUserRole     string
+
+	// CLI options for API tokens
+	CreateTokenName string // Name of an API token to create for TokenUser
+	TokenUser       string // Username the API token options act on
+	TokenScopes     string // Comma-separated scopes of the new API token
+	TokenDays       int    // Days until the new API token expires
+	ListTokensUser  string // Username whose API tokens to list
+	RevokeTokenID   int64  // ID of an API token to revoke
+
+	// Single sign-on through an OpenID Connect provider, enabled by OIDCIssuer
+	OIDCIssuer        string
+	OIDCClientID      string
+	OIDCClientSecret  string
+	OIDCRedirectURL   string            // Public URL of /api/auth/oidc/callback
+	OIDCScopes        []string          // Requested scopes
+	OIDCUsernameClaim string            // Claim with the local username
+	OIDCRoleClaim     string            // Claim with the user's groups or roles
+	OIDCRoleMap       map[string]string // Values of OIDCRoleClaim to roles
+	OIDCDefaultRole   string            // Role of users without a mapped value; empty denies them
+
+	DisablePasswordLogin bool // Refuse password logins, for single sign-on only
+
+	// Password logins against an LDAP directory, enabled by LDAPURL
+	LDAPURL            string
+	LDAPStartTLS       bool
+	LDAPBindDNTemplate string // DN with %s for the username, for direct binds
+	LDAPBindDN         string // Service account for search-then-bind
+	LDAPBindPassword   string
+	LDAPBaseDN         string            // Subtree to search for users
+	LDAPUserFilter     string            // Filter with %s for the username
+	LDAPGroupAttribute string            // Attribute listing the user's groups
+	LDAPRoleMap        map[string]string // Group CNs to roles
+	LDAPDefaultRole    string            // Role of users without a mapped group; empty denies them
+
+	// Password policy of local accounts
+	PasswordMinLength     int    // Minimum number of characters
+	BreachedPasswordsFile string // Optional list of passwords known from breaches
+
+	// Login throttling
+	LoginLockoutThreshold   int           // Failures that lock an account
+	LoginIPLockoutThreshold int           // Failures that block a client IP address
+	LoginLockoutDuration    time.Duration // How long lockouts last
 }
 
 // LoadConfig loads configuration from command-line flags and environment variables.
@@ -33,9 +89,52 @@ func LoadConfig() (*Config, error) {
 	flag.StringVar(&cfg.DataDir, "data-dir", "data", "Directory for application data (database, IP geo files)")
 	flag.StringVar(&cfg.ImportIPDBFile, "import-ip-db", "", "Path to an IPInfo MMDB file to import (e.g., /path/to/ipinfo-city.mmdb)")
 
+	flag.StringVar(&cfg.BackupFile, "backup", "", "Write a consistent database snapshot to the given path and exit (a .gz suffix compresses it)")
+	flag.StringVar(&cfg.RestoreFile, "restore", "", "Restore the database from a snapshot (plain or .gz) and exit")
+	flag.BoolVar(&cfg.BackupAPI, "enable-backup-api", false, "Let admins download, create and restore database snapshots through the API")
+
+	flag.StringVar(&cfg.ExportDataFile, "export-data", "", "Write a portable ZIP data archive to the given path and exit")
+	flag.StringVar(&cfg.ExportFrom, "export-from", "", "Only export reports beginning on or after this date (YYYY-MM-DD, used with --export-data)")
+	flag.StringVar(&cfg.ExportTo, "export-to", "", "Only export reports beginning on or before this date (YYYY-MM-DD, used with --export-data)")
+	flag.StringVar(&cfg.ImportDataFile, "import-data", "", "Merge a ZIP data archive into the database and exit")
+
 	// New flags for user creation
 	flag.StringVar(&cfg.CreateUserUsername, "create-user", "", "Create a new user with the given username")
 	flag.StringVar(&cfg.CreateUserPassword, "password", "", "Password for the new user (used with --create-user)")
+	flag.StringVar(&cfg.CreateUserRole, "role", "admin", "Role of the new user: admin, analyst or viewer (used with --create-user)")
+
+	flag.StringVar(&cfg.CreateTokenName, "create-token", "", "Create an API token with the given name for --token-user, print it and exit")
+	flag.StringVar(&cfg.TokenUser, "token-user", "", "Username to create the API token for (used with --create-token)")
+	flag.StringVar(&cfg.TokenScopes, "token-scopes", "read", "Comma-separated scopes of the API token: read, upload, admin (used with --create-token)")
+	flag.IntVar(&cfg.TokenDays, "token-days", 90, "Days until the API token expires (used with --create-token)")
+	flag.StringVar(&cfg.ListTokensUser, "list-tokens", "", "List the API tokens of the given username and exit")
+	flag.Int64Var(&cfg.RevokeTokenID, "revoke-token", 0, "Revoke the API token with the given ID and exit")
+
+	flag.StringVar(&cfg.OIDCIssuer, "oidc-issuer", os.Getenv("OIDC_ISSUER"), "Issuer URL of the OpenID Connect provider for single sign-on (environment variable OIDC_ISSUER)")
+	flag.StringVar(&cfg.OIDCClientID, "oidc-client-id", os.Getenv("OIDC_CLIENT_ID"), "OIDC client ID (environment variable OIDC_CLIENT_ID)")
+	flag.StringVar(&cfg.OIDCClientSecret, "oidc-client-secret", os.Getenv("OIDC_CLIENT_SECRET"), "OIDC client secret (environment variable OIDC_CLIENT_SECRET)")
+	flag.StringVar(&cfg.OIDCRedirectURL, "oidc-redirect-url", os.Getenv("OIDC_REDIRECT_URL"), "Public URL of /api/auth/oidc/callback, as registered with the provider (environment variable OIDC_REDIRECT_URL)")
+	oidcScopes := flag.String("oidc-scopes", "openid,profile,email", "Comma-separated OIDC scopes to request")
+	flag.StringVar(&cfg.OIDCUsernameClaim, "oidc-username-claim", "preferred_username", "OIDC claim with the username")
+	flag.StringVar(&cfg.OIDCRoleClaim, "oidc-role-claim", "groups", "OIDC claim with the user's groups or roles")
+	oidcRoleMap := flag.String("oidc-role-map", "", "Comma-separated VALUE=ROLE pairs mapping values of the role claim to admin, analyst or viewer, e.g. dmarc-admins=admin,dmarc-users=viewer")
+	flag.StringVar(&cfg.OIDCDefaultRole, "oidc-default-role", "", "Role of OIDC users without a mapped group; empty denies them")
+	flag.BoolVar(&cfg.DisablePasswordLogin, "disable-password-login", false, "Refuse password logins, so that users must use single sign-on")
+	flag.StringVar(&cfg.LDAPURL, "ldap-url", os.Getenv("LDAP_URL"), "URL of an LDAP server for password logins, e.g. ldaps://ad.example.com (environment variable LDAP_URL)")
+	flag.BoolVar(&cfg.LDAPStartTLS, "ldap-start-tls", false, "Upgrade ldap:// connections with StartTLS")
+	flag.StringVar(&cfg.LDAPBindDNTemplate, "ldap-bind-dn-template", "", "DN to bind as with %s for the username, e.g. uid=%s,ou=people,dc=example,dc=com; skips the user search")
+	flag.StringVar(&cfg.LDAPBindDN, "ldap-bind-dn", "", "DN of the service account that searches for users; empty searches anonymously")
+	flag.StringVar(&cfg.LDAPBindPassword, "ldap-bind-password", os.Getenv("LDAP_BIND_PASSWORD"), "Password of the LDAP service account (environment variable LDAP_BIND_PASSWORD)")
+	flag.StringVar(&cfg.LDAPBaseDN, "ldap-base-dn", "", "DN of the subtree to search for users, e.g. ou=people,dc=example,dc=com")
+	flag.StringVar(&cfg.LDAPUserFilter, "ldap-user-filter", "(uid=%s)", "LDAP filter finding a user with %s for the username; use (sAMAccountName=%s) for Active Directory")
+	flag.StringVar(&cfg.LDAPGroupAttribute, "ldap-group-attribute", "memberOf", "LDAP attribute listing the user's groups")
+	ldapRoleMap := flag.String("ldap-role-map", "", "Comma-separated GROUP=ROLE pairs mapping LDAP group CNs to admin, analyst or viewer, e.g. dmarc-admins=admin,dmarc-users=viewer")
+	flag.StringVar(&cfg.LDAPDefaultRole, "ldap-default-role", "", "Role of LDAP users without a mapped group; empty denies them")
+	flag.IntVar(&cfg.PasswordMinLength, "password-min-length", 12, "Minimum number of characters of local account passwords")
+	flag.StringVar(&cfg.BreachedPasswordsFile, "breached-passwords", "", "File of passwords, or SHA-1 hashes of passwords, that local accounts may not use, one per line")
+	flag.IntVar(&cfg.LoginLockoutThreshold, "login-lockout-threshold", 5, "Failed logins that lock an account for --login-lockout-duration; 0 disables the lockout")
+	flag.IntVar(&cfg.LoginIPLockoutThreshold, "login-ip-lockout-threshold", 50, "Failed logins that block a client IP address for --login-lockout-duration; 0 disables the block")
+	flag.DurationVar(&cfg.LoginLockoutDuration, "login-lockout-duration", 15*time.Minute, "How long locked accounts and blocked IP addresses have to wait")
 
 	flag.Parse()
 
@@ -69,9 +168,39 @@ func LoadConfig() (*Config, error) {
 		return nil, fmt.Errorf("failed to create IP geo database directory %s: %w", cfg.IPGeoDBPath, err)
 	}
 
-	// Validate JWT Secret only if not creating a user or importing IP DB
-	// If creating a user or importing IP DB, we might not need the server to run
-	if cfg.CreateUserUsername == "" && cfg.ImportIPDBFile == "" {
+	// Set BackupDir; it is created on first use
+	cfg.BackupDir = filepath.Join(cfg.DataDir, "backups")
+
+	cfg.OIDCScopes = splitList(*oidcScopes)
+	cfg.OIDCRoleMap, err = parseRoleMap(*oidcRoleMap)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --oidc-role-map: %w", err)
+	}
+	if cfg.OIDCIssuer != "" && (cfg.OIDCClientID == "" || cfg.OIDCRedirectURL == "") {
+		return nil, fmt.Errorf("--oidc-issuer requires --oidc-client-id and --oidc-redirect-url")
+	}
+	if cfg.DisablePasswordLogin && cfg.OIDCIssuer == "" {
+		return nil, fmt.Errorf("--disable-password-login requires single sign-on (--oidc-issuer)")
+	}
+	cfg.LDAPRoleMap, err = parseRoleMap(*ldapRoleMap)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --ldap-role-map: %w", err)
+	}
+	if cfg.LDAPURL != "" && cfg.LDAPBindDNTemplate == "" && cfg.LDAPBaseDN == "" {
+		return nil, fmt.Errorf("--ldap-url requires --ldap-bind-dn-template or --ldap-base-dn")
+	}
+
+	if cfg.PasswordMinLength < 1 {
+		return nil, fmt.Errorf("--password-min-length must be at least 1")
+	}
+	if cfg.LoginLockoutDuration <= 0 {
+		return nil, fmt.Errorf("--login-lockout-duration must be positive")
+	}
+
+	// Validate JWT Secret only when the server is going to run.
+	// CLI modes (user and token management, IP DB import, backup/restore, data
+	// export/import) exit before that.
+	if !cfg.IsCLIMode() {
 		if cfg.JWTSecret == "" {
 			return nil, fmt.Errorf("JWT_SECRET environment variable is not set. This is required for authentication.")
 		}
@@ -83,6 +212,39 @@ func LoadConfig() (*Config, error) {
 	return cfg, nil
 }
 
+// IsCLIMode reports whether a one-shot CLI option was given, in which case the
+// application performs that task and exits without starting the server.
+func (c *Config) IsCLIMode() bool {
+	return c.CreateUserUsername != "" || c.ImportIPDBFile != "" || c.BackupFile != "" || c.RestoreFile != "" ||
+		c.ExportDataFile != "" || c.ImportDataFile != "" || c.CreateTokenName != "" || c.ListTokensUser != "" || c.RevokeTokenID != 0
+}
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseRoleMap parses comma-separated VALUE=ROLE pairs. Roles are checked
+// by the caller.
+func parseRoleMap(s string) (map[string]string, error) {
+	roleMap := make(map[string]string)
+	for _, pair := range splitList(s) {
+		value, role, ok := strings.Cut(pair, "=")
+		value, role = strings.TrimSpace(value), strings.TrimSpace(role)
+		if !ok || value == "" || role == "" {
+			return nil, fmt.Errorf("%q is not a VALUE=ROLE pair", pair)
+		}
+		roleMap[value] = role
+	}
+	return roleMap, nil
+}
+
 // GetAppRoot returns the absolute path to the application's root directory.
 // This assumes the executable is directly in the root or a known subdirectory.
 func GetAppRoot() (string, error) {
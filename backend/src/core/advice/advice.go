@@ -0,0 +1,312 @@
+// Package advice derives remediation advice for DMARC records from their
+// authentication results, the published policy and the source IP information.
+package advice
+
+import (
+	"strconv"
+	"strings"
+
+	"dmarc-report-analyzer/backend/src/db"
+	"dmarc-report-analyzer/backend/src/util"
+)
+
+// Finding severities, matching the colors of the analysis modal.
+const (
+	SeveritySuccess = "success"
+	SeverityWarning = "warning"
+	SeverityDanger  = "danger"
+)
+
+// Finding codes. Codes are stable and can be used by clients to select icons
+// or documentation; messages and actions are localized.
+const (
+	CodeDMARCPass         = "dmarc_pass"
+	CodeDMARCFail         = "dmarc_fail"
+	CodeSPFNotAligned     = "spf_pass_not_aligned"
+	CodeSPFFail           = "spf_fail"
+	CodeDKIMThirdParty    = "dkim_third_party"
+	CodeDKIMFail          = "dkim_fail"
+	CodeDKIMMissing       = "dkim_missing"
+	CodePolicyNone        = "policy_none"
+	CodePolicyPctSampling = "policy_pct_sampling"
+	CodePolicyOverridden  = "policy_overridden"
+	CodeSourceWithoutPTR  = "source_without_ptr"
+)
+
+// Finding is a single piece of advice about a record.
+type Finding struct {
+	Severity string   `json:"severity"`
+	Code     string   `json:"code"`
+	Message  string   `json:"message"`
+	Actions  []string `json:"actions"`
+}
+
+// Policy is the DMARC policy published for the record's domain.
+type Policy struct {
+	Domain string
+	ADKIM  string // r or s
+	ASPF   string // r or s
+	P      string
+	SP     string
+	PCT    int
+}
+
+// Input holds everything the rules look at.
+type Input struct {
+	SourceIP    string
+	HeaderFrom  string
+	Disposition string
+	DKIMResult  string // Evaluated (aligned) DKIM result
+	SPFResult   string // Evaluated (aligned) SPF result
+	AuthResults []db.AnalyzedAuthResult
+	Policy      Policy
+	IPInfo      db.RecordIPInfo
+}
+
+// InputFromAnalysis builds the rule input from a record analysis.
+func InputFromAnalysis(a *db.RecordAnalysis) Input {
+	return Input{
+		SourceIP:    a.Record.SourceIP,
+		HeaderFrom:  a.Record.HeaderFrom,
+		Disposition: a.Record.Disposition,
+		DKIMResult:  a.Record.DKIMEvaluatedResult,
+		SPFResult:   a.Record.SPFEvaluatedResult,
+		AuthResults: a.Record.AuthResults,
+		Policy: Policy{
+			Domain: a.Report.PolicyDomain,
+			ADKIM:  a.Report.PolicyADKIM,
+			ASPF:   a.Report.PolicyASPF,
+			P:      a.Report.PolicyP,
+			SP:     a.Report.PolicySP,
+			PCT:    a.Report.PolicyPCT,
+		},
+		IPInfo: a.Record.IPInfo,
+	}
+}
+
+// rule inspects the input and returns its findings, if any.
+type rule func(in Input) []finding
+
+// finding is a finding before localization: the message and action templates
+// are looked up by code, and params fill their {placeholders}.
+type finding struct {
+	severity string
+	code     string
+	params   map[string]string
+	actions  []string // Action keys within the code's catalog entry
+}
+
+// rules are evaluated in order; findings are returned in the same order.
+var rules = []rule{
+	ruleDMARCResult,
+	ruleSPF,
+	ruleDKIM,
+	rulePolicy,
+	ruleSource,
+}
+
+// Evaluate runs every rule against in and returns the findings localized for
+// lang (see Languages). Unknown languages fall back to English.
+func Evaluate(in Input, lang string) []Finding {
+	catalog := catalogFor(lang)
+	findings := []Finding{}
+	for _, rule := range rules {
+		for _, f := range rule(in) {
+			findings = append(findings, f.localize(catalog))
+		}
+	}
+	return findings
+}
+
+// localize renders f with the messages of catalog.
+func (f finding) localize(catalog map[string]entry) Finding {
+	e := catalog[f.code]
+	var pairs []string
+	for k, v := range f.params {
+		pairs = append(pairs, "{"+k+"}", v)
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	out := Finding{
+		Severity: f.severity,
+		Code:     f.code,
+		Message:  replacer.Replace(e.message),
+		Actions:  []string{},
+	}
+	for _, key := range f.actions {
+		if action, ok := e.actions[key]; ok {
+			out.Actions = append(out.Actions, replacer.Replace(action))
+		}
+	}
+	return out
+}
+
+// ruleDMARCResult reports the overall DMARC outcome.
+func ruleDMARCResult(in Input) []finding {
+	params := map[string]string{
+		"header_from": in.HeaderFrom,
+		"ip":          in.SourceIP,
+		"source":      sourceLabel(in),
+		"disposition": in.Disposition,
+	}
+	if isPass(in.DKIMResult) || isPass(in.SPFResult) {
+		return []finding{{severity: SeveritySuccess, code: CodeDMARCPass, params: params}}
+	}
+
+	actions := []string{"verify_source", "authorize_or_block"}
+	if strings.EqualFold(in.Policy.P, "none") || in.Policy.P == "" {
+		actions = append(actions, "enforce_after_fix")
+	}
+	return []finding{{severity: SeverityDanger, code: CodeDMARCFail, params: params, actions: actions}}
+}
+
+// ruleSPF flags SPF passes that do not align and outright SPF failures.
+func ruleSPF(in Input) []finding {
+	if isPass(in.SPFResult) {
+		return nil
+	}
+	var findings []finding
+	for _, ar := range in.AuthResults {
+		if ar.AuthType != db.AuthTypeSPF {
+			continue
+		}
+		params := map[string]string{
+			"domain":      ar.Domain,
+			"header_from": in.HeaderFrom,
+			"ip":          in.SourceIP,
+			"result":      ar.Result,
+		}
+		if isPass(ar.Result) {
+			actions := []string{"custom_return_path"}
+			if strings.EqualFold(in.Policy.ASPF, "s") && aligned(ar.Domain, in.HeaderFrom, "r") {
+				actions = append(actions, "relax_aspf")
+			}
+			findings = append(findings, finding{severity: SeverityWarning, code: CodeSPFNotAligned, params: params, actions: actions})
+		} else {
+			severity := SeverityWarning
+			if !isPass(in.DKIMResult) {
+				severity = SeverityDanger // Nothing else saves DMARC
+			}
+			findings = append(findings, finding{severity: severity, code: CodeSPFFail, params: params,
+				actions: []string{"add_to_spf", "check_forwarding"}})
+		}
+	}
+	return findings
+}
+
+// ruleDKIM flags missing signatures, signatures that failed verification and
+// signatures by third-party domains that do not align with header_from.
+func ruleDKIM(in Input) []finding {
+	if isPass(in.DKIMResult) {
+		return nil
+	}
+	var findings []finding
+	signed := false
+	for _, ar := range in.AuthResults {
+		if ar.AuthType != db.AuthTypeDKIM {
+			continue
+		}
+		signed = true
+		params := map[string]string{
+			"domain":      ar.Domain,
+			"selector":    ar.Selector,
+			"header_from": in.HeaderFrom,
+			"result":      ar.Result,
+		}
+		switch {
+		case !isPass(ar.Result):
+			findings = append(findings, finding{severity: SeverityWarning, code: CodeDKIMFail, params: params,
+				actions: []string{"check_key", "check_modification"}})
+		case !aligned(ar.Domain, in.HeaderFrom, in.Policy.ADKIM):
+			actions := []string{"sign_with_own_domain"}
+			if strings.EqualFold(in.Policy.ADKIM, "s") && aligned(ar.Domain, in.HeaderFrom, "r") {
+				actions = append(actions, "relax_adkim")
+			}
+			findings = append(findings, finding{severity: SeverityWarning, code: CodeDKIMThirdParty, params: params, actions: actions})
+		}
+	}
+	if !signed {
+		findings = append(findings, finding{severity: SeverityWarning, code: CodeDKIMMissing,
+			params: map[string]string{"header_from": in.HeaderFrom}, actions: []string{"enable_dkim"}})
+	}
+	return findings
+}
+
+// rulePolicy flags weak published policies and receivers overriding them.
+func rulePolicy(in Input) []finding {
+	p := strings.ToLower(in.Policy.P)
+	params := map[string]string{
+		"domain":      in.Policy.Domain,
+		"p":           p,
+		"pct":         strconv.Itoa(in.Policy.PCT),
+		"disposition": in.Disposition,
+	}
+
+	var findings []finding
+	switch {
+	case p == "none":
+		findings = append(findings, finding{severity: SeverityWarning, code: CodePolicyNone, params: params,
+			actions: []string{"move_to_quarantine"}})
+	case in.Policy.PCT > 0 && in.Policy.PCT < 100:
+		findings = append(findings, finding{severity: SeverityWarning, code: CodePolicyPctSampling, params: params,
+			actions: []string{"raise_pct"}})
+	}
+
+	dmarcFailed := !isPass(in.DKIMResult) && !isPass(in.SPFResult)
+	if dmarcFailed && (p == "quarantine" || p == "reject") && strings.EqualFold(in.Disposition, db.DispositionNone) {
+		findings = append(findings, finding{severity: SeverityWarning, code: CodePolicyOverridden, params: params,
+			actions: []string{"check_forwarding_lists", "check_pct"}})
+	}
+	return findings
+}
+
+// ruleSource flags failing mail from sources without reverse DNS, which are
+// rarely legitimate senders.
+func ruleSource(in Input) []finding {
+	if isPass(in.DKIMResult) || isPass(in.SPFResult) {
+		return nil
+	}
+	if hostname := in.IPInfo.Hostname; hostname != "" && hostname != "N/A" {
+		return nil
+	}
+	return []finding{{severity: SeverityWarning, code: CodeSourceWithoutPTR,
+		params: map[string]string{"ip": in.SourceIP, "source": sourceLabel(in)}, actions: []string{"treat_as_suspicious"}}}
+}
+
+// sourceLabel describes the source IP with its AS and hostname when known.
+func sourceLabel(in Input) string {
+	var details []string
+	if as := in.IPInfo.ASName; as != "" && as != "N/A" {
+		details = append(details, as)
+	}
+	if host := in.IPInfo.Hostname; host != "" && host != "N/A" {
+		details = append(details, host)
+	}
+	if len(details) == 0 {
+		return in.SourceIP
+	}
+	return in.SourceIP + " (" + strings.Join(details, ", ") + ")"
+}
+
+// isPass reports whether an authentication result is a pass.
+func isPass(result string) bool {
+	return strings.EqualFold(result, db.ResultPass)
+}
+
+// aligned reports whether domain aligns with headerFrom under mode: "s"
+// (strict) requires an exact match, anything else (relaxed) the same
+// organizational domain.
+func aligned(domain, headerFrom, mode string) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	headerFrom = strings.ToLower(strings.TrimSuffix(headerFrom, "."))
+	if domain == "" || headerFrom == "" {
+		return false
+	}
+	if domain == headerFrom {
+		return true
+	}
+	if strings.EqualFold(mode, "s") {
+		return false
+	}
+	return util.GetApexDomain(domain) == util.GetApexDomain(headerFrom)
+}
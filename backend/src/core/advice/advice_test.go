@@ -0,0 +1,184 @@
+package advice
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"dmarc-report-analyzer/backend/src/db"
+)
+
+// passingInput returns the input of a record that passes DMARC with aligned
+// SPF and DKIM under an enforced policy, so that no rule but
+// ruleDMARCResult has anything to report.
+func passingInput() Input {
+	return Input{
+		SourceIP:    "192.0.2.1",
+		HeaderFrom:  "example.com",
+		Disposition: db.DispositionNone,
+		DKIMResult:  "pass",
+		SPFResult:   "pass",
+		AuthResults: []db.AnalyzedAuthResult{
+			{AuthType: db.AuthTypeDKIM, Domain: "example.com", Result: "pass", Selector: "s1"},
+			{AuthType: db.AuthTypeSPF, Domain: "example.com", Result: "pass"},
+		},
+		Policy: Policy{Domain: "example.com", ADKIM: "r", ASPF: "r", P: "reject", PCT: 100},
+		IPInfo: db.RecordIPInfo{Hostname: "mail.example.com", ASName: "Example AS"},
+	}
+}
+
+// summarize lists the findings as "code severity action,action".
+func summarize(findings []finding) []string {
+	out := []string{}
+	for _, f := range findings {
+		out = append(out, strings.TrimSpace(f.code+" "+f.severity+" "+strings.Join(f.actions, ",")))
+	}
+	return out
+}
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   rule
+		modify func(in *Input)
+		want   []string
+	}{
+		{"dmarc pass", ruleDMARCResult, func(in *Input) {}, []string{"dmarc_pass success"}},
+		{"dmarc pass with dkim only", ruleDMARCResult, func(in *Input) { in.SPFResult = "fail" }, []string{"dmarc_pass success"}},
+		{"dmarc fail", ruleDMARCResult, func(in *Input) { in.DKIMResult, in.SPFResult = "fail", "fail" },
+			[]string{"dmarc_fail danger verify_source,authorize_or_block"}},
+		{"dmarc fail without enforcement", ruleDMARCResult, func(in *Input) {
+			in.DKIMResult, in.SPFResult, in.Policy.P = "fail", "fail", "none"
+		}, []string{"dmarc_fail danger verify_source,authorize_or_block,enforce_after_fix"}},
+
+		{"spf aligned", ruleSPF, func(in *Input) {}, []string{}},
+		{"spf pass not aligned", ruleSPF, func(in *Input) {
+			in.SPFResult = "fail"
+			in.AuthResults[1].Domain = "bounce.mailer.example.net"
+		}, []string{"spf_pass_not_aligned warning custom_return_path"}},
+		{"spf pass not aligned under strict alignment", ruleSPF, func(in *Input) {
+			in.SPFResult, in.Policy.ASPF = "fail", "s"
+			in.AuthResults[1].Domain = "bounce.example.com"
+		}, []string{"spf_pass_not_aligned warning custom_return_path,relax_aspf"}},
+		{"spf fail saved by dkim", ruleSPF, func(in *Input) {
+			in.SPFResult = "fail"
+			in.AuthResults[1].Result = "softfail"
+		}, []string{"spf_fail warning add_to_spf,check_forwarding"}},
+		{"spf fail", ruleSPF, func(in *Input) {
+			in.SPFResult, in.DKIMResult = "fail", "fail"
+			in.AuthResults[1].Result = "fail"
+		}, []string{"spf_fail danger add_to_spf,check_forwarding"}},
+
+		{"dkim aligned", ruleDKIM, func(in *Input) {}, []string{}},
+		{"dkim fail", ruleDKIM, func(in *Input) {
+			in.DKIMResult = "fail"
+			in.AuthResults[0].Result = "fail"
+		}, []string{"dkim_fail warning check_key,check_modification"}},
+		{"dkim third party", ruleDKIM, func(in *Input) {
+			in.DKIMResult = "fail"
+			in.AuthResults[0].Domain = "mailer.example.net"
+		}, []string{"dkim_third_party warning sign_with_own_domain"}},
+		{"dkim subdomain under strict alignment", ruleDKIM, func(in *Input) {
+			in.DKIMResult, in.Policy.ADKIM = "fail", "s"
+			in.AuthResults[0].Domain = "mail.example.com"
+		}, []string{"dkim_third_party warning sign_with_own_domain,relax_adkim"}},
+		{"dkim missing", ruleDKIM, func(in *Input) {
+			in.DKIMResult = "none"
+			in.AuthResults = in.AuthResults[1:]
+		}, []string{"dkim_missing warning enable_dkim"}},
+
+		{"policy enforced", rulePolicy, func(in *Input) {}, []string{}},
+		{"policy none", rulePolicy, func(in *Input) { in.Policy.P = "None" }, []string{"policy_none warning move_to_quarantine"}},
+		{"policy sampling", rulePolicy, func(in *Input) { in.Policy.PCT = 10 }, []string{"policy_pct_sampling warning raise_pct"}},
+		{"policy without pct", rulePolicy, func(in *Input) { in.Policy.PCT = 0 }, []string{}},
+		{"policy overridden", rulePolicy, func(in *Input) { in.DKIMResult, in.SPFResult = "fail", "fail" },
+			[]string{"policy_overridden warning check_forwarding_lists,check_pct"}},
+		{"policy applied", rulePolicy, func(in *Input) {
+			in.DKIMResult, in.SPFResult, in.Disposition = "fail", "fail", "reject"
+		}, []string{}},
+
+		{"source of passing mail", ruleSource, func(in *Input) { in.IPInfo.Hostname = "" }, []string{}},
+		{"source with ptr", ruleSource, func(in *Input) { in.DKIMResult, in.SPFResult = "fail", "fail" }, []string{}},
+		{"source without ptr", ruleSource, func(in *Input) {
+			in.DKIMResult, in.SPFResult, in.IPInfo.Hostname = "fail", "fail", "N/A"
+		}, []string{"source_without_ptr warning treat_as_suspicious"}},
+	}
+
+	for _, tt := range tests {
+		in := passingInput()
+		tt.modify(&in)
+		findings := tt.rule(in)
+		if got := summarize(findings); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: findings = %q, want %q", tt.name, got, tt.want)
+		}
+
+		// Every finding has to be localizable in every language
+		for _, lang := range Languages {
+			for _, f := range findings {
+				e, ok := catalogs[lang][f.code]
+				if !ok {
+					t.Errorf("%s: %s catalog lacks %s", tt.name, lang, f.code)
+				}
+				for _, action := range f.actions {
+					if _, ok := e.actions[action]; !ok {
+						t.Errorf("%s: %s catalog lacks action %s of %s", tt.name, lang, action, f.code)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestEvaluateLocalizes(t *testing.T) {
+	in := passingInput()
+	in.DKIMResult, in.SPFResult, in.Policy.P = "fail", "fail", "none"
+	in.IPInfo.Hostname = ""
+	in.AuthResults[0].Result = "fail"
+	in.AuthResults[1].Result = "fail"
+
+	findings := Evaluate(in, "fr") // Falls back to English
+	var codes []string
+	for _, f := range findings {
+		codes = append(codes, f.Code)
+		if strings.ContainsAny(f.Message, "{}") {
+			t.Errorf("%s message %q has an unreplaced placeholder", f.Code, f.Message)
+		}
+		for _, action := range f.Actions {
+			if strings.ContainsAny(action, "{}") {
+				t.Errorf("%s action %q has an unreplaced placeholder", f.Code, action)
+			}
+		}
+	}
+	want := []string{CodeDMARCFail, CodeSPFFail, CodeDKIMFail, CodePolicyNone, CodeSourceWithoutPTR}
+	if fmt.Sprint(codes) != fmt.Sprint(want) {
+		t.Fatalf("codes = %v, want %v", codes, want)
+	}
+	if msg := findings[0].Message; !strings.Contains(msg, "example.com") || !strings.Contains(msg, "192.0.2.1 (Example AS)") {
+		t.Errorf("message = %q, want the header_from and source filled in", msg)
+	}
+
+	if ja := Evaluate(in, LangJapanese); ja[0].Message == findings[0].Message || len(ja[0].Actions) != len(findings[0].Actions) {
+		t.Errorf("Japanese finding = %+v, want a translation of %+v", ja[0], findings[0])
+	}
+}
+
+func TestAligned(t *testing.T) {
+	tests := []struct {
+		domain, headerFrom, mode string
+		want                     bool
+	}{
+		{"example.com", "example.com", "s", true},
+		{"Example.COM.", "example.com", "s", true},
+		{"mail.example.com", "example.com", "s", false},
+		{"mail.example.com", "example.com", "r", true},
+		{"mail.example.com", "news.example.com", "", true},
+		{"example.net", "example.com", "r", false},
+		{"example.co.uk", "other.co.uk", "r", false},
+		{"", "example.com", "r", false},
+	}
+	for _, tt := range tests {
+		if got := aligned(tt.domain, tt.headerFrom, tt.mode); got != tt.want {
+			t.Errorf("aligned(%q, %q, %q) = %t, want %t", tt.domain, tt.headerFrom, tt.mode, got, tt.want)
+		}
+	}
+}
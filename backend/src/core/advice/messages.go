@@ -0,0 +1,193 @@
+package advice
+
+import "strings"
+
+// Supported message languages, matching the frontend locales.
+const (
+	LangEnglish  = "en"
+	LangJapanese = "ja"
+)
+
+// Languages lists the supported message languages; the first is the default.
+var Languages = []string{LangEnglish, LangJapanese}
+
+// entry holds the localized message of a finding code and its actions.
+type entry struct {
+	message string
+	actions map[string]string
+}
+
+// catalogs holds the messages per language. Placeholders in braces are
+// replaced with the finding's parameters.
+var catalogs = map[string]map[string]entry{
+	LangEnglish: {
+		CodeDMARCPass: {
+			message: "DMARC passes for {header_from}: mail from {source} is authenticated with an aligned identifier.",
+		},
+		CodeDMARCFail: {
+			message: "DMARC fails for {header_from}: neither SPF nor DKIM produced an aligned pass for mail from {source} (disposition: {disposition}).",
+			actions: map[string]string{
+				"verify_source":      "Check whether {source} is a service that legitimately sends mail for {header_from}.",
+				"authorize_or_block": "If it is legitimate, authorize it with SPF and aligned DKIM signing; if not, this may be spoofing that your policy should block.",
+				"enforce_after_fix":  "Once all legitimate sources pass, move the policy from p=none to quarantine or reject.",
+			},
+		},
+		CodeSPFNotAligned: {
+			message: "SPF passes for {domain}, but that domain is not aligned with the header From domain {header_from}.",
+			actions: map[string]string{
+				"custom_return_path": "Configure the sending service to use a custom envelope sender (Return-Path) under {header_from}.",
+				"relax_aspf":         "The domains share an organizational domain; relaxed SPF alignment (aspf=r) would make this pass.",
+			},
+		},
+		CodeSPFFail: {
+			message: "SPF evaluated to {result} for {domain}: {ip} is not authorized by its SPF record.",
+			actions: map[string]string{
+				"add_to_spf":       "If {ip} sends legitimate mail for {domain}, add it (or the provider's include) to the SPF record.",
+				"check_forwarding": "Forwarded mail commonly fails SPF; make sure such mail carries an aligned DKIM signature.",
+			},
+		},
+		CodeDKIMThirdParty: {
+			message: "DKIM is signed by the third-party domain {domain}, which is not aligned with {header_from}.",
+			actions: map[string]string{
+				"sign_with_own_domain": "Configure the sending service to sign with your own domain (d={header_from}) and publish its DKIM key.",
+				"relax_adkim":          "The domains share an organizational domain; relaxed DKIM alignment (adkim=r) would make this pass.",
+			},
+		},
+		CodeDKIMFail: {
+			message: "The DKIM signature of {domain} (selector {selector}) failed verification ({result}).",
+			actions: map[string]string{
+				"check_key":          "Check that the public key for selector {selector} is published in DNS and matches the signing key.",
+				"check_modification": "Mailing lists and gateways that modify messages break DKIM signatures.",
+			},
+		},
+		CodeDKIMMissing: {
+			message: "The message carried no DKIM signature.",
+			actions: map[string]string{
+				"enable_dkim": "Enable DKIM signing for {header_from} on this source; DKIM survives forwarding where SPF does not.",
+			},
+		},
+		CodePolicyNone: {
+			message: "The policy for {domain} is p=none: failing mail is only monitored, not blocked.",
+			actions: map[string]string{
+				"move_to_quarantine": "After fixing legitimate sources, move to p=quarantine and then p=reject.",
+			},
+		},
+		CodePolicyPctSampling: {
+			message: "The policy for {domain} is p={p} with pct={pct}: only {pct}% of failing mail is subject to the policy.",
+			actions: map[string]string{
+				"raise_pct": "Raise pct step by step to 100 so that the policy applies to all failing mail.",
+			},
+		},
+		CodePolicyOverridden: {
+			message: "DMARC failed and the policy is p={p}, but the receiver delivered the mail (disposition: {disposition}).",
+			actions: map[string]string{
+				"check_forwarding_lists": "Receivers often override the policy for forwarded or mailing list mail; check the report's override reasons.",
+				"check_pct":              "With pct below 100, part of the failing mail is deliberately exempted.",
+			},
+		},
+		CodeSourceWithoutPTR: {
+			message: "{source} has no reverse DNS (PTR) record.",
+			actions: map[string]string{
+				"treat_as_suspicious": "Legitimate mail servers almost always have reverse DNS; treat failing mail from this source as likely spoofing.",
+			},
+		},
+	},
+	LangJapanese: {
+		CodeDMARCPass: {
+			message: "{header_from} の DMARC は合格です。{source} からのメールはアラインされた識別子で認証されています。",
+		},
+		CodeDMARCFail: {
+			message: "{header_from} の DMARC は不合格です。{source} からのメールは SPF、DKIM のいずれもアラインされた合格になりませんでした（処理: {disposition}）。",
+			actions: map[string]string{
+				"verify_source":      "{source} が {header_from} のメールを正当に送信するサービスかどうか確認してください。",
+				"authorize_or_block": "正当な送信元であれば SPF とアラインされた DKIM 署名で認可してください。そうでなければなりすましの可能性があり、ポリシーでブロックすべきです。",
+				"enforce_after_fix":  "正当な送信元がすべて合格したら、ポリシーを p=none から quarantine または reject に移行してください。",
+			},
+		},
+		CodeSPFNotAligned: {
+			message: "{domain} の SPF は合格していますが、ヘッダー From ドメイン {header_from} とアラインしていません。",
+			actions: map[string]string{
+				"custom_return_path": "送信サービスで {header_from} 配下の独自のエンベロープ送信者（Return-Path）を使うよう設定してください。",
+				"relax_aspf":         "両ドメインは同じ組織ドメインです。緩和 SPF アライメント（aspf=r）にすれば合格します。",
+			},
+		},
+		CodeSPFFail: {
+			message: "{domain} の SPF 評価結果は {result} です。{ip} は SPF レコードで認可されていません。",
+			actions: map[string]string{
+				"add_to_spf":       "{ip} が {domain} の正当なメールを送信している場合は、SPF レコードに追加（またはプロバイダーの include を追加）してください。",
+				"check_forwarding": "転送されたメールは SPF に失敗しがちです。そのようなメールにアラインされた DKIM 署名があることを確認してください。",
+			},
+		},
+		CodeDKIMThirdParty: {
+			message: "DKIM はサードパーティドメイン {domain} で署名されており、{header_from} とアラインしていません。",
+			actions: map[string]string{
+				"sign_with_own_domain": "送信サービスで自社ドメイン（d={header_from}）で署名するよう設定し、DKIM 公開鍵を公開してください。",
+				"relax_adkim":          "両ドメインは同じ組織ドメインです。緩和 DKIM アライメント（adkim=r）にすれば合格します。",
+			},
+		},
+		CodeDKIMFail: {
+			message: "{domain}（セレクター {selector}）の DKIM 署名の検証に失敗しました（{result}）。",
+			actions: map[string]string{
+				"check_key":          "セレクター {selector} の公開鍵が DNS に公開され、署名鍵と一致していることを確認してください。",
+				"check_modification": "メッセージを書き換えるメーリングリストやゲートウェイは DKIM 署名を壊します。",
+			},
+		},
+		CodeDKIMMissing: {
+			message: "メッセージに DKIM 署名がありませんでした。",
+			actions: map[string]string{
+				"enable_dkim": "この送信元で {header_from} の DKIM 署名を有効にしてください。DKIM は SPF と違い転送後も有効です。",
+			},
+		},
+		CodePolicyNone: {
+			message: "{domain} のポリシーは p=none です。不合格のメールは監視されるだけでブロックされません。",
+			actions: map[string]string{
+				"move_to_quarantine": "正当な送信元を修正した後、p=quarantine、さらに p=reject に移行してください。",
+			},
+		},
+		CodePolicyPctSampling: {
+			message: "{domain} のポリシーは p={p}、pct={pct} です。不合格メールの {pct}% にしかポリシーが適用されません。",
+			actions: map[string]string{
+				"raise_pct": "pct を段階的に 100 まで引き上げ、不合格メールすべてにポリシーを適用してください。",
+			},
+		},
+		CodePolicyOverridden: {
+			message: "DMARC は不合格でポリシーは p={p} ですが、受信側はメールを配送しました（処理: {disposition}）。",
+			actions: map[string]string{
+				"check_forwarding_lists": "受信側は転送メールやメーリングリストのメールでポリシーを上書きすることがよくあります。レポートの上書き理由を確認してください。",
+				"check_pct":              "pct が 100 未満の場合、不合格メールの一部は意図的に適用対象外になります。",
+			},
+		},
+		CodeSourceWithoutPTR: {
+			message: "{source} には逆引き DNS（PTR）レコードがありません。",
+			actions: map[string]string{
+				"treat_as_suspicious": "正当なメールサーバーにはほぼ必ず逆引き DNS があります。この送信元からの不合格メールはなりすましの可能性が高いと考えてください。",
+			},
+		},
+	},
+}
+
+// catalogFor returns the catalog for lang, falling back to the default language.
+func catalogFor(lang string) map[string]entry {
+	if catalog, ok := catalogs[lang]; ok {
+		return catalog
+	}
+	return catalogs[Languages[0]]
+}
+
+// NegotiateLanguage picks the supported language for an explicit lang value
+// or, if that is empty or unsupported, the first supported language in an
+// Accept-Language header. It returns the default language otherwise.
+func NegotiateLanguage(lang, acceptLanguage string) string {
+	candidates := []string{lang}
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		candidates = append(candidates, tag)
+	}
+	for _, candidate := range candidates {
+		base, _, _ := strings.Cut(strings.ToLower(candidate), "-")
+		if _, ok := catalogs[base]; ok {
+			return base
+		}
+	}
+	return Languages[0]
+}
@@ -0,0 +1,103 @@
+package advice
+
+import (
+	"regexp"
+	"sort"
+	"testing"
+)
+
+// placeholderPattern matches the {placeholders} of a message.
+var placeholderPattern = regexp.MustCompile(`\{[a-z_]+\}`)
+
+// placeholders returns the sorted distinct placeholders of s.
+func placeholders(s string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, p := range placeholderPattern.FindAllString(s, -1) {
+		if !seen[p] {
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestCatalogsMatch(t *testing.T) {
+	codes := []string{
+		CodeDMARCPass, CodeDMARCFail, CodeSPFNotAligned, CodeSPFFail, CodeDKIMThirdParty, CodeDKIMFail,
+		CodeDKIMMissing, CodePolicyNone, CodePolicyPctSampling, CodePolicyOverridden, CodeSourceWithoutPTR,
+	}
+	if len(catalogs) != len(Languages) {
+		t.Errorf("%d catalogs for %d languages", len(catalogs), len(Languages))
+	}
+
+	reference := catalogs[Languages[0]]
+	if len(reference) != len(codes) {
+		t.Errorf("%s catalog has %d codes, want %d", Languages[0], len(reference), len(codes))
+	}
+	for _, lang := range Languages {
+		catalog, ok := catalogs[lang]
+		if !ok {
+			t.Errorf("no catalog for %s", lang)
+			continue
+		}
+		if len(catalog) != len(reference) {
+			t.Errorf("%s catalog has %d codes, want %d", lang, len(catalog), len(reference))
+		}
+		for _, code := range codes {
+			e, ok := catalog[code]
+			if !ok || e.message == "" {
+				t.Errorf("%s catalog lacks a message for %s", lang, code)
+				continue
+			}
+			// Translations have to use the same placeholders as English
+			if got, want := placeholders(e.message), placeholders(reference[code].message); !equalStrings(got, want) {
+				t.Errorf("%s message of %s has placeholders %v, want %v", lang, code, got, want)
+			}
+			if len(e.actions) != len(reference[code].actions) {
+				t.Errorf("%s catalog has %d actions for %s, want %d", lang, len(e.actions), code, len(reference[code].actions))
+			}
+			for key, action := range reference[code].actions {
+				translated, ok := e.actions[key]
+				if !ok || translated == "" {
+					t.Errorf("%s catalog lacks action %s of %s", lang, key, code)
+					continue
+				}
+				if got, want := placeholders(translated), placeholders(action); !equalStrings(got, want) {
+					t.Errorf("%s action %s of %s has placeholders %v, want %v", lang, key, code, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestNegotiateLanguage(t *testing.T) {
+	tests := []struct {
+		lang, acceptLanguage, want string
+	}{
+		{"", "", LangEnglish},
+		{"ja", "en-US,en;q=0.9", LangJapanese},
+		{"JA-jp", "", LangJapanese},
+		{"fr", "fr-FR, ja;q=0.8, en;q=0.5", LangJapanese},
+		{"", "de-DE,de;q=0.9", LangEnglish},
+	}
+	for _, tt := range tests {
+		if got := NegotiateLanguage(tt.lang, tt.acceptLanguage); got != tt.want {
+			t.Errorf("NegotiateLanguage(%q, %q) = %q, want %q", tt.lang, tt.acceptLanguage, got, tt.want)
+		}
+	}
+}
+
+// equalStrings reports whether a and b hold the same strings in order.
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
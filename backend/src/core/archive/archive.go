@@ -0,0 +1,453 @@
+package archive
+
+import (
+	"archive/zip"
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"dmarc-report-analyzer/backend/src/db"
+)
+
+const (
+	// DataFileName is the name of the JSON document inside a data archive.
+	DataFileName = "dmarc-analyzer-data.json"
+	// FormatName identifies data archives written by this application.
+	FormatName = "dmarc-report-analyzer"
+	// FormatVersion is the archive format version written by Export. Import
+	// accepts this version and older ones.
+	FormatVersion = 1
+)
+
+// archiveReport is a report as stored in a data archive. The archive types are
+// kept separate from the db models so the file format stays stable.
+type archiveReport struct {
+	XMLHash        string          `json:"xml_hash"`
+	OriginalXML    string          `json:"original_xml"`
+	OrgName        string          `json:"org_name"`
+	ReportID       string          `json:"report_id"`
+	DateRangeBegin int64           `json:"date_range_begin"`
+	DateRangeEnd   int64           `json:"date_range_end"`
+	Domain         string          `json:"domain"`
+	ADKIM          string          `json:"adkim"`
+	ASPF           string          `json:"aspf"`
+	P              string          `json:"p"`
+	SP             string          `json:"sp"`
+	PCT            int             `json:"pct"`
+	Records        []archiveRecord `json:"records"`
+}
+
+// archiveRecord is a record as stored in a data archive.
+type archiveRecord struct {
+	SourceIP    string              `json:"source_ip"`
+	Count       int                 `json:"count"`
+	HeaderFrom  string              `json:"header_from"`
+	Disposition string              `json:"disposition"`
+	DKIMResult  string              `json:"dkim_result"`
+	SPFResult   string              `json:"spf_result"`
+	AuthResults []archiveAuthResult `json:"auth_results"`
+}
+
+// archiveAuthResult is a raw DKIM or SPF result as stored in a data archive.
+type archiveAuthResult struct {
+	Type     string `json:"type"`
+	Domain   string `json:"domain"`
+	Result   string `json:"result"`
+	Selector string `json:"selector,omitempty"`
+}
+
+// archiveIPInfo is IP enrichment data as stored in a data archive.
+type archiveIPInfo struct {
+	IPAddress        string `json:"ip_address"`
+	CountryCode      string `json:"country_code"`
+	CountryName      string `json:"country_name"`
+	CityName         string `json:"city_name"`
+	ASNNumber        int    `json:"asn_number"`
+	ASNOrganization  string `json:"asn_organization"`
+	Hostname         string `json:"hostname"`
+	ReversedHostname string `json:"reversed_hostname"`
+	ApexDomain       string `json:"apex_domain"`
+	LastUpdated      int64  `json:"last_updated"`
+}
+
+// ExportSummary describes the content of a written archive.
+type ExportSummary struct {
+	Reports int `json:"reports"`
+	Records int `json:"records"`
+	IPInfo  int `json:"ip_info"`
+}
+
+// ImportSummary describes the outcome of merging an archive.
+type ImportSummary struct {
+	ReportsImported int      `json:"reports_imported"`
+	ReportsSkipped  int      `json:"reports_skipped"`
+	ReportsFailed   int      `json:"reports_failed"`
+	RecordsImported int      `json:"records_imported"`
+	IPInfoImported  int      `json:"ip_info_imported"`
+	Errors          []string `json:"errors,omitempty"`
+}
+
+// Archiver exports and imports portable data archives.
+type Archiver struct {
+	DBRepo *db.Repository
+}
+
+// NewArchiver creates a new Archiver instance.
+func NewArchiver(dbRepo *db.Repository) *Archiver {
+	return &Archiver{DBRepo: dbRepo}
+}
+
+// Export writes a ZIP archive containing every report whose date range begins
+// within [begin, end] (0 = unbounded), with original XML, records, auth results
+// and the IP information of their source IPs. The JSON document is streamed so
+// large databases are not held in memory.
+func (a *Archiver) Export(w io.Writer, begin, end int64) (*ExportSummary, error) {
+	zipWriter := zip.NewWriter(w)
+	entry, err := zipWriter.CreateHeader(&zip.FileHeader{
+		Name:     DataFileName,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive entry: %w", err)
+	}
+
+	out := bufio.NewWriter(entry)
+	summary := &ExportSummary{}
+
+	fmt.Fprintf(out, `{"format":%q,"version":%d,"exported_at":%d,"reports":[`, FormatName, FormatVersion, time.Now().Unix())
+
+	seenIPs := make(map[string]struct{})
+	var ips []string
+	encoder := json.NewEncoder(out)
+	err = a.DBRepo.ForEachReportBundle(begin, end, func(bundle *db.ReportBundle) error {
+		if summary.Reports > 0 {
+			out.WriteString(",")
+		}
+		if err := encoder.Encode(toArchiveReport(bundle)); err != nil {
+			return fmt.Errorf("failed to encode report %d: %w", bundle.Report.ID, err)
+		}
+		summary.Reports++
+		summary.Records += len(bundle.Records)
+
+		for _, record := range bundle.Records {
+			if _, ok := seenIPs[record.Record.SourceIP]; !ok {
+				seenIPs[record.Record.SourceIP] = struct{}{}
+				ips = append(ips, record.Record.SourceIP)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out.WriteString(`],"ip_info":[`)
+	for _, ip := range ips {
+		info, err := a.DBRepo.GetIPInfo(ip)
+		if err != nil {
+			return nil, err
+		}
+		if info == nil {
+			continue
+		}
+		if summary.IPInfo > 0 {
+			out.WriteString(",")
+		}
+		if err := encoder.Encode(toArchiveIPInfo(info)); err != nil {
+			return nil, fmt.Errorf("failed to encode IP info for %s: %w", ip, err)
+		}
+		summary.IPInfo++
+	}
+	out.WriteString("]}\n")
+
+	if err := out.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := zipWriter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish archive: %w", err)
+	}
+	return summary, nil
+}
+
+// Import merges the archive in r into the database. Reports whose xml_hash is
+// already present are skipped; IP information is kept from whichever side was
+// updated last. Problems with individual reports are recorded in the summary
+// instead of aborting the import.
+func (a *Archiver) Import(r io.ReaderAt, size int64) (*ImportSummary, error) {
+	zipReader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("not a valid ZIP archive: %w", err)
+	}
+
+	var dataFile *zip.File
+	for _, f := range zipReader.File {
+		if f.Name == DataFileName {
+			dataFile = f
+			break
+		}
+	}
+	if dataFile == nil {
+		return nil, fmt.Errorf("archive does not contain %s", DataFileName)
+	}
+
+	rc, err := dataFile.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", DataFileName, err)
+	}
+	defer rc.Close()
+
+	summary := &ImportSummary{}
+	if err := a.decode(json.NewDecoder(bufio.NewReader(rc)), summary); err != nil {
+		return summary, err
+	}
+	return summary, nil
+}
+
+// decode walks the archive JSON document, importing reports and IP information
+// one at a time as they are read.
+func (a *Archiver) decode(dec *json.Decoder, summary *ImportSummary) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+
+	versionChecked := false
+	for dec.More() {
+		keyToken, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("malformed archive: %w", err)
+		}
+		key, _ := keyToken.(string)
+
+		switch key {
+		case "format":
+			var format string
+			if err := dec.Decode(&format); err != nil {
+				return fmt.Errorf("malformed archive format: %w", err)
+			}
+			if format != FormatName {
+				return fmt.Errorf("unsupported archive format %q", format)
+			}
+		case "version":
+			var version int
+			if err := dec.Decode(&version); err != nil {
+				return fmt.Errorf("malformed archive version: %w", err)
+			}
+			if version < 1 || version > FormatVersion {
+				return fmt.Errorf("unsupported archive version %d (supported: 1 to %d)", version, FormatVersion)
+			}
+			versionChecked = true
+		case "reports", "ip_info":
+			if !versionChecked {
+				return fmt.Errorf("archive version must precede its data")
+			}
+			if err := expectDelim(dec, '['); err != nil {
+				return err
+			}
+			for dec.More() {
+				if key == "reports" {
+					var report archiveReport
+					if err := dec.Decode(&report); err != nil {
+						return fmt.Errorf("malformed report in archive: %w", err)
+					}
+					a.importReport(&report, summary)
+				} else {
+					var info archiveIPInfo
+					if err := dec.Decode(&info); err != nil {
+						return fmt.Errorf("malformed IP info in archive: %w", err)
+					}
+					a.importIPInfo(&info, summary)
+				}
+			}
+			if err := expectDelim(dec, ']'); err != nil {
+				return err
+			}
+		default:
+			// Unknown keys (e.g. exported_at) are skipped for forward compatibility
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return fmt.Errorf("malformed archive: %w", err)
+			}
+		}
+	}
+
+	if !versionChecked {
+		return fmt.Errorf("archive version is missing")
+	}
+	return expectDelim(dec, '}')
+}
+
+// importReport stores a single archived report unless it already exists.
+func (a *Archiver) importReport(report *archiveReport, summary *ImportSummary) {
+	fail := func(format string, args ...interface{}) {
+		summary.ReportsFailed++
+		summary.Errors = append(summary.Errors, fmt.Sprintf("report %s (%s): %s", report.ReportID, report.OrgName, fmt.Sprintf(format, args...)))
+	}
+
+	if hash := fmt.Sprintf("%x", sha256.Sum256([]byte(report.OriginalXML))); hash != report.XMLHash {
+		fail("xml_hash does not match original_xml")
+		return
+	}
+
+	exists, err := a.DBRepo.ReportExistsByHash(report.XMLHash)
+	if err != nil {
+		fail("%v", err)
+		return
+	}
+	if exists {
+		summary.ReportsSkipped++
+		return
+	}
+
+	bundle := fromArchiveReport(report)
+	if err := a.DBRepo.SaveReportBundle(bundle); err != nil {
+		log.Printf("Failed to import report %s: %v", report.XMLHash, err)
+		fail("%v", err)
+		return
+	}
+	summary.ReportsImported++
+	summary.RecordsImported += len(bundle.Records)
+}
+
+// importIPInfo merges a single archived IP information entry.
+func (a *Archiver) importIPInfo(info *archiveIPInfo, summary *ImportSummary) {
+	written, err := a.DBRepo.MergeIPInfo(fromArchiveIPInfo(info))
+	if err != nil {
+		summary.Errors = append(summary.Errors, fmt.Sprintf("ip_info %s: %v", info.IPAddress, err))
+		return
+	}
+	if written {
+		summary.IPInfoImported++
+	}
+}
+
+// expectDelim reads the next JSON token and checks that it is want.
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("malformed archive: %w", err)
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != want {
+		return fmt.Errorf("malformed archive: expected %q", want)
+	}
+	return nil
+}
+
+// toArchiveReport converts a stored report bundle to its archive form.
+func toArchiveReport(bundle *db.ReportBundle) archiveReport {
+	report := bundle.Report
+	out := archiveReport{
+		XMLHash:        report.XMLHash,
+		OriginalXML:    report.OriginalXML,
+		OrgName:        report.OrgName,
+		ReportID:       report.ReportID,
+		DateRangeBegin: report.DateRangeBegin,
+		DateRangeEnd:   report.DateRangeEnd,
+		Domain:         report.Domain,
+		ADKIM:          report.ADKIM,
+		ASPF:           report.ASPF,
+		P:              report.P,
+		SP:             report.SP,
+		PCT:            report.PCT,
+		Records:        make([]archiveRecord, 0, len(bundle.Records)),
+	}
+	for _, rb := range bundle.Records {
+		record := archiveRecord{
+			SourceIP:    rb.Record.SourceIP,
+			Count:       rb.Record.Count,
+			HeaderFrom:  rb.Record.HeaderFrom,
+			Disposition: rb.Record.Disposition,
+			DKIMResult:  rb.Record.DKIMResult,
+			SPFResult:   rb.Record.SPFResult,
+			AuthResults: make([]archiveAuthResult, 0, len(rb.AuthResults)),
+		}
+		for _, ar := range rb.AuthResults {
+			record.AuthResults = append(record.AuthResults, archiveAuthResult{
+				Type:     ar.AuthType,
+				Domain:   ar.Domain,
+				Result:   ar.Result,
+				Selector: ar.Selector,
+			})
+		}
+		out.Records = append(out.Records, record)
+	}
+	return out
+}
+
+// fromArchiveReport converts an archived report to a bundle ready for saving.
+func fromArchiveReport(report *archiveReport) *db.ReportBundle {
+	bundle := &db.ReportBundle{
+		Report: db.Report{
+			XMLHash:        report.XMLHash,
+			OriginalXML:    report.OriginalXML,
+			OrgName:        report.OrgName,
+			ReportID:       report.ReportID,
+			DateRangeBegin: report.DateRangeBegin,
+			DateRangeEnd:   report.DateRangeEnd,
+			Domain:         report.Domain,
+			ADKIM:          report.ADKIM,
+			ASPF:           report.ASPF,
+			P:              report.P,
+			SP:             report.SP,
+			PCT:            report.PCT,
+		},
+	}
+	for _, record := range report.Records {
+		rb := db.RecordBundle{
+			Record: db.Record{
+				SourceIP:    record.SourceIP,
+				Count:       record.Count,
+				HeaderFrom:  record.HeaderFrom,
+				Disposition: record.Disposition,
+				DKIMResult:  record.DKIMResult,
+				SPFResult:   record.SPFResult,
+			},
+		}
+		for _, ar := range record.AuthResults {
+			rb.AuthResults = append(rb.AuthResults, db.AuthResult{
+				AuthType: ar.Type,
+				Domain:   ar.Domain,
+				Result:   ar.Result,
+				Selector: ar.Selector,
+			})
+		}
+		bundle.Records = append(bundle.Records, rb)
+	}
+	return bundle
+}
+
+// toArchiveIPInfo converts stored IP information to its archive form.
+func toArchiveIPInfo(info *db.IPInfo) archiveIPInfo {
+	return archiveIPInfo{
+		IPAddress:        info.IPAddress,
+		CountryCode:      info.CountryCode,
+		CountryName:      info.CountryName,
+		CityName:         info.CityName,
+		ASNNumber:        info.ASNNumber,
+		ASNOrganization:  info.ASNOrganization,
+		Hostname:         info.Hostname,
+		ReversedHostname: info.ReversedHostname,
+		ApexDomain:       info.ApexDomain,
+		LastUpdated:      info.LastUpdated,
+	}
+}
+
+// fromArchiveIPInfo converts archived IP information to its db model.
+func fromArchiveIPInfo(info *archiveIPInfo) *db.IPInfo {
+	return &db.IPInfo{
+		IPAddress:        info.IPAddress,
+		CountryCode:      info.CountryCode,
+		CountryName:      info.CountryName,
+		CityName:         info.CityName,
+		ASNNumber:        info.ASNNumber,
+		ASNOrganization:  info.ASNOrganization,
+		Hostname:         info.Hostname,
+		ReversedHostname: info.ReversedHostname,
+		ApexDomain:       info.ApexDomain,
+		LastUpdated:      info.LastUpdated,
+	}
+}
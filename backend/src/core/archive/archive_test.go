@@ -0,0 +1,258 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"dmarc-report-analyzer/backend/src/db"
+)
+
+func newTestArchiver(t *testing.T) *Archiver {
+	t.Helper()
+	database, err := db.InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return NewArchiver(db.NewRepository(database))
+}
+
+// testBundle returns a report bundle beginning at begin with one record from
+// sourceIP and a DKIM and SPF result.
+func testBundle(begin int64, sourceIP string) *db.ReportBundle {
+	xml := fmt.Sprintf("<feedback><begin>%d</begin></feedback>", begin)
+	return &db.ReportBundle{
+		Report: db.Report{
+			XMLHash:        fmt.Sprintf("%x", sha256.Sum256([]byte(xml))),
+			OriginalXML:    xml,
+			OrgName:        "example.net",
+			ReportID:       fmt.Sprintf("report-%d", begin),
+			DateRangeBegin: begin,
+			DateRangeEnd:   begin + 86400,
+			Domain:         "example.com",
+			ADKIM:          "r",
+			ASPF:           "s",
+			P:              "reject",
+			SP:             "none",
+			PCT:            100,
+		},
+		Records: []db.RecordBundle{{
+			Record: db.Record{
+				SourceIP:    sourceIP,
+				Count:       3,
+				HeaderFrom:  "example.com",
+				Disposition: "none",
+				DKIMResult:  "pass",
+				SPFResult:   "fail",
+			},
+			AuthResults: []db.AuthResult{
+				{AuthType: db.AuthTypeDKIM, Domain: "example.com", Result: "pass", Selector: "s1"},
+				{AuthType: db.AuthTypeSPF, Domain: "mail.example.net", Result: "fail"},
+			},
+		}},
+	}
+}
+
+// saveBundles stores bundles in the archiver's database.
+func saveBundles(t *testing.T, a *Archiver, bundles ...*db.ReportBundle) {
+	t.Helper()
+	for _, bundle := range bundles {
+		if err := a.DBRepo.SaveReportBundle(bundle); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// exportArchive exports [begin, end] and returns the archive.
+func exportArchive(t *testing.T, a *Archiver, begin, end int64) ([]byte, *ExportSummary) {
+	t.Helper()
+	var buf bytes.Buffer
+	summary, err := a.Export(&buf, begin, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), summary
+}
+
+// importArchive imports data into a and returns the summary.
+func importArchive(t *testing.T, a *Archiver, data []byte) *ImportSummary {
+	t.Helper()
+	summary, err := a.Import(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return summary
+}
+
+// storedBundles returns every stored bundle with database IDs cleared, so that
+// bundles from different databases can be compared.
+func storedBundles(t *testing.T, a *Archiver) []db.ReportBundle {
+	t.Helper()
+	var bundles []db.ReportBundle
+	err := a.DBRepo.ForEachReportBundle(0, 0, func(bundle *db.ReportBundle) error {
+		bundle.Report.ID = 0
+		for i := range bundle.Records {
+			record := &bundle.Records[i]
+			record.Record.ID, record.Record.ReportID = 0, 0
+			for j := range record.AuthResults {
+				record.AuthResults[j].ID, record.AuthResults[j].RecordID = 0, 0
+			}
+		}
+		bundles = append(bundles, *bundle)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bundles
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	source := newTestArchiver(t)
+	saveBundles(t, source, testBundle(1000, "192.0.2.1"), testBundle(2000, "192.0.2.2"))
+	info := &db.IPInfo{
+		IPAddress:        "192.0.2.1",
+		CountryCode:      "NL",
+		CountryName:      "Netherlands",
+		CityName:         "Amsterdam",
+		ASNNumber:        64496,
+		ASNOrganization:  "Example Networks",
+		Hostname:         "mail.example.net",
+		ReversedHostname: "net.example.mail",
+		ApexDomain:       "example.net",
+		LastUpdated:      1500,
+	}
+	if _, err := source.DBRepo.MergeIPInfo(info); err != nil {
+		t.Fatal(err)
+	}
+
+	data, exported := exportArchive(t, source, 0, 0)
+	if want := (ExportSummary{Reports: 2, Records: 2, IPInfo: 1}); *exported != want {
+		t.Errorf("export summary = %+v, want %+v", *exported, want)
+	}
+
+	target := newTestArchiver(t)
+	imported := importArchive(t, target, data)
+	want := ImportSummary{ReportsImported: 2, RecordsImported: 2, IPInfoImported: 1}
+	if !reflect.DeepEqual(*imported, want) {
+		t.Errorf("import summary = %+v, want %+v", *imported, want)
+	}
+
+	if got, want := storedBundles(t, target), storedBundles(t, source); !reflect.DeepEqual(got, want) {
+		t.Errorf("imported bundles = %+v, want %+v", got, want)
+	}
+	got, err := target.DBRepo.GetIPInfo(info.IPAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || *got != *info {
+		t.Errorf("imported IP info = %+v, want %+v", got, info)
+	}
+}
+
+func TestImportSkipsExistingReports(t *testing.T) {
+	source := newTestArchiver(t)
+	saveBundles(t, source, testBundle(1000, "192.0.2.1"), testBundle(2000, "192.0.2.2"))
+	data, _ := exportArchive(t, source, 0, 0)
+
+	// Import into a database that already holds one of the reports, then again
+	target := newTestArchiver(t)
+	saveBundles(t, target, testBundle(1000, "192.0.2.1"))
+	first := importArchive(t, target, data)
+	if first.ReportsImported != 1 || first.ReportsSkipped != 1 || first.ReportsFailed != 0 {
+		t.Errorf("first import = %+v, want 1 imported and 1 skipped", *first)
+	}
+	second := importArchive(t, target, data)
+	if second.ReportsImported != 0 || second.ReportsSkipped != 2 || second.RecordsImported != 0 {
+		t.Errorf("second import = %+v, want 2 skipped", *second)
+	}
+	if n := len(storedBundles(t, target)); n != 2 {
+		t.Errorf("stored reports = %d, want 2", n)
+	}
+}
+
+func TestExportDateFilter(t *testing.T) {
+	source := newTestArchiver(t)
+	saveBundles(t, source, testBundle(1000, "192.0.2.1"), testBundle(2000, "192.0.2.2"), testBundle(3000, "192.0.2.3"))
+
+	tests := []struct {
+		begin, end int64
+		want       []string
+	}{
+		{0, 0, []string{"report-1000", "report-2000", "report-3000"}},
+		{2000, 0, []string{"report-2000", "report-3000"}},
+		{0, 2000, []string{"report-1000", "report-2000"}},
+		{1500, 2500, []string{"report-2000"}},
+		{4000, 5000, nil},
+	}
+	for _, tt := range tests {
+		data, summary := exportArchive(t, source, tt.begin, tt.end)
+		if summary.Reports != len(tt.want) {
+			t.Errorf("Export(%d, %d) reports = %d, want %d", tt.begin, tt.end, summary.Reports, len(tt.want))
+		}
+
+		target := newTestArchiver(t)
+		importArchive(t, target, data)
+		var got []string
+		for _, bundle := range storedBundles(t, target) {
+			got = append(got, bundle.Report.ReportID)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Export(%d, %d) = %v, want %v", tt.begin, tt.end, got, tt.want)
+		}
+	}
+}
+
+func TestImportRejectsTamperedHash(t *testing.T) {
+	source := newTestArchiver(t)
+	saveBundles(t, source, testBundle(1000, "192.0.2.1"), testBundle(2000, "192.0.2.2"))
+	data, _ := exportArchive(t, source, 0, 0)
+
+	// Rewrite the archive with the XML of the first report changed
+	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := zipReader.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document bytes.Buffer
+	if _, err := document.ReadFrom(rc); err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	tampered := strings.Replace(document.String(), `"original_xml":"`, `"original_xml":" `, 1)
+	if tampered == document.String() {
+		t.Fatal("archive does not contain the original XML")
+	}
+	var out bytes.Buffer
+	zipWriter := zip.NewWriter(&out)
+	entry, err := zipWriter.Create(DataFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Write([]byte(tampered))
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	target := newTestArchiver(t)
+	summary := importArchive(t, target, out.Bytes())
+	if summary.ReportsImported != 1 || summary.ReportsFailed != 1 {
+		t.Errorf("import = %+v, want 1 imported and 1 failed", *summary)
+	}
+	if len(summary.Errors) != 1 || !strings.Contains(summary.Errors[0], "xml_hash does not match original_xml") {
+		t.Errorf("errors = %q, want an xml_hash mismatch", summary.Errors)
+	}
+	bundles := storedBundles(t, target)
+	if len(bundles) != 1 || bundles[0].Report.ReportID != "report-2000" {
+		t.Errorf("stored reports = %+v, want only report-2000", bundles)
+	}
+}
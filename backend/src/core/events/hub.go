@@ -0,0 +1,142 @@
+// Package events is an in-process publish/subscribe hub for notifying API
+// clients of ingestion activity as it happens.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	TypeIngestionStarted  = "ingestion.started"
+	TypeIngestionFinished = "ingestion.finished"
+	TypeReportStored      = "report.stored"
+	TypeReportDuplicate   = "report.duplicate"
+	TypeIngestionError    = "ingestion.error"
+	TypeIPDBReloaded      = "ipdb.reloaded"
+)
+
+const (
+	// historySize is the number of recent events kept for reconnecting subscribers.
+	historySize = 256
+	// subscriberBuffer is the number of events a subscriber may fall behind
+	// before further events are dropped for it.
+	subscriberBuffer = 64
+)
+
+// Event is a published event. IDs increase by one per event for the lifetime
+// of the hub.
+type Event struct {
+	ID   uint64      `json:"id"`
+	Type string      `json:"type"`
+	Time int64       `json:"time"` // Unix timestamp
+	Data interface{} `json:"data"`
+}
+
+// Hub fans events out to subscribers. Publishing never blocks: a subscriber
+// that does not keep up loses events rather than stalling ingestion. A nil
+// *Hub is valid and discards everything, so publishers need no checks.
+type Hub struct {
+	mu          sync.Mutex
+	lastID      uint64
+	history     []Event // Most recent events, oldest first
+	subscribers map[chan Event]struct{}
+}
+
+// NewHub creates an empty Hub.
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[chan Event]struct{})}
+}
+
+// Publish sends an event of the given type to all subscribers.
+func (h *Hub) Publish(eventType string, data interface{}) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastID++
+	event := Event{ID: h.lastID, Type: eventType, Time: time.Now().Unix(), Data: data}
+	if len(h.history) == historySize {
+		h.history = append(h.history[:0], h.history[1:]...)
+	}
+	h.history = append(h.history, event)
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default: // Subscriber is too slow; drop the event for it
+		}
+	}
+}
+
+// Subscribe registers a subscriber. It returns the retained events published
+// after lastID (for clients resuming after a reconnect; 0 for none), the
+// channel of new events, and a function that unsubscribes and must be called
+// when the subscriber is done.
+func (h *Hub) Subscribe(lastID uint64) ([]Event, <-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+	if h == nil {
+		return nil, ch, func() {}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var missed []Event
+	if lastID > 0 {
+		for _, event := range h.history {
+			if event.ID > lastID {
+				missed = append(missed, event)
+			}
+		}
+	}
+	h.subscribers[ch] = struct{}{}
+
+	unsubscribe := func() {
+		h.mu.Lock()
+		delete(h.subscribers, ch)
+		h.mu.Unlock()
+	}
+	return missed, ch, unsubscribe
+}
+
+// FileEvent is the data of TypeIngestionStarted events.
+type FileEvent struct {
+	Filename string `json:"filename"`
+}
+
+// IngestionSummary is the data of TypeIngestionFinished events.
+type IngestionSummary struct {
+	Filename string `json:"filename"`
+	Stored   int    `json:"stored"`
+	Skipped  int    `json:"skipped"`
+	Failed   int    `json:"failed"`
+}
+
+// ReportStored is the data of TypeReportStored events.
+type ReportStored struct {
+	Filename       string `json:"filename"`
+	ID             int64  `json:"id"`
+	OrgName        string `json:"org_name"`
+	ReportID       string `json:"report_id"`
+	Domain         string `json:"domain"`
+	DateRangeBegin int64  `json:"date_range_begin"`
+	DateRangeEnd   int64  `json:"date_range_end"`
+	Records        int    `json:"records"`
+}
+
+// IngestionIssue is the data of TypeReportDuplicate and TypeIngestionError events.
+type IngestionIssue struct {
+	Filename  string `json:"filename"`
+	XMLHash   string `json:"xml_hash,omitempty"`
+	ErrorType string `json:"error_type"`
+	Message   string `json:"message"`
+}
+
+// IPDBReload is the data of TypeIPDBReloaded events.
+type IPDBReload struct {
+	Loaded bool   `json:"loaded"`
+	Error  string `json:"error,omitempty"`
+}
@@ -0,0 +1,129 @@
+package events
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// receive returns the events buffered in ch, without waiting for more.
+func receive(ch <-chan Event) []uint64 {
+	var ids []uint64
+	for {
+		select {
+		case event := <-ch:
+			ids = append(ids, event.ID)
+		default:
+			return ids
+		}
+	}
+}
+
+// idRange returns the IDs from first to last.
+func idRange(first, last uint64) []uint64 {
+	var ids []uint64
+	for id := first; id <= last; id++ {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestHubFanOut(t *testing.T) {
+	hub := NewHub()
+	_, first, unsubscribeFirst := hub.Subscribe(0)
+	_, second, unsubscribeSecond := hub.Subscribe(0)
+	defer unsubscribeSecond()
+
+	hub.Publish(TypeIngestionStarted, FileEvent{Filename: "a.xml"})
+	hub.Publish(TypeIngestionFinished, IngestionSummary{Filename: "a.xml", Stored: 1})
+	for name, ch := range map[string]<-chan Event{"first": first, "second": second} {
+		if got := receive(ch); fmt.Sprint(got) != "[1 2]" {
+			t.Errorf("%s subscriber received %v, want [1 2]", name, got)
+		}
+	}
+
+	unsubscribeFirst()
+	hub.Publish(TypeReportStored, ReportStored{ID: 1})
+	if got := receive(first); len(got) != 0 {
+		t.Errorf("unsubscribed subscriber received %v", got)
+	}
+	if got := receive(second); fmt.Sprint(got) != "[3]" {
+		t.Errorf("remaining subscriber received %v, want [3]", got)
+	}
+}
+
+func TestHubSlowSubscriber(t *testing.T) {
+	hub := NewHub()
+	_, slow, unsubscribeSlow := hub.Subscribe(0)
+	defer unsubscribeSlow()
+	_, fast, unsubscribeFast := hub.Subscribe(0)
+	defer unsubscribeFast()
+
+	// The fast subscriber drains its channel after every buffer full of
+	// events; the slow one never reads until publishing is done
+	done := make(chan []uint64)
+	go func() {
+		var ids []uint64
+		for batch := 0; batch < 3; batch++ {
+			for i := 0; i < subscriberBuffer; i++ {
+				hub.Publish(TypeReportStored, nil)
+			}
+			ids = append(ids, receive(fast)...)
+		}
+		done <- ids
+	}()
+	select {
+	case ids := <-done:
+		if fmt.Sprint(ids) != fmt.Sprint(idRange(1, 3*subscriberBuffer)) {
+			t.Errorf("fast subscriber received %d events, want all %d in order", len(ids), 3*subscriberBuffer)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish blocked on a subscriber that does not read")
+	}
+
+	// The slow subscriber keeps the events that fit its buffer and loses the rest
+	if got := receive(slow); fmt.Sprint(got) != fmt.Sprint(idRange(1, subscriberBuffer)) {
+		t.Errorf("slow subscriber received %v, want the first %d events", got, subscriberBuffer)
+	}
+}
+
+func TestHubResume(t *testing.T) {
+	hub := NewHub()
+	for i := 0; i < historySize+10; i++ {
+		hub.Publish(TypeReportStored, nil)
+	}
+
+	missed, _, unsubscribe := hub.Subscribe(historySize + 5)
+	unsubscribe()
+	var ids []uint64
+	for _, event := range missed {
+		ids = append(ids, event.ID)
+	}
+	if fmt.Sprint(ids) != fmt.Sprint(idRange(historySize+6, historySize+10)) {
+		t.Errorf("missed events after %d = %v, want %d to %d", historySize+5, ids, historySize+6, historySize+10)
+	}
+
+	// Only the retained events are replayed to a client that fell further behind
+	missed, _, unsubscribe = hub.Subscribe(1)
+	unsubscribe()
+	if len(missed) != historySize || missed[0].ID != 11 {
+		t.Errorf("missed events after 1 = %d, want the %d retained ones starting at 11", len(missed), historySize)
+	}
+
+	// A new client gets no history
+	missed, _, unsubscribe = hub.Subscribe(0)
+	unsubscribe()
+	if len(missed) != 0 {
+		t.Errorf("new subscriber got %d past events, want none", len(missed))
+	}
+}
+
+func TestNilHub(t *testing.T) {
+	var hub *Hub
+	hub.Publish(TypeIngestionStarted, nil)
+	missed, ch, unsubscribe := hub.Subscribe(1)
+	defer unsubscribe()
+	if len(missed) != 0 || len(receive(ch)) != 0 {
+		t.Error("nil hub delivered events")
+	}
+}
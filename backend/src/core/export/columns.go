@@ -0,0 +1,130 @@
+package export
+
+import (
+	"fmt"
+	"time"
+
+	"dmarc-report-analyzer/backend/src/db"
+)
+
+// Exported columns. Column names and order are part of the export format and
+// must only be extended at the end; they are documented in the README.
+var (
+	// ReportColumns are the columns of a reports export.
+	ReportColumns = []string{
+		"id", "org_name", "report_id", "domain", "date_range_begin", "date_range_end",
+		"adkim", "aspf", "p", "sp", "pct", "xml_hash",
+	}
+
+	// recordColumns are the columns shared by both record export modes.
+	recordColumns = []string{
+		"id", "source_ip", "count", "disposition", "dkim_result", "spf_result", "dmarc_result",
+		"header_from", "date_range_begin", "date_range_end",
+		"country_code", "country_name", "as_number", "as_name", "hostname", "reverse_domain",
+	}
+
+	// AggregatedRecordColumns are the columns of an aggregated records export.
+	AggregatedRecordColumns = append(append([]string{}, recordColumns...), "report_count")
+
+	// IndividualRecordColumns are the columns of an individual records export.
+	IndividualRecordColumns = append(append([]string{}, recordColumns...), "report_org_name", "report_id")
+
+	// AuditColumns are the columns of an audit log export.
+	AuditColumns = []string{
+		"id", "timestamp", "actor", "action", "target", "details", "source_ip",
+		"request_id", "method", "path", "user_agent",
+	}
+
+	// groupSummaryColumns are the columns of the country, AS and domain summaries.
+	groupSummaryColumns = []string{
+		"key", "name", "total", "dmarc_pass", "dmarc_fail", "spf_pass", "spf_fail", "dkim_pass", "dkim_fail",
+	}
+)
+
+// Summary sections that can be exported.
+const (
+	SummaryTimeseries = "timeseries"
+	SummarySourceIP   = "source_ip"
+	SummaryCountry    = "country"
+	SummaryAS         = "as"
+	SummaryDomain     = "domain"
+)
+
+// SummarySections lists the exportable summary sections.
+var SummarySections = []string{SummaryTimeseries, SummarySourceIP, SummaryCountry, SummaryAS, SummaryDomain}
+
+// FormatTime formats a Unix timestamp as RFC 3339 in UTC, or "" for 0.
+func FormatTime(unix int64) string {
+	if unix == 0 {
+		return ""
+	}
+	return time.Unix(unix, 0).UTC().Format(time.RFC3339)
+}
+
+// ReportValues returns the values of a report in ReportColumns order.
+func ReportValues(r *db.Report) []interface{} {
+	return []interface{}{
+		r.ID, r.OrgName, r.ReportID, r.Domain, FormatTime(r.DateRangeBegin), FormatTime(r.DateRangeEnd),
+		r.ADKIM, r.ASPF, r.P, r.SP, r.PCT, r.XMLHash,
+	}
+}
+
+// RecordColumns returns the columns of a records export in the given mode.
+func RecordColumns(aggregate bool) []string {
+	if aggregate {
+		return AggregatedRecordColumns
+	}
+	return IndividualRecordColumns
+}
+
+// RecordValues returns the values of a records view row in RecordColumns order.
+func RecordValues(row *db.RecordRow, aggregate bool) []interface{} {
+	values := []interface{}{
+		row.ID, row.SourceIP, row.Count, row.Disposition, row.DKIMResult, row.SPFResult, row.DMARCResult,
+		row.HeaderFrom, FormatTime(row.DateRangeBegin), FormatTime(row.DateRangeEnd),
+		row.IPInfo.CountryCode, row.IPInfo.CountryName, row.IPInfo.ASNumber, row.IPInfo.ASName,
+		row.IPInfo.Hostname, row.IPInfo.ReverseDomain,
+	}
+	if aggregate {
+		return append(values, row.ReportCount)
+	}
+	return append(values, row.ReportOrgName, row.ReportID)
+}
+
+// AuditValues returns the values of an audit log entry in AuditColumns order.
+func AuditValues(e *db.AuditEntry) []interface{} {
+	return []interface{}{
+		e.ID, FormatTime(e.Timestamp), e.Actor, e.Action, e.Target, e.Details, e.SourceIP,
+		e.RequestID, e.Method, e.Path, e.UserAgent,
+	}
+}
+
+// SummaryTable returns the columns and rows of one section of a dashboard summary.
+func SummaryTable(s *db.DashboardSummary, section string) ([]string, [][]interface{}, error) {
+	var rows [][]interface{}
+	switch section {
+	case SummaryTimeseries:
+		for _, p := range s.TimeseriesData {
+			rows = append(rows, []interface{}{p.Date, p.None, p.Quarantine, p.Reject})
+		}
+		return []string{"date", "none", "quarantine", "reject"}, rows, nil
+	case SummarySourceIP:
+		for _, ip := range s.SourceIPSummary {
+			rows = append(rows, []interface{}{ip.IP, ip.Total, ip.Pass, ip.Fail, ip.Info.ASName, ip.Info.ReverseDomain})
+		}
+		return []string{"ip", "total", "dmarc_pass", "dmarc_fail", "as_name", "reverse_domain"}, rows, nil
+	case SummaryCountry, SummaryAS, SummaryDomain:
+		groups := map[string][]db.GroupSummary{
+			SummaryCountry: s.CountrySummary,
+			SummaryAS:      s.ASSummary,
+			SummaryDomain:  s.DomainSummary,
+		}[section]
+		for _, g := range groups {
+			rows = append(rows, []interface{}{
+				g.Key, g.Name, g.Total, g.DMARCPass, g.DMARCFail, g.SPFPass, g.SPFFail, g.DKIMPass, g.DKIMFail,
+			})
+		}
+		return groupSummaryColumns, rows, nil
+	}
+	return nil, nil, fmt.Errorf("unknown summary section %q", section)
+}
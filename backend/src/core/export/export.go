@@ -0,0 +1,135 @@
+// Package export writes tabular query results as CSV, JSON Lines or XLSX,
+// one row at a time so that results can be streamed from a database cursor.
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Supported export formats.
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+	FormatXLSX  = "xlsx"
+)
+
+// Writer writes the rows of one table. Values are written in column order and
+// may be strings, integers or floats.
+type Writer interface {
+	WriteRow(values []interface{}) error
+	// Close flushes buffered output and completes the file. It does not close
+	// the underlying io.Writer.
+	Close() error
+}
+
+// IsValidFormat reports whether format is a supported export format.
+func IsValidFormat(format string) bool {
+	switch format {
+	case FormatCSV, FormatJSONL, FormatXLSX:
+		return true
+	}
+	return false
+}
+
+// ContentType returns the MIME type of format.
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatJSONL:
+		return "application/x-ndjson"
+	case FormatXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "application/octet-stream"
+}
+
+// NewWriter creates a Writer for format writing to w. CSV and XLSX output
+// starts with a header row of columns; JSON Lines objects use them as keys.
+// sheetName names the XLSX worksheet and is ignored by the other formats.
+func NewWriter(format string, w io.Writer, columns []string, sheetName string) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w, columns)
+	case FormatJSONL:
+		return &jsonlWriter{w: w, columns: columns}, nil
+	case FormatXLSX:
+		return newXLSXWriter(w, columns, sheetName)
+	}
+	return nil, fmt.Errorf("unsupported export format %q", format)
+}
+
+// csvWriter writes RFC 4180 CSV.
+type csvWriter struct {
+	w *csv.Writer
+}
+
+// newCSVWriter creates a csvWriter and writes the header row.
+func newCSVWriter(w io.Writer, columns []string) (*csvWriter, error) {
+	cw := &csvWriter{w: csv.NewWriter(w)}
+	if err := cw.w.Write(columns); err != nil {
+		return nil, fmt.Errorf("failed to write CSV header: %w", err)
+	}
+	return cw, nil
+}
+
+// WriteRow writes one CSV record. Strings that a spreadsheet would evaluate
+// as a formula are prefixed with a single quote, because report fields such
+// as header_from are controlled by whoever sent the mail.
+func (cw *csvWriter) WriteRow(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		if s, ok := v.(string); ok {
+			if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+				s = "'" + s
+			}
+			record[i] = s
+		} else {
+			record[i] = fmt.Sprint(v)
+		}
+	}
+	return cw.w.Write(record)
+}
+
+// Close flushes the CSV output.
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// jsonlWriter writes one JSON object per line, with keys in column order.
+type jsonlWriter struct {
+	w       io.Writer
+	columns []string
+}
+
+// WriteRow writes one JSON object.
+func (jw *jsonlWriter) WriteRow(values []interface{}) error {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(jw.columns[i])
+		value, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", jw.columns[i], err)
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(jw.w, b.String())
+	return err
+}
+
+// Close is a no-op; JSON Lines output is not buffered.
+func (jw *jsonlWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,95 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestCSVWriterEscapesFormulas(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{"example.com", "example.com"},
+		{"=HYPERLINK(\"http://attacker.example\")", "'=HYPERLINK(\"http://attacker.example\")"},
+		{"+1+1", "'+1+1"},
+		{"-1+1", "'-1+1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\tcmd", "'\tcmd"},
+		{"\rcmd", "'\rcmd"},
+		{"a=b", "a=b"},
+		{"", ""},
+		{"comma, \"quote\"\nnewline", "comma, \"quote\"\nnewline"},
+		// Only strings come from report fields; numbers are written as is
+		{-5, "-5"},
+		{int64(-5), "-5"},
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(FormatCSV, &buf, []string{"value", "next"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		if err := w.WriteRow([]interface{}{tt.value, "next"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read CSV output: %v", err)
+	}
+	if len(records) != len(tests)+1 || records[0][0] != "value" {
+		t.Fatalf("records = %q, want the header and %d rows", records, len(tests))
+	}
+	for i, tt := range tests {
+		if got := records[i+1][0]; got != tt.want || records[i+1][1] != "next" {
+			t.Errorf("CSV row for %q = %q, want %q and next", tt.value, records[i+1], tt.want)
+		}
+	}
+}
+
+func TestJSONLWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(FormatJSONL, &buf, []string{"b", "a"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteRow([]interface{}{"=x\n<&>", int64(2)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	line := buf.String()
+	if !strings.HasPrefix(line, `{"b":`) || !strings.HasSuffix(line, "}\n") || strings.Count(line, "\n") != 1 {
+		t.Errorf("line = %q, want one object with keys in column order", line)
+	}
+	var row struct {
+		A int64
+		B string
+	}
+	if err := json.Unmarshal([]byte(line), &row); err != nil {
+		t.Fatal(err)
+	}
+	if row.B != "=x\n<&>" || row.A != 2 {
+		t.Errorf("row = %+v, want the written values unchanged", row)
+	}
+}
+
+func TestNewWriterRejectsUnknownFormat(t *testing.T) {
+	if IsValidFormat("xls") {
+		t.Error("IsValidFormat(xls) = true, want false")
+	}
+	if _, err := NewWriter("xls", &bytes.Buffer{}, []string{"value"}, ""); err == nil {
+		t.Error("NewWriter(xls) succeeded, want an error")
+	}
+}
@@ -0,0 +1,189 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxSheetNameLength is the longest worksheet name Excel accepts.
+const maxSheetNameLength = 31
+
+// xlsxStaticParts are the package parts written before the worksheet. The
+// workbook part is added separately because it contains the sheet name.
+var xlsxStaticParts = []struct{ name, content string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+</Relationships>`},
+	// Style 1 (bold) is used for the header row.
+	{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>
+<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>
+</styleSheet>`},
+}
+
+// xlsxWriter writes a single-sheet XLSX workbook. The worksheet is the last
+// part of the ZIP package, so rows are streamed into it with inline strings
+// and no shared string table has to be kept in memory.
+type xlsxWriter struct {
+	zw    *zip.Writer
+	sheet *bufio.Writer
+	row   int
+}
+
+// newXLSXWriter writes the package parts preceding the worksheet, opens the
+// worksheet and writes the header row.
+func newXLSXWriter(w io.Writer, columns []string, sheetName string) (*xlsxWriter, error) {
+	zw := zip.NewWriter(w)
+
+	for _, part := range xlsxStaticParts {
+		if err := writeZipPart(zw, part.name, part.content); err != nil {
+			return nil, err
+		}
+	}
+	workbook := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="` + escapeXML(sanitizeSheetName(sheetName)) + `" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`
+	if err := writeZipPart(zw, "xl/workbook.xml", workbook); err != nil {
+		return nil, err
+	}
+
+	part, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create worksheet: %w", err)
+	}
+	xw := &xlsxWriter{zw: zw, sheet: bufio.NewWriter(part)}
+	xw.sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	header := make([]interface{}, len(columns))
+	for i, c := range columns {
+		header[i] = c
+	}
+	if err := xw.writeRow(header, 1); err != nil {
+		return nil, err
+	}
+	return xw, nil
+}
+
+// WriteRow writes one worksheet row.
+func (xw *xlsxWriter) WriteRow(values []interface{}) error {
+	return xw.writeRow(values, 0)
+}
+
+// writeRow writes values as the next row, with every cell in style.
+func (xw *xlsxWriter) writeRow(values []interface{}, style int) error {
+	xw.row++
+	rowRef := strconv.Itoa(xw.row)
+
+	var b strings.Builder
+	b.WriteString(`<row r="` + rowRef + `">`)
+	for i, v := range values {
+		ref := columnName(i) + rowRef
+		styleAttr := ""
+		if style != 0 {
+			styleAttr = ` s="` + strconv.Itoa(style) + `"`
+		}
+		switch n := v.(type) {
+		case int:
+			b.WriteString(`<c r="` + ref + `"` + styleAttr + `><v>` + strconv.Itoa(n) + `</v></c>`)
+		case int64:
+			b.WriteString(`<c r="` + ref + `"` + styleAttr + `><v>` + strconv.FormatInt(n, 10) + `</v></c>`)
+		case float64:
+			b.WriteString(`<c r="` + ref + `"` + styleAttr + `><v>` + strconv.FormatFloat(n, 'g', -1, 64) + `</v></c>`)
+		default:
+			b.WriteString(`<c r="` + ref + `"` + styleAttr + ` t="inlineStr"><is><t xml:space="preserve">` +
+				escapeXML(fmt.Sprint(v)) + `</t></is></c>`)
+		}
+	}
+	b.WriteString(`</row>`)
+
+	if _, err := xw.sheet.WriteString(b.String()); err != nil {
+		return fmt.Errorf("failed to write worksheet row: %w", err)
+	}
+	return nil
+}
+
+// Close ends the worksheet and writes the ZIP central directory.
+func (xw *xlsxWriter) Close() error {
+	xw.sheet.WriteString(`</sheetData></worksheet>`)
+	if err := xw.sheet.Flush(); err != nil {
+		return fmt.Errorf("failed to write worksheet: %w", err)
+	}
+	if err := xw.zw.Close(); err != nil {
+		return fmt.Errorf("failed to finish XLSX package: %w", err)
+	}
+	return nil
+}
+
+// writeZipPart adds a complete part to the package.
+func writeZipPart(zw *zip.Writer, name, content string) error {
+	part, err := zw.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", name, err)
+	}
+	if _, err := io.WriteString(part, content); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// columnName converts a zero-based column index to its spreadsheet name
+// (0 -> A, 25 -> Z, 26 -> AA).
+func columnName(index int) string {
+	name := ""
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+	return name
+}
+
+// escapeXML escapes s for use in XML text and attributes. Characters that are
+// not allowed in XML are replaced with U+FFFD.
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// sanitizeSheetName makes name a valid worksheet name.
+func sanitizeSheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" {
+		name = "Sheet1"
+	}
+	if runes := []rune(name); len(runes) > maxSheetNameLength {
+		name = string(runes[:maxSheetNameLength])
+	}
+	return name
+}
@@ -0,0 +1,160 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"testing"
+)
+
+// xlsxSheet is the part of a worksheet that the tests read back.
+type xlsxSheet struct {
+	Rows []struct {
+		R     string `xml:"r,attr"`
+		Cells []struct {
+			R      string `xml:"r,attr"`
+			Type   string `xml:"t,attr"`
+			Value  string `xml:"v"`
+			Inline string `xml:"is>t"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// readXLSX reopens an XLSX package, checks that every XML part is well
+// formed and returns the worksheet and the workbook.
+func readXLSX(t *testing.T, data []byte) (xlsxSheet, string) {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("failed to reopen XLSX: %v", err)
+	}
+
+	parts := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", f.Name, err)
+		}
+		parts[f.Name] = content
+
+		// Decode every token so that malformed XML anywhere is an error
+		d := xml.NewDecoder(bytes.NewReader(content))
+		for {
+			if _, err := d.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s is not well-formed XML: %v", f.Name, err)
+			}
+		}
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml", "xl/worksheets/sheet1.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("XLSX lacks %s", name)
+		}
+	}
+
+	var sheet xlsxSheet
+	if err := xml.Unmarshal(parts["xl/worksheets/sheet1.xml"], &sheet); err != nil {
+		t.Fatalf("failed to decode worksheet: %v", err)
+	}
+	return sheet, string(parts["xl/workbook.xml"])
+}
+
+func TestXLSXWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(FormatXLSX, &buf, []string{"text", "count", "rate"}, "Records")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := [][]interface{}{
+		{`<b>Tom & "Jerry"</b>`, 3, 0.5},
+		{"=SUM(A1)", int64(-7), 1e21},
+		{"tab\there\nnew line ", 0, 0.0},
+		{"bell\x07 nul\x00 esc\x1b", 1, 1.0},
+	}
+	for _, row := range rows {
+		if err := w.WriteRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sheet, _ := readXLSX(t, buf.Bytes())
+	if len(sheet.Rows) != len(rows)+1 {
+		t.Fatalf("worksheet has %d rows, want %d", len(sheet.Rows), len(rows)+1)
+	}
+	header := sheet.Rows[0]
+	if header.R != "1" || len(header.Cells) != 3 || header.Cells[0].Inline != "text" || header.Cells[2].R != "C1" {
+		t.Errorf("header row = %+v, want text, count and rate in A1 to C1", header)
+	}
+
+	tests := []struct {
+		cell      int
+		row       int
+		wantType  string
+		wantValue string
+	}{
+		{0, 1, "inlineStr", `<b>Tom & "Jerry"</b>`},
+		{1, 1, "", "3"},
+		{2, 1, "", "0.5"},
+		// Inline strings are never evaluated, so formulas need no escaping
+		{0, 2, "inlineStr", "=SUM(A1)"},
+		{1, 2, "", "-7"},
+		{2, 2, "", "1e+21"},
+		{0, 3, "inlineStr", "tab\there\nnew line "},
+		// Characters not allowed in XML are replaced
+		{0, 4, "inlineStr", "bell� nul� esc�"},
+	}
+	for _, tt := range tests {
+		c := sheet.Rows[tt.row].Cells[tt.cell]
+		value := c.Value
+		if tt.wantType == "inlineStr" {
+			value = c.Inline
+		}
+		if c.Type != tt.wantType || value != tt.wantValue {
+			t.Errorf("cell %s = %q of type %q, want %q of type %q", c.R, value, c.Type, tt.wantValue, tt.wantType)
+		}
+	}
+}
+
+func TestXLSXSheetName(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(FormatXLSX, &buf, []string{"a"}, `R&D <"reports">: 2024/01 [all] and then some`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, workbook := readXLSX(t, buf.Bytes())
+	var wb struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := xml.Unmarshal([]byte(workbook), &wb); err != nil {
+		t.Fatal(err)
+	}
+	// Characters Excel refuses are replaced and the name is cut to 31
+	want := `R&D <"reports">_ 2024_01 _all_ `
+	if len(wb.Sheets) != 1 || wb.Sheets[0].Name != want {
+		t.Errorf("sheets = %+v, want one named %q", wb.Sheets, want)
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	for index, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"} {
+		if got := columnName(index); got != want {
+			t.Errorf("columnName(%d) = %q, want %q", index, got, want)
+		}
+	}
+}
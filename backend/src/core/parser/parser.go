@@ -14,6 +14,7 @@ import (
 	"strings"
 	"time"
 
+	"dmarc-report-analyzer/backend/src/core/events"
 	"dmarc-report-analyzer/backend/src/db"
 	"dmarc-report-analyzer/backend/src/ip_geo"
 )
@@ -22,19 +23,50 @@ import (
 type ReportProcessor struct {
 	DBRepo     *db.Repository
 	IPResolver *ip_geo.Resolver
+	Events     *events.Hub // Receives ingestion events; may be nil
 }
 
-// NewReportProcessor creates a new ReportProcessor instance.
-func NewReportProcessor(dbRepo *db.Repository, ipResolver *ip_geo.Resolver) *ReportProcessor {
+// NewReportProcessor creates a new ReportProcessor instance. hub may be nil.
+func NewReportProcessor(dbRepo *db.Repository, ipResolver *ip_geo.Resolver, hub *events.Hub) *ReportProcessor {
 	return &ReportProcessor{
 		DBRepo:     dbRepo,
 		IPResolver: ipResolver,
+		Events:     hub,
 	}
 }
 
 // ProcessUploadedFile processes a single uploaded DMARC report file.
-// It returns a list of ingestion errors for the file.
+// It returns a list of ingestion errors for the file. Every source of reports
+// goes through here, so the ingestion events are published here too.
 func (rp *ReportProcessor) ProcessUploadedFile(file io.Reader, filename string) []db.IngestionError {
+	rp.Events.Publish(events.TypeIngestionStarted, events.FileEvent{Filename: filename})
+
+	ingestionErrors, stored := rp.processFile(file, filename)
+
+	summary := events.IngestionSummary{Filename: filename, Stored: stored}
+	for _, errInfo := range ingestionErrors {
+		issue := events.IngestionIssue{
+			Filename:  errInfo.Filename,
+			XMLHash:   errInfo.XMLHash,
+			ErrorType: errInfo.ErrorType,
+			Message:   errInfo.Message,
+		}
+		if errInfo.ErrorType == "SKIPPED_DUPLICATE" {
+			summary.Skipped++
+			rp.Events.Publish(events.TypeReportDuplicate, issue)
+		} else {
+			summary.Failed++
+			rp.Events.Publish(events.TypeIngestionError, issue)
+		}
+	}
+	rp.Events.Publish(events.TypeIngestionFinished, summary)
+
+	return ingestionErrors
+}
+
+// processFile extracts and stores the reports of one file. It returns the
+// ingestion errors and the number of reports stored.
+func (rp *ReportProcessor) processFile(file io.Reader, filename string) ([]db.IngestionError, int) {
 	var ingestionErrors []db.IngestionError
 
 	// 1. ファイルヘッダの読み込みと厳密なファイルタイプ識別
@@ -48,7 +80,7 @@ func (rp *ReportProcessor) ProcessUploadedFile(file io.Reader, filename string)
 			Message:   fmt.Sprintf("Failed to read file header: %v", err),
 			Timestamp: time.Now().Unix(),
 		})
-		return ingestionErrors
+		return ingestionErrors, 0
 	}
 
 	fileType := identifyFileType(header, filename)
@@ -59,7 +91,7 @@ func (rp *ReportProcessor) ProcessUploadedFile(file io.Reader, filename string)
 			Message:   "File format not recognized as XML, ZIP, GZ, or TGZ.",
 			Timestamp: time.Now().Unix(),
 		})
-		return ingestionErrors
+		return ingestionErrors, 0
 	}
 
 	// 2. アーカイブの展開 / XMLコンテンツの取得
@@ -71,7 +103,7 @@ func (rp *ReportProcessor) ProcessUploadedFile(file io.Reader, filename string)
 			Message:   fmt.Sprintf("Failed to extract XML from archive: %v", err),
 			Timestamp: time.Now().Unix(),
 		})
-		return ingestionErrors
+		return ingestionErrors, 0
 	}
 
 	// Process each extracted XML content (for ZIP/TGZ, there might be multiple)
@@ -82,15 +114,19 @@ func (rp *ReportProcessor) ProcessUploadedFile(file io.Reader, filename string)
 			Message:   "No XML content found in the provided file or archive.",
 			Timestamp: time.Now().Unix(),
 		})
-		return ingestionErrors
+		return ingestionErrors, 0
 	}
 
+	stored := 0
 	for _, xmlContent := range xmlContents {
 		reportErrors := rp.processSingleXML(xmlContent, filename)
+		if len(reportErrors) == 0 {
+			stored++
+		}
 		ingestionErrors = append(ingestionErrors, reportErrors...)
 	}
 
-	return ingestionErrors
+	return ingestionErrors, stored
 }
 
 // processSingleXML processes a single DMARC XML content.
@@ -162,7 +198,8 @@ uniqueIPs[record.Row.SourceIP] = struct{}{}
 		ipInfo, err := rp.IPResolver.ResolveIP(ipStr)
 		if err != nil {
 			log.Printf("Failed to resolve IP %s: %v. Storing partial IPInfo.", ipStr, err)
-			// Create a minimal IPInfo with "N/A" for unresolved fields
+			// Create a minimal IPInfo with "N/A" for unresolved fields. The
+			// repository keeps stored values and snapshots for "N/A" fields.
 			ipInfo = &db.IPInfo{
 				IPAddress: ipStr,
 				Hostname: "N/A", ReversedHostname: "N/A", ApexDomain: "N/A",
@@ -194,8 +231,23 @@ uniqueIPs[record.Row.SourceIP] = struct{}{}
 		PCT:            feedback.PolicyPublished.PCT,
 	}
 
-	reportID, err := rp.DBRepo.SaveReport(&report)
-	if err != nil {
+	bundle := db.ReportBundle{Report: report}
+	for _, record := range feedback.Records {
+		bundle.Records = append(bundle.Records, db.RecordBundle{
+			Record: db.Record{
+				SourceIP:    record.Row.SourceIP,
+				Count:       record.Row.Count,
+				HeaderFrom:  record.Identifiers.HeaderFrom,
+				Disposition: record.Row.PolicyEvaluated.Disposition, // Access through record.Row
+				DKIMResult:  record.Row.PolicyEvaluated.DKIM,        // Access through record.Row
+				SPFResult:   record.Row.PolicyEvaluated.SPF,         // Access through record.Row
+			},
+			AuthResults: authResultsFromRecord(record),
+		})
+	}
+
+	// The report, its records and their auth results are saved atomically
+	if err := rp.DBRepo.SaveReportBundle(&bundle); err != nil {
 		ingestionErrors = append(ingestionErrors, db.IngestionError{
 			Filename:  originalFilename,
 			XMLHash:   xmlHash,
@@ -206,23 +258,99 @@ uniqueIPs[record.Row.SourceIP] = struct{}{}
 		return ingestionErrors
 	}
 
-	for _, record := range feedback.Records {
-		dbRecord := db.Record{
-			ReportID:    reportID,
-			SourceIP:    record.Row.SourceIP,
-			Count:       record.Row.Count,
-			HeaderFrom:  record.Identifiers.HeaderFrom,
-			Disposition: record.Row.PolicyEvaluated.Disposition, // Access through record.Row
-			DKIMResult:  record.Row.PolicyEvaluated.DKIM,        // Access through record.Row
-			SPFResult:   record.Row.PolicyEvaluated.SPF,         // Access through record.Row
+	rp.Events.Publish(events.TypeReportStored, events.ReportStored{
+		Filename:       originalFilename,
+		ID:             bundle.Report.ID,
+		OrgName:        report.OrgName,
+		ReportID:       report.ReportID,
+		Domain:         report.Domain,
+		DateRangeBegin: report.DateRangeBegin,
+		DateRangeEnd:   report.DateRangeEnd,
+		Records:        len(bundle.Records),
+	})
+
+	return ingestionErrors
+}
+
+// ReloadIPDatabases reloads the IP geolocation databases from disk, for
+// example after they were replaced, and publishes the outcome.
+func (rp *ReportProcessor) ReloadIPDatabases() error {
+	err := rp.IPResolver.LoadDatabases()
+	reload := events.IPDBReload{Loaded: err == nil}
+	if err != nil {
+		reload.Error = err.Error()
+	}
+	rp.Events.Publish(events.TypeIPDBReloaded, reload)
+	return err
+}
+
+// authResultsFromRecord converts the raw DKIM and SPF results of a record.
+func authResultsFromRecord(record Record) []db.AuthResult {
+	var results []db.AuthResult
+	for _, dkim := range record.AuthResults.DKIM {
+		results = append(results, db.AuthResult{
+			AuthType: db.AuthTypeDKIM,
+			Domain:   dkim.Domain,
+			Result:   dkim.Result,
+			Selector: dkim.Selector,
+		})
+	}
+	for _, spf := range record.AuthResults.SPF {
+		results = append(results, db.AuthResult{
+			AuthType: db.AuthTypeSPF,
+			Domain:   spf.Domain,
+			Result:   spf.Result,
+		})
+	}
+	return results
+}
+
+// BackfillAuthResults re-parses the stored XML of reports ingested before raw
+// auth results were kept, and stores their auth results. Records are matched
+// to the XML by position, which is the order they were saved in.
+func (rp *ReportProcessor) BackfillAuthResults() error {
+	reportIDs, err := rp.DBRepo.GetReportIDsWithoutAuthResults()
+	if err != nil {
+		return err
+	}
+
+	var filled int
+	for _, reportID := range reportIDs {
+		report, err := rp.DBRepo.GetReportByID(reportID)
+		if err != nil {
+			return err
 		}
-		if err := rp.DBRepo.SaveRecord(&dbRecord); err != nil {
-			log.Printf("Failed to save record for report %d, IP %s: %v", reportID, record.Row.SourceIP, err)
-			// This error is logged but doesn't stop report ingestion
+		if report == nil {
+			continue
+		}
+
+		var feedback Feedback
+		if err := xml.Unmarshal([]byte(report.OriginalXML), &feedback); err != nil {
+			log.Printf("Failed to re-parse report %d for auth results: %v", reportID, err)
+			continue
+		}
+
+		records, err := rp.DBRepo.GetRecordsByReportID(reportID)
+		if err != nil {
+			return err
 		}
+		if len(records) != len(feedback.Records) {
+			log.Printf("Skipping auth result backfill for report %d: %d stored records, %d in XML", reportID, len(records), len(feedback.Records))
+			continue
+		}
+
+		for i, record := range records {
+			if err := rp.DBRepo.SaveAuthResults(record.ID, authResultsFromRecord(feedback.Records[i])); err != nil {
+				return err
+			}
+		}
+		filled++
 	}
 
-	return ingestionErrors
+	if filled > 0 {
+		log.Printf("Backfilled auth results for %d reports.", filled)
+	}
+	return nil
 }
 
 // --- Helper functions for file type identification and extraction ---
@@ -0,0 +1,100 @@
+// Package retention deletes reports that are older than the retention period
+// configured in the application settings.
+package retention
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"dmarc-report-analyzer/backend/src/db"
+)
+
+// sweepInterval is how often expired reports are looked for.
+const sweepInterval = time.Hour
+
+// Enforcer periodically deletes expired reports.
+type Enforcer struct {
+	DBRepo *db.Repository
+
+	mu      sync.Mutex
+	days    int
+	trigger chan struct{}
+}
+
+// NewEnforcer creates an Enforcer that keeps reports forever until SetDays
+// is called.
+func NewEnforcer(dbRepo *db.Repository) *Enforcer {
+	return &Enforcer{DBRepo: dbRepo, trigger: make(chan struct{}, 1)}
+}
+
+// SetDays sets the retention period in days (0 keeps reports forever) and
+// triggers a sweep.
+func (e *Enforcer) SetDays(days int) {
+	e.mu.Lock()
+	e.days = days
+	e.mu.Unlock()
+
+	select {
+	case e.trigger <- struct{}{}:
+	default: // A sweep is already pending
+	}
+}
+
+// Run sweeps every sweepInterval and whenever the retention period changes,
+// until ctx is done.
+func (e *Enforcer) Run(ctx context.Context) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-e.trigger:
+		}
+		if _, err := e.Sweep(); err != nil {
+			log.Printf("Retention: %v", err)
+		}
+	}
+}
+
+// Sweep deletes the reports whose date range began before the retention
+// period and records the deletion in the audit log. It returns nil if
+// reports are kept forever or none expired.
+func (e *Enforcer) Sweep() (*db.DeletionResult, error) {
+	e.mu.Lock()
+	days := e.days
+	e.mu.Unlock()
+	if days <= 0 {
+		return nil, nil
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -days).Unix()
+	result, err := e.DBRepo.DeleteReports(db.ReportFilter{End: cutoff}, false)
+	if err != nil {
+		return nil, err
+	}
+	if result.Reports == 0 {
+		return nil, nil
+	}
+	log.Printf("Retention: Deleted %d reports older than %d days", result.Reports, days)
+
+	details, _ := json.Marshal(map[string]interface{}{
+		"retention_days": days,
+		"before":         cutoff,
+		"deleted":        result,
+	})
+	entry := &db.AuditEntry{
+		Actor:   "system",
+		Action:  "report.retention_delete",
+		Target:  "reports",
+		Details: string(details),
+	}
+	if err := e.DBRepo.AddAuditEntry(entry); err != nil {
+		log.Printf("Failed to record audit entry for retention deletion: %v", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,120 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dmarc-report-analyzer/backend/src/db"
+)
+
+// newTestEnforcer returns an Enforcer for a database holding reports that
+// began the given numbers of days ago.
+func newTestEnforcer(t *testing.T, ages ...int) *Enforcer {
+	t.Helper()
+	database, err := db.InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	repo := db.NewRepository(database)
+
+	for _, age := range ages {
+		begin := time.Now().AddDate(0, 0, -age).Unix()
+		bundle := &db.ReportBundle{
+			Report: db.Report{
+				XMLHash:        fmt.Sprintf("hash-%d", age),
+				OrgName:        "example.net",
+				ReportID:       fmt.Sprintf("%d-days", age),
+				DateRangeBegin: begin,
+				DateRangeEnd:   begin + 86399,
+				Domain:         "example.com",
+			},
+			Records: []db.RecordBundle{{Record: db.Record{SourceIP: "192.0.2.1", Count: 1}}},
+		}
+		if err := repo.SaveReportBundle(bundle); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewEnforcer(repo)
+}
+
+// remainingReports returns the report IDs left in the database.
+func remainingReports(t *testing.T, e *Enforcer) []string {
+	t.Helper()
+	reports, err := e.DBRepo.GetReports(db.ReportQuery{SortBy: "date_range_begin", SortOrder: "desc", Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, report := range reports {
+		ids = append(ids, report.ReportID)
+	}
+	return ids
+}
+
+func TestSweep(t *testing.T) {
+	e := newTestEnforcer(t, 1, 29, 31, 400)
+
+	// Reports are kept forever until a retention period is set
+	if result, err := e.Sweep(); err != nil || result != nil {
+		t.Errorf("Sweep() without retention = %+v, %v, want nil, nil", result, err)
+	}
+
+	e.SetDays(30)
+	result, err := e.Sweep()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (db.DeletionResult{Reports: 2, Records: 2}); result == nil || *result != want {
+		t.Errorf("Sweep() = %+v, want %+v", result, want)
+	}
+	if got := remainingReports(t, e); fmt.Sprint(got) != "[1-days 29-days]" {
+		t.Errorf("remaining reports = %v, want [1-days 29-days]", got)
+	}
+
+	entries, err := e.DBRepo.GetAuditEntries(db.AuditQuery{Filter: db.AuditFilter{Action: "report.retention_delete"}, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Actor != "system" {
+		t.Errorf("audit entries = %+v, want one by system", entries)
+	}
+
+	// Nothing further expired, so nothing is deleted or audited
+	if result, err := e.Sweep(); err != nil || result != nil {
+		t.Errorf("second Sweep() = %+v, %v, want nil, nil", result, err)
+	}
+	entries, err = e.DBRepo.GetAuditEntries(db.AuditQuery{Filter: db.AuditFilter{Action: "report.retention_delete"}, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("got %d audit entries after a sweep without deletions, want 1", len(entries))
+	}
+}
+
+func TestRunSweepsWhenDaysChange(t *testing.T) {
+	e := newTestEnforcer(t, 1, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	e.SetDays(5)
+	deadline := time.Now().Add(5 * time.Second)
+	for fmt.Sprint(remainingReports(t, e)) != "[1-days]" {
+		if time.Now().After(deadline) {
+			t.Fatalf("remaining reports = %v, want [1-days] after changing the retention period", remainingReports(t, e))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,149 @@
+// Package settings provides the application settings that can be changed at
+// runtime. They are stored in the settings table, one row per setting with a
+// JSON-encoded value, and fall back to their defaults when not stored.
+package settings
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"dmarc-report-analyzer/backend/src/db"
+)
+
+// ErrInvalid is returned for settings that fail validation.
+var ErrInvalid = errors.New("invalid settings")
+
+// Settings are the runtime application settings. The JSON names are the keys
+// in the settings table.
+type Settings struct {
+	// DNSLookups enables reverse DNS (PTR) lookups when enriching source IPs.
+	DNSLookups bool `json:"dns_lookups"`
+	// DNSTimeoutSeconds bounds each reverse DNS lookup.
+	DNSTimeoutSeconds int `json:"dns_timeout_seconds"`
+	// RetentionDays is the age in days after which reports are deleted,
+	// judged by the beginning of their date range. 0 keeps reports forever.
+	RetentionDays int `json:"retention_days"`
+	// RequireAdminTOTP requires admins to enable TOTP two-factor
+	// authentication before they can do anything else.
+	RequireAdminTOTP bool `json:"require_admin_totp"`
+}
+
+// Defaults returns the settings used for keys that are not stored.
+func Defaults() Settings {
+	return Settings{
+		DNSLookups:        true,
+		DNSTimeoutSeconds: 5,
+		RetentionDays:     0,
+		RequireAdminTOTP:  false,
+	}
+}
+
+// Validate checks that every setting is within its allowed range.
+func (s Settings) Validate() error {
+	if s.DNSTimeoutSeconds < 1 || s.DNSTimeoutSeconds > 60 {
+		return fmt.Errorf("%w: dns_timeout_seconds must be between 1 and 60", ErrInvalid)
+	}
+	if s.RetentionDays < 0 || s.RetentionDays > 36500 {
+		return fmt.Errorf("%w: retention_days must be between 0 and 36500", ErrInvalid)
+	}
+	return nil
+}
+
+// Service holds the current settings and persists changes to them.
+type Service struct {
+	DBRepo *db.Repository
+
+	mu        sync.RWMutex
+	current   Settings
+	listeners []func(Settings)
+}
+
+// NewService loads the stored settings on top of the defaults. Stored values
+// that cannot be decoded or fail validation are ignored with a warning, so a
+// bad row never prevents startup.
+func NewService(dbRepo *db.Repository) (*Service, error) {
+	stored, err := dbRepo.GetSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	current := Defaults()
+	for _, setting := range stored {
+		candidate := current
+		doc := fmt.Sprintf(`{%q: %s}`, setting.Key, setting.Value)
+		if err := json.Unmarshal([]byte(doc), &candidate); err != nil {
+			log.Printf("Warning: Ignoring stored setting %s: %v", setting.Key, err)
+			continue
+		}
+		if err := candidate.Validate(); err != nil {
+			log.Printf("Warning: Ignoring stored setting %s: %v", setting.Key, err)
+			continue
+		}
+		current = candidate
+	}
+	return &Service{DBRepo: dbRepo, current: current}, nil
+}
+
+// Get returns the current settings.
+func (s *Service) Get() Settings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+// Update validates and stores new settings, then notifies the listeners.
+func (s *Service) Update(settings Settings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	rows, err := toRows(settings)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if err := s.DBRepo.SaveSettings(rows); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	s.current = settings
+	listeners := append([]func(Settings){}, s.listeners...)
+	s.mu.Unlock()
+
+	for _, listener := range listeners {
+		listener(settings)
+	}
+	return nil
+}
+
+// OnChange registers fn to be called with the current settings now and with
+// the new settings after every update, so that components can apply them.
+func (s *Service) OnChange(fn func(Settings)) {
+	s.mu.Lock()
+	s.listeners = append(s.listeners, fn)
+	current := s.current
+	s.mu.Unlock()
+
+	fn(current)
+}
+
+// toRows converts settings to settings table rows.
+func toRows(settings Settings) ([]db.Setting, error) {
+	encoded, err := json.Marshal(settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode settings: %w", err)
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &values); err != nil {
+		return nil, fmt.Errorf("failed to encode settings: %w", err)
+	}
+
+	rows := make([]db.Setting, 0, len(values))
+	for key, value := range values {
+		rows = append(rows, db.Setting{Key: key, Value: string(value)})
+	}
+	return rows, nil
+}
@@ -0,0 +1,113 @@
+package settings
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"dmarc-report-analyzer/backend/src/db"
+)
+
+func newTestRepository(t *testing.T) *db.Repository {
+	t.Helper()
+	database, err := db.InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return db.NewRepository(database)
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		edit  func(*Settings)
+		valid bool
+	}{
+		{"defaults", func(s *Settings) {}, true},
+		{"shortest DNS timeout", func(s *Settings) { s.DNSTimeoutSeconds = 1 }, true},
+		{"longest DNS timeout", func(s *Settings) { s.DNSTimeoutSeconds = 60 }, true},
+		{"no DNS timeout", func(s *Settings) { s.DNSTimeoutSeconds = 0 }, false},
+		{"DNS timeout too long", func(s *Settings) { s.DNSTimeoutSeconds = 61 }, false},
+		{"longest retention", func(s *Settings) { s.RetentionDays = 36500 }, true},
+		{"negative retention", func(s *Settings) { s.RetentionDays = -1 }, false},
+		{"retention too long", func(s *Settings) { s.RetentionDays = 36501 }, false},
+	}
+	for _, tt := range tests {
+		s := Defaults()
+		tt.edit(&s)
+		err := s.Validate()
+		if tt.valid && err != nil {
+			t.Errorf("%s: Validate() = %v, want nil", tt.name, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: Validate() = %v, want ErrInvalid", tt.name, err)
+		}
+	}
+}
+
+func TestServiceUpdate(t *testing.T) {
+	repo := newTestRepository(t)
+	service, err := NewService(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := service.Get(); got != Defaults() {
+		t.Errorf("settings of an empty database = %+v, want the defaults", got)
+	}
+
+	var notified []Settings
+	service.OnChange(func(s Settings) { notified = append(notified, s) })
+
+	invalid := Defaults()
+	invalid.RetentionDays = -1
+	if err := service.Update(invalid); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Update(invalid) = %v, want ErrInvalid", err)
+	}
+	if got := service.Get(); got != Defaults() || len(notified) != 1 {
+		t.Errorf("invalid update changed the settings to %+v or notified %d times", got, len(notified))
+	}
+
+	updated := Settings{DNSLookups: false, DNSTimeoutSeconds: 10, RetentionDays: 30, RequireAdminTOTP: true}
+	if err := service.Update(updated); err != nil {
+		t.Fatal(err)
+	}
+	if got := service.Get(); got != updated {
+		t.Errorf("Get() = %+v, want %+v", got, updated)
+	}
+	if len(notified) != 2 || notified[0] != Defaults() || notified[1] != updated {
+		t.Errorf("listener was called with %+v, want the defaults and then %+v", notified, updated)
+	}
+
+	// The update is stored
+	reloaded, err := NewService(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded.Get(); got != updated {
+		t.Errorf("reloaded settings = %+v, want %+v", got, updated)
+	}
+}
+
+func TestServiceIgnoresBadStoredSettings(t *testing.T) {
+	repo := newTestRepository(t)
+	err := repo.SaveSettings([]db.Setting{
+		{Key: "dns_lookups", Value: "false"},
+		{Key: "dns_timeout_seconds", Value: `"ten"`}, // Not a number
+		{Key: "retention_days", Value: "-5"},         // Out of range
+		{Key: "unknown_setting", Value: "1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service, err := NewService(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Defaults()
+	want.DNSLookups = false
+	if got := service.Get(); got != want {
+		t.Errorf("settings = %+v, want %+v", got, want)
+	}
+}
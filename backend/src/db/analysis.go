@@ -0,0 +1,188 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+// AnalyzedAuthResult is a raw DKIM or SPF result shown in a record analysis.
+type AnalyzedAuthResult struct {
+	AuthType string `json:"auth_type"`
+	Domain   string `json:"domain"`
+	Result   string `json:"result"`
+	Selector string `json:"selector,omitempty"`
+}
+
+// AnalyzedRecord is the record part of a record analysis. The auth_* fields
+// hold the first raw DKIM and SPF result; AuthResults holds all of them.
+type AnalyzedRecord struct {
+	ID                  string               `json:"id"`
+	SourceIP            string               `json:"source_ip"`
+	Count               int64                `json:"count"`
+	Disposition         string               `json:"disposition"`
+	DKIMEvaluatedResult string               `json:"dkim_evaluated_result"`
+	SPFEvaluatedResult  string               `json:"spf_evaluated_result"`
+	DMARCResult         string               `json:"dmarc_result"`
+	HeaderFrom          string               `json:"header_from"`
+	AuthDKIMDomain      string               `json:"auth_dkim_domain"`
+	AuthDKIMResult      string               `json:"auth_dkim_result"`
+	AuthDKIMSelector    string               `json:"auth_dkim_selector"`
+	AuthSPFDomain       string               `json:"auth_spf_domain"`
+	AuthSPFResult       string               `json:"auth_spf_result"`
+	AuthResults         []AnalyzedAuthResult `json:"auth_results"`
+	IPInfo              RecordIPInfo         `json:"ip_info"`
+}
+
+// ReportPolicy is the report and published policy part of a record analysis.
+type ReportPolicy struct {
+	ID             int64  `json:"id"`
+	ReportID       string `json:"report_id"`
+	OrgName        string `json:"org_name"`
+	DateRangeBegin int64  `json:"date_range_begin"`
+	DateRangeEnd   int64  `json:"date_range_end"`
+	PolicyDomain   string `json:"policy_domain"`
+	PolicyADKIM    string `json:"policy_adkim"`
+	PolicyASPF     string `json:"policy_aspf"`
+	PolicyP        string `json:"policy_p"`
+	PolicySP       string `json:"policy_sp"`
+	PolicyPCT      int    `json:"policy_pct"`
+}
+
+// ContributingReport is a report that contributed to an aggregated record.
+type ContributingReport struct {
+	ID             int64  `json:"id"`
+	OrgName        string `json:"org_name"`
+	ReportID       string `json:"report_id"`
+	DateRangeBegin int64  `json:"date_range_begin"`
+	DateRangeEnd   int64  `json:"date_range_end"`
+	Count          int64  `json:"count"`
+}
+
+// RecordAnalysis holds everything shown in the analysis of a record.
+type RecordAnalysis struct {
+	Record              AnalyzedRecord       `json:"record"`
+	Report              ReportPolicy         `json:"report"`
+	ContributingReports []ContributingReport `json:"contributing_reports"`
+}
+
+// AnalyzeRecord retrieves the analysis of an individual record. It returns nil
+// if the record does not exist.
+func (r *Repository) AnalyzeRecord(recordID int64) (*RecordAnalysis, error) {
+	analysis := &RecordAnalysis{ContributingReports: []ContributingReport{}}
+	rec, rep := &analysis.Record, &analysis.Report
+
+	var id int64
+	err := r.db.QueryRow(`
+		SELECT rec.id, rec.source_ip, rec.count, rec.disposition, rec.dkim_result, rec.spf_result, rec.header_from,
+			rep.id, rep.report_id, rep.org_name, rep.date_range_begin, rep.date_range_end,
+			rep.domain, rep.adkim, rep.aspf, rep.p, rep.sp, rep.pct
+		FROM records rec
+		JOIN reports rep ON rep.id = rec.report_id
+		WHERE rec.id = ?
+	`, recordID).Scan(
+		&id, &rec.SourceIP, &rec.Count, &rec.Disposition, &rec.DKIMEvaluatedResult, &rec.SPFEvaluatedResult, &rec.HeaderFrom,
+		&rep.ID, &rep.ReportID, &rep.OrgName, &rep.DateRangeBegin, &rep.DateRangeEnd,
+		&rep.PolicyDomain, &rep.PolicyADKIM, &rep.PolicyASPF, &rep.PolicyP, &rep.PolicySP, &rep.PolicyPCT,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Record not found
+		}
+		return nil, fmt.Errorf("failed to query record %d for analysis: %w", recordID, err)
+	}
+	rec.ID = strconv.FormatInt(id, 10)
+	rec.DMARCResult = dmarcResult(rec.DKIMEvaluatedResult, rec.SPFEvaluatedResult)
+
+	authResults, err := r.GetAuthResultsByRecordID(recordID)
+	if err != nil {
+		return nil, err
+	}
+	rec.AuthResults = []AnalyzedAuthResult{}
+	for _, ar := range authResults {
+		rec.AuthResults = append(rec.AuthResults, AnalyzedAuthResult{
+			AuthType: ar.AuthType, Domain: ar.Domain, Result: ar.Result, Selector: ar.Selector,
+		})
+		switch {
+		case ar.AuthType == AuthTypeDKIM && rec.AuthDKIMResult == "":
+			rec.AuthDKIMDomain, rec.AuthDKIMResult, rec.AuthDKIMSelector = ar.Domain, ar.Result, ar.Selector
+		case ar.AuthType == AuthTypeSPF && rec.AuthSPFResult == "":
+			rec.AuthSPFDomain, rec.AuthSPFResult = ar.Domain, ar.Result
+		}
+	}
+
+	snapshot, err := r.GetRecordIPInfo(recordID)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot != nil {
+		rec.IPInfo = RecordIPInfo{
+			CountryCode:   snapshot.CountryCode,
+			CountryName:   snapshot.CountryName,
+			ASNumber:      snapshot.ASNNumber,
+			ASName:        snapshot.ASNOrganization,
+			Hostname:      snapshot.Hostname,
+			ReverseDomain: snapshot.ApexDomain,
+		}
+	}
+	return analysis, nil
+}
+
+// AnalyzeAggregatedRecord retrieves the analysis of the aggregated record with
+// key, combining the matching records of the reports selected by filter. The
+// record details, IP information and policy are those of the latest
+// contributing report. It returns nil if no record matches.
+func (r *Repository) AnalyzeAggregatedRecord(key RecordKey, filter RecordFilter) (*RecordAnalysis, error) {
+	where, args := filter.where()
+	args = append(args, key.SourceIP, key.Disposition, key.DKIMResult, key.SPFResult, key.HeaderFrom)
+
+	rows, err := r.db.Query(`
+		SELECT rec.id, rep.id, rep.org_name, rep.report_id, rep.date_range_begin, rep.date_range_end, rec.count
+		`+recordsFrom+`
+		WHERE `+where+` AND rec.source_ip = ? AND rec.disposition = ? AND rec.dkim_result = ?
+			AND rec.spf_result = ? AND rec.header_from = ?
+		ORDER BY rep.date_range_end DESC, rep.id DESC, rec.id DESC
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query aggregated record: %w", err)
+	}
+	defer rows.Close()
+
+	var latestRecordID, total int64
+	contributing := []ContributingReport{}
+	index := make(map[int64]int) // Report ID to position in contributing
+	for rows.Next() {
+		var recordID int64
+		var c ContributingReport
+		if err := rows.Scan(&recordID, &c.ID, &c.OrgName, &c.ReportID, &c.DateRangeBegin, &c.DateRangeEnd, &c.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan aggregated record row: %w", err)
+		}
+		if latestRecordID == 0 {
+			latestRecordID = recordID
+		}
+		total += c.Count
+		if i, ok := index[c.ID]; ok {
+			contributing[i].Count += c.Count // Several matching records in one report
+			continue
+		}
+		index[c.ID] = len(contributing)
+		contributing = append(contributing, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read aggregated record rows: %w", err)
+	}
+	rows.Close()
+
+	if latestRecordID == 0 {
+		return nil, nil // No matching records
+	}
+
+	analysis, err := r.AnalyzeRecord(latestRecordID)
+	if err != nil || analysis == nil {
+		return analysis, err
+	}
+	analysis.Record.ID = key.ID()
+	analysis.Record.Count = total
+	analysis.ContributingReports = contributing
+	return analysis, nil
+}
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeRecord(t *testing.T) {
+	repo := newTestRepository(t)
+	ids := saveRecordFixture(t, repo)
+
+	got, err := repo.AnalyzeRecord(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &RecordAnalysis{
+		Record: AnalyzedRecord{
+			ID: fmt.Sprint(ids[0]), SourceIP: "192.0.2.1", Count: 10, Disposition: "none",
+			DKIMEvaluatedResult: "pass", SPFEvaluatedResult: "pass", DMARCResult: "pass", HeaderFrom: "example.com",
+			// The first raw result of each type
+			AuthDKIMDomain: "example.com", AuthDKIMResult: "pass", AuthDKIMSelector: "s1",
+			AuthSPFDomain: "example.com", AuthSPFResult: "pass",
+			AuthResults: []AnalyzedAuthResult{
+				{AuthType: AuthTypeDKIM, Domain: "example.com", Result: "pass", Selector: "s1"},
+				{AuthType: AuthTypeDKIM, Domain: "esp.example.net", Result: "fail", Selector: "s2"},
+				{AuthType: AuthTypeSPF, Domain: "example.com", Result: "pass"},
+			},
+			IPInfo: googleIPInfo,
+		},
+		Report: ReportPolicy{
+			ID: 1, ReportID: "g-1", OrgName: "google.com", DateRangeBegin: fixtureDay1, DateRangeEnd: fixtureDay1 + 86399,
+			PolicyDomain: "example.com", PolicyADKIM: "r", PolicyASPF: "r", PolicyP: "reject", PolicySP: "quarantine", PolicyPCT: 100,
+		},
+		ContributingReports: []ContributingReport{},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AnalyzeRecord() =\n%+v\nwant\n%+v", *got, *want)
+	}
+
+	// Without raw results or IP information
+	got, err = repo.AnalyzeRecord(ids[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Record.DMARCResult != "fail" || len(got.Record.AuthResults) != 0 || got.Record.AuthResults == nil ||
+		got.Record.IPInfo != (RecordIPInfo{}) || got.Report.ReportID != "y-1" {
+		t.Errorf("AnalyzeRecord() = %+v, want DMARC fail of report y-1 without auth results or IP information", got.Record)
+	}
+
+	if got, err := repo.AnalyzeRecord(ids[4] + 1); err != nil || got != nil {
+		t.Errorf("AnalyzeRecord of a missing record = %+v, %v, want nil, nil", got, err)
+	}
+}
+
+func TestAnalyzeAggregatedRecord(t *testing.T) {
+	repo := newTestRepository(t)
+	saveRecordFixture(t, repo)
+	key := RecordKey{SourceIP: "192.0.2.1", Disposition: "none", DKIMResult: "pass", SPFResult: "pass", HeaderFrom: "example.com"}
+
+	got, err := repo.AnalyzeAggregatedRecord(key, RecordFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Record.ID != key.ID() || got.Record.Count != 14 {
+		t.Errorf("record = %s with %d emails, want %s with 14", got.Record.ID, got.Record.Count, key.ID())
+	}
+	// The details and policy are those of the latest report, which has no raw results
+	if got.Report.ReportID != "y-1" || got.Report.PolicyP != "none" || len(got.Record.AuthResults) != 0 {
+		t.Errorf("analysis = %+v, want the policy of y-1 and no auth results", got)
+	}
+	wantReports := []ContributingReport{
+		{ID: 2, OrgName: "Yahoo", ReportID: "y-1", DateRangeBegin: fixtureDay2, DateRangeEnd: fixtureDay2 + 86399, Count: 4},
+		{ID: 1, OrgName: "google.com", ReportID: "g-1", DateRangeBegin: fixtureDay1, DateRangeEnd: fixtureDay1 + 86399, Count: 10},
+	}
+	if !reflect.DeepEqual(got.ContributingReports, wantReports) {
+		t.Errorf("contributing reports = %+v, want %+v", got.ContributingReports, wantReports)
+	}
+
+	// The filter selects the contributing reports
+	got, err = repo.AnalyzeAggregatedRecord(key, RecordFilter{End: fixtureDay1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Record.Count != 10 || len(got.ContributingReports) != 1 || got.Report.ReportID != "g-1" ||
+		got.Record.AuthDKIMSelector != "s1" {
+		t.Errorf("analysis of the first day = %+v, want 10 emails of g-1 with its auth results", got)
+	}
+
+	for _, filter := range []RecordFilter{{Begin: fixtureDay2 + 86400}, {CountryCode: "JP"}} {
+		if got, err := repo.AnalyzeAggregatedRecord(key, filter); err != nil || got != nil {
+			t.Errorf("AnalyzeAggregatedRecord with %+v = %+v, %v, want nil, nil", filter, got, err)
+		}
+	}
+	key.Disposition = "reject"
+	if got, err := repo.AnalyzeAggregatedRecord(key, RecordFilter{}); err != nil || got != nil {
+		t.Errorf("AnalyzeAggregatedRecord of a missing key = %+v, %v, want nil, nil", got, err)
+	}
+}
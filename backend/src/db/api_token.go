@@ -0,0 +1,123 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrAPITokenNotFound is returned for changes to API tokens that do not exist.
+var ErrAPITokenNotFound = errors.New("API token not found")
+
+// apiTokenColumns are the columns read by scanAPIToken, in order.
+const apiTokenColumns = "id, user_id, name, token_hash, prefix, scopes, created_at, expires_at, COALESCE(last_used_at, 0)"
+
+// scanAPIToken scans a row of apiTokenColumns.
+func scanAPIToken(row interface{ Scan(...interface{}) error }) (*APIToken, error) {
+	var token APIToken
+	var scopes string
+	err := row.Scan(&token.ID, &token.UserID, &token.Name, &token.TokenHash, &token.Prefix, &scopes,
+		&token.CreatedAt, &token.ExpiresAt, &token.LastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	token.Scopes = strings.Split(scopes, ",")
+	return &token, nil
+}
+
+// CreateAPIToken stores a new API token and sets its ID.
+func (r *Repository) CreateAPIToken(token *APIToken) error {
+	res, err := r.db.Exec(`
+		INSERT INTO api_tokens (user_id, name, token_hash, prefix, scopes, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, token.UserID, token.Name, token.TokenHash, token.Prefix, strings.Join(token.Scopes, ","),
+		token.CreatedAt, token.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert API token: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last insert ID for API token: %w", err)
+	}
+	token.ID = id
+	return nil
+}
+
+// GetAPITokenByHash retrieves an API token by the hash of its secret. It
+// returns nil if no token has the hash.
+func (r *Repository) GetAPITokenByHash(hash string) (*APIToken, error) {
+	token, err := scanAPIToken(r.db.QueryRow("SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = ?", hash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query API token: %w", err)
+	}
+	return token, nil
+}
+
+// GetAPITokenByID retrieves an API token by its ID. It returns nil if the
+// token does not exist.
+func (r *Repository) GetAPITokenByID(id int64) (*APIToken, error) {
+	token, err := scanAPIToken(r.db.QueryRow("SELECT "+apiTokenColumns+" FROM api_tokens WHERE id = ?", id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query API token %d: %w", id, err)
+	}
+	return token, nil
+}
+
+// ListAPITokens returns the API tokens of a user, newest first.
+func (r *Repository) ListAPITokens(userID int64) ([]APIToken, error) {
+	rows, err := r.db.Query("SELECT "+apiTokenColumns+" FROM api_tokens WHERE user_id = ? ORDER BY id DESC", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query API tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API token row: %w", err)
+		}
+		tokens = append(tokens, *token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating API token rows: %w", err)
+	}
+	return tokens, nil
+}
+
+// TouchAPIToken records that a token was used at the given time. To avoid a
+// write on every request, the time is only updated once it is a minute old.
+func (r *Repository) TouchAPIToken(id, usedAt int64) error {
+	_, err := r.db.Exec(`
+		UPDATE api_tokens SET last_used_at = ?
+		WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)
+	`, usedAt, id, usedAt-60)
+	if err != nil {
+		return fmt.Errorf("failed to update last use of API token %d: %w", id, err)
+	}
+	return nil
+}
+
+// DeleteAPIToken revokes an API token. It fails with ErrAPITokenNotFound if
+// the token does not exist.
+func (r *Repository) DeleteAPIToken(id int64) error {
+	res, err := r.db.Exec("DELETE FROM api_tokens WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete API token %d: %w", id, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows for API token deletion: %w", err)
+	}
+	if n == 0 {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AddAuditEntry appends an entry to the audit log. The timestamp is set to the
+// current time if it is zero. Entries cannot be changed or deleted afterwards.
+func (r *Repository) AddAuditEntry(entry *AuditEntry) error {
+	if entry.Timestamp == 0 {
+		entry.Timestamp = time.Now().Unix()
+	}
+
+	res, err := r.db.Exec(`
+		INSERT INTO audit_log (timestamp, actor, action, target, details, source_ip, request_id, method, path, user_agent)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, entry.Timestamp, entry.Actor, entry.Action, entry.Target, entry.Details, entry.SourceIP,
+		entry.RequestID, entry.Method, entry.Path, entry.UserAgent)
+	if err != nil {
+		return fmt.Errorf("failed to save audit entry: %w", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last insert ID for audit entry: %w", err)
+	}
+	entry.ID = id
+	return nil
+}
+
+// AuditFilter selects audit log entries. Empty fields match everything.
+type AuditFilter struct {
+	Begin  int64  // Unix timestamp, inclusive
+	End    int64  // Unix timestamp, inclusive
+	Actor  string // Exact username
+	Action string // Exact action, or a prefix such as "user" for all user.* actions
+	Target string // Exact target, such as user:alice
+}
+
+// where returns the SQL condition and arguments of the filter.
+func (f AuditFilter) where() (string, []interface{}) {
+	conditions := []string{"1 = 1"}
+	var args []interface{}
+
+	if f.Begin != 0 {
+		conditions = append(conditions, "timestamp >= ?")
+		args = append(args, f.Begin)
+	}
+	if f.End != 0 {
+		conditions = append(conditions, "timestamp <= ?")
+		args = append(args, f.End)
+	}
+	if f.Actor != "" {
+		conditions = append(conditions, "actor = ?")
+		args = append(args, f.Actor)
+	}
+	if f.Action != "" {
+		conditions = append(conditions, "(action = ? OR substr(action, 1, ?) = ?)")
+		args = append(args, f.Action, len(f.Action)+1, f.Action+".")
+	}
+	if f.Target != "" {
+		conditions = append(conditions, "target = ?")
+		args = append(args, f.Target)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// AuditQuery selects a page of the audit log, newest entries first.
+type AuditQuery struct {
+	Filter AuditFilter
+	Limit  int
+	Offset int     // Ignored when After is set
+	After  *Cursor // Return the entries following this position, from AuditCursor
+}
+
+// auditSortColumn is the only order of the audit log: by ID, which follows
+// the order the entries were written in.
+const auditSortColumn = "id"
+
+// AuditCursor returns the cursor positioned after entry.
+func AuditCursor(entry *AuditEntry) Cursor {
+	return Cursor{SortBy: auditSortColumn, SortOrder: "desc", Values: []interface{}{entry.ID}}
+}
+
+// GetAuditEntries retrieves a page of the audit log.
+func (r *Repository) GetAuditEntries(q AuditQuery) ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+	err := r.ForEachAuditEntry(q, func(entry *AuditEntry) error {
+		entries = append(entries, *entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// CountAuditEntries returns the number of audit log entries matching filter.
+func (r *Repository) CountAuditEntries(filter AuditFilter) (int, error) {
+	where, args := filter.where()
+
+	var totalCount int
+	if err := r.db.QueryRow("SELECT COUNT(*) FROM audit_log WHERE "+where, args...).Scan(&totalCount); err != nil {
+		return 0, fmt.Errorf("failed to count audit entries: %w", err)
+	}
+	return totalCount, nil
+}
+
+// ForEachAuditEntry calls fn for every audit log entry selected by q, newest
+// first, reading them from the database cursor one at a time. A Limit of 0 or
+// less selects all entries. Iteration stops at the first error returned by fn.
+func (r *Repository) ForEachAuditEntry(q AuditQuery, fn func(*AuditEntry) error) error {
+	where, args := q.Filter.where()
+	limit := q.Limit
+	if limit <= 0 {
+		limit = -1 // No limit
+	}
+
+	offset := q.Offset
+	if q.After != nil {
+		cond, condArgs, err := q.After.after([]string{auditSortColumn}, auditSortColumn, "desc")
+		if err != nil {
+			return err
+		}
+		where += " AND " + cond
+		args = append(args, condArgs...)
+		offset = 0
+	}
+
+	rows, err := r.db.Query(`
+		SELECT id, timestamp, actor, action, COALESCE(target, ''), COALESCE(details, ''), COALESCE(source_ip, ''),
+			request_id, method, path, user_agent
+		FROM audit_log
+		WHERE `+where+`
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?
+	`, append(args, limit, offset)...)
+	if err != nil {
+		return fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.Scan(&entry.ID, &entry.Timestamp, &entry.Actor, &entry.Action, &entry.Target, &entry.Details,
+			&entry.SourceIP, &entry.RequestID, &entry.Method, &entry.Path, &entry.UserAgent); err != nil {
+			return fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		if err := fn(&entry); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,377 @@
+package db
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+const (
+	// backupStepPages is the number of pages copied per backup step. Copying in
+	// small steps lets the server keep writing between steps.
+	backupStepPages = 256
+	// backupStepPause is the pause between steps, and the retry delay when the
+	// source database is busy or locked.
+	backupStepPause = 10 * time.Millisecond
+)
+
+// maxSnapshotSize limits the decompressed size of a compressed snapshot, so
+// that a small upload cannot fill the disk.
+var maxSnapshotSize int64 = 16 << 30 // 16 GB
+
+// gzipMagic is the two-byte header of a gzip stream.
+var gzipMagic = []byte{0x1F, 0x8B}
+
+// ErrInvalidSnapshot is returned when a snapshot fails verification.
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+// BackupToFile writes a consistent snapshot of the live database to destPath
+// using SQLite's online backup API. If compress is true the snapshot is
+// gzip-compressed. The file is written next to destPath first and renamed
+// into place once complete.
+func (r *Repository) BackupToFile(ctx context.Context, destPath string, compress bool) error {
+	if err := os.MkdirAll(filepath.Dir(destPath), 0700); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(destPath), ".backup-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create backup file: %w", err)
+	}
+	tmpPath := out.Name()
+	defer os.Remove(tmpPath) // No-op once renamed
+
+	if err := r.WriteSnapshot(ctx, out, compress); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close backup file: %w", err)
+	}
+	if err := os.Chmod(tmpPath, 0600); err != nil {
+		return fmt.Errorf("failed to set backup file permissions: %w", err)
+	}
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		return fmt.Errorf("failed to move backup into place: %w", err)
+	}
+	return nil
+}
+
+// WriteSnapshot takes a consistent snapshot of the live database and streams it
+// to w, optionally gzip-compressed. The snapshot is staged in a temporary file
+// because the backup API can only write to another database.
+func (r *Repository) WriteSnapshot(ctx context.Context, w io.Writer, compress bool) error {
+	tmpDir, err := os.MkdirTemp("", "dmarc-snapshot-")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	snapshotPath := filepath.Join(tmpDir, "snapshot.db")
+	if err := r.backupInto(ctx, snapshotPath); err != nil {
+		return err
+	}
+
+	snapshot, err := os.Open(snapshotPath)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer snapshot.Close()
+
+	if !compress {
+		if _, err := io.Copy(w, snapshot); err != nil {
+			return fmt.Errorf("failed to write snapshot: %w", err)
+		}
+		return nil
+	}
+
+	gzWriter := gzip.NewWriter(w)
+	if _, err := io.Copy(gzWriter, snapshot); err != nil {
+		gzWriter.Close()
+		return fmt.Errorf("failed to write compressed snapshot: %w", err)
+	}
+	if err := gzWriter.Close(); err != nil {
+		return fmt.Errorf("failed to finish compressed snapshot: %w", err)
+	}
+	return nil
+}
+
+// RestoreFromFile replaces the contents of the live database with the snapshot
+// at srcPath (plain or gzip-compressed). The snapshot is checked with
+// VerifySnapshot before anything in the live database is touched, and is then
+// copied in with the online backup API so open connections stay valid.
+func (r *Repository) RestoreFromFile(ctx context.Context, srcPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot %s: %w", srcPath, err)
+	}
+	defer src.Close()
+	return r.RestoreFromReader(ctx, src)
+}
+
+// RestoreFromReader is like RestoreFromFile but reads the snapshot from rd.
+//
+// The live audit log survives a restore: its entries that the snapshot lacks
+// are added back after the snapshot's own. Every session is ended, since the
+// snapshot may hold sessions that were ended after it was taken.
+func (r *Repository) RestoreFromReader(ctx context.Context, rd io.Reader) error {
+	tmpDir, err := os.MkdirTemp("", "dmarc-restore-")
+	if err != nil {
+		return fmt.Errorf("failed to create restore directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	snapshotPath := filepath.Join(tmpDir, "restore.db")
+	if err := stageSnapshot(rd, snapshotPath); err != nil {
+		return err
+	}
+	if err := VerifySnapshot(snapshotPath); err != nil {
+		return err
+	}
+	auditPath := filepath.Join(tmpDir, "audit.db")
+	if err := r.saveAuditLog(ctx, auditPath); err != nil {
+		return err
+	}
+
+	if err := r.copyFrom(ctx, snapshotPath); err != nil {
+		return fmt.Errorf("failed to restore snapshot: %w", err)
+	}
+
+	// Older snapshots are brought up to the current schema.
+	if err := runMigrations(r.db); err != nil {
+		return fmt.Errorf("failed to migrate restored database: %w", err)
+	}
+	if err := ensureSearchIndex(r.db); err != nil {
+		return fmt.Errorf("failed to index restored database: %w", err)
+	}
+	if err := r.mergeAuditLog(ctx, auditPath); err != nil {
+		return err
+	}
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM sessions"); err != nil {
+		return fmt.Errorf("failed to end sessions after restore: %w", err)
+	}
+	return nil
+}
+
+// auditColumns are the columns of audit_log that are carried across a
+// restore. IDs are assigned anew.
+const auditColumns = "timestamp, actor, action, target, details, source_ip, request_id, method, path, user_agent"
+
+// saveAuditLog copies the live audit log into a new database at path.
+func (r *Repository) saveAuditLog(ctx context.Context, path string) error {
+	return r.withAttached(ctx, path, func(conn *sql.Conn) error {
+		if _, err := conn.ExecContext(ctx, "CREATE TABLE carried.audit_log AS SELECT * FROM main.audit_log"); err != nil {
+			return fmt.Errorf("failed to save audit log: %w", err)
+		}
+		return nil
+	})
+}
+
+// mergeAuditLog appends the entries saved by saveAuditLog at path that the
+// restored audit log lacks, in their original order. Entries are the same if
+// their ID, timestamp, actor and action match.
+func (r *Repository) mergeAuditLog(ctx context.Context, path string) error {
+	return r.withAttached(ctx, path, func(conn *sql.Conn) error {
+		_, err := conn.ExecContext(ctx, `
+			INSERT INTO main.audit_log (`+auditColumns+`)
+			SELECT `+auditColumns+` FROM carried.audit_log c
+			WHERE NOT EXISTS (
+				SELECT 1 FROM main.audit_log a
+				WHERE a.id = c.id AND a.timestamp = c.timestamp AND a.actor = c.actor AND a.action = c.action
+			)
+			ORDER BY c.id
+		`)
+		if err != nil {
+			return fmt.Errorf("failed to carry the audit log across the restore: %w", err)
+		}
+		return nil
+	})
+}
+
+// withAttached calls fn with a connection that has the database at path
+// attached as "carried".
+func (r *Repository) withAttached(ctx context.Context, path string, fn func(*sql.Conn) error) error {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get database connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS carried", path); err != nil {
+		return fmt.Errorf("failed to attach %s: %w", path, err)
+	}
+	defer conn.ExecContext(context.Background(), "DETACH DATABASE carried")
+	return fn(conn)
+}
+
+// VerifySnapshot checks that the database file at path passes SQLite's
+// integrity check and has a schema version this build can use. Version 0
+// is a database from before schema versions were recorded; it is accepted
+// if it has the required tables, and the migrations upgrade it.
+func VerifySnapshot(path string) error {
+	snapshot, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer snapshot.Close()
+
+	var result string
+	if err := snapshot.QueryRow("PRAGMA integrity_check;").Scan(&result); err != nil {
+		return fmt.Errorf("%w: not a valid SQLite database: %v", ErrInvalidSnapshot, err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("%w: integrity check failed: %s", ErrInvalidSnapshot, result)
+	}
+
+	version, err := schemaVersion(snapshot)
+	if err != nil {
+		return err
+	}
+	if version < 0 || version > SchemaVersion {
+		return fmt.Errorf("%w: schema version %d is not supported (expected 0 to %d)", ErrInvalidSnapshot, version, SchemaVersion)
+	}
+
+	var tables int
+	err = snapshot.QueryRow(
+		"SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('reports', 'records', 'ip_info', 'users')",
+	).Scan(&tables)
+	if err != nil {
+		return fmt.Errorf("failed to inspect snapshot schema: %w", err)
+	}
+	if tables != 4 {
+		return fmt.Errorf("%w: missing required tables", ErrInvalidSnapshot)
+	}
+	return nil
+}
+
+// stageSnapshot writes rd to destPath, transparently decompressing gzip input
+// of at most maxSnapshotSize bytes.
+func stageSnapshot(rd io.Reader, destPath string) error {
+	buffered := bufio.NewReader(rd)
+	header, err := buffered.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read snapshot header: %w", err)
+	}
+
+	var content io.Reader = buffered
+	compressed := false
+	if len(header) == len(gzipMagic) && string(header) == string(gzipMagic) {
+		gzReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return fmt.Errorf("%w: failed to open compressed snapshot: %v", ErrInvalidSnapshot, err)
+		}
+		defer gzReader.Close()
+		content = io.LimitReader(gzReader, maxSnapshotSize+1)
+		compressed = true
+	}
+
+	out, err := os.OpenFile(destPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create staging file: %w", err)
+	}
+	n, err := io.Copy(out, content)
+	if err != nil {
+		out.Close()
+		return fmt.Errorf("failed to stage snapshot: %w", err)
+	}
+	if compressed && n > maxSnapshotSize {
+		out.Close()
+		return fmt.Errorf("%w: decompressed snapshot is larger than %d bytes", ErrInvalidSnapshot, maxSnapshotSize)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close staging file: %w", err)
+	}
+	return nil
+}
+
+// backupInto copies the live database into a new database file at destPath.
+func (r *Repository) backupInto(ctx context.Context, destPath string) error {
+	dest, err := sql.Open("sqlite3", destPath)
+	if err != nil {
+		return fmt.Errorf("failed to open backup destination: %w", err)
+	}
+	defer dest.Close()
+
+	return runBackup(ctx, dest, r.db)
+}
+
+// copyFrom overwrites the live database with the database file at srcPath.
+func (r *Repository) copyFrom(ctx context.Context, srcPath string) error {
+	src, err := sql.Open("sqlite3", "file:"+srcPath+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("failed to open restore source: %w", err)
+	}
+	defer src.Close()
+
+	return runBackup(ctx, r.db, src)
+}
+
+// runBackup copies the main database of src into dest with the online backup
+// API, a few pages at a time, retrying while the source is busy.
+func runBackup(ctx context.Context, dest, src *sql.DB) error {
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get destination connection: %w", err)
+	}
+	defer destConn.Close()
+
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get source connection: %w", err)
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(destDriver any) error {
+		return srcConn.Raw(func(srcDriver any) error {
+			destSQLite, ok := destDriver.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("destination is not a SQLite connection")
+			}
+			srcSQLite, ok := srcDriver.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("source is not a SQLite connection")
+			}
+
+			backup, err := destSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return fmt.Errorf("failed to start backup: %w", err)
+			}
+			for {
+				done, err := backup.Step(backupStepPages)
+				if err != nil {
+					backup.Finish()
+					return fmt.Errorf("backup step failed: %w", err)
+				}
+				if done {
+					break
+				}
+				select {
+				case <-ctx.Done():
+					backup.Finish()
+					return ctx.Err()
+				case <-time.After(backupStepPause):
+				}
+			}
+			if err := backup.Finish(); err != nil {
+				return fmt.Errorf("failed to finish backup: %w", err)
+			}
+			return nil
+		})
+	})
+}
+
+// IsCompressedBackupPath reports whether a backup written to path should be
+// gzip-compressed, based on its extension.
+func IsCompressedBackupPath(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), ".gz")
+}
@@ -0,0 +1,180 @@
+package db
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// baselineSchema is the schema of databases created before schema versions
+// were recorded in PRAGMA user_version.
+const baselineSchema = `
+	CREATE TABLE reports (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, xml_hash TEXT UNIQUE NOT NULL, original_xml TEXT NOT NULL,
+		org_name TEXT, report_id TEXT, date_range_begin INTEGER, date_range_end INTEGER, domain TEXT,
+		adkim TEXT, aspf TEXT, p TEXT, sp TEXT, pct INTEGER
+	);
+	CREATE TABLE records (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, report_id INTEGER NOT NULL, source_ip TEXT NOT NULL, count INTEGER,
+		header_from TEXT, disposition TEXT, dkim_result TEXT, spf_result TEXT,
+		FOREIGN KEY (report_id) REFERENCES reports(id) ON DELETE CASCADE
+	);
+	CREATE TABLE ip_info (
+		ip_address TEXT PRIMARY KEY, country_code TEXT, country_name TEXT, city_name TEXT, asn_number INTEGER,
+		asn_organization TEXT, hostname TEXT, reversed_hostname TEXT, apex_domain TEXT, last_updated INTEGER
+	);
+	CREATE TABLE ingestion_errors (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, filename TEXT NOT NULL, xml_hash TEXT, error_type TEXT NOT NULL,
+		message TEXT NOT NULL, timestamp INTEGER NOT NULL
+	);
+	CREATE TABLE users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, username TEXT UNIQUE NOT NULL, password_hash TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	CREATE TABLE settings (key TEXT PRIMARY KEY, value TEXT);
+	INSERT INTO users (username, password_hash, created_at) VALUES ('legacy', 'hash', 1700000000);
+`
+
+// writeBaselineDatabase creates a database with baselineSchema at path.
+func writeBaselineDatabase(t *testing.T, path string) {
+	t.Helper()
+	database, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	if _, err := database.Exec(baselineSchema); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRestoreUnversionedSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "baseline.db")
+	writeBaselineDatabase(t, path)
+	if err := VerifySnapshot(path); err != nil {
+		t.Fatalf("VerifySnapshot of a version 0 database: %v", err)
+	}
+
+	repo := newTestRepository(t)
+	if err := repo.RestoreFromFile(context.Background(), path); err != nil {
+		t.Fatalf("RestoreFromFile: %v", err)
+	}
+	version, err := schemaVersion(repo.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != SchemaVersion {
+		t.Errorf("schema version after restore = %d, want %d", version, SchemaVersion)
+	}
+	user, err := repo.GetUserByUsername("legacy")
+	if err != nil || user == nil {
+		t.Fatalf("restored user = %+v, %v", user, err)
+	}
+	if user.Role != RoleAdmin {
+		t.Errorf("restored user role = %q, want %q", user.Role, RoleAdmin)
+	}
+}
+
+func TestVerifySnapshotRejectsUnknownDatabases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "other.db")
+	database, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.Exec("CREATE TABLE notes (body TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	database.Close()
+
+	if err := VerifySnapshot(path); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("VerifySnapshot of a database without the required tables error = %v, want ErrInvalidSnapshot", err)
+	}
+}
+
+func TestRestoreRejectsOversizedCompressedSnapshot(t *testing.T) {
+	defer func(size int64) { maxSnapshotSize = size }(maxSnapshotSize)
+	maxSnapshotSize = 1 << 20
+
+	var compressed bytes.Buffer
+	gzWriter := gzip.NewWriter(&compressed)
+	if _, err := gzWriter.Write(make([]byte, 4<<20)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := newTestRepository(t)
+	if err := repo.RestoreFromReader(context.Background(), &compressed); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("RestoreFromReader of %d bytes inflating to 4 MB error = %v, want ErrInvalidSnapshot", compressed.Len(), err)
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	repo := newTestRepository(t)
+	if _, err := repo.CreateUser("alice", "a good long passphrase", RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	var snapshot bytes.Buffer
+	if err := repo.WriteSnapshot(context.Background(), &snapshot, true); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := newTestRepository(t)
+	if err := restored.RestoreFromReader(context.Background(), &snapshot); err != nil {
+		t.Fatalf("RestoreFromReader: %v", err)
+	}
+	user, err := restored.GetUserByUsername("alice")
+	if err != nil || user == nil || user.Role != RoleViewer {
+		t.Errorf("restored user = %+v, %v", user, err)
+	}
+}
+
+func TestRestoreKeepsLiveAuditLogAndEndsSessions(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepository(t)
+	user, err := repo.CreateUser("alice", "a good long passphrase", RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AddAuditEntry(&AuditEntry{Actor: "alice", Action: "user.create", Target: "user:bob"}); err != nil {
+		t.Fatal(err)
+	}
+	var snapshot bytes.Buffer
+	if err := repo.WriteSnapshot(ctx, &snapshot, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Made after the snapshot: an entry that a restore must not erase and a
+	// session that it must not bring back.
+	if err := repo.AddAuditEntry(&AuditEntry{Actor: "alice", Action: "report.bulk_delete", Target: "reports"}); err != nil {
+		t.Fatal(err)
+	}
+	session := &Session{UserID: user.ID, RefreshHash: "hash", CreatedAt: 1, LastUsedAt: 1, ExpiresAt: 1 << 40}
+	if err := repo.CreateSession(session); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.RestoreFromReader(ctx, &snapshot); err != nil {
+		t.Fatalf("RestoreFromReader: %v", err)
+	}
+	entries, err := repo.GetAuditEntries(AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, e := range entries {
+		actions = append(actions, e.Action)
+	}
+	if want := "report.bulk_delete user.create"; strings.Join(actions, " ") != want {
+		t.Errorf("audit log after restore = %v, want %s (newest first)", actions, want)
+	}
+	if sessions, err := repo.ListSessions(user.ID, 0); err != nil || len(sessions) != 0 {
+		t.Errorf("sessions after restore = %v, %v; want none", sessions, err)
+	}
+}
@@ -0,0 +1,344 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SaveReportBundle saves a report, its records and their auth results in a
+// single transaction. IDs are written back into the bundle.
+func (r *Repository) SaveReportBundle(bundle *ReportBundle) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction for saving report: %w", err)
+	}
+	defer tx.Rollback() // No-op after commit
+
+	report := &bundle.Report
+	res, err := tx.Exec(`
+		INSERT INTO reports (xml_hash, original_xml, org_name, report_id, date_range_begin, date_range_end, domain, adkim, aspf, p, sp, pct)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		report.XMLHash, report.OriginalXML, report.OrgName, report.ReportID,
+		report.DateRangeBegin, report.DateRangeEnd, report.Domain, report.ADKIM,
+		report.ASPF, report.P, report.SP, report.PCT,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save report: %w", err)
+	}
+	report.ID, err = res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last insert ID for report: %w", err)
+	}
+
+	recordStmt, err := tx.Prepare(`
+		INSERT INTO records (report_id, source_ip, count, header_from, disposition, dkim_result, spf_result)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement for saving record: %w", err)
+	}
+	defer recordStmt.Close()
+
+	authStmt, err := tx.Prepare(`
+		INSERT INTO auth_results (record_id, auth_type, domain, result, selector)
+		VALUES (?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement for saving auth result: %w", err)
+	}
+	defer authStmt.Close()
+
+	for i := range bundle.Records {
+		record := &bundle.Records[i].Record
+		record.ReportID = report.ID
+		res, err := recordStmt.Exec(
+			record.ReportID, record.SourceIP, record.Count, record.HeaderFrom,
+			record.Disposition, record.DKIMResult, record.SPFResult,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to save record for IP %s: %w", record.SourceIP, err)
+		}
+		record.ID, err = res.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("failed to get last insert ID for record: %w", err)
+		}
+
+		if err := insertAuthResults(authStmt, record.ID, bundle.Records[i].AuthResults); err != nil {
+			return err
+		}
+	}
+
+	if r.searchEnabled() {
+		if err := indexReportBundleTx(tx, bundle); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit report: %w", err)
+	}
+	return nil
+}
+
+// SaveAuthResults saves auth results for an already stored record.
+func (r *Repository) SaveAuthResults(recordID int64, results []AuthResult) error {
+	stmt, err := r.db.Prepare(`
+		INSERT INTO auth_results (record_id, auth_type, domain, result, selector)
+		VALUES (?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement for saving auth result: %w", err)
+	}
+	defer stmt.Close()
+
+	if err := insertAuthResults(stmt, recordID, results); err != nil {
+		return err
+	}
+	return r.indexRecord(recordID)
+}
+
+// insertAuthResults executes stmt for every auth result of a record.
+func insertAuthResults(stmt *sql.Stmt, recordID int64, results []AuthResult) error {
+	for i := range results {
+		result := &results[i]
+		result.RecordID = recordID
+		res, err := stmt.Exec(result.RecordID, result.AuthType, result.Domain, result.Result, result.Selector)
+		if err != nil {
+			return fmt.Errorf("failed to save %s auth result for record %d: %w", result.AuthType, recordID, err)
+		}
+		result.ID, err = res.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("failed to get last insert ID for auth result: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetRecordsByReportID retrieves all records of a report in insertion order.
+func (r *Repository) GetRecordsByReportID(reportID int64) ([]Record, error) {
+	rows, err := r.db.Query(`
+		SELECT id, report_id, source_ip, count, header_from, disposition, dkim_result, spf_result
+		FROM records
+		WHERE report_id = ?
+		ORDER BY id
+	`, reportID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query records for report %d: %w", reportID, err)
+	}
+	defer rows.Close()
+
+	var records []Record
+	for rows.Next() {
+		var record Record
+		if err := rows.Scan(
+			&record.ID, &record.ReportID, &record.SourceIP, &record.Count, &record.HeaderFrom,
+			&record.Disposition, &record.DKIMResult, &record.SPFResult,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan record row: %w", err)
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// GetAuthResultsByRecordID retrieves the raw auth results of a record.
+func (r *Repository) GetAuthResultsByRecordID(recordID int64) ([]AuthResult, error) {
+	rows, err := r.db.Query(`
+		SELECT id, record_id, auth_type, domain, result, selector
+		FROM auth_results
+		WHERE record_id = ?
+		ORDER BY id
+	`, recordID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query auth results for record %d: %w", recordID, err)
+	}
+	defer rows.Close()
+
+	var results []AuthResult
+	for rows.Next() {
+		var result AuthResult
+		var domain, res, selector sql.NullString
+		if err := rows.Scan(&result.ID, &result.RecordID, &result.AuthType, &domain, &res, &selector); err != nil {
+			return nil, fmt.Errorf("failed to scan auth result row: %w", err)
+		}
+		result.Domain, result.Result, result.Selector = domain.String, res.String, selector.String
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// ForEachReportBundle calls fn for every report whose date range begins within
+// [begin, end], oldest first. A zero begin or end leaves that side unbounded.
+// Iteration stops at the first error returned by fn.
+func (r *Repository) ForEachReportBundle(begin, end int64, fn func(*ReportBundle) error) error {
+	if end == 0 {
+		end = 1<<63 - 1
+	}
+
+	// Collect IDs first so that no read cursor is held open while fn runs.
+	rows, err := r.db.Query(`
+		SELECT id FROM reports
+		WHERE date_range_begin >= ? AND date_range_begin <= ?
+		ORDER BY date_range_begin, id
+	`, begin, end)
+	if err != nil {
+		return fmt.Errorf("failed to query reports for export: %w", err)
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan report ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to iterate reports for export: %w", err)
+	}
+
+	for _, id := range ids {
+		bundle, err := r.GetReportBundle(id)
+		if err != nil {
+			return err
+		}
+		if bundle == nil {
+			continue // Deleted since the IDs were read
+		}
+		if err := fn(bundle); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetReportBundle retrieves a report with all of its records and auth results.
+func (r *Repository) GetReportBundle(id int64) (*ReportBundle, error) {
+	report, err := r.GetReportByID(id)
+	if err != nil || report == nil {
+		return nil, err
+	}
+
+	records, err := r.GetRecordsByReportID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &ReportBundle{Report: *report}
+	for _, record := range records {
+		results, err := r.GetAuthResultsByRecordID(record.ID)
+		if err != nil {
+			return nil, err
+		}
+		bundle.Records = append(bundle.Records, RecordBundle{Record: record, AuthResults: results})
+	}
+	return bundle, nil
+}
+
+// GetReportIDsWithoutAuthResults returns the IDs of reports that have records
+// but no stored auth results, i.e. reports ingested before auth results were kept.
+func (r *Repository) GetReportIDsWithoutAuthResults() ([]int64, error) {
+	rows, err := r.db.Query(`
+		SELECT rp.id
+		FROM reports rp
+		WHERE EXISTS (SELECT 1 FROM records rec WHERE rec.report_id = rp.id)
+		AND NOT EXISTS (
+			SELECT 1 FROM records rec
+			JOIN auth_results ar ON ar.record_id = rec.id
+			WHERE rec.report_id = rp.id
+		)
+		ORDER BY rp.id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reports without auth results: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan report ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetIPInfo retrieves the stored IP information for an address.
+func (r *Repository) GetIPInfo(ipAddress string) (*IPInfo, error) {
+	var info IPInfo
+	err := r.db.QueryRow(`
+		SELECT ip_address, country_code, country_name, city_name, asn_number, asn_organization, hostname, reversed_hostname, apex_domain, last_updated
+		FROM ip_info
+		WHERE ip_address = ?
+	`, ipAddress).Scan(
+		&info.IPAddress, &info.CountryCode, &info.CountryName, &info.CityName,
+		&info.ASNNumber, &info.ASNOrganization, &info.Hostname, &info.ReversedHostname,
+		&info.ApexDomain, &info.LastUpdated,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // IP info not found
+		}
+		return nil, fmt.Errorf("failed to query IP info for %s: %w", ipAddress, err)
+	}
+	return &info, nil
+}
+
+// MergeIPInfo stores imported IP information, keeping whichever of the stored
+// and the imported entries was updated most recently. It reports whether the
+// imported entry was written. A written entry is added to the snapshot history
+// as observed at its last_updated time.
+func (r *Repository) MergeIPInfo(info *IPInfo) (bool, error) {
+	if info.LastUpdated == 0 {
+		info.LastUpdated = time.Now().Unix()
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction for merging IP info: %w", err)
+	}
+	defer tx.Rollback() // No-op after commit
+
+	res, err := tx.Exec(`
+		INSERT INTO ip_info (ip_address, country_code, country_name, city_name, asn_number, asn_organization, hostname, reversed_hostname, apex_domain, last_updated)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(ip_address) DO UPDATE SET
+			country_code = EXCLUDED.country_code,
+			country_name = EXCLUDED.country_name,
+			city_name = EXCLUDED.city_name,
+			asn_number = EXCLUDED.asn_number,
+			asn_organization = EXCLUDED.asn_organization,
+			hostname = EXCLUDED.hostname,
+			reversed_hostname = EXCLUDED.reversed_hostname,
+			apex_domain = EXCLUDED.apex_domain,
+			last_updated = EXCLUDED.last_updated
+		WHERE EXCLUDED.last_updated > ip_info.last_updated
+	`,
+		info.IPAddress, info.CountryCode, info.CountryName, info.CityName,
+		info.ASNNumber, info.ASNOrganization, info.Hostname, info.ReversedHostname,
+		info.ApexDomain, info.LastUpdated,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to merge IP info for %s: %w", info.IPAddress, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get affected rows for IP info %s: %w", info.IPAddress, err)
+	}
+	if n == 0 {
+		return false, nil
+	}
+	if err := recordIPSnapshotTx(tx, info, info.LastUpdated); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit merged IP info for %s: %w", info.IPAddress, err)
+	}
+	return true, r.indexIPInfo(info.IPAddress)
+}
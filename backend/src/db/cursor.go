@@ -0,0 +1,74 @@
+package db
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidCursor is returned for cursors that cannot be decoded or do not
+// belong to the requested listing.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is a position in a keyset-paginated listing: the ORDER BY values of
+// the last row of the previous page, together with the sort it was taken
+// from. Unlike an offset, it stays on the same row while rows are inserted
+// before it, and the database can seek to it instead of skipping rows.
+type Cursor struct {
+	SortBy    string        `json:"s"`
+	SortOrder string        `json:"o"` // asc or desc
+	Values    []interface{} `json:"v"` // Sort column first, then the tie-breaking columns
+}
+
+// Encode returns the opaque string form of c.
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses a cursor returned by Encode.
+func DecodeCursor(s string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var c Cursor
+	if err := decoder.Decode(&c); err != nil || len(c.Values) == 0 {
+		return nil, ErrInvalidCursor
+	}
+	for i, v := range c.Values {
+		switch value := v.(type) {
+		case string:
+		case json.Number:
+			n, err := value.Int64()
+			if err != nil {
+				return nil, ErrInvalidCursor
+			}
+			c.Values[i] = n
+		default:
+			return nil, ErrInvalidCursor
+		}
+	}
+	return &c, nil
+}
+
+// after returns the condition selecting the rows that follow the cursor in
+// the order given by columns, sortBy and sortOrder, which must be the
+// normalized sort the cursor was created with. All columns are sorted in the
+// same direction, so a single row value comparison expresses the position.
+func (c *Cursor) after(columns []string, sortBy, sortOrder string) (string, []interface{}, error) {
+	if c.SortBy != sortBy || !strings.EqualFold(c.SortOrder, sortOrder) || len(c.Values) != len(columns) {
+		return "", nil, fmt.Errorf("%w: cursor does not match the requested sort", ErrInvalidCursor)
+	}
+	op := ">"
+	if strings.EqualFold(sortOrder, "desc") {
+		op = "<"
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	return fmt.Sprintf("(%s) %s (%s)", strings.Join(columns, ", "), op, placeholders), c.Values, nil
+}
@@ -0,0 +1,248 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// saveTestReports stores reports and records with many equal sort values, so
+// that paging depends on the tie-breaking columns.
+func saveTestReports(t *testing.T, repo *Repository) {
+	t.Helper()
+	orgs := []string{"b", "a", "b", "c", "a", "b", "a"}
+	begins := []int64{100, 200, 100, 300, 200, 100, 400}
+	for i := range orgs {
+		report := &Report{
+			XMLHash:        fmt.Sprintf("hash%d", i),
+			OrgName:        orgs[i],
+			ReportID:       fmt.Sprintf("r%d", i%3),
+			DateRangeBegin: begins[i],
+			DateRangeEnd:   begins[i] + 86400,
+			Domain:         "example.com",
+		}
+		if _, err := repo.SaveReport(report); err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < 3; j++ {
+			record := &Record{
+				ReportID:    report.ID,
+				SourceIP:    fmt.Sprintf("192.0.2.%d", (i+j)%4),
+				Count:       1 + (i*j)%2,
+				HeaderFrom:  "example.com",
+				Disposition: "none",
+				DKIMResult:  "pass",
+				SPFResult:   []string{"pass", "fail"}[j%2],
+			}
+			if err := repo.SaveRecord(record); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// compareCursorValues compares two cursor value lists like SQLite compares
+// row values of integers and strings.
+func compareCursorValues(a, b []interface{}) int {
+	for i := range a {
+		switch x := a[i].(type) {
+		case int64:
+			if y := b[i].(int64); x != y {
+				if x < y {
+					return -1
+				}
+				return 1
+			}
+		case string:
+			if y := b[i].(string); x != y {
+				if x < y {
+					return -1
+				}
+				return 1
+			}
+		}
+	}
+	return 0
+}
+
+// checkStrictOrder fails unless the cursors of consecutive rows are strictly
+// ordered in sortOrder, that is, ties are broken in the same direction and
+// no two rows share a position.
+func checkStrictOrder(t *testing.T, name string, cursors []Cursor, sortOrder string) {
+	t.Helper()
+	for i := 1; i < len(cursors); i++ {
+		cmp := compareCursorValues(cursors[i-1].Values, cursors[i].Values)
+		if (sortOrder == "asc" && cmp >= 0) || (sortOrder == "desc" && cmp <= 0) {
+			t.Errorf("%s: row %d %v is not %s after row %d %v", name, i, cursors[i].Values, sortOrder, i-1, cursors[i-1].Values)
+		}
+	}
+}
+
+func TestReportKeysetPaging(t *testing.T) {
+	repo := newTestRepository(t)
+	saveTestReports(t, repo)
+
+	for _, sortBy := range []string{"id", "org_name", "report_id", "date_range_begin", "domain"} {
+		for _, sortOrder := range []string{"asc", "desc"} {
+			name := sortBy + " " + sortOrder
+			all, err := repo.GetReports(ReportQuery{SortBy: sortBy, SortOrder: sortOrder, Limit: 100})
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			var cursors []Cursor
+			for i := range all {
+				cursors = append(cursors, ReportCursor(&all[i], sortBy, sortOrder))
+			}
+			checkStrictOrder(t, name, cursors, sortOrder)
+
+			// Page through two at a time, resuming from encoded cursors
+			var paged []int64
+			q := ReportQuery{SortBy: sortBy, SortOrder: sortOrder, Limit: 2}
+			for {
+				page, err := repo.GetReports(q)
+				if err != nil {
+					t.Fatalf("%s: page after %d reports: %v", name, len(paged), err)
+				}
+				for _, report := range page {
+					paged = append(paged, report.ID)
+				}
+				if len(page) < q.Limit {
+					break
+				}
+				cursor, err := DecodeCursor(ReportCursor(&page[len(page)-1], sortBy, sortOrder).Encode())
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				q.After = cursor
+			}
+
+			if fmt.Sprint(paged) != fmt.Sprint(reportIDs(all)) {
+				t.Errorf("%s: paged %v, want %v", name, paged, reportIDs(all))
+			}
+		}
+	}
+}
+
+// reportIDs returns the IDs of reports in order.
+func reportIDs(reports []Report) []int64 {
+	ids := make([]int64, len(reports))
+	for i, report := range reports {
+		ids[i] = report.ID
+	}
+	return ids
+}
+
+func TestRecordKeysetPaging(t *testing.T) {
+	repo := newTestRepository(t)
+	saveTestReports(t, repo)
+
+	for _, aggregate := range []bool{false, true} {
+		sorts := []string{"count", "source_ip", "date_range_begin", "as_name", "report_org_name"}
+		if aggregate {
+			sorts[len(sorts)-1] = "report_count"
+		}
+		for _, sortBy := range sorts {
+			for _, sortOrder := range []string{"asc", "desc"} {
+				name := fmt.Sprintf("%s %s (aggregate %t)", sortBy, sortOrder, aggregate)
+				q := RecordQuery{Aggregate: aggregate, SortBy: sortBy, SortOrder: sortOrder}
+				all, err := repo.GetRecords(q)
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				var cursors []Cursor
+				var want []string
+				for i := range all {
+					cursors = append(cursors, RecordCursor(&all[i], q))
+					want = append(want, all[i].ID)
+				}
+				checkStrictOrder(t, name, cursors, sortOrder)
+
+				var paged []string
+				q.Limit = 3
+				for {
+					page, err := repo.GetRecords(q)
+					if err != nil {
+						t.Fatalf("%s: page after %d rows: %v", name, len(paged), err)
+					}
+					for _, row := range page {
+						paged = append(paged, row.ID)
+					}
+					if len(page) < q.Limit {
+						break
+					}
+					cursor, err := DecodeCursor(RecordCursor(&page[len(page)-1], q).Encode())
+					if err != nil {
+						t.Fatalf("%s: %v", name, err)
+					}
+					q.After = cursor
+				}
+
+				if fmt.Sprint(paged) != fmt.Sprint(want) {
+					t.Errorf("%s: paged %v, want %v", name, paged, want)
+				}
+			}
+		}
+	}
+}
+
+func TestCursorMustMatchSort(t *testing.T) {
+	repo := newTestRepository(t)
+	saveTestReports(t, repo)
+
+	reports, err := repo.GetReports(ReportQuery{SortBy: "org_name", SortOrder: "asc", Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor := ReportCursor(&reports[0], "org_name", "asc")
+	for _, q := range []ReportQuery{
+		{SortBy: "org_name", SortOrder: "desc"},
+		{SortBy: "domain", SortOrder: "asc"},
+		{SortBy: "", SortOrder: ""}, // The default sort
+	} {
+		q.Limit, q.After = 10, &cursor
+		if _, err := repo.GetReports(q); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("report cursor for org_name asc with %s %s: error = %v, want ErrInvalidCursor", q.SortBy, q.SortOrder, err)
+		}
+	}
+
+	// Individual and aggregated rows have different tie-breaking columns
+	q := RecordQuery{SortBy: "count", SortOrder: "desc", Limit: 1}
+	rows, err := repo.GetRecords(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordCursor := RecordCursor(&rows[0], q)
+	for _, other := range []RecordQuery{
+		{SortBy: "count", SortOrder: "desc", Aggregate: true},
+		{SortBy: "count", SortOrder: "asc"},
+		{SortBy: "source_ip", SortOrder: "desc"},
+	} {
+		other.Limit, other.After = 10, &recordCursor
+		if _, err := repo.GetRecords(other); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("record cursor for count desc with %+v: error = %v, want ErrInvalidCursor", other, err)
+		}
+	}
+}
+
+func TestDecodeCursor(t *testing.T) {
+	cursor := Cursor{SortBy: "org_name", SortOrder: "asc", Values: []interface{}{"example", int64(42)}}
+	decoded, err := DecodeCursor(cursor.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%#v", *decoded) != fmt.Sprintf("%#v", cursor) {
+		t.Errorf("decoded cursor = %#v, want %#v", *decoded, cursor)
+	}
+
+	for _, invalid := range []string{
+		"not base64!",
+		Cursor{SortBy: "id", SortOrder: "asc"}.Encode(),                                  // No values
+		Cursor{SortBy: "id", SortOrder: "asc", Values: []interface{}{1.5}}.Encode(),      // Not an integer
+		Cursor{SortBy: "id", SortOrder: "asc", Values: []interface{}{true}}.Encode(),     // Not a string or number
+		Cursor{SortBy: "id", SortOrder: "asc", Values: []interface{}{[]int{1}}}.Encode(), // Not a scalar
+	} {
+		if _, err := DecodeCursor(invalid); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("DecodeCursor(%q) error = %v, want ErrInvalidCursor", invalid, err)
+		}
+	}
+}
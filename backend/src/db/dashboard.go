@@ -0,0 +1,227 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Dispositions reported in DMARC records.
+const (
+	DispositionNone       = "none"
+	DispositionQuarantine = "quarantine"
+	DispositionReject     = "reject"
+)
+
+// Limits on the number of rows in the dashboard's ranked summaries.
+const (
+	topSourceIPs   = 10
+	maxSummaryRows = 100
+)
+
+// DispositionCounts holds email counts per disposition.
+type DispositionCounts struct {
+	None       int64 `json:"none"`
+	Quarantine int64 `json:"quarantine"`
+	Reject     int64 `json:"reject"`
+}
+
+// add adds count emails to the bucket of disposition. Unknown values are ignored.
+func (c *DispositionCounts) add(disposition string, count int64) {
+	switch disposition {
+	case DispositionNone:
+		c.None += count
+	case DispositionQuarantine:
+		c.Quarantine += count
+	case DispositionReject:
+		c.Reject += count
+	}
+}
+
+// TimeseriesPoint holds the emails of one day (UTC) per disposition.
+type TimeseriesPoint struct {
+	Date string `json:"date"`
+	DispositionCounts
+}
+
+// SourceIPInfo is the IP information shown with a source IP summary.
+type SourceIPInfo struct {
+	ASName        string `json:"as_name"`
+	ReverseDomain string `json:"reverse_domain"`
+}
+
+// SourceIPSummary holds the email counts of one source IP.
+type SourceIPSummary struct {
+	IP    string       `json:"ip"`
+	Total int64        `json:"total"`
+	Pass  int64        `json:"pass"`
+	Fail  int64        `json:"fail"`
+	Info  SourceIPInfo `json:"info"`
+}
+
+// GroupSummary holds the email counts and authentication results of one
+// country, AS or reverse domain.
+type GroupSummary struct {
+	Key       string `json:"key"`
+	Name      string `json:"name,omitempty"` // Display name, if different from Key
+	Total     int64  `json:"total"`
+	DMARCPass int64  `json:"dmarc_pass"`
+	DMARCFail int64  `json:"dmarc_fail"`
+	SPFPass   int64  `json:"spf_pass"`
+	SPFFail   int64  `json:"spf_fail"`
+	DKIMPass  int64  `json:"dkim_pass"`
+	DKIMFail  int64  `json:"dkim_fail"`
+}
+
+// DashboardSummary holds the dashboard's aggregates over the filtered records.
+type DashboardSummary struct {
+	TotalReportsCount  int64             `json:"total_reports_count"` // Before filtering
+	TotalEmailsCount   int64             `json:"total_emails_count"`
+	TotalDomainsCount  int64             `json:"total_domains_count"` // Distinct header From domains
+	MinDate            int64             `json:"min_date"`
+	MaxDate            int64             `json:"max_date"`
+	DispositionSummary DispositionCounts `json:"disposition_summary"`
+	TimeseriesData     []TimeseriesPoint `json:"timeseries_data"`
+	SourceIPSummary    []SourceIPSummary `json:"source_ip_summary"`
+	CountrySummary     []GroupSummary    `json:"country_summary"`
+	ASSummary          []GroupSummary    `json:"as_summary"`
+	DomainSummary      []GroupSummary    `json:"domain_summary"`
+}
+
+// GetDashboardSummary computes the dashboard aggregates over the records
+// matching filter.
+func (r *Repository) GetDashboardSummary(filter RecordFilter) (*DashboardSummary, error) {
+	where, args := filter.where()
+	summary := &DashboardSummary{
+		TimeseriesData:  []TimeseriesPoint{},
+		SourceIPSummary: []SourceIPSummary{},
+	}
+
+	if err := r.db.QueryRow("SELECT COUNT(*) FROM reports").Scan(&summary.TotalReportsCount); err != nil {
+		return nil, fmt.Errorf("failed to count reports: %w", err)
+	}
+
+	err := r.db.QueryRow(`
+		SELECT COALESCE(SUM(rec.count), 0), COUNT(DISTINCT rec.header_from),
+			COALESCE(MIN(rep.date_range_begin), 0), COALESCE(MAX(rep.date_range_end), 0)
+		`+recordsFrom+`
+		WHERE `+where, args...,
+	).Scan(&summary.TotalEmailsCount, &summary.TotalDomainsCount, &summary.MinDate, &summary.MaxDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dashboard totals: %w", err)
+	}
+
+	if err := r.queryDispositionTimeseries(summary, where, args); err != nil {
+		return nil, err
+	}
+	if err := r.querySourceIPSummary(summary, where, args); err != nil {
+		return nil, err
+	}
+
+	groups := []struct {
+		key, name string
+		dest      *[]GroupSummary
+		what      string
+	}{
+		{"ih.country_code", "MAX(ih.country_name)", &summary.CountrySummary, "country"},
+		{"ih.asn_organization", "''", &summary.ASSummary, "AS"},
+		{"ih.apex_domain", "''", &summary.DomainSummary, "domain"},
+	}
+	for _, g := range groups {
+		rows, err := r.queryGroupSummary(g.key, g.name, where, args)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query %s summary: %w", g.what, err)
+		}
+		*g.dest = rows
+	}
+
+	return summary, nil
+}
+
+// queryDispositionTimeseries fills the disposition summary and the per-day
+// timeseries. Days are taken from the start of each report's period.
+func (r *Repository) queryDispositionTimeseries(summary *DashboardSummary, where string, args []interface{}) error {
+	rows, err := r.db.Query(`
+		SELECT strftime('%Y-%m-%d', rep.date_range_begin, 'unixepoch') AS day, rec.disposition, SUM(rec.count)
+		`+recordsFrom+`
+		WHERE `+where+`
+		GROUP BY day, rec.disposition
+		ORDER BY day
+	`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query disposition timeseries: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var day, disposition string
+		var count int64
+		if err := rows.Scan(&day, &disposition, &count); err != nil {
+			return fmt.Errorf("failed to scan disposition timeseries row: %w", err)
+		}
+		if n := len(summary.TimeseriesData); n == 0 || summary.TimeseriesData[n-1].Date != day {
+			summary.TimeseriesData = append(summary.TimeseriesData, TimeseriesPoint{Date: day})
+		}
+		summary.TimeseriesData[len(summary.TimeseriesData)-1].add(disposition, count)
+		summary.DispositionSummary.add(disposition, count)
+	}
+	return rows.Err()
+}
+
+// querySourceIPSummary fills the top source IPs by email count.
+func (r *Repository) querySourceIPSummary(summary *DashboardSummary, where string, args []interface{}) error {
+	rows, err := r.db.Query(`
+		SELECT rec.source_ip, SUM(rec.count) AS total,
+			SUM(CASE WHEN `+dmarcPassExpr+` THEN rec.count ELSE 0 END),
+			COALESCE(MAX(ih.asn_organization), ''), COALESCE(MAX(ih.apex_domain), '')
+		`+recordsFrom+`
+		WHERE `+where+`
+		GROUP BY rec.source_ip
+		ORDER BY total DESC, rec.source_ip
+		LIMIT `+strconv.Itoa(topSourceIPs), args...)
+	if err != nil {
+		return fmt.Errorf("failed to query source IP summary: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s SourceIPSummary
+		if err := rows.Scan(&s.IP, &s.Total, &s.Pass, &s.Info.ASName, &s.Info.ReverseDomain); err != nil {
+			return fmt.Errorf("failed to scan source IP summary row: %w", err)
+		}
+		s.Fail = s.Total - s.Pass
+		summary.SourceIPSummary = append(summary.SourceIPSummary, s)
+	}
+	return rows.Err()
+}
+
+// queryGroupSummary computes authentication results grouped by keyExpr, with
+// nameExpr as the display name, largest groups first.
+func (r *Repository) queryGroupSummary(keyExpr, nameExpr, where string, args []interface{}) ([]GroupSummary, error) {
+	rows, err := r.db.Query(`
+		SELECT COALESCE(`+keyExpr+`, '') AS group_key, COALESCE(`+nameExpr+`, ''), SUM(rec.count) AS total,
+			SUM(CASE WHEN `+dmarcPassExpr+` THEN rec.count ELSE 0 END),
+			SUM(CASE WHEN rec.spf_result = 'pass' THEN rec.count ELSE 0 END),
+			SUM(CASE WHEN rec.dkim_result = 'pass' THEN rec.count ELSE 0 END)
+		`+recordsFrom+`
+		WHERE `+where+`
+		GROUP BY group_key
+		ORDER BY total DESC, group_key
+		LIMIT `+strconv.Itoa(maxSummaryRows), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []GroupSummary{}
+	for rows.Next() {
+		var g GroupSummary
+		if err := rows.Scan(&g.Key, &g.Name, &g.Total, &g.DMARCPass, &g.SPFPass, &g.DKIMPass); err != nil {
+			return nil, err
+		}
+		g.DMARCFail = g.Total - g.DMARCPass
+		g.SPFFail = g.Total - g.SPFPass
+		g.DKIMFail = g.Total - g.DKIMPass
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Periods of the reports stored by saveRecordFixture: two consecutive UTC days.
+const (
+	fixtureDay1 = 1704067200 // 2024-01-01
+	fixtureDay2 = 1704153600 // 2024-01-02
+)
+
+// saveRecordFixture stores two reports, one per day, with five records from
+// three source IPs, two of which have IP information. The first and last
+// record share a RecordKey. It returns the record IDs in the order stored.
+func saveRecordFixture(t *testing.T, repo *Repository) []int64 {
+	t.Helper()
+	bundles := []ReportBundle{
+		{
+			Report: Report{
+				XMLHash: "hash-g", OrgName: "google.com", ReportID: "g-1", Domain: "example.com",
+				DateRangeBegin: fixtureDay1, DateRangeEnd: fixtureDay1 + 86399,
+				ADKIM: "r", ASPF: "r", P: "reject", SP: "quarantine", PCT: 100,
+			},
+			Records: []RecordBundle{
+				{
+					Record: Record{SourceIP: "192.0.2.1", Count: 10, HeaderFrom: "example.com", Disposition: "none", DKIMResult: "pass", SPFResult: "pass"},
+					AuthResults: []AuthResult{
+						{AuthType: AuthTypeDKIM, Domain: "example.com", Result: "pass", Selector: "s1"},
+						{AuthType: AuthTypeDKIM, Domain: "esp.example.net", Result: "fail", Selector: "s2"},
+						{AuthType: AuthTypeSPF, Domain: "example.com", Result: "pass"},
+					},
+				},
+				{Record: Record{SourceIP: "198.51.100.2", Count: 3, HeaderFrom: "example.com", Disposition: "reject", DKIMResult: "fail", SPFResult: "fail"}},
+			},
+		},
+		{
+			Report: Report{
+				XMLHash: "hash-y", OrgName: "Yahoo", ReportID: "y-1", Domain: "example.com",
+				DateRangeBegin: fixtureDay2, DateRangeEnd: fixtureDay2 + 86399,
+				ADKIM: "s", ASPF: "s", P: "none", SP: "none", PCT: 50,
+			},
+			Records: []RecordBundle{
+				{Record: Record{SourceIP: "192.0.2.1", Count: 5, HeaderFrom: "example.com", Disposition: "quarantine", DKIMResult: "fail", SPFResult: "pass"}},
+				{Record: Record{SourceIP: "203.0.113.3", Count: 2, HeaderFrom: "other.example", Disposition: "none", DKIMResult: "fail", SPFResult: "fail"}},
+				{Record: Record{SourceIP: "192.0.2.1", Count: 4, HeaderFrom: "example.com", Disposition: "none", DKIMResult: "pass", SPFResult: "pass"}},
+			},
+		},
+	}
+	var recordIDs []int64
+	for i := range bundles {
+		if err := repo.SaveReportBundle(&bundles[i]); err != nil {
+			t.Fatal(err)
+		}
+		for _, rb := range bundles[i].Records {
+			recordIDs = append(recordIDs, rb.Record.ID)
+		}
+	}
+
+	for _, info := range []IPInfo{
+		{
+			IPAddress: "192.0.2.1", CountryCode: "US", CountryName: "United States", ASNNumber: 15169,
+			ASNOrganization: "Google LLC", Hostname: "mail.google.com", ReversedHostname: "com.google.mail", ApexDomain: "google.com",
+		},
+		{
+			IPAddress: "198.51.100.2", CountryCode: "JP", CountryName: "Japan", ASNNumber: 64496,
+			ASNOrganization: "Example Net", Hostname: "relay.example.net", ReversedHostname: "net.example.relay", ApexDomain: "example.net",
+		},
+	} {
+		if err := repo.SaveOrUpdateIPInfo(&info); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return recordIDs
+}
+
+func TestDashboardSummary(t *testing.T) {
+	repo := newTestRepository(t)
+	saveRecordFixture(t, repo)
+
+	got, err := repo.GetDashboardSummary(RecordFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &DashboardSummary{
+		TotalReportsCount:  2,
+		TotalEmailsCount:   24,
+		TotalDomainsCount:  2,
+		MinDate:            fixtureDay1,
+		MaxDate:            fixtureDay2 + 86399,
+		DispositionSummary: DispositionCounts{None: 16, Quarantine: 5, Reject: 3},
+		TimeseriesData: []TimeseriesPoint{
+			{Date: "2024-01-01", DispositionCounts: DispositionCounts{None: 10, Reject: 3}},
+			{Date: "2024-01-02", DispositionCounts: DispositionCounts{None: 6, Quarantine: 5}},
+		},
+		SourceIPSummary: []SourceIPSummary{
+			{IP: "192.0.2.1", Total: 19, Pass: 19, Info: SourceIPInfo{ASName: "Google LLC", ReverseDomain: "google.com"}},
+			{IP: "198.51.100.2", Total: 3, Fail: 3, Info: SourceIPInfo{ASName: "Example Net", ReverseDomain: "example.net"}},
+			{IP: "203.0.113.3", Total: 2, Fail: 2},
+		},
+		CountrySummary: []GroupSummary{
+			{Key: "US", Name: "United States", Total: 19, DMARCPass: 19, SPFPass: 19, DKIMPass: 14, DKIMFail: 5},
+			{Key: "JP", Name: "Japan", Total: 3, DMARCFail: 3, SPFFail: 3, DKIMFail: 3},
+			{Key: "", Total: 2, DMARCFail: 2, SPFFail: 2, DKIMFail: 2},
+		},
+		ASSummary: []GroupSummary{
+			{Key: "Google LLC", Total: 19, DMARCPass: 19, SPFPass: 19, DKIMPass: 14, DKIMFail: 5},
+			{Key: "Example Net", Total: 3, DMARCFail: 3, SPFFail: 3, DKIMFail: 3},
+			{Key: "", Total: 2, DMARCFail: 2, SPFFail: 2, DKIMFail: 2},
+		},
+		DomainSummary: []GroupSummary{
+			{Key: "google.com", Total: 19, DMARCPass: 19, SPFPass: 19, DKIMPass: 14, DKIMFail: 5},
+			{Key: "example.net", Total: 3, DMARCFail: 3, SPFFail: 3, DKIMFail: 3},
+			{Key: "", Total: 2, DMARCFail: 2, SPFFail: 2, DKIMFail: 2},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetDashboardSummary() =\n%+v\nwant\n%+v", *got, *want)
+	}
+}
+
+func TestDashboardSummaryEmpty(t *testing.T) {
+	repo := newTestRepository(t)
+
+	got, err := repo.GetDashboardSummary(RecordFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.TotalEmailsCount != 0 || got.TimeseriesData == nil || got.SourceIPSummary == nil ||
+		got.CountrySummary == nil || got.ASSummary == nil || got.DomainSummary == nil {
+		t.Errorf("GetDashboardSummary() on an empty database = %+v, want zero counts and empty lists", *got)
+	}
+}
+
+func TestRecordFilter(t *testing.T) {
+	repo := newTestRepository(t)
+	saveRecordFixture(t, repo)
+
+	tests := []struct {
+		name   string
+		filter RecordFilter
+		emails int64
+	}{
+		{"none", RecordFilter{}, 24},
+		{"begin", RecordFilter{Begin: fixtureDay2}, 11},
+		{"end", RecordFilter{End: fixtureDay1}, 13},
+		{"disposition ignores case", RecordFilter{Disposition: "REJECT"}, 3},
+		{"any disposition", RecordFilter{Disposition: FilterAny}, 24},
+		{"DMARC pass", RecordFilter{DMARCResult: ResultPass}, 19},
+		{"DMARC fail", RecordFilter{DMARCResult: ResultFail}, 5},
+		{"SPF fail", RecordFilter{SPFResult: ResultFail}, 5},
+		{"DKIM pass", RecordFilter{DKIMResult: ResultPass}, 14},
+		{"AS name", RecordFilter{ASName: "google llc"}, 19},
+		{"country", RecordFilter{CountryCode: "jp"}, 3},
+		{"reverse domain", RecordFilter{Domain: "google.com"}, 19},
+		{"header From domain", RecordFilter{Domain: "other.example"}, 2},
+		{"keyword in hostname", RecordFilter{Keyword: "relay"}, 3},
+		{"keyword in reporter", RecordFilter{Keyword: "yahoo"}, 11},
+		{"keyword wildcards are literal", RecordFilter{Keyword: "%"}, 0},
+		{"combined", RecordFilter{Begin: fixtureDay2, DMARCResult: ResultPass, CountryCode: "US"}, 9},
+	}
+	for _, tt := range tests {
+		summary, err := repo.GetDashboardSummary(tt.filter)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if summary.TotalEmailsCount != tt.emails {
+			t.Errorf("%s: emails = %d, want %d", tt.name, summary.TotalEmailsCount, tt.emails)
+		}
+		if summary.TotalReportsCount != 2 {
+			t.Errorf("%s: total reports = %d, want 2 regardless of the filter", tt.name, summary.TotalReportsCount)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// ReportFilter selects reports for bulk operations. Empty fields are ignored;
+// Begin and End bound date_range_begin and are Unix timestamps (0 = unbounded).
+type ReportFilter struct {
+	Begin   int64
+	End     int64
+	OrgName string
+	Domain  string
+}
+
+// IsEmpty reports whether the filter has no criteria and so matches every report.
+func (f ReportFilter) IsEmpty() bool {
+	return f.Begin == 0 && f.End == 0 && f.OrgName == "" && f.Domain == ""
+}
+
+// where builds the WHERE clause (without the keyword) and arguments for f.
+func (f ReportFilter) where() (string, []interface{}) {
+	conditions := []string{"1 = 1"}
+	var args []interface{}
+
+	if f.Begin != 0 {
+		conditions = append(conditions, "date_range_begin >= ?")
+		args = append(args, f.Begin)
+	}
+	if f.End != 0 {
+		conditions = append(conditions, "date_range_begin <= ?")
+		args = append(args, f.End)
+	}
+	if f.OrgName != "" {
+		conditions = append(conditions, "org_name = ? COLLATE NOCASE")
+		args = append(args, f.OrgName)
+	}
+	if f.Domain != "" {
+		conditions = append(conditions, "domain = ? COLLATE NOCASE")
+		args = append(args, f.Domain)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// DeletionResult counts the rows removed (or, for a dry run, that would be).
+type DeletionResult struct {
+	Reports     int64 `json:"reports"`
+	Records     int64 `json:"records"`
+	AuthResults int64 `json:"auth_results"`
+}
+
+// DeleteReport deletes a report and everything that belongs to it in one
+// transaction. It returns nil if the report does not exist.
+func (r *Repository) DeleteReport(id int64) (*DeletionResult, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction for deleting report: %w", err)
+	}
+	defer tx.Rollback() // No-op after commit
+
+	result, err := deleteReportsTx(tx, "id = ?", []interface{}{id}, r.searchEnabled())
+	if err != nil {
+		return nil, err
+	}
+	if result.Reports == 0 {
+		return nil, nil // Report not found
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit report deletion: %w", err)
+	}
+	return result, nil
+}
+
+// DeleteReports deletes every report matching filter, with its records and
+// auth results, in one transaction. With dryRun the rows are only counted.
+func (r *Repository) DeleteReports(filter ReportFilter, dryRun bool) (*DeletionResult, error) {
+	where, args := filter.where()
+
+	if dryRun {
+		return countReportRows(r.db, where, args)
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction for deleting reports: %w", err)
+	}
+	defer tx.Rollback() // No-op after commit
+
+	result, err := deleteReportsTx(tx, where, args, r.searchEnabled())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit report deletion: %w", err)
+	}
+	return result, nil
+}
+
+// countReportRows counts the reports matching where and the rows that depend on them.
+func countReportRows(conn *sql.DB, where string, args []interface{}) (*DeletionResult, error) {
+	reportSubquery := "SELECT id FROM reports WHERE " + where
+	recordSubquery := "SELECT id FROM records WHERE report_id IN (" + reportSubquery + ")"
+
+	result := &DeletionResult{}
+	if err := conn.QueryRow("SELECT COUNT(*) FROM reports WHERE "+where, args...).Scan(&result.Reports); err != nil {
+		return nil, fmt.Errorf("failed to count reports: %w", err)
+	}
+	if err := conn.QueryRow("SELECT COUNT(*) FROM records WHERE report_id IN ("+reportSubquery+")", args...).Scan(&result.Records); err != nil {
+		return nil, fmt.Errorf("failed to count records: %w", err)
+	}
+	if err := conn.QueryRow("SELECT COUNT(*) FROM auth_results WHERE record_id IN ("+recordSubquery+")", args...).Scan(&result.AuthResults); err != nil {
+		return nil, fmt.Errorf("failed to count auth results: %w", err)
+	}
+	return result, nil
+}
+
+// deleteReportsTx removes the reports matching where, children first, so the
+// result is correct whether or not foreign key enforcement is active. With
+// indexed set, the search index entries are removed as well.
+func deleteReportsTx(tx *sql.Tx, where string, args []interface{}, indexed bool) (*DeletionResult, error) {
+	if indexed {
+		if err := unindexReportsTx(tx, where, args); err != nil {
+			return nil, err
+		}
+	}
+
+	reportSubquery := "SELECT id FROM reports WHERE " + where
+	recordSubquery := "SELECT id FROM records WHERE report_id IN (" + reportSubquery + ")"
+
+	result := &DeletionResult{}
+	steps := []struct {
+		query string
+		count *int64
+		what  string
+	}{
+		{"DELETE FROM auth_results WHERE record_id IN (" + recordSubquery + ")", &result.AuthResults, "auth results"},
+		{"DELETE FROM records WHERE report_id IN (" + reportSubquery + ")", &result.Records, "records"},
+		{"DELETE FROM reports WHERE " + where, &result.Reports, "reports"},
+	}
+
+	for _, step := range steps {
+		res, err := tx.Exec(step.query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete %s: %w", step.what, err)
+		}
+		if *step.count, err = res.RowsAffected(); err != nil {
+			return nil, fmt.Errorf("failed to count deleted %s: %w", step.what, err)
+		}
+	}
+	return result, nil
+}
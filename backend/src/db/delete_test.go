@@ -0,0 +1,186 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+)
+
+// saveDeleteFixture stores reports of two organizations for two domains on
+// three days, each with two records of which the first has an auth result.
+func saveDeleteFixture(t *testing.T, repo *Repository) {
+	t.Helper()
+	i := 0
+	for _, org := range []string{"google.com", "Yahoo"} {
+		for _, domain := range []string{"example.com", "example.org"} {
+			for _, begin := range []int64{1000, 2000, 3000} {
+				bundle := ReportBundle{
+					Report: Report{
+						XMLHash:        fmt.Sprintf("hash%d", i),
+						OrgName:        org,
+						ReportID:       fmt.Sprintf("r%d", i),
+						DateRangeBegin: begin,
+						DateRangeEnd:   begin + 999,
+						Domain:         domain,
+					},
+					Records: []RecordBundle{
+						{
+							Record:      Record{SourceIP: "192.0.2.1", Count: 1, HeaderFrom: domain},
+							AuthResults: []AuthResult{{AuthType: AuthTypeSPF, Domain: domain, Result: "pass"}},
+						},
+						{Record: Record{SourceIP: "192.0.2.2", Count: 1, HeaderFrom: domain}},
+					},
+				}
+				if err := repo.SaveReportBundle(&bundle); err != nil {
+					t.Fatal(err)
+				}
+				i++
+			}
+		}
+	}
+}
+
+// remainingReportIDs returns the report_id of every stored report in order.
+func remainingReportIDs(t *testing.T, repo *Repository) []string {
+	t.Helper()
+	rows, err := repo.db.Query("SELECT report_id FROM reports ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// countAllRows counts every stored report, record and auth result.
+func countAllRows(t *testing.T, repo *Repository) DeletionResult {
+	t.Helper()
+	result, err := countReportRows(repo.db, "1 = 1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *result
+}
+
+func TestDeleteReports(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter ReportFilter
+		want   []string // report_id of the remaining reports
+	}{
+		{"everything", ReportFilter{}, nil},
+		{"begin", ReportFilter{Begin: 2000}, []string{"r0", "r3", "r6", "r9"}},
+		{"end", ReportFilter{End: 1999}, []string{"r1", "r2", "r4", "r5", "r7", "r8", "r10", "r11"}},
+		{"range", ReportFilter{Begin: 1500, End: 2500}, []string{"r0", "r2", "r3", "r5", "r6", "r8", "r9", "r11"}},
+		{"organization ignores case", ReportFilter{OrgName: "YAHOO"}, []string{"r0", "r1", "r2", "r3", "r4", "r5"}},
+		{"domain", ReportFilter{Domain: "example.org"}, []string{"r0", "r1", "r2", "r6", "r7", "r8"}},
+		{"all criteria", ReportFilter{Begin: 3000, OrgName: "google.com", Domain: "example.com"},
+			[]string{"r0", "r1", "r3", "r4", "r5", "r6", "r7", "r8", "r9", "r10", "r11"}},
+		{"no match", ReportFilter{OrgName: "other"},
+			[]string{"r0", "r1", "r2", "r3", "r4", "r5", "r6", "r7", "r8", "r9", "r10", "r11"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newTestRepository(t)
+			saveDeleteFixture(t, repo)
+			before := countAllRows(t, repo)
+
+			removed := int64(len(remainingReportIDs(t, repo)) - len(tt.want))
+			want := DeletionResult{Reports: removed, Records: 2 * removed, AuthResults: removed}
+
+			dryRun, err := repo.DeleteReports(tt.filter, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *dryRun != want {
+				t.Errorf("dry run = %+v, want %+v", *dryRun, want)
+			}
+			if after := countAllRows(t, repo); after != before {
+				t.Errorf("rows after dry run = %+v, want %+v", after, before)
+			}
+
+			result, err := repo.DeleteReports(tt.filter, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *result != want {
+				t.Errorf("deletion = %+v, want %+v", *result, want)
+			}
+			if got := remainingReportIDs(t, repo); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("remaining reports = %v, want %v", got, tt.want)
+			}
+			wantLeft := DeletionResult{
+				Reports:     before.Reports - want.Reports,
+				Records:     before.Records - want.Records,
+				AuthResults: before.AuthResults - want.AuthResults,
+			}
+			if after := countAllRows(t, repo); after != wantLeft {
+				t.Errorf("rows after deletion = %+v, want %+v", after, wantLeft)
+			}
+		})
+	}
+}
+
+func TestDeleteReport(t *testing.T) {
+	repo := newTestRepository(t)
+	saveDeleteFixture(t, repo)
+
+	result, err := repo.DeleteReport(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (DeletionResult{Reports: 1, Records: 2, AuthResults: 1}); result == nil || *result != want {
+		t.Errorf("DeleteReport(2) = %+v, want %+v", result, want)
+	}
+	if got := remainingReportIDs(t, repo); len(got) != 11 || got[1] != "r2" {
+		t.Errorf("remaining reports = %v, want all but r1", got)
+	}
+
+	if result, err := repo.DeleteReport(2); err != nil || result != nil {
+		t.Errorf("DeleteReport of a missing report = %+v, %v, want nil, nil", result, err)
+	}
+}
+
+func TestDeleteReportsUpdatesSearch(t *testing.T) {
+	repo := newTestRepository(t)
+	recordIDs := saveSearchFixture(t, repo)
+
+	if _, err := repo.DeleteReports(ReportFilter{OrgName: "Mailer Service"}, false); err != nil {
+		t.Fatal(err)
+	}
+	if repo.searchEnabled() {
+		for _, table := range []string{"search_records", "search_reports"} {
+			var n int
+			if err := repo.db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+				t.Fatal(err)
+			}
+			if want := map[string]int{"search_records": 2, "search_reports": 1}[table]; n != want {
+				t.Errorf("%s has %d rows, want %d", table, n, want)
+			}
+		}
+	}
+
+	groups, err := repo.Search("mailer", "", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		SearchEntityRecord: {fmt.Sprint(recordIDs[0])},
+		SearchEntityReport: {},
+	}
+	for entity, ids := range want {
+		var got []string
+		for _, hit := range groups[entity].Hits {
+			got = append(got, hit.ID)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(ids) {
+			t.Errorf("%s hits for %q after deletion = %v, want %v", entity, "mailer", got, ids)
+		}
+	}
+}
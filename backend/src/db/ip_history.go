@@ -0,0 +1,224 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// ipSnapshotColumns are the ip_info_history columns read into an IPInfoSnapshot.
+const ipSnapshotColumns = `id, ip_address, country_code, country_name, city_name, asn_number, asn_organization,
+	hostname, reversed_hostname, apex_domain, valid_from, valid_to`
+
+// RecordIPSnapshotJoin joins records (alias rec) of reports (alias rep) with
+// the IP information snapshot (alias ih) that was current at the end of the
+// report's date range, i.e. when the reported mail was sent.
+const RecordIPSnapshotJoin = `
+	LEFT JOIN ip_info_history ih ON ih.ip_address = rec.source_ip
+		AND ih.valid_from <= rep.date_range_end
+		AND (ih.valid_to IS NULL OR ih.valid_to > rep.date_range_end)`
+
+// unknownIPValue is stored for fields that a lookup could not resolve.
+const unknownIPValue = "N/A"
+
+// knownIPValue reports whether a looked up field holds a value.
+func knownIPValue(value string) bool {
+	return value != "" && value != unknownIPValue
+}
+
+// hasKnownFields reports whether a lookup resolved anything about the address.
+func (info *IPInfo) hasKnownFields() bool {
+	return knownIPValue(info.CountryCode) || knownIPValue(info.CountryName) || knownIPValue(info.CityName) ||
+		info.ASNNumber != 0 || knownIPValue(info.ASNOrganization) || knownIPValue(info.Hostname)
+}
+
+// fillUnknown replaces the fields that a lookup left unknown ("" or "N/A") with
+// those of prev, so that a failed lookup does not overwrite what is known. The
+// hostname, reversed hostname and apex domain come from one PTR lookup and are
+// replaced together.
+func (info *IPInfo) fillUnknown(prev *IPInfo) {
+	fill := func(value *string, prevValue string) {
+		if !knownIPValue(*value) {
+			*value = prevValue
+		}
+	}
+	fill(&info.CountryCode, prev.CountryCode)
+	fill(&info.CountryName, prev.CountryName)
+	fill(&info.CityName, prev.CityName)
+	fill(&info.ASNOrganization, prev.ASNOrganization)
+	if info.ASNNumber == 0 {
+		info.ASNNumber = prev.ASNNumber
+	}
+	if !knownIPValue(info.Hostname) {
+		info.Hostname, info.ReversedHostname, info.ApexDomain = prev.Hostname, prev.ReversedHostname, prev.ApexDomain
+	}
+}
+
+// ipInfo returns the enrichment data of the snapshot.
+func (s *IPInfoSnapshot) ipInfo() *IPInfo {
+	return &IPInfo{
+		IPAddress:        s.IPAddress,
+		CountryCode:      s.CountryCode,
+		CountryName:      s.CountryName,
+		CityName:         s.CityName,
+		ASNNumber:        s.ASNNumber,
+		ASNOrganization:  s.ASNOrganization,
+		Hostname:         s.Hostname,
+		ReversedHostname: s.ReversedHostname,
+		ApexDomain:       s.ApexDomain,
+	}
+}
+
+// sameEnrichment reports whether a snapshot holds the same data as info.
+func (s *IPInfoSnapshot) sameEnrichment(info *IPInfo) bool {
+	return s.CountryCode == info.CountryCode &&
+		s.CountryName == info.CountryName &&
+		s.CityName == info.CityName &&
+		s.ASNNumber == info.ASNNumber &&
+		s.ASNOrganization == info.ASNOrganization &&
+		s.Hostname == info.Hostname &&
+		s.ReversedHostname == info.ReversedHostname &&
+		s.ApexDomain == info.ApexDomain
+}
+
+// scanIPSnapshot scans a row selected with ipSnapshotColumns.
+func scanIPSnapshot(row interface{ Scan(...interface{}) error }) (*IPInfoSnapshot, error) {
+	var s IPInfoSnapshot
+	var validTo sql.NullInt64
+	err := row.Scan(
+		&s.ID, &s.IPAddress, &s.CountryCode, &s.CountryName, &s.CityName, &s.ASNNumber, &s.ASNOrganization,
+		&s.Hostname, &s.ReversedHostname, &s.ApexDomain, &s.ValidFrom, &validTo,
+	)
+	if err != nil {
+		return nil, err
+	}
+	s.ValidTo = validTo.Int64
+	return &s, nil
+}
+
+// recordIPSnapshotTx records info as observed at observedAt. If it differs from
+// the current snapshot, that snapshot is closed and a new one opened; unchanged
+// data leaves the history alone. Fields the lookup left unknown are carried
+// forward from the current snapshot, and a lookup that resolved nothing never
+// opens a snapshot. An address without history gets a snapshot valid from 0,
+// because nothing older is known about it.
+func recordIPSnapshotTx(tx *sql.Tx, info *IPInfo, observedAt int64) error {
+	current, err := scanIPSnapshot(tx.QueryRow(
+		"SELECT "+ipSnapshotColumns+" FROM ip_info_history WHERE ip_address = ? AND valid_to IS NULL",
+		info.IPAddress,
+	))
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to query current IP info snapshot for %s: %w", info.IPAddress, err)
+	}
+	if !info.hasKnownFields() {
+		return nil
+	}
+	if current != nil {
+		merged := *info
+		merged.fillUnknown(current.ipInfo())
+		info = &merged
+	}
+
+	validFrom := observedAt
+	switch {
+	case current == nil:
+		// Start where the last closed snapshot ended, or at 0 for a new address.
+		if err := tx.QueryRow(
+			"SELECT COALESCE(MAX(valid_to), 0) FROM ip_info_history WHERE ip_address = ?", info.IPAddress,
+		).Scan(&validFrom); err != nil {
+			return fmt.Errorf("failed to query IP info history for %s: %w", info.IPAddress, err)
+		}
+	case current.sameEnrichment(info):
+		return nil
+	case observedAt <= current.ValidFrom:
+		// Nothing can be closed at or before the snapshot's own start, so the
+		// observation corrects the current snapshot instead.
+		_, err := tx.Exec(`
+			UPDATE ip_info_history
+			SET country_code = ?, country_name = ?, city_name = ?, asn_number = ?, asn_organization = ?,
+				hostname = ?, reversed_hostname = ?, apex_domain = ?
+			WHERE id = ?
+		`,
+			info.CountryCode, info.CountryName, info.CityName, info.ASNNumber, info.ASNOrganization,
+			info.Hostname, info.ReversedHostname, info.ApexDomain, current.ID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to update IP info snapshot for %s: %w", info.IPAddress, err)
+		}
+		return nil
+	default:
+		if _, err := tx.Exec("UPDATE ip_info_history SET valid_to = ? WHERE id = ?", observedAt, current.ID); err != nil {
+			return fmt.Errorf("failed to close IP info snapshot for %s: %w", info.IPAddress, err)
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO ip_info_history (ip_address, country_code, country_name, city_name, asn_number, asn_organization, hostname, reversed_hostname, apex_domain, valid_from, valid_to)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NULL)
+	`,
+		info.IPAddress, info.CountryCode, info.CountryName, info.CityName, info.ASNNumber, info.ASNOrganization,
+		info.Hostname, info.ReversedHostname, info.ApexDomain, validFrom,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save IP info snapshot for %s: %w", info.IPAddress, err)
+	}
+	return nil
+}
+
+// GetIPInfoHistory retrieves every snapshot of an address, oldest first.
+func (r *Repository) GetIPInfoHistory(ipAddress string) ([]IPInfoSnapshot, error) {
+	rows, err := r.db.Query(
+		"SELECT "+ipSnapshotColumns+" FROM ip_info_history WHERE ip_address = ? ORDER BY valid_from, id",
+		ipAddress,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query IP info history for %s: %w", ipAddress, err)
+	}
+	defer rows.Close()
+
+	var history []IPInfoSnapshot
+	for rows.Next() {
+		s, err := scanIPSnapshot(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan IP info snapshot row: %w", err)
+		}
+		history = append(history, *s)
+	}
+	return history, rows.Err()
+}
+
+// GetIPInfoAt retrieves the snapshot of an address that was current at the
+// given Unix time. It returns nil if nothing was known about the address then.
+func (r *Repository) GetIPInfoAt(ipAddress string, at int64) (*IPInfoSnapshot, error) {
+	s, err := scanIPSnapshot(r.db.QueryRow(`
+		SELECT `+ipSnapshotColumns+`
+		FROM ip_info_history
+		WHERE ip_address = ? AND valid_from <= ? AND (valid_to IS NULL OR valid_to > ?)
+	`, ipAddress, at, at))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No snapshot covers that time
+		}
+		return nil, fmt.Errorf("failed to query IP info snapshot for %s: %w", ipAddress, err)
+	}
+	return s, nil
+}
+
+// GetRecordIPInfo retrieves the IP information snapshot of a record's source
+// IP as it was when the record's report period ended. It returns nil if the
+// record does not exist or nothing was known about the address then.
+func (r *Repository) GetRecordIPInfo(recordID int64) (*IPInfoSnapshot, error) {
+	s, err := scanIPSnapshot(r.db.QueryRow(`
+		SELECT ih.id, ih.ip_address, ih.country_code, ih.country_name, ih.city_name, ih.asn_number, ih.asn_organization,
+			ih.hostname, ih.reversed_hostname, ih.apex_domain, ih.valid_from, ih.valid_to
+		FROM records rec
+		JOIN reports rep ON rep.id = rec.report_id`+RecordIPSnapshotJoin+`
+		WHERE rec.id = ? AND ih.id IS NOT NULL
+	`, recordID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Record or snapshot not found
+		}
+		return nil, fmt.Errorf("failed to query IP info snapshot for record %d: %w", recordID, err)
+	}
+	return s, nil
+}
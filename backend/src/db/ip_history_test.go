@@ -0,0 +1,100 @@
+package db
+
+import "testing"
+
+// failedLookup is the IP information stored when resolving an address fails.
+func failedLookup(ip string) *IPInfo {
+	return &IPInfo{
+		IPAddress: ip,
+		Hostname:  "N/A", ReversedHostname: "N/A", ApexDomain: "N/A",
+		CountryCode: "N/A", CountryName: "N/A", CityName: "N/A",
+		ASNOrganization: "N/A",
+	}
+}
+
+// goodLookup is a successful lookup of 192.0.2.1.
+func goodLookup() *IPInfo {
+	return &IPInfo{
+		IPAddress:        "192.0.2.1",
+		CountryCode:      "US",
+		CountryName:      "United States",
+		CityName:         "N/A",
+		ASNNumber:        64496,
+		ASNOrganization:  "Example Networks",
+		Hostname:         "mail.example.com",
+		ReversedHostname: "com.example.mail",
+		ApexDomain:       "example.com",
+	}
+}
+
+// checkIPHistory fails unless the history of ip consists of snapshots with
+// the enrichment data of want, the last one being current.
+func checkIPHistory(t *testing.T, repo *Repository, ip string, want ...*IPInfo) {
+	t.Helper()
+	history, err := repo.GetIPInfoHistory(ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != len(want) {
+		t.Fatalf("history of %s has %d snapshots, want %d: %+v", ip, len(history), len(want), history)
+	}
+	for i, snapshot := range history {
+		if !snapshot.sameEnrichment(want[i]) {
+			t.Errorf("snapshot %d of %s = %+v, want %+v", i, ip, snapshot, *want[i])
+		}
+		if current := i == len(history)-1; (snapshot.ValidTo == 0) != current {
+			t.Errorf("snapshot %d of %s valid to %d, want open only for the last", i, ip, snapshot.ValidTo)
+		}
+	}
+}
+
+func TestFailedLookupKeepsIPInfo(t *testing.T) {
+	repo := newTestRepository(t)
+	good := goodLookup()
+	if err := repo.SaveOrUpdateIPInfo(good); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.SaveOrUpdateIPInfo(failedLookup(good.IPAddress)); err != nil {
+		t.Fatal(err)
+	}
+	checkIPHistory(t, repo, good.IPAddress, good)
+	stored, err := repo.GetIPInfo(good.IPAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored.LastUpdated = 0
+	if *stored != *good {
+		t.Errorf("stored IP info = %+v, want %+v", *stored, *good)
+	}
+
+	// A lookup without PTR data keeps the hostname, while the rest changes
+	moved := goodLookup()
+	moved.CountryCode, moved.CountryName = "NL", "Netherlands"
+	moved.Hostname, moved.ReversedHostname, moved.ApexDomain = "N/A", "N/A", "N/A"
+	if err := repo.SaveOrUpdateIPInfo(moved); err != nil {
+		t.Fatal(err)
+	}
+	want := goodLookup()
+	want.CountryCode, want.CountryName = "NL", "Netherlands"
+	checkIPHistory(t, repo, good.IPAddress, good, want)
+}
+
+func TestFailedLookupOpensNoSnapshot(t *testing.T) {
+	repo := newTestRepository(t)
+	if err := repo.SaveOrUpdateIPInfo(failedLookup("192.0.2.2")); err != nil {
+		t.Fatal(err)
+	}
+	checkIPHistory(t, repo, "192.0.2.2")
+
+	// The first successful lookup is then valid from the start
+	good := goodLookup()
+	good.IPAddress = "192.0.2.2"
+	if err := repo.SaveOrUpdateIPInfo(good); err != nil {
+		t.Fatal(err)
+	}
+	checkIPHistory(t, repo, "192.0.2.2", good)
+	if snapshot, err := repo.GetIPInfoAt("192.0.2.2", 1); err != nil || snapshot == nil {
+		t.Errorf("GetIPInfoAt(1) = %+v, %v, want the first snapshot", snapshot, err)
+	}
+}
@@ -29,6 +29,34 @@ type Record struct {
 	SPFResult   string `db:"spf_result"`
 }
 
+// Authentication result types stored in AuthResult.AuthType.
+const (
+	AuthTypeDKIM = "dkim"
+	AuthTypeSPF  = "spf"
+)
+
+// AuthResult represents a raw DKIM or SPF result from a record's auth_results.
+type AuthResult struct {
+	ID       int64  `db:"id"`
+	RecordID int64  `db:"record_id"`
+	AuthType string `db:"auth_type"`
+	Domain   string `db:"domain"`
+	Result   string `db:"result"`
+	Selector string `db:"selector"` // DKIM only
+}
+
+// RecordBundle is a record together with its raw authentication results.
+type RecordBundle struct {
+	Record      Record
+	AuthResults []AuthResult
+}
+
+// ReportBundle is a report together with all of its records.
+type ReportBundle struct {
+	Report  Report
+	Records []RecordBundle
+}
+
 // IPInfo represents geographical and ASN information for an IP address.
 type IPInfo struct {
 	IPAddress       string `db:"ip_address"`
@@ -43,6 +71,25 @@ type IPInfo struct {
 	LastUpdated     int64  `db:"last_updated"`
 }
 
+// IPInfoSnapshot is the IP information that was current for an address
+// between ValidFrom and ValidTo (Unix timestamps). ValidTo is 0 for the current
+// snapshot, and the first snapshot of an address starts at 0 so that it also
+// covers reports older than the first lookup.
+type IPInfoSnapshot struct {
+	ID               int64  `db:"id" json:"id"`
+	IPAddress        string `db:"ip_address" json:"ip_address"`
+	CountryCode      string `db:"country_code" json:"country_code"`
+	CountryName      string `db:"country_name" json:"country_name"`
+	CityName         string `db:"city_name" json:"city_name"`
+	ASNNumber        int    `db:"asn_number" json:"asn_number"`
+	ASNOrganization  string `db:"asn_organization" json:"asn_organization"`
+	Hostname         string `db:"hostname" json:"hostname"`
+	ReversedHostname string `db:"reversed_hostname" json:"reversed_hostname"`
+	ApexDomain       string `db:"apex_domain" json:"apex_domain"`
+	ValidFrom        int64  `db:"valid_from" json:"valid_from"`
+	ValidTo          int64  `db:"valid_to" json:"valid_to"`
+}
+
 // IngestionError represents an error that occurred during DMARC report ingestion.
 type IngestionError struct {
 	ID        int64  `db:"id"`
@@ -53,12 +100,139 @@ type IngestionError struct {
 	Timestamp int64  `db:"timestamp"`
 }
 
+// User roles, from least to most privileged. Each role may do everything
+// the roles before it may.
+const (
+	RoleViewer  = "viewer"  // Reads reports and dashboards
+	RoleAnalyst = "analyst" // Also uploads and imports reports
+	RoleAdmin   = "admin"   // Also manages users, settings, deletion and IP databases
+)
+
+// Roles lists the user roles from least to most privileged.
+var Roles = []string{RoleViewer, RoleAnalyst, RoleAdmin}
+
+// IsValidRole reports whether role is one of Roles.
+func IsValidRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Authentication sources of users. Local users log in with the password
+// stored here; the others are provisioned on their first login through an
+// identity provider, which also manages their password and role.
+const (
+	AuthSourceLocal = "local"
+	AuthSourceOIDC  = "oidc"
+	AuthSourceLDAP  = "ldap"
+)
+
+// API token scopes. A token may only be given scopes within its user's role:
+// read needs the viewer role, upload the analyst role and admin the admin role.
+const (
+	ScopeRead   = "read"   // Reads reports and dashboards
+	ScopeUpload = "upload" // Also uploads reports
+	ScopeAdmin  = "admin"  // Also performs admin actions
+)
+
+// Scopes lists the API token scopes from least to most privileged.
+var Scopes = []string{ScopeRead, ScopeUpload, ScopeAdmin}
+
+// IsValidScope reports whether scope is one of Scopes.
+func IsValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIToken is a long-lived token that authenticates automation as a user.
+// Only a hash of the token is stored.
+type APIToken struct {
+	ID         int64    `db:"id"`
+	UserID     int64    `db:"user_id"`
+	Name       string   `db:"name"`
+	TokenHash  string   `db:"token_hash"`
+	Prefix     string   `db:"prefix"` // Start of the token, to tell tokens apart
+	Scopes     []string `db:"scopes"` // Stored comma-separated
+	CreatedAt  int64    `db:"created_at"`
+	ExpiresAt  int64    `db:"expires_at"`
+	LastUsedAt int64    `db:"last_used_at"` // 0 if never used
+}
+
+// Session is a login on one device. Its refresh token, of which only a hash
+// is stored, is replaced on every use; access tokens name the session and
+// stop working when it is deleted.
+type Session struct {
+	ID          int64  `db:"id"`
+	UserID      int64  `db:"user_id"`
+	RefreshHash string `db:"refresh_hash"`
+	UserAgent   string `db:"user_agent"`
+	SourceIP    string `db:"source_ip"`
+	CreatedAt   int64  `db:"created_at"`
+	LastUsedAt  int64  `db:"last_used_at"`
+	ExpiresAt   int64  `db:"expires_at"`
+}
+
 // User represents a user of the application.
 type User struct {
 	ID           int64  `db:"id"`
 	Username     string `db:"username"`
 	PasswordHash string `db:"password_hash"`
+	Role         string `db:"role"`
 	CreatedAt    int64  `db:"created_at"`
+
+	Disabled           bool `db:"disabled"`             // Disabled users cannot log in or use their tokens
+	MustChangePassword bool `db:"must_change_password"` // Set when an admin resets the password
+
+	// TOTP state, changed only through the TOTP methods of Repository. The
+	// secret is set during enrolment, before TOTPEnabled.
+	TOTPSecret   string `db:"totp_secret"`
+	TOTPEnabled  bool   `db:"totp_enabled"`
+	TOTPLastStep int64  `db:"totp_last_step"` // Last accepted time step, against replays
+
+	AuthSource string `db:"auth_source"` // One of the AuthSource constants
+	ExternalID string `db:"external_id"` // Identity at the provider, empty for local users
+}
+
+// IsLocal reports whether user logs in with a password stored here.
+func (u *User) IsLocal() bool {
+	return u.AuthSource == AuthSourceLocal
+}
+
+// UsesSingleSignOn reports whether user logs in at an identity provider
+// rather than with a password.
+func (u *User) UsesSingleSignOn() bool {
+	return u.AuthSource == AuthSourceOIDC
+}
+
+// RecoveryCode is a one-time code that replaces a TOTP code when the
+// authenticator is lost. Only a hash of the code is stored.
+type RecoveryCode struct {
+	ID       int64  `db:"id"`
+	UserID   int64  `db:"user_id"`
+	CodeHash string `db:"code_hash"`
+	UsedAt   int64  `db:"used_at"` // 0 if unused
+}
+
+// AuditEntry represents a recorded security-relevant or data-changing action.
+type AuditEntry struct {
+	ID        int64  `db:"id"`
+	Timestamp int64  `db:"timestamp"`
+	Actor     string `db:"actor"`
+	Action    string `db:"action"`
+	Target    string `db:"target"`
+	Details   string `db:"details"`   // JSON, or empty
+	SourceIP  string `db:"source_ip"`
+	RequestID string `db:"request_id"` // Empty for entries not made through the API
+	Method    string `db:"method"`
+	Path      string `db:"path"`
+	UserAgent string `db:"user_agent"`
 }
 
 // Setting represents a key-value pair for application settings.
@@ -0,0 +1,102 @@
+package db
+
+import "strings"
+
+// Result and disposition filter values accepted by RecordFilter. An empty
+// value or FilterAny disables the corresponding filter.
+const (
+	FilterAny  = "any"
+	ResultPass = "pass"
+	ResultFail = "fail"
+)
+
+// recordsFrom is the FROM clause shared by record queries: records (rec) with
+// their report (rep) and the IP information snapshot (ih) that was current
+// when the report's period ended.
+const recordsFrom = `
+	FROM records rec
+	JOIN reports rep ON rep.id = rec.report_id` + RecordIPSnapshotJoin
+
+// dmarcPassExpr is true for records that passed DMARC, i.e. whose evaluated
+// DKIM or SPF result is an aligned pass.
+const dmarcPassExpr = "(rec.dkim_result = 'pass' OR rec.spf_result = 'pass')"
+
+// RecordFilter selects records for the dashboard and record views. Empty
+// fields are ignored; Begin and End bound the report's date_range_begin and
+// are Unix timestamps (0 = unbounded).
+type RecordFilter struct {
+	Begin       int64
+	End         int64
+	Disposition string // none, quarantine or reject
+	DMARCResult string // pass or fail
+	SPFResult   string // pass or fail (evaluated)
+	DKIMResult  string // pass or fail (evaluated)
+	ASName      string
+	CountryCode string
+	Domain      string // Reverse (apex) domain of the source IP or header From domain
+	Keyword     string // Substring of IP, AS, hostname, country, From domain or reporter
+}
+
+// where builds the condition of the WHERE clause and its arguments for f,
+// for use with recordsFrom.
+func (f RecordFilter) where() (string, []interface{}) {
+	conditions := []string{"1 = 1"}
+	var args []interface{}
+
+	if f.Begin != 0 {
+		conditions = append(conditions, "rep.date_range_begin >= ?")
+		args = append(args, f.Begin)
+	}
+	if f.End != 0 {
+		conditions = append(conditions, "rep.date_range_begin <= ?")
+		args = append(args, f.End)
+	}
+	if isSet(f.Disposition) {
+		conditions = append(conditions, "rec.disposition = ? COLLATE NOCASE")
+		args = append(args, f.Disposition)
+	}
+	switch f.DMARCResult {
+	case ResultPass:
+		conditions = append(conditions, dmarcPassExpr)
+	case ResultFail:
+		conditions = append(conditions, "NOT "+dmarcPassExpr)
+	}
+	if isSet(f.SPFResult) {
+		conditions = append(conditions, "rec.spf_result = ? COLLATE NOCASE")
+		args = append(args, f.SPFResult)
+	}
+	if isSet(f.DKIMResult) {
+		conditions = append(conditions, "rec.dkim_result = ? COLLATE NOCASE")
+		args = append(args, f.DKIMResult)
+	}
+	if f.ASName != "" {
+		conditions = append(conditions, "ih.asn_organization = ? COLLATE NOCASE")
+		args = append(args, f.ASName)
+	}
+	if f.CountryCode != "" {
+		conditions = append(conditions, "ih.country_code = ? COLLATE NOCASE")
+		args = append(args, f.CountryCode)
+	}
+	if f.Domain != "" {
+		conditions = append(conditions, "(ih.apex_domain = ? COLLATE NOCASE OR rec.header_from = ? COLLATE NOCASE)")
+		args = append(args, f.Domain, f.Domain)
+	}
+	if f.Keyword != "" {
+		pattern := "%" + escapeLike(f.Keyword) + "%"
+		var matches []string
+		for _, col := range []string{
+			"rec.source_ip", "rec.header_from", "rep.org_name",
+			"ih.hostname", "ih.asn_organization", "ih.country_name", "ih.country_code",
+		} {
+			matches = append(matches, col+` LIKE ? ESCAPE '\'`)
+			args = append(args, pattern)
+		}
+		conditions = append(conditions, "("+strings.Join(matches, " OR ")+")")
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// isSet reports whether an enumerated filter value restricts the results.
+func isSet(value string) bool {
+	return value != "" && value != FilterAny
+}
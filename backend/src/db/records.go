@@ -0,0 +1,317 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// aggregateIDPrefix marks the identifier of an aggregated record row.
+const aggregateIDPrefix = "a."
+
+// RecordKey identifies an aggregated record: records across reports with the
+// same source IP, evaluated results and header From domain are combined.
+type RecordKey struct {
+	SourceIP    string
+	Disposition string
+	DKIMResult  string
+	SPFResult   string
+	HeaderFrom  string
+}
+
+// ID returns the stable identifier of the aggregated record with key k. It is
+// derived from the key alone, so it stays valid while reports are added.
+func (k RecordKey) ID() string {
+	data, _ := json.Marshal([]string{k.SourceIP, k.Disposition, k.DKIMResult, k.SPFResult, k.HeaderFrom})
+	return aggregateIDPrefix + base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseRecordID parses a record identifier as returned by GetRecords: either
+// the numeric ID of an individual record, or the identifier of an aggregated
+// record, in which case key is non-nil.
+func ParseRecordID(id string) (recordID int64, key *RecordKey, err error) {
+	if !strings.HasPrefix(id, aggregateIDPrefix) {
+		recordID, err = strconv.ParseInt(id, 10, 64)
+		if err != nil || recordID <= 0 {
+			return 0, nil, fmt.Errorf("invalid record ID %q", id)
+		}
+		return recordID, nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(id, aggregateIDPrefix))
+	var fields []string
+	if err == nil {
+		err = json.Unmarshal(data, &fields)
+	}
+	if err != nil || len(fields) != 5 {
+		return 0, nil, fmt.Errorf("invalid aggregated record ID %q", id)
+	}
+	return 0, &RecordKey{
+		SourceIP:    fields[0],
+		Disposition: fields[1],
+		DKIMResult:  fields[2],
+		SPFResult:   fields[3],
+		HeaderFrom:  fields[4],
+	}, nil
+}
+
+// RecordIPInfo is the IP information shown with a record.
+type RecordIPInfo struct {
+	CountryCode   string `json:"country_code"`
+	CountryName   string `json:"country_name"`
+	ASNumber      int    `json:"as_number"`
+	ASName        string `json:"as_name"`
+	Hostname      string `json:"hostname"`
+	ReverseDomain string `json:"reverse_domain"`
+}
+
+// RecordRow is a row of the records view: an individual record, or records
+// aggregated across reports by RecordKey.
+type RecordRow struct {
+	ID             string       `json:"id"`
+	SourceIP       string       `json:"source_ip"`
+	Count          int64        `json:"count"`
+	Disposition    string       `json:"disposition"`
+	DKIMResult     string       `json:"dkim_result"`
+	SPFResult      string       `json:"spf_result"`
+	DMARCResult    string       `json:"dmarc_result"`
+	HeaderFrom     string       `json:"header_from"`
+	DateRangeBegin int64        `json:"date_range_begin"` // Earliest contributing report, if aggregated
+	DateRangeEnd   int64        `json:"date_range_end"`   // Latest contributing report, if aggregated
+	IPInfo         RecordIPInfo `json:"ip_info"`
+	ReportCount    int64        `json:"report_count,omitempty"`    // Aggregated rows only
+	ReportOrgName  string       `json:"report_org_name,omitempty"` // Individual rows only
+	ReportID       string       `json:"report_id,omitempty"`       // Individual rows only
+}
+
+// RecordQuery selects, sorts and pages rows of the records view.
+type RecordQuery struct {
+	Filter    RecordFilter
+	Aggregate bool
+	SortBy    string // One of the keys of recordSortColumns
+	SortOrder string // asc or desc
+	Limit     int
+	Offset    int     // Ignored when After is set
+	After     *Cursor // Return the rows following this position, from RecordCursor
+}
+
+// recordSortColumns maps sortable columns to result column names of the
+// records view queries. report_count and report_org_name only apply to one mode.
+var recordSortColumns = map[string]string{
+	"count":            "count",
+	"source_ip":        "source_ip",
+	"disposition":      "disposition",
+	"dkim_result":      "dkim_result",
+	"spf_result":       "spf_result",
+	"header_from":      "header_from",
+	"date_range_begin": "date_range_begin",
+	"as_name":          "as_name",
+	"country_code":     "country_code",
+	"reverse_domain":   "reverse_domain",
+	"report_count":     "report_count",
+	"report_org_name":  "report_org_name",
+}
+
+// IsValidRecordSort reports whether column can be used as RecordQuery.SortBy.
+func IsValidRecordSort(column string, aggregate bool) bool {
+	if _, ok := recordSortColumns[column]; !ok {
+		return false
+	}
+	if aggregate {
+		return column != "report_org_name"
+	}
+	return column != "report_count"
+}
+
+// recordIPColumns selects the IP information columns of snapshot alias ih.
+const recordIPColumns = `COALESCE(ih.country_code, '') AS country_code, COALESCE(ih.country_name, ''),
+	COALESCE(ih.asn_number, 0), COALESCE(ih.asn_organization, '') AS as_name,
+	COALESCE(ih.hostname, ''), COALESCE(ih.apex_domain, '') AS reverse_domain`
+
+// recordsViewQuery returns the SELECT of the records view for the given mode,
+// without ORDER BY and LIMIT. Aggregated rows carry the IP information
+// snapshot of their latest contributing report.
+func recordsViewQuery(aggregate bool, where string) string {
+	if !aggregate {
+		return `
+			SELECT rec.id AS id, rec.source_ip AS source_ip, rec.count AS count, rec.disposition AS disposition,
+				rec.dkim_result AS dkim_result, rec.spf_result AS spf_result, rec.header_from AS header_from,
+				rep.date_range_begin AS date_range_begin, rep.date_range_end,
+				` + recordIPColumns + `,
+				rep.org_name AS report_org_name, rep.report_id
+			` + recordsFrom + `
+			WHERE ` + where
+	}
+	return `
+		SELECT g.source_ip AS source_ip, g.count AS count, g.disposition AS disposition,
+			g.dkim_result AS dkim_result, g.spf_result AS spf_result, g.header_from AS header_from,
+			g.date_range_begin AS date_range_begin, g.date_range_end,
+			` + recordIPColumns + `,
+			g.report_count AS report_count
+		FROM (
+			SELECT rec.source_ip, rec.disposition, rec.dkim_result, rec.spf_result, rec.header_from,
+				SUM(rec.count) AS count, COUNT(DISTINCT rec.report_id) AS report_count,
+				MIN(rep.date_range_begin) AS date_range_begin, MAX(rep.date_range_end) AS date_range_end
+			` + recordsFrom + `
+			WHERE ` + where + `
+			GROUP BY rec.source_ip, rec.disposition, rec.dkim_result, rec.spf_result, rec.header_from
+		) g
+		LEFT JOIN ip_info_history ih ON ih.ip_address = g.source_ip
+			AND ih.valid_from <= g.date_range_end
+			AND (ih.valid_to IS NULL OR ih.valid_to > g.date_range_end)`
+}
+
+// GetRecords retrieves a page of the records view.
+func (r *Repository) GetRecords(q RecordQuery) ([]RecordRow, error) {
+	records := []RecordRow{}
+	err := r.ForEachRecordRow(q, func(row *RecordRow) error {
+		records = append(records, *row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// CountRecords returns the number of rows of the records view matching the
+// query's filter.
+func (r *Repository) CountRecords(q RecordQuery) (int, error) {
+	where, args := q.Filter.where()
+
+	var totalCount int
+	if err := r.db.QueryRow("SELECT COUNT(*) FROM ("+recordsViewQuery(q.Aggregate, where)+")", args...).Scan(&totalCount); err != nil {
+		return 0, fmt.Errorf("failed to count records: %w", err)
+	}
+	return totalCount, nil
+}
+
+// recordSort returns the validated sort column and order of q, and the
+// columns that break ties between rows with equal sort values.
+func recordSort(q RecordQuery) (sortBy, sortOrder string, tieBreak []string) {
+	// Validate sortBy and sortOrder to prevent SQL injection
+	sortBy = "count" // Default sort by
+	if IsValidRecordSort(q.SortBy, q.Aggregate) {
+		sortBy = recordSortColumns[q.SortBy]
+	}
+	sortOrder = "desc" // Default sort order
+	if strings.EqualFold(q.SortOrder, "asc") {
+		sortOrder = "asc"
+	}
+	tieBreak = []string{"source_ip", "header_from", "disposition", "dkim_result", "spf_result"}
+	if !q.Aggregate {
+		tieBreak = append(tieBreak, "id")
+	}
+	return sortBy, sortOrder, tieBreak
+}
+
+// RecordCursor returns the cursor positioned after row in the records view
+// selected by q.
+func RecordCursor(row *RecordRow, q RecordQuery) Cursor {
+	sortBy, sortOrder, _ := recordSort(q)
+	var value interface{}
+	switch sortBy {
+	case "count":
+		value = row.Count
+	case "source_ip":
+		value = row.SourceIP
+	case "disposition":
+		value = row.Disposition
+	case "dkim_result":
+		value = row.DKIMResult
+	case "spf_result":
+		value = row.SPFResult
+	case "header_from":
+		value = row.HeaderFrom
+	case "date_range_begin":
+		value = row.DateRangeBegin
+	case "as_name":
+		value = row.IPInfo.ASName
+	case "country_code":
+		value = row.IPInfo.CountryCode
+	case "reverse_domain":
+		value = row.IPInfo.ReverseDomain
+	case "report_count":
+		value = row.ReportCount
+	case "report_org_name":
+		value = row.ReportOrgName
+	}
+	values := []interface{}{value, row.SourceIP, row.HeaderFrom, row.Disposition, row.DKIMResult, row.SPFResult}
+	if !q.Aggregate {
+		id, _ := strconv.ParseInt(row.ID, 10, 64)
+		values = append(values, id)
+	}
+	return Cursor{SortBy: sortBy, SortOrder: sortOrder, Values: values}
+}
+
+// ForEachRecordRow calls fn for every row of the records view selected by q,
+// in order, reading them from the database cursor one at a time. A Limit of 0
+// or less selects all rows. Iteration stops at the first error returned by fn.
+func (r *Repository) ForEachRecordRow(q RecordQuery, fn func(*RecordRow) error) error {
+	where, args := q.Filter.where()
+	sortBy, sortOrder, tieBreak := recordSort(q)
+	limit := q.Limit
+	if limit <= 0 {
+		limit = -1 // No limit
+	}
+
+	query := recordsViewQuery(q.Aggregate, where)
+	offset := q.Offset
+	if q.After != nil {
+		cond, condArgs, err := q.After.after(append([]string{sortBy}, tieBreak...), sortBy, sortOrder)
+		if err != nil {
+			return err
+		}
+		query = "SELECT * FROM (" + query + ") WHERE " + cond
+		args = append(args, condArgs...)
+		offset = 0
+	}
+	query = fmt.Sprintf("%s ORDER BY %s %s, %s %s LIMIT ? OFFSET ?", query, sortBy, sortOrder,
+		strings.Join(tieBreak, " "+sortOrder+", "), sortOrder)
+	rows, err := r.db.Query(query, append(args, limit, offset)...)
+	if err != nil {
+		return fmt.Errorf("failed to query records: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row RecordRow
+		var recordID int64
+		dest := []interface{}{
+			&row.SourceIP, &row.Count, &row.Disposition, &row.DKIMResult, &row.SPFResult, &row.HeaderFrom,
+			&row.DateRangeBegin, &row.DateRangeEnd,
+			&row.IPInfo.CountryCode, &row.IPInfo.CountryName, &row.IPInfo.ASNumber, &row.IPInfo.ASName,
+			&row.IPInfo.Hostname, &row.IPInfo.ReverseDomain,
+		}
+		if q.Aggregate {
+			dest = append(dest, &row.ReportCount)
+		} else {
+			dest = append([]interface{}{&recordID}, dest...)
+			dest = append(dest, &row.ReportOrgName, &row.ReportID)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return fmt.Errorf("failed to scan record row: %w", err)
+		}
+
+		if q.Aggregate {
+			row.ID = RecordKey{row.SourceIP, row.Disposition, row.DKIMResult, row.SPFResult, row.HeaderFrom}.ID()
+		} else {
+			row.ID = strconv.FormatInt(recordID, 10)
+		}
+		row.DMARCResult = dmarcResult(row.DKIMResult, row.SPFResult)
+		if err := fn(&row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// dmarcResult derives the DMARC result from the evaluated DKIM and SPF results.
+func dmarcResult(dkimResult, spfResult string) string {
+	if dkimResult == ResultPass || spfResult == ResultPass {
+		return ResultPass
+	}
+	return ResultFail
+}
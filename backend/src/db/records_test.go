@@ -0,0 +1,148 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// googleIPInfo is the IP information of 192.0.2.1 in saveRecordFixture.
+var googleIPInfo = RecordIPInfo{
+	CountryCode: "US", CountryName: "United States", ASNumber: 15169, ASName: "Google LLC",
+	Hostname: "mail.google.com", ReverseDomain: "google.com",
+}
+
+// rowIDs returns the IDs of rows in order.
+func rowIDs(rows []RecordRow) []string {
+	ids := []string{}
+	for _, row := range rows {
+		ids = append(ids, row.ID)
+	}
+	return ids
+}
+
+func TestGetRecordsIndividual(t *testing.T) {
+	repo := newTestRepository(t)
+	ids := saveRecordFixture(t, repo)
+
+	rows, err := repo.GetRecords(RecordQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// By count, largest first
+	want := []string{fmt.Sprint(ids[0]), fmt.Sprint(ids[2]), fmt.Sprint(ids[4]), fmt.Sprint(ids[1]), fmt.Sprint(ids[3])}
+	if got := rowIDs(rows); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("record IDs = %v, want %v", got, want)
+	}
+	wantRow := RecordRow{
+		ID: fmt.Sprint(ids[2]), SourceIP: "192.0.2.1", Count: 5, Disposition: "quarantine",
+		DKIMResult: "fail", SPFResult: "pass", DMARCResult: "pass", HeaderFrom: "example.com",
+		DateRangeBegin: fixtureDay2, DateRangeEnd: fixtureDay2 + 86399,
+		IPInfo: googleIPInfo, ReportOrgName: "Yahoo", ReportID: "y-1",
+	}
+	if !reflect.DeepEqual(rows[1], wantRow) {
+		t.Errorf("row = %+v, want %+v", rows[1], wantRow)
+	}
+	if rows[4].DMARCResult != "fail" || rows[4].IPInfo != (RecordIPInfo{}) {
+		t.Errorf("row without IP information = %+v, want DMARC fail and empty IP information", rows[4])
+	}
+
+	count, err := repo.CountRecords(RecordQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 5 {
+		t.Errorf("CountRecords() = %d, want 5", count)
+	}
+}
+
+func TestGetRecordsAggregated(t *testing.T) {
+	repo := newTestRepository(t)
+	saveRecordFixture(t, repo)
+
+	q := RecordQuery{Aggregate: true}
+	rows, err := repo.GetRecords(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("got %d aggregated rows, want 4: %+v", len(rows), rows)
+	}
+	key := RecordKey{SourceIP: "192.0.2.1", Disposition: "none", DKIMResult: "pass", SPFResult: "pass", HeaderFrom: "example.com"}
+	want := RecordRow{
+		ID: key.ID(), SourceIP: "192.0.2.1", Count: 14, Disposition: "none",
+		DKIMResult: "pass", SPFResult: "pass", DMARCResult: "pass", HeaderFrom: "example.com",
+		DateRangeBegin: fixtureDay1, DateRangeEnd: fixtureDay2 + 86399,
+		IPInfo: googleIPInfo, ReportCount: 2,
+	}
+	if !reflect.DeepEqual(rows[0], want) {
+		t.Errorf("aggregated row = %+v, want %+v", rows[0], want)
+	}
+	if count, err := repo.CountRecords(q); err != nil || count != 4 {
+		t.Errorf("CountRecords(aggregated) = %d, %v, want 4", count, err)
+	}
+
+	// The filter applies before aggregation
+	q.Filter = RecordFilter{Begin: fixtureDay2}
+	rows, err = repo.GetRecords(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("got %d aggregated rows of the second day, want 3: %+v", len(rows), rows)
+	}
+	for _, row := range rows {
+		if row.ID == key.ID() && (row.Count != 4 || row.ReportCount != 1 || row.DateRangeBegin != fixtureDay2) {
+			t.Errorf("aggregated row of the second day = %+v, want 4 emails of one report", row)
+		}
+	}
+}
+
+func TestGetRecordsSort(t *testing.T) {
+	repo := newTestRepository(t)
+	ids := saveRecordFixture(t, repo)
+
+	tests := []struct {
+		q    RecordQuery
+		want []int // Indexes into ids
+	}{
+		{RecordQuery{SortBy: "count", SortOrder: "asc"}, []int{3, 1, 4, 2, 0}},
+		{RecordQuery{SortBy: "as_name", SortOrder: "asc"}, []int{3, 1, 0, 4, 2}},
+		{RecordQuery{SortBy: "report_org_name", SortOrder: "desc"}, []int{1, 0, 3, 2, 4}},
+		{RecordQuery{SortBy: "date_range_begin", SortOrder: "asc", Limit: 2, Offset: 1}, []int{1, 4}},
+		// Invalid columns fall back to the default sort
+		{RecordQuery{SortBy: "report_count"}, []int{0, 2, 4, 1, 3}},
+	}
+	for _, tt := range tests {
+		rows, err := repo.GetRecords(tt.q)
+		if err != nil {
+			t.Fatalf("%+v: %v", tt.q, err)
+		}
+		var want []string
+		for _, i := range tt.want {
+			want = append(want, fmt.Sprint(ids[i]))
+		}
+		if got := rowIDs(rows); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("GetRecords(%s %s) = %v, want %v", tt.q.SortBy, tt.q.SortOrder, got, want)
+		}
+	}
+}
+
+func TestParseRecordID(t *testing.T) {
+	key := RecordKey{SourceIP: "2001:db8::1", Disposition: "none", DKIMResult: "pass", SPFResult: "fail", HeaderFrom: "example.com"}
+	id, parsed, err := ParseRecordID(key.ID())
+	if err != nil || id != 0 || parsed == nil || *parsed != key {
+		t.Errorf("ParseRecordID(%q) = %d, %+v, %v, want key %+v", key.ID(), id, parsed, err, key)
+	}
+
+	id, parsed, err = ParseRecordID("42")
+	if err != nil || id != 42 || parsed != nil {
+		t.Errorf("ParseRecordID(\"42\") = %d, %+v, %v, want 42", id, parsed, err)
+	}
+
+	for _, invalid := range []string{"", "0", "-1", "abc", "a.", "a.!!", "a.WyJhIl0"} {
+		if _, _, err := ParseRecordID(invalid); err == nil {
+			t.Errorf("ParseRecordID(%q) accepted an invalid ID", invalid)
+		}
+	}
+}
@@ -4,12 +4,16 @@ import (
 	"database/sql"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 )
 
 // Repository provides methods for interacting with the database.
 type Repository struct {
 	db *sql.DB
+
+	searchOnce sync.Once
+	searchFTS  bool // Whether the FTS5 search tables are maintained
 }
 
 // NewRepository creates a new Repository instance.
@@ -55,59 +59,146 @@ func (r *Repository) ReportExistsByHash(xmlHash string) (bool, error) {
 	return count > 0, nil
 }
 
-// GetReports retrieves a list of DMARC reports with pagination and sorting.
-func (r *Repository) GetReports(limit, offset int, sortBy, sortOrder string) ([]Report, int, error) {
-	var reports []Report
-	var totalCount int
+// ReportQuery sorts and pages the report listing.
+type ReportQuery struct {
+	SortBy    string // One of the keys of reportSortColumns
+	SortOrder string // asc or desc
+	Limit     int
+	Offset    int     // Ignored when After is set
+	After     *Cursor // Return the reports following this position, from ReportCursor
+}
 
-	// Get total count
-	err := r.db.QueryRow("SELECT COUNT(*) FROM reports").Scan(&totalCount)
+// reportSortColumns are the columns reports can be sorted by.
+var reportSortColumns = map[string]bool{
+	"id": true, "org_name": true, "report_id": true, "date_range_begin": true, "domain": true,
+}
+
+// GetReports retrieves a page of DMARC reports.
+func (r *Repository) GetReports(q ReportQuery) ([]Report, error) {
+	sortBy, sortOrder := normalizeReportSort(q.SortBy, q.SortOrder)
+	where := "1=1"
+	var args []interface{}
+	offset := q.Offset
+	if q.After != nil {
+		cond, condArgs, err := q.After.after([]string{sortBy, "id"}, sortBy, sortOrder)
+		if err != nil {
+			return nil, err
+		}
+		where, args, offset = cond, condArgs, 0
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, xml_hash, original_xml, org_name, report_id, date_range_begin, date_range_end, domain, adkim, aspf, p, sp, pct
+		FROM reports
+		WHERE %s
+		ORDER BY %s
+		LIMIT ? OFFSET ?
+	`, where, reportOrderBy(sortBy, sortOrder))
+
+	rows, err := r.db.Query(query, append(args, q.Limit, offset)...)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to get total report count: %w", err)
+		return nil, fmt.Errorf("failed to query reports: %w", err)
 	}
+	defer rows.Close()
 
-	// Validate sortBy and sortOrder to prevent SQL injection
-	validSortBy := map[string]bool{
-		"id": true, "org_name": true, "report_id": true, "date_range_begin": true, "domain": true,
+	var reports []Report
+	for rows.Next() {
+		var report Report
+		err := rows.Scan(
+			&report.ID, &report.XMLHash, &report.OriginalXML, &report.OrgName, &report.ReportID,
+			&report.DateRangeBegin, &report.DateRangeEnd, &report.Domain, &report.ADKIM,
+			&report.ASPF, &report.P, &report.SP, &report.PCT,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan report row: %w", err)
+		}
+		reports = append(reports, report)
+	}
+
+	return reports, rows.Err()
+}
+
+// CountReports returns the total number of reports.
+func (r *Repository) CountReports() (int, error) {
+	var totalCount int
+	if err := r.db.QueryRow("SELECT COUNT(*) FROM reports").Scan(&totalCount); err != nil {
+		return 0, fmt.Errorf("failed to get total report count: %w", err)
 	}
-	validSortOrder := map[string]bool{
-		"asc": true, "desc": true,
+	return totalCount, nil
+}
+
+// ReportCursor returns the cursor positioned after report in the report
+// listing sorted by sortBy and sortOrder.
+func ReportCursor(report *Report, sortBy, sortOrder string) Cursor {
+	sortBy, sortOrder = normalizeReportSort(sortBy, sortOrder)
+	var value interface{}
+	switch sortBy {
+	case "id":
+		value = report.ID
+	case "org_name":
+		value = report.OrgName
+	case "report_id":
+		value = report.ReportID
+	case "domain":
+		value = report.Domain
+	default:
+		value = report.DateRangeBegin
 	}
+	return Cursor{SortBy: sortBy, SortOrder: sortOrder, Values: []interface{}{value, report.ID}}
+}
 
-	if !validSortBy[sortBy] {
+// normalizeReportSort validates sortBy and sortOrder, replacing invalid
+// values with the defaults.
+func normalizeReportSort(sortBy, sortOrder string) (string, string) {
+	// Validate sortBy and sortOrder to prevent SQL injection
+	if !reportSortColumns[sortBy] {
 		sortBy = "date_range_begin" // Default sort by
 	}
-	if !validSortOrder[strings.ToLower(sortOrder)] {
+	sortOrder = strings.ToLower(sortOrder)
+	if sortOrder != "asc" && sortOrder != "desc" {
 		sortOrder = "desc" // Default sort order
 	}
+	return sortBy, sortOrder
+}
 
-	query := fmt.Sprintf(`
-		SELECT id, xml_hash, original_xml, org_name, report_id, date_range_begin, date_range_end, domain, adkim, aspf, p, sp, pct
-		FROM reports
-		ORDER BY %s %s
-		LIMIT ? OFFSET ?
-	`, sortBy, sortOrder)
+// reportOrderBy returns the ORDER BY expression for sorting reports. Ties are
+// broken by ID in the same direction, so that the order can be resumed from
+// a Cursor.
+func reportOrderBy(sortBy, sortOrder string) string {
+	sortBy, sortOrder = normalizeReportSort(sortBy, sortOrder)
+	return sortBy + " " + sortOrder + ", id " + sortOrder
+}
 
-	rows, err := r.db.Query(query, limit, offset)
+// ForEachReport calls fn for every report matching filter, sorted like
+// GetReports, reading them from the database cursor one at a time. The
+// original XML is not loaded. Iteration stops at the first error returned by fn.
+func (r *Repository) ForEachReport(filter ReportFilter, sortBy, sortOrder string, fn func(*Report) error) error {
+	where, args := filter.where()
+	rows, err := r.db.Query(`
+		SELECT id, xml_hash, org_name, report_id, date_range_begin, date_range_end, domain, adkim, aspf, p, sp, pct
+		FROM reports
+		WHERE `+where+`
+		ORDER BY `+reportOrderBy(sortBy, sortOrder), args...)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to query reports: %w", err)
+		return fmt.Errorf("failed to query reports: %w", err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var report Report
 		err := rows.Scan(
-			&report.ID, &report.XMLHash, &report.OriginalXML, &report.OrgName, &report.ReportID,
+			&report.ID, &report.XMLHash, &report.OrgName, &report.ReportID,
 			&report.DateRangeBegin, &report.DateRangeEnd, &report.Domain, &report.ADKIM,
 			&report.ASPF, &report.P, &report.SP, &report.PCT,
 		)
 		if err != nil {
-			return nil, 0, fmt.Errorf("failed to scan report row: %w", err)
+			return fmt.Errorf("failed to scan report row: %w", err)
+		}
+		if err := fn(&report); err != nil {
+			return err
 		}
-		reports = append(reports, report)
 	}
-
-	return reports, totalCount, nil
+	return rows.Err()
 }
 
 // GetReportByID retrieves a single DMARC report by its ID.
@@ -134,24 +225,21 @@ func (r *Repository) GetReportByID(id int64) (*Report, error) {
 
 // GetUserByUsername retrieves a user by their username.
 func (r *Repository) GetUserByUsername(username string) (*User, error) {
-	var user User
-	err := r.db.QueryRow("SELECT id, username, password_hash, created_at FROM users WHERE username = ?", username).Scan(
-		&user.ID, &user.Username, &user.PasswordHash, &user.CreatedAt,
-	)
+	user, err := scanUser(r.db.QueryRow("SELECT "+userColumns+" FROM users WHERE username = ?", username))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // User not found
 		}
 		return nil, fmt.Errorf("failed to query user by username: %w", err)
 	}
-	return &user, nil
+	return user, nil
 }
 
 // UpdateUser updates a user's information in the database.
 func (r *Repository) UpdateUser(user *User) error {
 	stmt, err := r.db.Prepare(`
 		UPDATE users
-		SET username = ?, password_hash = ?
+		SET username = ?, password_hash = ?, role = ?, disabled = ?, must_change_password = ?
 		WHERE id = ?
 	`)
 	if err != nil {
@@ -159,7 +247,7 @@ func (r *Repository) UpdateUser(user *User) error {
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(user.Username, user.PasswordHash, user.ID)
+	_, err = stmt.Exec(user.Username, user.PasswordHash, user.Role, user.Disabled, user.MustChangePassword, user.ID)
 	if err != nil {
 		return fmt.Errorf("failed to execute statement for updating user: %w", err)
 	}
@@ -193,9 +281,37 @@ func (r *Repository) SaveRecord(record *Record) error {
 	return nil
 }
 
-// SaveOrUpdateIPInfo saves or updates IP information in the database.
-func (r *Repository) SaveOrUpdateIPInfo(info *IPInfo) error {
-	stmt, err := r.db.Prepare(`
+// SaveOrUpdateIPInfo saves or updates IP information in the database. ip_info
+// holds the latest lookup; if it differs from the previous one, the change is
+// also recorded in the address's snapshot history. Fields the lookup left
+// unknown ("" or "N/A") keep their stored values.
+func (r *Repository) SaveOrUpdateIPInfo(lookup *IPInfo) error {
+	now := time.Now().Unix()
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction for saving/updating IP info: %w", err)
+	}
+	defer tx.Rollback() // No-op after commit
+
+	info := *lookup
+	var stored IPInfo
+	err = tx.QueryRow(`
+		SELECT country_code, country_name, city_name, asn_number, asn_organization, hostname, reversed_hostname, apex_domain
+		FROM ip_info
+		WHERE ip_address = ?
+	`, info.IPAddress).Scan(
+		&stored.CountryCode, &stored.CountryName, &stored.CityName, &stored.ASNNumber,
+		&stored.ASNOrganization, &stored.Hostname, &stored.ReversedHostname, &stored.ApexDomain,
+	)
+	switch {
+	case err == nil:
+		info.fillUnknown(&stored)
+	case err != sql.ErrNoRows:
+		return fmt.Errorf("failed to query stored IP info for %s: %w", info.IPAddress, err)
+	}
+
+	_, err = tx.Exec(`
 		INSERT INTO ip_info (ip_address, country_code, country_name, city_name, asn_number, asn_organization, hostname, reversed_hostname, apex_domain, last_updated)
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(ip_address) DO UPDATE SET
@@ -208,21 +324,22 @@ func (r *Repository) SaveOrUpdateIPInfo(info *IPInfo) error {
 			reversed_hostname = EXCLUDED.reversed_hostname,
 			apex_domain = EXCLUDED.apex_domain,
 			last_updated = EXCLUDED.last_updated
-	`)
-	if err != nil {
-		return fmt.Errorf("failed to prepare statement for saving/updating IP info: %w", err)
-	}
-	defer stmt.Close()
-
-	_, err = stmt.Exec(
+	`,
 		info.IPAddress, info.CountryCode, info.CountryName, info.CityName,
 		info.ASNNumber, info.ASNOrganization, info.Hostname, info.ReversedHostname,
-		info.ApexDomain, time.Now().Unix(),
+		info.ApexDomain, now,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to execute statement for saving/updating IP info: %w", err)
 	}
-	return nil
+	if err := recordIPSnapshotTx(tx, &info, now); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit IP info: %w", err)
+	}
+	return r.indexIPInfo(info.IPAddress)
 }
 
 // SaveIngestionError saves an ingestion error to the database.
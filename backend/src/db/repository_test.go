@@ -0,0 +1,29 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+// newTestRepository returns a Repository on a migrated in-memory database
+// that lives until the end of the test.
+func newTestRepository(t *testing.T) *Repository {
+	t.Helper()
+	// A named in-memory database with a shared cache is the same database on
+	// every connection of the pool.
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	database, err := sql.Open("sqlite3", "file:"+name+"?mode=memory&cache=shared&_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	if err := runMigrations(database); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureSearchIndex(database); err != nil {
+		t.Fatal(err)
+	}
+	return NewRepository(database)
+}
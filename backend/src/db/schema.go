@@ -10,7 +10,9 @@ import (
 
 // InitDB initializes the SQLite database and performs migrations.
 func InitDB(dbPath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	// Foreign keys are enforced per connection, so they are enabled in the DSN
+	// to cover every connection in the pool.
+	db, err := sql.Open("sqlite3", dbPath+"?_foreign_keys=on")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -26,13 +28,32 @@ func InitDB(dbPath string) (*sql.DB, error) {
 		return nil, fmt.Errorf("failed to run database migrations: %w", err)
 	}
 
+	// The search index lives outside the versioned migrations because it
+	// depends on FTS5 being compiled in.
+	if err := ensureSearchIndex(db); err != nil {
+		return nil, err
+	}
+
 	return db, nil
 }
 
-// runMigrations applies necessary schema changes.
-func runMigrations(db *sql.DB) error {
-	// Version 1: Initial schema
-	_, err := db.Exec(`
+// SchemaVersion is the schema version this build expects. It is stored in
+// SQLite's PRAGMA user_version so that backups can be checked before restore.
+const SchemaVersion = 12
+
+// migration is a single versioned schema change.
+type migration struct {
+	version     int
+	description string
+	stmts       string
+}
+
+// migrations lists every schema change in the order it must be applied.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "initial schema",
+		stmts: `
 		CREATE TABLE IF NOT EXISTS reports (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			xml_hash TEXT UNIQUE NOT NULL,
@@ -94,13 +115,7 @@ func runMigrations(db *sql.DB) error {
 			key TEXT PRIMARY KEY,
 			value TEXT
 		);
-	`)
-	if err != nil {
-		return fmt.Errorf("failed to create initial schema (migration v1): %w", err)
-	}
 
-	// Add indexes for performance
-	_, err = db.Exec(`
 		CREATE INDEX IF NOT EXISTS idx_reports_date_range_begin ON reports(date_range_begin);
 		CREATE INDEX IF NOT EXISTS idx_records_report_id ON records(report_id);
 		CREATE INDEX IF NOT EXISTS idx_records_source_ip ON records(source_ip);
@@ -108,11 +123,243 @@ func runMigrations(db *sql.DB) error {
 		CREATE INDEX IF NOT EXISTS idx_ip_info_hostname ON ip_info(hostname);
 		CREATE INDEX IF NOT EXISTS idx_ip_info_reversed_hostname ON ip_info(reversed_hostname);
 		CREATE INDEX IF NOT EXISTS idx_ip_info_apex_domain ON ip_info(apex_domain);
-	`)
+		`,
+	},
+	{
+		version:     2,
+		description: "auth results",
+		stmts: `
+		CREATE TABLE IF NOT EXISTS auth_results (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			record_id INTEGER NOT NULL,
+			auth_type TEXT NOT NULL,
+			domain TEXT,
+			result TEXT,
+			selector TEXT,
+			FOREIGN KEY (record_id) REFERENCES records(id) ON DELETE CASCADE
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_auth_results_record_id ON auth_results(record_id);
+		`,
+	},
+	{
+		version:     3,
+		description: "orphan cleanup and audit log",
+		stmts: `
+		DELETE FROM auth_results WHERE record_id NOT IN (SELECT id FROM records);
+		DELETE FROM records WHERE report_id NOT IN (SELECT id FROM reports);
+
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp INTEGER NOT NULL,
+			actor TEXT NOT NULL,
+			action TEXT NOT NULL,
+			target TEXT,
+			details TEXT,
+			source_ip TEXT
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_audit_log_timestamp ON audit_log(timestamp);
+		CREATE INDEX IF NOT EXISTS idx_reports_org_name ON reports(org_name);
+		CREATE INDEX IF NOT EXISTS idx_reports_domain ON reports(domain);
+		`,
+	},
+	{
+		version:     4,
+		description: "IP enrichment history",
+		stmts: `
+		CREATE TABLE IF NOT EXISTS ip_info_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			ip_address TEXT NOT NULL,
+			country_code TEXT,
+			country_name TEXT,
+			city_name TEXT,
+			asn_number INTEGER,
+			asn_organization TEXT,
+			hostname TEXT,
+			reversed_hostname TEXT,
+			apex_domain TEXT,
+			valid_from INTEGER NOT NULL,
+			valid_to INTEGER
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_ip_info_history_ip_valid_from ON ip_info_history(ip_address, valid_from);
+
+		INSERT INTO ip_info_history (ip_address, country_code, country_name, city_name, asn_number, asn_organization, hostname, reversed_hostname, apex_domain, valid_from, valid_to)
+		SELECT ip_address, country_code, country_name, city_name, asn_number, asn_organization, hostname, reversed_hostname, apex_domain, 0, NULL
+		FROM ip_info;
+		`,
+	},
+	{
+		version:     5,
+		description: "user roles",
+		// Users created before roles existed had full access, so they
+		// become admins.
+		stmts: `
+		ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'admin';
+		`,
+	},
+	{
+		version:     6,
+		description: "user account state",
+		stmts: `
+		ALTER TABLE users ADD COLUMN disabled INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE users ADD COLUMN must_change_password INTEGER NOT NULL DEFAULT 0;
+		`,
+	},
+	{
+		version:     7,
+		description: "API tokens",
+		stmts: `
+		CREATE TABLE IF NOT EXISTS api_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			token_hash TEXT UNIQUE NOT NULL,
+			prefix TEXT NOT NULL,
+			scopes TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL,
+			last_used_at INTEGER
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id);
+		`,
+	},
+	{
+		version:     8,
+		description: "login sessions",
+		stmts: `
+		CREATE TABLE IF NOT EXISTS sessions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			refresh_hash TEXT UNIQUE NOT NULL,
+			user_agent TEXT NOT NULL,
+			source_ip TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			last_used_at INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+		`,
+	},
+	{
+		version:     9,
+		description: "TOTP two-factor authentication",
+		stmts: `
+		ALTER TABLE users ADD COLUMN totp_secret TEXT NOT NULL DEFAULT '';
+		ALTER TABLE users ADD COLUMN totp_enabled INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE users ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0;
+
+		CREATE TABLE IF NOT EXISTS recovery_codes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			code_hash TEXT NOT NULL,
+			used_at INTEGER
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes(user_id);
+		`,
+	},
+	{
+		version:     10,
+		description: "external identities",
+		stmts: `
+		ALTER TABLE users ADD COLUMN auth_source TEXT NOT NULL DEFAULT 'local';
+		ALTER TABLE users ADD COLUMN external_id TEXT NOT NULL DEFAULT '';
+
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_users_external_id ON users(auth_source, external_id) WHERE external_id != '';
+		`,
+	},
+	{
+		version:     11,
+		description: "append-only audit log with request metadata",
+		stmts: `
+		ALTER TABLE audit_log ADD COLUMN request_id TEXT NOT NULL DEFAULT '';
+		ALTER TABLE audit_log ADD COLUMN method TEXT NOT NULL DEFAULT '';
+		ALTER TABLE audit_log ADD COLUMN path TEXT NOT NULL DEFAULT '';
+		ALTER TABLE audit_log ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+
+		CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log(actor);
+		CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action);
+
+		CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+		BEGIN
+			SELECT RAISE(ABORT, 'the audit log is append-only');
+		END;
+		CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+		BEGIN
+			SELECT RAISE(ABORT, 'the audit log is append-only');
+		END;
+		`,
+	},
+	{
+		version:     12,
+		description: "used refresh tokens",
+		stmts: `
+		CREATE TABLE IF NOT EXISTS used_refresh_tokens (
+			refresh_hash TEXT PRIMARY KEY,
+			session_id INTEGER NOT NULL REFERENCES sessions(id) ON DELETE CASCADE
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_used_refresh_tokens_session_id ON used_refresh_tokens(session_id);
+		`,
+	},
+}
+
+// runMigrations applies every migration newer than the database's current
+// schema version. Databases created before versioning report version 0; the
+// initial schema is idempotent so it is safe to re-run for them.
+func runMigrations(db *sql.DB) error {
+	current, err := schemaVersion(db)
 	if err != nil {
-		return fmt.Errorf("failed to create indexes: %w", err)
+		return err
+	}
+	if current > SchemaVersion {
+		return fmt.Errorf("database schema version %d is newer than supported version %d", current, SchemaVersion)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return err
+		}
 	}
 
 	log.Println("Database schema initialized/migrated successfully.")
 	return nil
 }
+
+// applyMigration runs the statements of m and records its version in one
+// transaction, so that a migration failing halfway leaves the schema at the
+// previous version and can be retried.
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin migration v%d: %w", m.version, err)
+	}
+	defer tx.Rollback() // No-op after commit
+
+	if _, err := tx.Exec(m.stmts); err != nil {
+		return fmt.Errorf("failed to apply migration v%d (%s): %w", m.version, m.description, err)
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d;", m.version)); err != nil {
+		return fmt.Errorf("failed to record schema version %d: %w", m.version, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration v%d: %w", m.version, err)
+	}
+	return nil
+}
+
+// schemaVersion reads the schema version stored in PRAGMA user_version.
+func schemaVersion(db *sql.DB) (int, error) {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version;").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
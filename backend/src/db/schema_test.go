@@ -0,0 +1,63 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestFailedMigrationIsRolledBack(t *testing.T) {
+	database, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	if err := runMigrations(database); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(original []migration) { migrations = original }(migrations)
+	broken := migration{
+		version:     SchemaVersion + 1,
+		description: "fails halfway",
+		stmts: `
+			CREATE TABLE migration_test (id INTEGER PRIMARY KEY);
+			ALTER TABLE reports ADD COLUMN migration_test TEXT;
+			ALTER TABLE no_such_table ADD COLUMN migration_test TEXT;
+		`,
+	}
+	migrations = append(append([]migration{}, migrations...), broken)
+
+	if err := runMigrations(database); err == nil {
+		t.Fatal("runMigrations with a failing migration succeeded")
+	}
+	version, err := schemaVersion(database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != SchemaVersion {
+		t.Errorf("schema version after the failed migration = %d, want %d", version, SchemaVersion)
+	}
+	var tables int
+	if err := database.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'migration_test'").Scan(&tables); err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 {
+		t.Error("the table created by the failed migration was kept")
+	}
+	if _, err := database.Exec("SELECT migration_test FROM reports"); err == nil {
+		t.Error("the column added by the failed migration was kept")
+	}
+
+	// Once fixed, the same migration applies cleanly
+	migrations[len(migrations)-1].stmts = `
+		CREATE TABLE migration_test (id INTEGER PRIMARY KEY);
+		ALTER TABLE reports ADD COLUMN migration_test TEXT;
+	`
+	if err := runMigrations(database); err != nil {
+		t.Fatalf("retrying the fixed migration: %v", err)
+	}
+	if version, _ := schemaVersion(database); version != SchemaVersion+1 {
+		t.Errorf("schema version after the retry = %d, want %d", version, SchemaVersion+1)
+	}
+}
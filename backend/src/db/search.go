@@ -0,0 +1,382 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Search entity types, used to group search hits.
+const (
+	SearchEntityRecord = "record"
+	SearchEntityIP     = "ip"
+	SearchEntityReport = "report"
+)
+
+// Search rankings, telling how the hits of a group are ordered.
+const (
+	// SearchRankingRelevance orders hits by bm25 relevance, best first.
+	SearchRankingRelevance = "relevance"
+	// SearchRankingNewest orders hits by when they were stored, newest first.
+	// It is used without FTS5 and for queries too short for it; hits then have
+	// no rank.
+	SearchRankingNewest = "newest"
+)
+
+// minFTSQueryLength is the shortest query the trigram tokenizer can match.
+// Shorter queries fall back to a LIKE scan.
+const minFTSQueryLength = 3
+
+// searchIndexSchema creates the FTS5 tables. Each table's rowid is the rowid of
+// the indexed row (records.id, ip_info's rowid, reports.id), so entries can be
+// replaced and deleted cheaply. The trigram tokenizer gives case-insensitive
+// substring matching.
+const searchIndexSchema = `
+	CREATE VIRTUAL TABLE IF NOT EXISTS search_records USING fts5(
+		source_ip, header_from, auth_domains, tokenize = 'trigram'
+	);
+	CREATE VIRTUAL TABLE IF NOT EXISTS search_ips USING fts5(
+		ip_address, hostname, asn_organization, country, tokenize = 'trigram'
+	);
+	CREATE VIRTUAL TABLE IF NOT EXISTS search_reports USING fts5(
+		org_name, domain, report_id, tokenize = 'trigram'
+	);
+`
+
+// SearchHit is a single search result.
+type SearchHit struct {
+	EntityType string                 `json:"entity_type"`
+	ID         string                 `json:"id"`
+	Rank       *float64               `json:"rank,omitempty"` // bm25 score, lower is better; nil unless ranked by relevance
+	Fields     map[string]interface{} `json:"fields"`
+}
+
+// SearchGroup holds the hits for one entity type.
+type SearchGroup struct {
+	Total   int         `json:"total"`
+	Ranking string      `json:"ranking"` // SearchRankingRelevance or SearchRankingNewest
+	Hits    []SearchHit `json:"hits"`
+}
+
+// searchSupported reports whether the linked SQLite was built with FTS5
+// (the sqlite_fts5 build tag).
+func searchSupported(db *sql.DB) bool {
+	var enabled int
+	err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled)
+	return err == nil && enabled == 1
+}
+
+// ensureSearchIndex creates the full-text search tables when FTS5 is available
+// and rebuilds them if they are out of step with the tables they index, e.g.
+// after the first start with FTS5, a restore of a snapshot taken without it or
+// changes made while running without it.
+func ensureSearchIndex(db *sql.DB) error {
+	if !searchSupported(db) {
+		log.Println("Warning: SQLite was built without FTS5 (build tag sqlite_fts5); search falls back to slower substring scans.")
+		return nil
+	}
+	if _, err := db.Exec(searchIndexSchema); err != nil {
+		return fmt.Errorf("failed to create search index: %w", err)
+	}
+
+	stale, err := searchIndexStale(db)
+	if err != nil {
+		return err
+	}
+	if stale {
+		log.Println("Search index is out of date, rebuilding...")
+		return rebuildSearchIndex(db)
+	}
+	return nil
+}
+
+// searchIndexStale reports whether any search table differs from its base
+// table in row count or highest rowid, as it does once rows were added or
+// deleted without maintaining the index.
+func searchIndexStale(db *sql.DB) (bool, error) {
+	for _, pair := range []struct{ index, base string }{
+		{"search_reports", "reports"},
+		{"search_records", "records"},
+		{"search_ips", "ip_info"},
+	} {
+		var indexCount, baseCount, indexMax, baseMax int64
+		if err := db.QueryRow("SELECT COUNT(*), COALESCE(MAX(rowid), 0) FROM "+pair.index).Scan(&indexCount, &indexMax); err != nil {
+			return false, fmt.Errorf("failed to inspect search index %s: %w", pair.index, err)
+		}
+		if err := db.QueryRow("SELECT COUNT(*), COALESCE(MAX(rowid), 0) FROM "+pair.base).Scan(&baseCount, &baseMax); err != nil {
+			return false, fmt.Errorf("failed to count %s: %w", pair.base, err)
+		}
+		if indexCount != baseCount || indexMax != baseMax {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// rebuildSearchIndex repopulates every search table from the base tables.
+func rebuildSearchIndex(db *sql.DB) error {
+	_, err := db.Exec(`
+		DELETE FROM search_records;
+		DELETE FROM search_ips;
+		DELETE FROM search_reports;
+
+		INSERT INTO search_records (rowid, source_ip, header_from, auth_domains)
+		SELECT rec.id, rec.source_ip, rec.header_from,
+			COALESCE((SELECT group_concat(ar.domain, ' ') FROM auth_results ar WHERE ar.record_id = rec.id), '')
+		FROM records rec;
+
+		INSERT INTO search_ips (rowid, ip_address, hostname, asn_organization, country)
+		SELECT rowid, ip_address, hostname, asn_organization, country_code || ' ' || country_name
+		FROM ip_info;
+
+		INSERT INTO search_reports (rowid, org_name, domain, report_id)
+		SELECT id, org_name, domain, report_id
+		FROM reports;
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to rebuild search index: %w", err)
+	}
+	return nil
+}
+
+// searchEnabled reports whether the search tables are maintained, caching the
+// answer for the lifetime of the repository.
+func (r *Repository) searchEnabled() bool {
+	r.searchOnce.Do(func() {
+		r.searchFTS = searchSupported(r.db)
+	})
+	return r.searchFTS
+}
+
+// indexReportBundleTx adds a freshly saved report and its records to the search index.
+func indexReportBundleTx(tx *sql.Tx, bundle *ReportBundle) error {
+	report := bundle.Report
+	if _, err := tx.Exec(
+		"INSERT INTO search_reports (rowid, org_name, domain, report_id) VALUES (?, ?, ?, ?)",
+		report.ID, report.OrgName, report.Domain, report.ReportID,
+	); err != nil {
+		return fmt.Errorf("failed to index report: %w", err)
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO search_records (rowid, source_ip, header_from, auth_domains) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement for indexing records: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, rb := range bundle.Records {
+		var domains []string
+		for _, ar := range rb.AuthResults {
+			domains = append(domains, ar.Domain)
+		}
+		if _, err := stmt.Exec(rb.Record.ID, rb.Record.SourceIP, rb.Record.HeaderFrom, strings.Join(domains, " ")); err != nil {
+			return fmt.Errorf("failed to index record: %w", err)
+		}
+	}
+	return nil
+}
+
+// indexRecord replaces the search index entry of a stored record.
+func (r *Repository) indexRecord(recordID int64) error {
+	if !r.searchEnabled() {
+		return nil
+	}
+	_, err := r.db.Exec(`
+		INSERT OR REPLACE INTO search_records (rowid, source_ip, header_from, auth_domains)
+		SELECT rec.id, rec.source_ip, rec.header_from,
+			COALESCE((SELECT group_concat(ar.domain, ' ') FROM auth_results ar WHERE ar.record_id = rec.id), '')
+		FROM records rec WHERE rec.id = ?
+	`, recordID)
+	if err != nil {
+		return fmt.Errorf("failed to index record %d: %w", recordID, err)
+	}
+	return nil
+}
+
+// unindexReportsTx removes the reports matching where, and their records, from the search index.
+func unindexReportsTx(tx *sql.Tx, where string, args []interface{}) error {
+	reportSubquery := "SELECT id FROM reports WHERE " + where
+	if _, err := tx.Exec("DELETE FROM search_records WHERE rowid IN (SELECT id FROM records WHERE report_id IN ("+reportSubquery+"))", args...); err != nil {
+		return fmt.Errorf("failed to remove records from search index: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM search_reports WHERE rowid IN ("+reportSubquery+")", args...); err != nil {
+		return fmt.Errorf("failed to remove reports from search index: %w", err)
+	}
+	return nil
+}
+
+// indexIPInfo replaces the search index entry of an IP address.
+func (r *Repository) indexIPInfo(ipAddress string) error {
+	if !r.searchEnabled() {
+		return nil
+	}
+	_, err := r.db.Exec(`
+		INSERT OR REPLACE INTO search_ips (rowid, ip_address, hostname, asn_organization, country)
+		SELECT rowid, ip_address, hostname, asn_organization, country_code || ' ' || country_name
+		FROM ip_info WHERE ip_address = ?
+	`, ipAddress)
+	if err != nil {
+		return fmt.Errorf("failed to index IP info for %s: %w", ipAddress, err)
+	}
+	return nil
+}
+
+// searchSpec describes how to search one entity type, with FTS5 and with the
+// LIKE fallback. Both queries select columns; the FTS5 query adds the rank.
+type searchSpec struct {
+	entity    string
+	ftsTable  string
+	ftsSelect string // Joins the FTS table to the base table
+	likeFrom  string // FROM clause of the fallback query
+	likeCols  []string
+	likeOrder string // Newest first, as the rowid grows with every insert
+	columns   []string
+}
+
+var searchSpecs = []searchSpec{
+	{
+		entity:   SearchEntityRecord,
+		ftsTable: "search_records",
+		ftsSelect: `SELECT rec.id, rec.report_id, rec.source_ip, rec.header_from, rec.count, rec.disposition, rec.dkim_result, rec.spf_result, search_records.rank
+			FROM search_records JOIN records rec ON rec.id = search_records.rowid`,
+		likeFrom: `SELECT rec.id, rec.report_id, rec.source_ip, rec.header_from, rec.count, rec.disposition, rec.dkim_result, rec.spf_result
+			FROM records rec`,
+		likeCols: []string{
+			"rec.source_ip", "rec.header_from",
+			"(SELECT group_concat(ar.domain, ' ') FROM auth_results ar WHERE ar.record_id = rec.id)",
+		},
+		likeOrder: "rec.id DESC",
+		columns:   []string{"id", "report_id", "source_ip", "header_from", "count", "disposition", "dkim_result", "spf_result"},
+	},
+	{
+		entity:   SearchEntityIP,
+		ftsTable: "search_ips",
+		ftsSelect: `SELECT ip.ip_address, ip.hostname, ip.asn_number, ip.asn_organization, ip.country_code, ip.country_name, search_ips.rank
+			FROM search_ips JOIN ip_info ip ON ip.rowid = search_ips.rowid`,
+		likeFrom: `SELECT ip.ip_address, ip.hostname, ip.asn_number, ip.asn_organization, ip.country_code, ip.country_name
+			FROM ip_info ip`,
+		likeCols:  []string{"ip.ip_address", "ip.hostname", "ip.asn_organization", "ip.country_code", "ip.country_name"},
+		likeOrder: "ip.rowid DESC",
+		columns:   []string{"ip_address", "hostname", "asn_number", "asn_organization", "country_code", "country_name"},
+	},
+	{
+		entity:   SearchEntityReport,
+		ftsTable: "search_reports",
+		ftsSelect: `SELECT rp.id, rp.org_name, rp.report_id, rp.domain, rp.date_range_begin, rp.date_range_end, search_reports.rank
+			FROM search_reports JOIN reports rp ON rp.id = search_reports.rowid`,
+		likeFrom: `SELECT rp.id, rp.org_name, rp.report_id, rp.domain, rp.date_range_begin, rp.date_range_end
+			FROM reports rp`,
+		likeCols:  []string{"rp.org_name", "rp.domain", "rp.report_id"},
+		likeOrder: "rp.id DESC",
+		columns:   []string{"id", "org_name", "report_id", "domain", "date_range_begin", "date_range_end"},
+	},
+}
+
+// Search runs a case-insensitive substring search across source IPs, PTR
+// hostnames, ASN organizations, countries, header_from, DKIM/SPF domains and
+// reporter organizations. Hits are ranked by bm25 relevance when FTS5 is
+// available and the query is long enough for it, otherwise they are ordered
+// newest first and not ranked (see SearchGroup.Ranking). They are grouped by
+// entity type; limit and offset apply to each group. If entityType
+// is non-empty only that group is searched.
+func (r *Repository) Search(query, entityType string, limit, offset int) (map[string]*SearchGroup, error) {
+	query = strings.TrimSpace(query)
+	useFTS := r.searchEnabled() && len([]rune(query)) >= minFTSQueryLength
+
+	groups := make(map[string]*SearchGroup)
+	for _, spec := range searchSpecs {
+		if entityType != "" && entityType != spec.entity {
+			continue
+		}
+
+		var selectSQL, countSQL string
+		var args []interface{}
+		if useFTS {
+			match := ftsPhrase(query)
+			selectSQL = spec.ftsSelect + " WHERE " + spec.ftsTable + " MATCH ? ORDER BY " + spec.ftsTable + ".rank LIMIT ? OFFSET ?"
+			countSQL = "SELECT COUNT(*) FROM " + spec.ftsTable + " WHERE " + spec.ftsTable + " MATCH ?"
+			args = []interface{}{match}
+		} else {
+			var conditions []string
+			pattern := "%" + escapeLike(query) + "%"
+			for _, col := range spec.likeCols {
+				conditions = append(conditions, col+` LIKE ? ESCAPE '\'`)
+				args = append(args, pattern)
+			}
+			where := " WHERE " + strings.Join(conditions, " OR ")
+			selectSQL = spec.likeFrom + where + " ORDER BY " + spec.likeOrder + " LIMIT ? OFFSET ?"
+			countSQL = "SELECT COUNT(*) FROM (" + spec.likeFrom + where + ")"
+		}
+
+		group := &SearchGroup{Ranking: SearchRankingNewest, Hits: []SearchHit{}}
+		if useFTS {
+			group.Ranking = SearchRankingRelevance
+		}
+		if err := r.db.QueryRow(countSQL, args...).Scan(&group.Total); err != nil {
+			return nil, fmt.Errorf("failed to count %s search hits: %w", spec.entity, err)
+		}
+		if group.Total > 0 {
+			hits, err := r.querySearchHits(spec, useFTS, selectSQL, append(args, limit, offset)...)
+			if err != nil {
+				return nil, err
+			}
+			group.Hits = hits
+		}
+		groups[spec.entity] = group
+	}
+	return groups, nil
+}
+
+// querySearchHits runs a search query built from spec and converts its rows.
+// ranked tells whether the query selects the rank after the columns.
+func (r *Repository) querySearchHits(spec searchSpec, ranked bool, query string, args ...interface{}) ([]SearchHit, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search %s: %w", spec.entity, err)
+	}
+	defer rows.Close()
+
+	var hits []SearchHit
+	for rows.Next() {
+		values := make([]interface{}, len(spec.columns))
+		dest := make([]interface{}, len(spec.columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		var rank float64
+		if ranked {
+			dest = append(dest, &rank)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to scan %s search hit: %w", spec.entity, err)
+		}
+
+		hit := SearchHit{EntityType: spec.entity, Fields: make(map[string]interface{})}
+		if ranked {
+			hit.Rank = &rank
+		}
+		for i, col := range spec.columns {
+			value := values[i]
+			if b, ok := value.([]byte); ok {
+				value = string(b)
+			}
+			hit.Fields[col] = value
+		}
+		hit.ID = fmt.Sprint(hit.Fields[spec.columns[0]])
+		hits = append(hits, hit)
+	}
+	return hits, rows.Err()
+}
+
+// ftsPhrase quotes a user query as a single FTS5 phrase so that operators and
+// punctuation in it (e.g. the dots of an IP address) are matched literally.
+func ftsPhrase(query string) string {
+	return `"` + strings.ReplaceAll(query, `"`, `""`) + `"`
+}
+
+// escapeLike escapes LIKE wildcards in s for use with ESCAPE '\'.
+func escapeLike(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return replacer.Replace(s)
+}
@@ -0,0 +1,70 @@
+//go:build sqlite_fts5
+
+package db
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSearchFTS5(t *testing.T) {
+	repo := newTestRepository(t)
+	if !searchSupported(repo.db) {
+		t.Fatal("SQLite lacks FTS5 although built with the sqlite_fts5 tag")
+	}
+	recordIDs := saveSearchFixture(t, repo)
+
+	checkSearch(t, repo, recordIDs, SearchRankingRelevance)
+}
+
+func TestSearchIndexRebuild(t *testing.T) {
+	repo := newTestRepository(t)
+	recordIDs := saveSearchFixture(t, repo)
+
+	// As after the first start with FTS5 or restoring a snapshot taken
+	// without it
+	for _, table := range []string{"search_records", "search_ips", "search_reports"} {
+		if _, err := repo.db.Exec("DELETE FROM " + table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ensureSearchIndex(repo.db); err != nil {
+		t.Fatal(err)
+	}
+
+	checkSearch(t, repo, recordIDs, SearchRankingRelevance)
+}
+
+func TestSearchIndexCatchesUp(t *testing.T) {
+	repo := newTestRepository(t)
+	recordIDs := saveSearchFixture(t, repo)
+
+	// Store another report through a repository that does not maintain the
+	// index, as when running a build without FTS5
+	unindexed := NewRepository(repo.db)
+	unindexed.searchOnce.Do(func() {})
+	bundle := ReportBundle{
+		Report: Report{XMLHash: "hash3", OrgName: "Zeta Corp", ReportID: "z-1", Domain: "zeta.test"},
+		Records: []RecordBundle{
+			{Record: Record{SourceIP: "203.0.113.5", Count: 1, HeaderFrom: "zeta.test"}},
+		},
+	}
+	if err := unindexed.SaveReportBundle(&bundle); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ensureSearchIndex(repo.db); err != nil {
+		t.Fatal(err)
+	}
+	groups, err := repo.Search("zeta", "", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reports := groups[SearchEntityReport]; reports.Total != 1 || reports.Hits[0].ID != "3" {
+		t.Errorf("report hits = %+v, want report 3", reports.Hits)
+	}
+	if records := groups[SearchEntityRecord]; records.Total != 1 || records.Hits[0].ID != fmt.Sprint(bundle.Records[0].Record.ID) {
+		t.Errorf("record hits = %+v, want record %d", records.Hits, bundle.Records[0].Record.ID)
+	}
+	checkSearch(t, repo, recordIDs, SearchRankingRelevance)
+}
@@ -0,0 +1,214 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+// saveSearchFixture stores two reports with three records and the IP
+// information of two of their sources, returning the record IDs in the
+// order they were stored.
+func saveSearchFixture(t *testing.T, repo *Repository) []int64 {
+	t.Helper()
+	bundles := []ReportBundle{
+		{
+			Report: Report{XMLHash: "hash1", OrgName: "Google Inc.", ReportID: "g-1", Domain: "example.com"},
+			Records: []RecordBundle{
+				{
+					Record:      Record{SourceIP: "192.0.2.10", Count: 3, HeaderFrom: "example.com"},
+					AuthResults: []AuthResult{{AuthType: AuthTypeDKIM, Domain: "mailer.example.net", Result: "pass"}},
+				},
+				{
+					Record: Record{SourceIP: "198.51.100.20", Count: 1, HeaderFrom: "shop.example.com"},
+				},
+			},
+		},
+		{
+			Report: Report{XMLHash: "hash2", OrgName: "Mailer Service", ReportID: "m_100%", Domain: "example.org"},
+			Records: []RecordBundle{
+				{
+					Record:      Record{SourceIP: "192.0.2.11", Count: 2, HeaderFrom: "example.org"},
+					AuthResults: []AuthResult{{AuthType: AuthTypeSPF, Domain: "bounce.mailer.example.net", Result: "fail"}},
+				},
+			},
+		},
+	}
+	var recordIDs []int64
+	for i := range bundles {
+		if err := repo.SaveReportBundle(&bundles[i]); err != nil {
+			t.Fatal(err)
+		}
+		for _, rb := range bundles[i].Records {
+			recordIDs = append(recordIDs, rb.Record.ID)
+		}
+	}
+
+	for _, info := range []IPInfo{
+		{IPAddress: "192.0.2.10", CountryCode: "US", CountryName: "United States", ASNOrganization: "Google LLC", Hostname: "mail.google.com"},
+		{IPAddress: "198.51.100.20", CountryCode: "JP", CountryName: "Japan", ASNOrganization: "Example Net", Hostname: "relay.example.org"},
+	} {
+		if err := repo.SaveOrUpdateIPInfo(&info); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return recordIDs
+}
+
+// searchTest is a search and the IDs of the hits expected per entity type.
+type searchTest struct {
+	query, entityType string
+	want              map[string][]string
+}
+
+// searchTests returns searches of the fixture that both search modes have
+// to answer alike. Record IDs are given as their index in recordIDs.
+func searchTests(recordIDs []int64) []searchTest {
+	rec := func(indexes ...int) []string {
+		ids := []string{}
+		for _, i := range indexes {
+			ids = append(ids, fmt.Sprint(recordIDs[i]))
+		}
+		return ids
+	}
+	return []searchTest{
+		// Auth domains and reporter organizations
+		{"mailer", "", map[string][]string{
+			SearchEntityRecord: rec(2, 0), SearchEntityIP: {}, SearchEntityReport: {"2"},
+		}},
+		// Dots are matched literally
+		{"192.0.2.1", "", map[string][]string{
+			SearchEntityRecord: rec(2, 0), SearchEntityIP: {"192.0.2.10"}, SearchEntityReport: {},
+		}},
+		// Case-insensitive, across hostnames and AS organizations
+		{"GOOGLE", "", map[string][]string{
+			SearchEntityRecord: {}, SearchEntityIP: {"192.0.2.10"}, SearchEntityReport: {"1"},
+		}},
+		{"Japan", "", map[string][]string{
+			SearchEntityRecord: {}, SearchEntityIP: {"198.51.100.20"}, SearchEntityReport: {},
+		}},
+		// LIKE wildcards and FTS5 syntax are matched literally
+		{"m_100%", "", map[string][]string{
+			SearchEntityRecord: {}, SearchEntityIP: {}, SearchEntityReport: {"2"},
+		}},
+		{"00%", "", map[string][]string{
+			SearchEntityRecord: {}, SearchEntityIP: {}, SearchEntityReport: {"2"},
+		}},
+		{`"example" OR x`, "", map[string][]string{
+			SearchEntityRecord: {}, SearchEntityIP: {}, SearchEntityReport: {},
+		}},
+		{"example.org", SearchEntityRecord, map[string][]string{
+			SearchEntityRecord: rec(2),
+		}},
+		{"example.com", SearchEntityReport, map[string][]string{
+			SearchEntityReport: {"1"},
+		}},
+	}
+}
+
+// checkSearch runs the searchTests and checks that every group is ranked
+// as wantRanking says: by relevance with a rank per hit, or newest first
+// without one.
+func checkSearch(t *testing.T, repo *Repository, recordIDs []int64, wantRanking string) {
+	t.Helper()
+	for _, tt := range searchTests(recordIDs) {
+		groups, err := repo.Search(tt.query, tt.entityType, 10, 0)
+		if err != nil {
+			t.Fatalf("Search(%q): %v", tt.query, err)
+		}
+		if len(groups) != len(tt.want) {
+			t.Errorf("Search(%q, %q) returned %d groups, want %d", tt.query, tt.entityType, len(groups), len(tt.want))
+		}
+		for entity, want := range tt.want {
+			group := groups[entity]
+			if group == nil {
+				t.Errorf("Search(%q) has no %s group", tt.query, entity)
+				continue
+			}
+			if group.Ranking != wantRanking {
+				t.Errorf("Search(%q) %s ranking = %q, want %q", tt.query, entity, group.Ranking, wantRanking)
+			}
+
+			var ids []string
+			var prevRank float64
+			for i, hit := range group.Hits {
+				ids = append(ids, hit.ID)
+				switch {
+				case wantRanking == SearchRankingNewest && hit.Rank != nil:
+					t.Errorf("Search(%q) %s hit %s has rank %v, want none", tt.query, entity, hit.ID, *hit.Rank)
+				case wantRanking == SearchRankingRelevance && hit.Rank == nil:
+					t.Errorf("Search(%q) %s hit %s has no rank", tt.query, entity, hit.ID)
+				case wantRanking == SearchRankingRelevance && i > 0 && *hit.Rank < prevRank:
+					t.Errorf("Search(%q) %s hit %s ranked before a better hit", tt.query, entity, hit.ID)
+				case hit.Rank != nil:
+					prevRank = *hit.Rank
+				}
+			}
+			// Only the fallback order is predictable for every query
+			if wantRanking == SearchRankingRelevance {
+				sort.Strings(ids)
+				sort.Strings(want)
+			}
+			if group.Total != len(want) || fmt.Sprint(ids) != fmt.Sprint(want) {
+				t.Errorf("Search(%q) %s hits = %v of %d, want %v", tt.query, entity, ids, group.Total, want)
+			}
+		}
+	}
+}
+
+func TestSearchFallback(t *testing.T) {
+	repo := newTestRepository(t)
+	// Use the fallback even where SQLite has FTS5
+	repo.searchOnce.Do(func() {})
+	recordIDs := saveSearchFixture(t, repo)
+
+	checkSearch(t, repo, recordIDs, SearchRankingNewest)
+}
+
+func TestSearchShortQuery(t *testing.T) {
+	repo := newTestRepository(t)
+	saveSearchFixture(t, repo)
+
+	// Too short for the trigram index, so never ranked
+	groups, err := repo.Search("jp", "", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for entity, group := range groups {
+		if group.Ranking != SearchRankingNewest {
+			t.Errorf("%s ranking = %q, want %q", entity, group.Ranking, SearchRankingNewest)
+		}
+	}
+	if ips := groups[SearchEntityIP]; ips.Total != 1 || ips.Hits[0].ID != "198.51.100.20" || ips.Hits[0].Rank != nil {
+		t.Errorf("IP hits = %+v, want 198.51.100.20 without a rank", ips.Hits)
+	}
+}
+
+func TestSearchPaging(t *testing.T) {
+	repo := newTestRepository(t)
+	recordIDs := saveSearchFixture(t, repo)
+
+	var paged []string
+	for offset := 0; offset < 4; offset++ {
+		groups, err := repo.Search("example", SearchEntityRecord, 1, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		group := groups[SearchEntityRecord]
+		if group.Total != len(recordIDs) {
+			t.Errorf("total at offset %d = %d, want %d", offset, group.Total, len(recordIDs))
+		}
+		for _, hit := range group.Hits {
+			paged = append(paged, hit.ID)
+		}
+	}
+	sort.Strings(paged)
+	var want []string
+	for _, id := range recordIDs {
+		want = append(want, fmt.Sprint(id))
+	}
+	sort.Strings(want)
+	if fmt.Sprint(paged) != fmt.Sprint(want) {
+		t.Errorf("paged hits = %v, want each record once: %v", paged, want)
+	}
+}
@@ -0,0 +1,193 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrSessionNotFound is returned for changes to sessions that do not exist.
+var ErrSessionNotFound = errors.New("session not found")
+
+// sessionColumns are the columns read by scanSession, in order.
+const sessionColumns = "id, user_id, refresh_hash, user_agent, source_ip, created_at, last_used_at, expires_at"
+
+// scanSession scans a row of sessionColumns.
+func scanSession(row interface{ Scan(...interface{}) error }) (*Session, error) {
+	var session Session
+	err := row.Scan(&session.ID, &session.UserID, &session.RefreshHash, &session.UserAgent, &session.SourceIP,
+		&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// CreateSession stores a new session and sets its ID. Expired sessions of
+// the same user are deleted on the way.
+func (r *Repository) CreateSession(session *Session) error {
+	if _, err := r.db.Exec("DELETE FROM sessions WHERE user_id = ? AND expires_at <= ?", session.UserID, session.CreatedAt); err != nil {
+		return fmt.Errorf("failed to delete expired sessions: %w", err)
+	}
+	res, err := r.db.Exec(`
+		INSERT INTO sessions (user_id, refresh_hash, user_agent, source_ip, created_at, last_used_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, session.UserID, session.RefreshHash, session.UserAgent, session.SourceIP,
+		session.CreatedAt, session.LastUsedAt, session.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert session: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last insert ID for session: %w", err)
+	}
+	session.ID = id
+	return nil
+}
+
+// GetSessionByRefreshHash retrieves a session by the hash of its current
+// refresh token. It returns nil if no session has the hash.
+func (r *Repository) GetSessionByRefreshHash(hash string) (*Session, error) {
+	session, err := scanSession(r.db.QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE refresh_hash = ?", hash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query session: %w", err)
+	}
+	return session, nil
+}
+
+// RotateSession replaces the refresh token of a session and extends it,
+// unless its refresh token has changed since it was read. The old refresh
+// token is remembered, see RevokeSessionByUsedRefreshHash. It fails with
+// ErrSessionNotFound if the session is gone or was rotated concurrently.
+func (r *Repository) RotateSession(session *Session, oldHash string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction for rotating session: %w", err)
+	}
+	defer tx.Rollback() // No-op after commit
+
+	res, err := tx.Exec(`
+		UPDATE sessions SET refresh_hash = ?, user_agent = ?, source_ip = ?, last_used_at = ?, expires_at = ?
+		WHERE id = ? AND refresh_hash = ?
+	`, session.RefreshHash, session.UserAgent, session.SourceIP, session.LastUsedAt, session.ExpiresAt,
+		session.ID, oldHash)
+	if err != nil {
+		return fmt.Errorf("failed to rotate session %d: %w", session.ID, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows for session rotation: %w", err)
+	}
+	if n == 0 {
+		return ErrSessionNotFound
+	}
+	if _, err := tx.Exec("INSERT INTO used_refresh_tokens (refresh_hash, session_id) VALUES (?, ?)", oldHash, session.ID); err != nil {
+		return fmt.Errorf("failed to record used refresh token of session %d: %w", session.ID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit session rotation: %w", err)
+	}
+	return nil
+}
+
+// RevokeSessionByUsedRefreshHash deletes the session that a refresh token
+// with the given hash was rotated away from, and returns it, or nil if no
+// session used the hash.
+func (r *Repository) RevokeSessionByUsedRefreshHash(hash string) (*Session, error) {
+	session, err := scanSession(r.db.QueryRow(`
+		DELETE FROM sessions WHERE id = (SELECT session_id FROM used_refresh_tokens WHERE refresh_hash = ?)
+		RETURNING `+sessionColumns, hash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return session, nil
+}
+
+// IsSessionActive reports whether the session with the given ID exists,
+// belongs to the named user and has not expired at now.
+func (r *Repository) IsSessionActive(id int64, username string, now int64) (bool, error) {
+	var active bool
+	err := r.db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM sessions s JOIN users u ON u.id = s.user_id
+			WHERE s.id = ? AND u.username = ? AND s.expires_at > ?
+		)
+	`, id, username, now).Scan(&active)
+	if err != nil {
+		return false, fmt.Errorf("failed to check session %d: %w", id, err)
+	}
+	return active, nil
+}
+
+// ListSessions returns the unexpired sessions of a user at now, most recently
+// used first.
+func (r *Repository) ListSessions(userID, now int64) ([]Session, error) {
+	rows, err := r.db.Query("SELECT "+sessionColumns+" FROM sessions WHERE user_id = ? AND expires_at > ? ORDER BY last_used_at DESC, id DESC", userID, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session row: %w", err)
+		}
+		sessions = append(sessions, *session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating session rows: %w", err)
+	}
+	return sessions, nil
+}
+
+// DeleteSession deletes a session of a user. It fails with
+// ErrSessionNotFound if the user has no session with the ID.
+func (r *Repository) DeleteSession(userID, id int64) error {
+	res, err := r.db.Exec("DELETE FROM sessions WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete session %d: %w", id, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows for session deletion: %w", err)
+	}
+	if n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// DeleteSessionByRefreshHash deletes the session whose current refresh token
+// has the given hash, and returns it, or nil if there is none.
+func (r *Repository) DeleteSessionByRefreshHash(hash string) (*Session, error) {
+	session, err := scanSession(r.db.QueryRow("DELETE FROM sessions WHERE refresh_hash = ? RETURNING "+sessionColumns, hash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to delete session: %w", err)
+	}
+	return session, nil
+}
+
+// DeleteUserSessions deletes every session of a user except the one with the
+// ID keep (0 keeps none), and returns how many were deleted.
+func (r *Repository) DeleteUserSessions(userID, keep int64) (int64, error) {
+	res, err := r.db.Exec("DELETE FROM sessions WHERE user_id = ? AND id != ?", userID, keep)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete sessions of user %d: %w", userID, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get affected rows for session deletion: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,49 @@
+package db
+
+import "fmt"
+
+// GetSettings returns every stored application setting.
+func (r *Repository) GetSettings() ([]Setting, error) {
+	rows, err := r.db.Query(`SELECT key, value FROM settings ORDER BY key`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query settings: %w", err)
+	}
+	defer rows.Close()
+
+	var settings []Setting
+	for rows.Next() {
+		var s Setting
+		if err := rows.Scan(&s.Key, &s.Value); err != nil {
+			return nil, fmt.Errorf("failed to scan setting row: %w", err)
+		}
+		settings = append(settings, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating setting rows: %w", err)
+	}
+	return settings, nil
+}
+
+// SaveSettings stores the given settings in one transaction, replacing the
+// values of keys that already exist.
+func (r *Repository) SaveSettings(settings []Setting) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction for saving settings: %w", err)
+	}
+	defer tx.Rollback() // No-op after commit
+
+	for _, s := range settings {
+		if _, err := tx.Exec(`
+			INSERT INTO settings (key, value) VALUES (?, ?)
+			ON CONFLICT(key) DO UPDATE SET value = excluded.value
+		`, s.Key, s.Value); err != nil {
+			return fmt.Errorf("failed to save setting %s: %w", s.Key, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit settings: %w", err)
+	}
+	return nil
+}
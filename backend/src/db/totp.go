@@ -0,0 +1,144 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SetTOTPSecret stores the secret of a TOTP enrolment that has not been
+// confirmed yet. It replaces an earlier unconfirmed secret.
+func (r *Repository) SetTOTPSecret(userID int64, secret string) error {
+	_, err := r.db.Exec(`
+		UPDATE users SET totp_secret = ?, totp_enabled = 0, totp_last_step = 0 WHERE id = ?
+	`, secret, userID)
+	if err != nil {
+		return fmt.Errorf("failed to store TOTP secret of user %d: %w", userID, err)
+	}
+	return nil
+}
+
+// EnableTOTP confirms the TOTP enrolment of a user and replaces their
+// recovery codes with the given hashes, in one transaction. step is the time
+// step of the code that confirmed the enrolment, which cannot be used again.
+func (r *Repository) EnableTOTP(userID, step int64, codeHashes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction for enabling TOTP: %w", err)
+	}
+	defer tx.Rollback() // No-op after commit
+
+	if _, err := tx.Exec("UPDATE users SET totp_enabled = 1, totp_last_step = ? WHERE id = ?", step, userID); err != nil {
+		return fmt.Errorf("failed to enable TOTP of user %d: %w", userID, err)
+	}
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit TOTP enrolment: %w", err)
+	}
+	return nil
+}
+
+// DisableTOTP removes the TOTP secret and recovery codes of a user.
+func (r *Repository) DisableTOTP(userID int64) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction for disabling TOTP: %w", err)
+	}
+	defer tx.Rollback() // No-op after commit
+
+	if _, err := tx.Exec("UPDATE users SET totp_secret = '', totp_enabled = 0, totp_last_step = 0 WHERE id = ?", userID); err != nil {
+		return fmt.Errorf("failed to disable TOTP of user %d: %w", userID, err)
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes of user %d: %w", userID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit disabling TOTP: %w", err)
+	}
+	return nil
+}
+
+// UseTOTPStep records that a TOTP code of the given time step was accepted.
+// It reports false if a code of that or a later step was accepted before, in
+// which case the code must be rejected as a replay.
+func (r *Repository) UseTOTPStep(userID, step int64) (bool, error) {
+	res, err := r.db.Exec("UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?", step, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to record TOTP use of user %d: %w", userID, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get affected rows for TOTP use: %w", err)
+	}
+	return n == 1, nil
+}
+
+// ReplaceRecoveryCodes replaces all recovery codes of a user with the given
+// hashes.
+func (r *Repository) ReplaceRecoveryCodes(userID int64, codeHashes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction for replacing recovery codes: %w", err)
+	}
+	defer tx.Rollback() // No-op after commit
+
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit recovery codes: %w", err)
+	}
+	return nil
+}
+
+// replaceRecoveryCodes replaces the recovery codes of a user within tx.
+func replaceRecoveryCodes(tx *sql.Tx, userID int64, codeHashes []string) error {
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes of user %d: %w", userID, err)
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, hash); err != nil {
+			return fmt.Errorf("failed to insert recovery code: %w", err)
+		}
+	}
+	return nil
+}
+
+// UnusedRecoveryCodes returns the recovery codes of a user that have not been
+// used.
+func (r *Repository) UnusedRecoveryCodes(userID int64) ([]RecoveryCode, error) {
+	rows, err := r.db.Query("SELECT id, user_id, code_hash FROM recovery_codes WHERE user_id = ? AND used_at IS NULL ORDER BY id", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query recovery codes: %w", err)
+	}
+	defer rows.Close()
+
+	var codes []RecoveryCode
+	for rows.Next() {
+		var code RecoveryCode
+		if err := rows.Scan(&code.ID, &code.UserID, &code.CodeHash); err != nil {
+			return nil, fmt.Errorf("failed to scan recovery code row: %w", err)
+		}
+		codes = append(codes, code)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating recovery code rows: %w", err)
+	}
+	return codes, nil
+}
+
+// UseRecoveryCode marks a recovery code as used. It reports false if it was
+// used already, by a concurrent login.
+func (r *Repository) UseRecoveryCode(id int64) (bool, error) {
+	res, err := r.db.Exec("UPDATE recovery_codes SET used_at = ? WHERE id = ? AND used_at IS NULL", time.Now().Unix(), id)
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code %d: %w", id, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get affected rows for recovery code use: %w", err)
+	}
+	return n == 1, nil
+}
@@ -1,14 +1,44 @@
 package db
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
+	sqlite3 "github.com/mattn/go-sqlite3"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// CreateUser creates a new user in the database.
-func (r *Repository) CreateUser(username, password string) (*User, error) {
+var (
+	// ErrUserNotFound is returned for changes to users that do not exist.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrUsernameTaken is returned when creating a user whose name exists.
+	ErrUsernameTaken = errors.New("username already exists")
+	// ErrLastAdmin is returned for changes that would leave no enabled admin.
+	ErrLastAdmin = errors.New("the last enabled admin cannot be removed, disabled or demoted")
+)
+
+// userColumns are the columns read by scanUser, in order.
+const userColumns = "id, username, password_hash, role, created_at, disabled, must_change_password, totp_secret, totp_enabled, totp_last_step, auth_source, external_id"
+
+// scanUser scans a row of userColumns.
+func scanUser(row interface{ Scan(...interface{}) error }) (*User, error) {
+	var user User
+	err := row.Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role, &user.CreatedAt,
+		&user.Disabled, &user.MustChangePassword, &user.TOTPSecret, &user.TOTPEnabled, &user.TOTPLastStep,
+		&user.AuthSource, &user.ExternalID)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// CreateUser creates a new user with the given role in the database.
+func (r *Repository) CreateUser(username, password, role string) (*User, error) {
+	if !IsValidRole(role) {
+		return nil, fmt.Errorf("invalid role %q", role)
+	}
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return nil, fmt.Errorf("failed to hash password: %w", err)
@@ -17,42 +47,204 @@ func (r *Repository) CreateUser(username, password string) (*User, error) {
 	user := &User{
 		Username:     username,
 		PasswordHash: string(hashedPassword),
+		Role:         role,
 		CreatedAt:    time.Now().Unix(),
+		AuthSource:   AuthSourceLocal,
+	}
+	if err := r.insertUser(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// CreateExternalUser creates a user authenticated by an identity provider.
+// The user has no password hash, so password logins always fail for them.
+func (r *Repository) CreateExternalUser(username, role, authSource, externalID string) (*User, error) {
+	if !IsValidRole(role) {
+		return nil, fmt.Errorf("invalid role %q", role)
+	}
+	user := &User{
+		Username:   username,
+		Role:       role,
+		CreatedAt:  time.Now().Unix(),
+		AuthSource: authSource,
+		ExternalID: externalID,
+	}
+	if err := r.insertUser(user); err != nil {
+		return nil, err
 	}
+	return user, nil
+}
 
+// insertUser inserts user and sets its ID.
+func (r *Repository) insertUser(user *User) error {
 	stmt, err := r.db.Prepare(`
-		INSERT INTO users (username, password_hash, created_at)
-		VALUES (?, ?, ?)
+		INSERT INTO users (username, password_hash, role, created_at, auth_source, external_id)
+		VALUES (?, ?, ?, ?, ?, ?)
 	`)
 	if err != nil {
-		return nil, fmt.Errorf("failed to prepare statement for creating user: %w", err)
+		return fmt.Errorf("failed to prepare statement for creating user: %w", err)
 	}
 	defer stmt.Close()
 
-	res, err := stmt.Exec(user.Username, user.PasswordHash, user.CreatedAt)
+	res, err := stmt.Exec(user.Username, user.PasswordHash, user.Role, user.CreatedAt, user.AuthSource, user.ExternalID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute statement for creating user: %w", err)
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return ErrUsernameTaken
+		}
+		return fmt.Errorf("failed to execute statement for creating user: %w", err)
 	}
 
 	id, err := res.LastInsertId()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get last insert ID for user: %w", err)
+		return fmt.Errorf("failed to get last insert ID for user: %w", err)
 	}
 	user.ID = id
+	return nil
+}
+
+// GetUserByExternalID retrieves the user with the given identity at an
+// identity provider. It returns nil if there is no such user.
+func (r *Repository) GetUserByExternalID(authSource, externalID string) (*User, error) {
+	user, err := scanUser(r.db.QueryRow("SELECT "+userColumns+" FROM users WHERE auth_source = ? AND external_id = ?", authSource, externalID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // User not found
+		}
+		return nil, fmt.Errorf("failed to query user by external ID: %w", err)
+	}
+	return user, nil
+}
+
+// GetUserByID retrieves a user by their ID. It returns nil if the user does
+// not exist.
+func (r *Repository) GetUserByID(id int64) (*User, error) {
+	user, err := scanUser(r.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // User not found
+		}
+		return nil, fmt.Errorf("failed to query user %d: %w", id, err)
+	}
 	return user, nil
 }
 
-// DeleteUser deletes a user by their ID.
+// ListUsers returns every user, ordered by username.
+func (r *Repository) ListUsers() ([]User, error) {
+	rows, err := r.db.Query("SELECT " + userColumns + " FROM users ORDER BY username")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user row: %w", err)
+		}
+		users = append(users, *user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating user rows: %w", err)
+	}
+	return users, nil
+}
+
+// ChangeUser applies change to the user with the given ID and saves the
+// result, in one transaction. It fails with ErrLastAdmin if the change would
+// leave no enabled admin, and with ErrUserNotFound if the user does not exist.
+// An error returned by change is returned as is.
+func (r *Repository) ChangeUser(id int64, change func(user *User) error) (*User, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction for changing user: %w", err)
+	}
+	defer tx.Rollback() // No-op after commit
+
+	user, err := scanUser(tx.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to query user %d: %w", id, err)
+	}
+	wasAdmin := isEnabledAdmin(user)
+
+	if err := change(user); err != nil {
+		return nil, err
+	}
+	if !IsValidRole(user.Role) {
+		return nil, fmt.Errorf("invalid role %q", user.Role)
+	}
+	if wasAdmin && !isEnabledAdmin(user) {
+		if err := ensureOtherAdmin(tx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE users
+		SET username = ?, password_hash = ?, role = ?, disabled = ?, must_change_password = ?
+		WHERE id = ?
+	`, user.Username, user.PasswordHash, user.Role, user.Disabled, user.MustChangePassword, id); err != nil {
+		return nil, fmt.Errorf("failed to update user %d: %w", id, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit user change: %w", err)
+	}
+	return user, nil
+}
+
+// DeleteUser deletes a user by their ID. It fails with ErrLastAdmin for the
+// last enabled admin, and with ErrUserNotFound if the user does not exist.
 func (r *Repository) DeleteUser(userID int64) error {
-	stmt, err := r.db.Prepare("DELETE FROM users WHERE id = ?")
+	tx, err := r.db.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to prepare statement for deleting user: %w", err)
+		return fmt.Errorf("failed to begin transaction for deleting user: %w", err)
 	}
-	defer stmt.Close()
+	defer tx.Rollback() // No-op after commit
 
-	_, err = stmt.Exec(userID)
+	user, err := scanUser(tx.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", userID))
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrUserNotFound
+		}
+		return fmt.Errorf("failed to query user %d: %w", userID, err)
+	}
+	if isEnabledAdmin(user) {
+		if err := ensureOtherAdmin(tx, userID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM users WHERE id = ?", userID); err != nil {
 		return fmt.Errorf("failed to execute statement for deleting user: %w", err)
 	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit user deletion: %w", err)
+	}
+	return nil
+}
+
+// isEnabledAdmin reports whether user can act as an admin.
+func isEnabledAdmin(user *User) bool {
+	return user.Role == RoleAdmin && !user.Disabled
+}
+
+// ensureOtherAdmin returns ErrLastAdmin unless an enabled admin other than
+// the user with the given ID exists.
+func ensureOtherAdmin(tx *sql.Tx, id int64) error {
+	var others int
+	err := tx.QueryRow(`
+		SELECT COUNT(*) FROM users WHERE role = ? AND disabled = 0 AND id != ?
+	`, RoleAdmin, id).Scan(&others)
+	if err != nil {
+		return fmt.Errorf("failed to count admins: %w", err)
+	}
+	if others == 0 {
+		return ErrLastAdmin
+	}
 	return nil
 }
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+// createTestUser creates a user and fails the test on error.
+func createTestUser(t *testing.T, repo *Repository, username, role string) *User {
+	t.Helper()
+	user, err := repo.CreateUser(username, "password", role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestChangeUserLastAdmin(t *testing.T) {
+	repo := newTestRepository(t)
+	admin := createTestUser(t, repo, "admin", RoleAdmin)
+	createTestUser(t, repo, "viewer", RoleViewer)
+
+	changes := map[string]func(user *User) error{
+		"demote":  func(user *User) error { user.Role = RoleAnalyst; return nil },
+		"disable": func(user *User) error { user.Disabled = true; return nil },
+	}
+	for name, change := range changes {
+		if _, err := repo.ChangeUser(admin.ID, change); !errors.Is(err, ErrLastAdmin) {
+			t.Errorf("%s the last admin: error = %v, want ErrLastAdmin", name, err)
+		}
+	}
+	if user, err := repo.GetUserByID(admin.ID); err != nil || user.Role != RoleAdmin || user.Disabled {
+		t.Fatalf("last admin after refused changes = %+v, %v, want an enabled admin", user, err)
+	}
+
+	// Changes that keep the admin are allowed
+	if _, err := repo.ChangeUser(admin.ID, func(user *User) error { user.Username = "root"; return nil }); err != nil {
+		t.Errorf("renaming the last admin: %v", err)
+	}
+
+	// A disabled admin does not count as another admin
+	other := createTestUser(t, repo, "other", RoleAdmin)
+	if _, err := repo.ChangeUser(other.ID, changes["disable"]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.ChangeUser(admin.ID, changes["demote"]); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("demote with only a disabled admin left: error = %v, want ErrLastAdmin", err)
+	}
+
+	// With another enabled admin, either may be demoted
+	if _, err := repo.ChangeUser(other.ID, func(user *User) error { user.Disabled = false; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	user, err := repo.ChangeUser(admin.ID, changes["demote"])
+	if err != nil {
+		t.Fatalf("demote with another admin: %v", err)
+	}
+	if user.Role != RoleAnalyst {
+		t.Errorf("role after demotion = %q, want %q", user.Role, RoleAnalyst)
+	}
+	if _, err := repo.ChangeUser(other.ID, changes["demote"]); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("demote the new last admin: error = %v, want ErrLastAdmin", err)
+	}
+}
+
+func TestDeleteUserLastAdmin(t *testing.T) {
+	repo := newTestRepository(t)
+	admin := createTestUser(t, repo, "admin", RoleAdmin)
+	viewer := createTestUser(t, repo, "viewer", RoleViewer)
+
+	if err := repo.DeleteUser(admin.ID); !errors.Is(err, ErrLastAdmin) {
+		t.Fatalf("DeleteUser(last admin) error = %v, want ErrLastAdmin", err)
+	}
+	if user, err := repo.GetUserByID(admin.ID); err != nil || user == nil {
+		t.Fatalf("last admin after refused deletion = %+v, %v, want the user", user, err)
+	}
+	if err := repo.DeleteUser(viewer.ID); err != nil {
+		t.Errorf("DeleteUser(viewer): %v", err)
+	}
+
+	other := createTestUser(t, repo, "other", RoleAdmin)
+	if err := repo.DeleteUser(admin.ID); err != nil {
+		t.Fatalf("DeleteUser with another admin: %v", err)
+	}
+	if user, err := repo.GetUserByID(admin.ID); err != nil || user != nil {
+		t.Errorf("deleted admin = %+v, %v, want nil", user, err)
+	}
+	if err := repo.DeleteUser(other.ID); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("DeleteUser(new last admin) error = %v, want ErrLastAdmin", err)
+	}
+
+	if err := repo.DeleteUser(other.ID + 1); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("DeleteUser(missing) error = %v, want ErrUserNotFound", err)
+	}
+}
@@ -28,12 +28,16 @@ type Resolver struct {
 	// AsnDB  *maxminddb.Reader // Removed as ASN info is in city DB
 	dataDir string // Absolute path to the ip_geo data directory
 	mu     sync.RWMutex // Protects access to CityDB and AsnDB
+
+	dnsMu      sync.RWMutex // Protects the DNS options
+	dnsLookups bool
+	dnsTimeout time.Duration
 }
 
 // NewResolver creates and initializes a new IP Geo Resolver.
 // It attempts to load the MMDB files from the specified data directory.
 func NewResolver(dataDir string) (*Resolver, error) { // Added dataDir parameter
-	r := &Resolver{dataDir: dataDir} // Initialize dataDir
+	r := &Resolver{dataDir: dataDir, dnsLookups: true, dnsTimeout: 5 * time.Second} // Initialize dataDir
 	err := r.LoadDatabases()
 	if err != nil {
 		log.Printf("Warning: Failed to load IP Geo databases: %v. IP resolution may be limited.", err)
@@ -42,6 +46,14 @@ func NewResolver(dataDir string) (*Resolver, error) { // Added dataDir parameter
 	return r, nil
 }
 
+// SetDNSOptions enables or disables reverse DNS lookups and sets their timeout.
+func (r *Resolver) SetDNSOptions(lookups bool, timeout time.Duration) {
+	r.dnsMu.Lock()
+	defer r.dnsMu.Unlock()
+	r.dnsLookups = lookups
+	r.dnsTimeout = timeout
+}
+
 // LoadDatabases attempts to load the IP Geo MMDB files.
 func (r *Resolver) LoadDatabases() error {
 	r.mu.Lock()
@@ -143,8 +155,16 @@ func (r *Resolver) ResolveIP(ipStr string) (*db.IPInfo, error) {
 		log.Printf("No IP Geo database loaded for lookup.")
 	}
 
-	// Resolve Hostname (PTR), ReversedHostname, and ApexDomain
-	hostname := util.ResolvePTR(ipStr)
+	// Resolve Hostname (PTR), ReversedHostname, and ApexDomain. With lookups
+	// disabled they stay "N/A", which SaveOrUpdateIPInfo treats as unknown:
+	// the stored PTR data and its snapshot are kept as they are.
+	r.dnsMu.RLock()
+	lookups, timeout := r.dnsLookups, r.dnsTimeout
+	r.dnsMu.RUnlock()
+	hostname := "N/A"
+	if lookups {
+		hostname = util.ResolvePTR(ipStr, timeout)
+	}
 	ipInfo.Hostname = hostname
 	if hostname != "N/A" && hostname != "" {
 		ipInfo.ReversedHostname = util.ReverseDomain(hostname)
@@ -0,0 +1,57 @@
+package ip_geo
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dmarc-report-analyzer/backend/src/db"
+)
+
+func TestDisabledDNSLookupsKeepPTRData(t *testing.T) {
+	database, err := db.InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	repo := db.NewRepository(database)
+
+	stored := &db.IPInfo{
+		IPAddress: "192.0.2.1", CountryCode: "US", CountryName: "United States", ASNNumber: 64496,
+		ASNOrganization: "Example Networks", Hostname: "mail.example.com", ReversedHostname: "com.example.mail",
+		ApexDomain: "example.com",
+	}
+	if err := repo.SaveOrUpdateIPInfo(stored); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without an IP database nothing else is resolved either
+	resolver, err := NewResolver(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver.SetDNSOptions(false, time.Second)
+	info, err := resolver.ResolveIP(stored.IPAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SaveOrUpdateIPInfo(info); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repo.GetIPInfo(stored.IPAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Hostname != stored.Hostname || got.ReversedHostname != stored.ReversedHostname || got.ApexDomain != stored.ApexDomain {
+		t.Errorf("PTR data after a lookup without DNS = %q, %q, %q, want %q, %q, %q",
+			got.Hostname, got.ReversedHostname, got.ApexDomain, stored.Hostname, stored.ReversedHostname, stored.ApexDomain)
+	}
+	history, err := repo.GetIPInfoHistory(stored.IPAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Hostname != stored.Hostname || history[0].ValidTo != 0 {
+		t.Errorf("history = %+v, want the original snapshot only", history)
+	}
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"embed"
+	"encoding/json"
 	"fmt"
 	"io/fs"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
@@ -15,9 +19,14 @@ import (
 	"dmarc-report-analyzer/backend/src/api"
 	"dmarc-report-analyzer/backend/src/auth"
 	"dmarc-report-analyzer/backend/src/config"
+	"dmarc-report-analyzer/backend/src/core/archive"
+	"dmarc-report-analyzer/backend/src/core/events"
 	"dmarc-report-analyzer/backend/src/core/parser"
+	"dmarc-report-analyzer/backend/src/core/retention"
+	"dmarc-report-analyzer/backend/src/core/settings"
 	"dmarc-report-analyzer/backend/src/db"
 	"dmarc-report-analyzer/backend/src/ip_geo"
+	"dmarc-report-analyzer/backend/src/util"
 )
 
 //go:embed static_frontend_dist/*
@@ -30,38 +39,108 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	// 2. Initialize Database
+	database, err := db.InitDB(cfg.DBPath)
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+	dbRepo := db.NewRepository(database)
+
 	// Handle --import-ip-db CLI option
 	if cfg.ImportIPDBFile != "" {
 		log.Printf("Attempting to import IP database from: %s", cfg.ImportIPDBFile)
 		if err := ip_geo.ImportMMDBFile(cfg.ImportIPDBFile, cfg.IPGeoDBPath); err != nil {
 			log.Fatalf("Failed to import IP database: %v", err)
 		}
+		recordCLIAudit(dbRepo, "ipdb.import", cfg.ImportIPDBFile, nil)
 		log.Println("IP database imported successfully. Exiting.")
 		os.Exit(0) // Exit after import
 	}
 
-	// 2. Initialize Database
-	database, err := db.InitDB(cfg.DBPath)
-	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
-	}
-	defer database.Close()
-	dbRepo := db.NewRepository(database)
+	passwordPolicy := newPasswordPolicy(cfg)
 
 	// Handle --create-user CLI option
 	if cfg.CreateUserUsername != "" {
 		if cfg.CreateUserPassword == "" {
 			log.Fatalf("Error: --password must be provided when using --create-user.")
 		}
+		if !db.IsValidRole(cfg.CreateUserRole) {
+			log.Fatalf("Error: --role must be one of %s.", strings.Join(db.Roles, ", "))
+		}
+		if err := passwordPolicy.Check(cfg.CreateUserUsername, cfg.CreateUserPassword); err != nil {
+			log.Fatalf("Error: %v.", err)
+		}
 		log.Printf("Attempting to create user: %s", cfg.CreateUserUsername)
-		_, err := dbRepo.CreateUser(cfg.CreateUserUsername, cfg.CreateUserPassword)
+		_, err := dbRepo.CreateUser(cfg.CreateUserUsername, cfg.CreateUserPassword, cfg.CreateUserRole)
 		if err != nil {
 			log.Fatalf("Failed to create user %s: %v", cfg.CreateUserUsername, err)
 		}
-		log.Printf("User %s created successfully. Exiting.", cfg.CreateUserUsername)
+		recordCLIAudit(dbRepo, "user.create", "user:"+cfg.CreateUserUsername, map[string]string{"role": cfg.CreateUserRole})
+		log.Printf("User %s created successfully with role %s. Exiting.", cfg.CreateUserUsername, cfg.CreateUserRole)
 		os.Exit(0) // Exit after user creation
 	}
 
+	// Handle the API token CLI options
+	if cfg.CreateTokenName != "" || cfg.ListTokensUser != "" || cfg.RevokeTokenID != 0 {
+		authService := auth.NewAuthService(dbRepo, cfg.JWTSecret)
+		var err error
+		switch {
+		case cfg.CreateTokenName != "":
+			err = createAPIToken(authService, cfg.TokenUser, cfg.CreateTokenName, cfg.TokenScopes, cfg.TokenDays)
+		case cfg.ListTokensUser != "":
+			err = listAPITokens(dbRepo, cfg.ListTokensUser)
+		default:
+			err = dbRepo.DeleteAPIToken(cfg.RevokeTokenID)
+			if err == nil {
+				recordCLIAudit(dbRepo, "token.revoke", "token:"+strconv.FormatInt(cfg.RevokeTokenID, 10), nil)
+				log.Printf("API token %d revoked. Exiting.", cfg.RevokeTokenID)
+			}
+		}
+		if err != nil {
+			log.Fatalf("Failed to manage API tokens: %v", err)
+		}
+		os.Exit(0) // Exit after token management
+	}
+
+	// Handle --backup CLI option
+	if cfg.BackupFile != "" {
+		log.Printf("Writing database snapshot to: %s", cfg.BackupFile)
+		if err := dbRepo.BackupToFile(context.Background(), cfg.BackupFile, db.IsCompressedBackupPath(cfg.BackupFile)); err != nil {
+			log.Fatalf("Failed to back up database: %v", err)
+		}
+		recordCLIAudit(dbRepo, "backup.create", cfg.BackupFile, nil)
+		log.Println("Database snapshot written successfully. Exiting.")
+		os.Exit(0) // Exit after backup
+	}
+
+	// Handle --restore CLI option
+	if cfg.RestoreFile != "" {
+		log.Printf("Restoring database from snapshot: %s", cfg.RestoreFile)
+		if err := dbRepo.RestoreFromFile(context.Background(), cfg.RestoreFile); err != nil {
+			log.Fatalf("Failed to restore database: %v", err)
+		}
+		recordCLIAudit(dbRepo, "backup.restore", cfg.RestoreFile, nil)
+		log.Println("Database restored successfully. Exiting.")
+		os.Exit(0) // Exit after restore
+	}
+
+	// Handle --export-data CLI option
+	if cfg.ExportDataFile != "" {
+		if err := exportDataArchive(dbRepo, cfg.ExportDataFile, cfg.ExportFrom, cfg.ExportTo); err != nil {
+			log.Fatalf("Failed to export data: %v", err)
+		}
+		os.Exit(0) // Exit after export
+	}
+
+	// Handle --import-data CLI option
+	if cfg.ImportDataFile != "" {
+		if err := importDataArchive(dbRepo, cfg.ImportDataFile); err != nil {
+			log.Fatalf("Failed to import data: %v", err)
+		}
+		os.Exit(0) // Exit after import
+	}
+
 	// 3. Initialize IP Geo Resolver
 	ipResolver, err := ip_geo.NewResolver(cfg.IPGeoDBPath)
 	if err != nil {
@@ -72,22 +151,84 @@ func main() {
 		log.Println("Warning: IP Geo databases are not loaded. IP resolution will not be available.")
 	}
 
+	// Load runtime settings and apply them now and whenever they change
+	settingsService, err := settings.NewService(dbRepo)
+	if err != nil {
+		log.Fatalf("Failed to load settings: %v", err)
+	}
+	retentionEnforcer := retention.NewEnforcer(dbRepo)
+
 	// 4. Initialize Auth Service
 	authService := auth.NewAuthService(dbRepo, cfg.JWTSecret)
+	if cfg.OIDCIssuer != "" {
+		authService.OIDC = newOIDCProvider(cfg)
+		log.Printf("Single sign-on enabled with OpenID Connect provider %s", cfg.OIDCIssuer)
+	}
+	authService.PasswordLoginDisabled = cfg.DisablePasswordLogin
+	authService.PasswordPolicy = passwordPolicy
+	authService.Throttle = auth.NewLoginThrottle(auth.ThrottleConfig{
+		AccountLockoutThreshold: cfg.LoginLockoutThreshold,
+		IPLockoutThreshold:      cfg.LoginIPLockoutThreshold,
+		LockoutDuration:         cfg.LoginLockoutDuration,
+		BaseDelay:               auth.DefaultThrottleConfig.BaseDelay,
+	})
+	if cfg.LDAPURL != "" {
+		authService.AddAuthenticator(newLDAPAuthenticator(cfg))
+		log.Printf("Password logins enabled for LDAP server %s", cfg.LDAPURL)
+	}
+
+	settingsService.OnChange(func(s settings.Settings) {
+		ipResolver.SetDNSOptions(s.DNSLookups, time.Duration(s.DNSTimeoutSeconds)*time.Second)
+		retentionEnforcer.SetDays(s.RetentionDays)
+		authService.SetAdminTOTPRequired(s.RequireAdminTOTP)
+	})
+	go retentionEnforcer.Run(context.Background())
 
 	// 5. Setup HTTP Router
 	router := mux.NewRouter()
 
 	// Initialize API handlers
-	reportProcessor := parser.NewReportProcessor(dbRepo, ipResolver)
+	eventHub := events.NewHub()
+	reportProcessor := parser.NewReportProcessor(dbRepo, ipResolver, eventHub)
+	if err := reportProcessor.BackfillAuthResults(); err != nil {
+		log.Printf("Warning: Failed to backfill auth results: %v", err)
+	}
 	reportsAPI := api.NewReportsAPI(reportProcessor, dbRepo)
 	authAPI := api.NewAuthAPI(authService, dbRepo)
 	usersAPI := api.NewUsersAPI(authService, dbRepo)
+	tokensAPI := api.NewTokensAPI(authService, dbRepo)
+	totpAPI := api.NewTOTPAPI(authService, dbRepo)
+	oidcAPI := api.NewOIDCAPI(authService, dbRepo)
+	backupAPI := api.NewBackupAPI(dbRepo, cfg.BackupDir)
+	dataAPI := api.NewDataAPI(archive.NewArchiver(dbRepo))
+	searchAPI := api.NewSearchAPI(dbRepo)
+	recordsAPI := api.NewRecordsAPI(dbRepo)
+	ipInfoAPI := api.NewIPInfoAPI(dbRepo, reportProcessor)
+	eventsAPI := api.NewEventsAPI(eventHub)
+	settingsAPI := api.NewSettingsAPI(settingsService, dbRepo)
+	auditAPI := api.NewAuditAPI(dbRepo)
 
 	// Register API routes
 	api.RegisterReportRoutes(router, reportsAPI)
 	api.RegisterAuthRoutes(router, authAPI)
 	api.RegisterUserRoutes(router, usersAPI)
+	api.RegisterTokenRoutes(router, tokensAPI)
+	api.RegisterTOTPRoutes(router, totpAPI)
+	api.RegisterOIDCRoutes(router, oidcAPI)
+	if cfg.BackupAPI {
+		// Snapshots hold every user and password hash, and a restore
+		// replaces all data, so these routes are opt-in.
+		api.RegisterBackupRoutes(router, backupAPI)
+	}
+	api.RegisterDataRoutes(router, dataAPI)
+	api.RegisterSearchRoutes(router, searchAPI)
+	api.RegisterRecordRoutes(router, recordsAPI)
+	api.RegisterIPInfoRoutes(router, ipInfoAPI)
+	api.RegisterEventRoutes(router, eventsAPI)
+	api.RegisterSettingsRoutes(router, settingsAPI)
+	api.RegisterAuditRoutes(router, auditAPI)
+	api.RegisterOpenAPIRoutes(router)
+	api.RegisterErrorHandlers(router)
 
 	// static_frontend_dist サブディレクトリをルートとして扱う
 	staticFiles, err := fs.Sub(embeddedFiles, "static_frontend_dist")
@@ -96,11 +237,15 @@ func main() {
 	}
 
 	// "/" を static_frontend_dist にマッピング
-	router.PathPrefix("/").Handler(spaHandler(staticFiles))
+	// /api/ is left to the router's error handlers. The path is checked
+	// before anything else so that a method mismatch on an API route is kept.
+	router.MatcherFunc(func(r *http.Request, _ *mux.RouteMatch) bool {
+		return !strings.HasPrefix(r.URL.Path, "/api/")
+	}).Handler(spaHandler(staticFiles))
 
 	// Configure CORS middleware
 	c := cors.AllowAll() // For development, allow all origins
-	handler := c.Handler(router)
+	handler := c.Handler(api.RequestID(api.Recover(api.Authenticate(authService)(router))))
 
 	// サーバー起動
 	addr := fmt.Sprintf(":%d", cfg.Port)
@@ -108,6 +253,188 @@ func main() {
 	log.Fatal(http.ListenAndServe(addr, handler))
 }
 
+// recordCLIAudit records an action of a CLI option in the audit log, with the
+// actor cli. Failures are logged but do not fail the action.
+func recordCLIAudit(dbRepo *db.Repository, action, target string, details interface{}) {
+	entry := &db.AuditEntry{Actor: "cli", Action: action, Target: target}
+	if details != nil {
+		encoded, err := json.Marshal(details)
+		if err != nil {
+			log.Printf("Failed to encode audit details for %s: %v", action, err)
+		} else {
+			entry.Details = string(encoded)
+		}
+	}
+	if err := dbRepo.AddAuditEntry(entry); err != nil {
+		log.Printf("Failed to record audit entry for %s on %s: %v", action, target, err)
+	}
+}
+
+// exportDataArchive writes a ZIP data archive to path for the --export-data CLI option.
+func exportDataArchive(dbRepo *db.Repository, path, from, to string) error {
+	begin, end, err := util.ParseDateRange(from, to)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	summary, err := archive.NewArchiver(dbRepo).Export(out, begin, end)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", path, err)
+	}
+	recordCLIAudit(dbRepo, "data.export", path, map[string]interface{}{"start_date": from, "end_date": to, "summary": summary})
+	log.Printf("Exported %d reports (%d records, %d IP entries) to %s. Exiting.", summary.Reports, summary.Records, summary.IPInfo, path)
+	return nil
+}
+
+// importDataArchive merges the ZIP data archive at path for the --import-data CLI option.
+func importDataArchive(dbRepo *db.Repository, path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	summary, err := archive.NewArchiver(dbRepo).Import(in, info.Size())
+	if err != nil {
+		return err
+	}
+	for _, msg := range summary.Errors {
+		log.Printf("Import error: %s", msg)
+	}
+	recordCLIAudit(dbRepo, "data.import", path, summary)
+	log.Printf("Imported %d reports (%d records), skipped %d duplicates, %d failed, %d IP entries updated. Exiting.",
+		summary.ReportsImported, summary.RecordsImported, summary.ReportsSkipped, summary.ReportsFailed, summary.IPInfoImported)
+	return nil
+}
+
+// newOIDCProvider creates the single sign-on provider of cfg. Invalid roles
+// in the role mapping are fatal.
+func newOIDCProvider(cfg *config.Config) *auth.OIDCProvider {
+	for value, role := range cfg.OIDCRoleMap {
+		if !db.IsValidRole(role) {
+			log.Fatalf("Invalid role %q for %q in --oidc-role-map; use admin, analyst or viewer", role, value)
+		}
+	}
+	if cfg.OIDCDefaultRole != "" && !db.IsValidRole(cfg.OIDCDefaultRole) {
+		log.Fatalf("Invalid --oidc-default-role %q; use admin, analyst or viewer", cfg.OIDCDefaultRole)
+	}
+	return auth.NewOIDCProvider(auth.OIDCConfig{
+		Issuer:        cfg.OIDCIssuer,
+		ClientID:      cfg.OIDCClientID,
+		ClientSecret:  cfg.OIDCClientSecret,
+		RedirectURL:   cfg.OIDCRedirectURL,
+		Scopes:        cfg.OIDCScopes,
+		UsernameClaim: cfg.OIDCUsernameClaim,
+		RoleClaim:     cfg.OIDCRoleClaim,
+		RoleMap:       cfg.OIDCRoleMap,
+		DefaultRole:   cfg.OIDCDefaultRole,
+	})
+}
+
+// newPasswordPolicy creates the password policy of cfg. A breached password
+// list that cannot be read is fatal.
+func newPasswordPolicy(cfg *config.Config) *auth.PasswordPolicy {
+	policy := auth.NewPasswordPolicy(cfg.PasswordMinLength)
+	if cfg.BreachedPasswordsFile != "" {
+		n, err := policy.LoadBreachedPasswords(cfg.BreachedPasswordsFile)
+		if err != nil {
+			log.Fatalf("Failed to load breached passwords: %v", err)
+		}
+		log.Printf("Loaded %d breached passwords from %s", n, cfg.BreachedPasswordsFile)
+	}
+	return policy
+}
+
+// newLDAPAuthenticator creates the LDAP authenticator of cfg. Invalid roles
+// in the role mapping are fatal.
+func newLDAPAuthenticator(cfg *config.Config) *auth.LDAPAuthenticator {
+	for group, role := range cfg.LDAPRoleMap {
+		if !db.IsValidRole(role) {
+			log.Fatalf("Invalid role %q for %q in --ldap-role-map; use admin, analyst or viewer", role, group)
+		}
+	}
+	if cfg.LDAPDefaultRole != "" && !db.IsValidRole(cfg.LDAPDefaultRole) {
+		log.Fatalf("Invalid --ldap-default-role %q; use admin, analyst or viewer", cfg.LDAPDefaultRole)
+	}
+	return auth.NewLDAPAuthenticator(auth.LDAPConfig{
+		URL:            cfg.LDAPURL,
+		StartTLS:       cfg.LDAPStartTLS,
+		BindDNTemplate: cfg.LDAPBindDNTemplate,
+		BindDN:         cfg.LDAPBindDN,
+		BindPassword:   cfg.LDAPBindPassword,
+		BaseDN:         cfg.LDAPBaseDN,
+		UserFilter:     cfg.LDAPUserFilter,
+		GroupAttribute: cfg.LDAPGroupAttribute,
+		RoleMap:        cfg.LDAPRoleMap,
+		DefaultRole:    cfg.LDAPDefaultRole,
+	})
+}
+
+// createAPIToken creates an API token for the --create-token CLI option and
+// prints it to standard output, as it cannot be shown again.
+func createAPIToken(authService *auth.AuthService, username, name, scopes string, days int) error {
+	if username == "" {
+		return fmt.Errorf("--token-user must be provided when using --create-token")
+	}
+	user, err := authService.DBRepo.GetUserByUsername(username)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("user %s does not exist", username)
+	}
+	token, record, err := authService.CreateAPIToken(user, name, strings.Split(scopes, ","), days)
+	if err != nil {
+		return err
+	}
+	recordCLIAudit(authService.DBRepo, "token.create", "user:"+username, map[string]interface{}{
+		"token_id":   record.ID,
+		"name":       record.Name,
+		"scopes":     record.Scopes,
+		"expires_at": record.ExpiresAt,
+	})
+	log.Printf("API token %d (%s) created for %s, expires %s. Exiting.", record.ID, record.Name, username,
+		time.Unix(record.ExpiresAt, 0).UTC().Format(time.RFC3339))
+	fmt.Println(token)
+	return nil
+}
+
+// listAPITokens prints the API tokens of a user for the --list-tokens CLI option.
+func listAPITokens(dbRepo *db.Repository, username string) error {
+	user, err := dbRepo.GetUserByUsername(username)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("user %s does not exist", username)
+	}
+	tokens, err := dbRepo.ListAPITokens(user.ID)
+	if err != nil {
+		return err
+	}
+	for _, t := range tokens {
+		lastUsed := "never"
+		if t.LastUsedAt != 0 {
+			lastUsed = time.Unix(t.LastUsedAt, 0).UTC().Format(time.RFC3339)
+		}
+		fmt.Printf("%d\t%s\t%s...\t%s\texpires %s\tlast used %s\n", t.ID, t.Name, t.Prefix, strings.Join(t.Scopes, ","),
+			time.Unix(t.ExpiresAt, 0).UTC().Format(time.RFC3339), lastUsed)
+	}
+	return nil
+}
+
 func spaHandler(staticFS fs.FS) http.Handler {
 	fileServer := http.FileServer(http.FS(staticFS))
 
@@ -0,0 +1,35 @@
+package util
+
+import (
+	"fmt"
+	"time"
+)
+
+// DateLayout is the date format accepted by date filters (YYYY-MM-DD).
+const DateLayout = "2006-01-02"
+
+// ParseDateRange converts optional YYYY-MM-DD start and end dates into Unix
+// timestamps in UTC. The end date is inclusive, so it is converted to the last
+// second of that day. An empty date yields 0, meaning unbounded.
+func ParseDateRange(startDate, endDate string) (int64, int64, error) {
+	var begin, end int64
+
+	if startDate != "" {
+		t, err := time.Parse(DateLayout, startDate)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid start date %q, expected YYYY-MM-DD", startDate)
+		}
+		begin = t.Unix()
+	}
+	if endDate != "" {
+		t, err := time.Parse(DateLayout, endDate)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid end date %q, expected YYYY-MM-DD", endDate)
+		}
+		end = t.Add(24*time.Hour - time.Second).Unix()
+	}
+	if begin != 0 && end != 0 && begin > end {
+		return 0, 0, fmt.Errorf("start date %s is after end date %s", startDate, endDate)
+	}
+	return begin, end, nil
+}
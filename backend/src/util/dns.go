@@ -10,14 +10,14 @@ import (
 // ResolvePTR performs a reverse DNS lookup (PTR record) for the given IP address.
 // It returns the resolved hostname or "N/A" if the lookup fails or no PTR record is found.
 // A timeout is applied to prevent long-running DNS queries.
-func ResolvePTR(ipAddr string) string {
+func ResolvePTR(ipAddr string, timeout time.Duration) string {
 	ip := net.ParseIP(ipAddr)
 	if ip == nil {
 		log.Printf("DNS: Invalid IP address format: %s", ipAddr)
 		return "N/A"
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second) // 5 seconds timeout
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
 	names, err := net.DefaultResolver.LookupAddr(ctx, ipAddr)
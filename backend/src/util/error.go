@@ -1,12 +1,51 @@
 package util
 
-import "fmt"
+import (
+	"fmt"
+	"net/http"
+)
+
+// Stable error codes returned to API clients in AppError.Kind.
+const (
+	ErrCodeInvalidRequest   = "invalid_request"
+	ErrCodeUnauthorized     = "unauthorized"
+	ErrCodeForbidden        = "forbidden"
+	ErrCodeNotFound         = "not_found"
+	ErrCodeMethodNotAllowed = "method_not_allowed"
+	ErrCodeConflict         = "conflict"
+	ErrCodePayloadTooLarge  = "payload_too_large"
+	ErrCodeTooManyRequests  = "too_many_requests"
+	ErrCodeInternal         = "internal_error"
+
+	// ErrCodePasswordChangeRequired is returned (with 403) for every request
+	// but a password change while the user must change their password.
+	ErrCodePasswordChangeRequired = "password_change_required"
+	// ErrCodeTOTPEnrolmentRequired is returned (with 403) for every request
+	// but TOTP enrolment while an admin must enable TOTP.
+	ErrCodeTOTPEnrolmentRequired = "totp_enrolment_required"
+	// ErrCodeWeakPassword is returned (with 400) for new passwords that do
+	// not satisfy the password policy.
+	ErrCodeWeakPassword = "weak_password"
+)
+
+// statusErrorCodes maps HTTP status codes to their default error codes.
+var statusErrorCodes = map[int]string{
+	http.StatusBadRequest:            ErrCodeInvalidRequest,
+	http.StatusUnauthorized:          ErrCodeUnauthorized,
+	http.StatusForbidden:             ErrCodeForbidden,
+	http.StatusNotFound:              ErrCodeNotFound,
+	http.StatusMethodNotAllowed:      ErrCodeMethodNotAllowed,
+	http.StatusConflict:              ErrCodeConflict,
+	http.StatusRequestEntityTooLarge: ErrCodePayloadTooLarge,
+	http.StatusTooManyRequests:       ErrCodeTooManyRequests,
+}
 
 // AppError is a custom error type for application-specific errors.
 type AppError struct {
-	Message string
-	Code    int // HTTP status code or custom error code
-	Err     error // Original error
+	Message string // Safe to show to the client
+	Code    int    // HTTP status code
+	Kind    string // Stable error code; derived from Code if empty
+	Err     error  // Original error, never shown to the client
 }
 
 func (e *AppError) Error() string {
@@ -21,6 +60,20 @@ func (e *AppError) Unwrap() error {
 	return e.Err
 }
 
+// ErrorCode returns the stable error code of e.
+func (e *AppError) ErrorCode() string {
+	if e.Kind != "" {
+		return e.Kind
+	}
+	if code, ok := statusErrorCodes[e.Code]; ok {
+		return code
+	}
+	if e.Code >= 400 && e.Code < 500 {
+		return ErrCodeInvalidRequest
+	}
+	return ErrCodeInternal
+}
+
 // NewAppError creates a new AppError.
 func NewAppError(message string, code int, err error) *AppError {
 	return &AppError{
@@ -29,3 +82,36 @@ func NewAppError(message string, code int, err error) *AppError {
 		Err:     err,
 	}
 }
+
+// BadRequest creates an AppError for an invalid request.
+func BadRequest(message string) *AppError {
+	return NewAppError(message, http.StatusBadRequest, nil)
+}
+
+// Unauthorized creates an AppError for missing or invalid credentials.
+func Unauthorized(message string) *AppError {
+	return NewAppError(message, http.StatusUnauthorized, nil)
+}
+
+// Forbidden creates an AppError for an authenticated user who lacks the
+// permission for an action.
+func Forbidden(message string) *AppError {
+	return NewAppError(message, http.StatusForbidden, nil)
+}
+
+// NotFound creates an AppError for a missing resource.
+func NotFound(message string) *AppError {
+	return NewAppError(message, http.StatusNotFound, nil)
+}
+
+// TooManyRequests creates an AppError for a client that has to wait before
+// retrying.
+func TooManyRequests(message string) *AppError {
+	return NewAppError(message, http.StatusTooManyRequests, nil)
+}
+
+// Internal creates an AppError for a server-side failure. Only message is
+// shown to the client; err is logged.
+func Internal(message string, err error) *AppError {
+	return NewAppError(message, http.StatusInternalServerError, err)
+}
@@ -41,8 +41,8 @@ cd - > /dev/null # Go back to the previous directory (backend)
 echo "Building Go backend..."
 # Create build directory if it doesn't exist
 mkdir -p "$BUILD_DIR"
-# Build the Go application with embed tag
-go build -tags=embed -o "$BUILD_DIR/$APP_NAME" src/main.go
+# Build the Go application with the embed and FTS5 (full-text search) tags
+go build -tags "embed sqlite_fts5" -o "$BUILD_DIR/$APP_NAME" src/main.go
 if [ $? -ne 0 ]; then
     echo "Go build failed."
     exit 1
@@ -1,12 +1,27 @@
-import React, { useState } from 'react';
+import React, { useEffect, useState } from 'react';
 import ReportList from './components/ReportList'; // Import the new component
+import Login from './components/Login';
 import { useTranslation } from 'react-i18next';
+import { apiFetch, AUTH_EXPIRED_EVENT, getRole, getToken, logout } from './api';
 
 function App() {
   const { t } = useTranslation();
   const [selectedFile, setSelectedFile] = useState<File | null>(null);
   const [message, setMessage] = useState<string | null>(null);
   const [isUploading, setIsUploading] = useState<boolean>(false);
+  const [loggedIn, setLoggedIn] = useState<boolean>(getToken() !== null);
+
+  // Return to the login form when the server rejects the token
+  useEffect(() => {
+    const handleExpired = () => setLoggedIn(false);
+    window.addEventListener(AUTH_EXPIRED_EVENT, handleExpired);
+    return () => window.removeEventListener(AUTH_EXPIRED_EVENT, handleExpired);
+  }, []);
+
+  const handleLogout = () => {
+    logout();
+    setLoggedIn(false);
+  };
 
   const handleFileChange = (event: React.ChangeEvent<HTMLInputElement>) => {
     if (event.target.files && event.target.files[0]) {
@@ -28,7 +43,7 @@ function App() {
     formData.append('file', selectedFile);
 
     try {
-      const response = await fetch('/api/reports/upload', {
+      const response = await apiFetch('/api/reports/upload', {
         method: 'POST',
         body: formData,
       });
@@ -62,12 +77,23 @@ function App() {
     }
   };
 
+  if (!loggedIn) {
+    return <Login onLogin={() => setLoggedIn(true)} />;
+  }
+
+  // Viewers can read reports but not upload them
+  const canUpload = getRole() !== 'viewer';
+
   return (
     <div className="min-h-screen bg-gray-100 p-4">
       <div className="max-w-4xl mx-auto bg-white p-8 rounded-lg shadow-md">
+        <div className="flex justify-end">
+          <button onClick={handleLogout} className="text-sm text-gray-600 hover:text-gray-800">{t('login.logout_button')}</button>
+        </div>
         <h1 className="text-3xl font-bold text-center text-gray-800 mb-8">{t('app.title')}</h1>
         
         {/* Upload Section */}
+        {canUpload && (
         <div className="mb-8 border-b pb-6">
           <h2 className="text-2xl font-bold text-gray-800 mb-4">{t('app.upload_new_report')}</h2>
           <div className="mb-4">
@@ -106,6 +132,7 @@ function App() {
             </div>
           )}
         </div>
+        )}
 
         {/* Report List Section */}
         <ReportList />
@@ -0,0 +1,111 @@
+// Helpers for calling the backend API with the stored login session.
+
+const TOKEN_KEY = 'dmarc_token';
+const REFRESH_KEY = 'dmarc_refresh_token';
+const ROLE_KEY = 'dmarc_role';
+
+// Fired on window when the session has ended and cannot be refreshed.
+export const AUTH_EXPIRED_EVENT = 'auth-expired';
+
+export const getToken = (): string | null => localStorage.getItem(TOKEN_KEY);
+
+// The role only adapts the UI; the server enforces it on every request.
+export const getRole = (): string | null => localStorage.getItem(ROLE_KEY);
+
+export const setToken = (token: string, role: string, refreshToken: string): void => {
+  localStorage.setItem(TOKEN_KEY, token);
+  localStorage.setItem(REFRESH_KEY, refreshToken);
+  localStorage.setItem(ROLE_KEY, role);
+};
+
+export const clearToken = (): void => {
+  localStorage.removeItem(TOKEN_KEY);
+  localStorage.removeItem(REFRESH_KEY);
+  localStorage.removeItem(ROLE_KEY);
+};
+
+const endSession = (): void => {
+  clearToken();
+  window.dispatchEvent(new Event(AUTH_EXPIRED_EVENT));
+};
+
+let refreshing: Promise<boolean> | null = null;
+
+// refreshSession exchanges the refresh token for new tokens. Concurrent calls
+// share one request, and tabs take turns through a lock, because presenting a
+// refresh token that was already used ends the session. It resolves to false
+// if the session has ended.
+export const refreshSession = (): Promise<boolean> => {
+  if (!refreshing) {
+    const presented = localStorage.getItem(REFRESH_KEY);
+    refreshing = navigator.locks
+      .request('dmarc-refresh', async () => {
+        const refreshToken = localStorage.getItem(REFRESH_KEY);
+        if (!refreshToken) {
+          return false;
+        }
+        if (refreshToken !== presented) {
+          // Another tab refreshed the session while this one waited
+          return true;
+        }
+        const response = await fetch('/api/auth/refresh', {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({ refresh_token: refreshToken }),
+        });
+        if (!response.ok) {
+          return false;
+        }
+        const data = await response.json();
+        setToken(data.token, data.user.role, data.refresh_token);
+        return true;
+      })
+      .finally(() => {
+        refreshing = null;
+      });
+  }
+  return refreshing;
+};
+
+// apiFetch is fetch with the Authorization header set. On a 401 response the
+// session is refreshed and the request retried once; if that fails, the
+// tokens are cleared and AUTH_EXPIRED_EVENT is fired so the app returns to
+// the login form.
+export const apiFetch = async (input: string, init: RequestInit = {}): Promise<Response> => {
+  const send = () => {
+    const headers = new Headers(init.headers);
+    const token = getToken();
+    if (token) {
+      headers.set('Authorization', `Bearer ${token}`);
+    }
+    return fetch(input, { ...init, headers });
+  };
+
+  let response = await send();
+  if (response.status === 401) {
+    if (await refreshSession()) {
+      response = await send();
+    }
+    if (response.status === 401) {
+      endSession();
+    }
+  }
+  return response;
+};
+
+// logout ends the session on the server and forgets its tokens.
+export const logout = async (): Promise<void> => {
+  const refreshToken = localStorage.getItem(REFRESH_KEY);
+  clearToken();
+  if (refreshToken) {
+    await fetch('/api/auth/logout', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ refresh_token: refreshToken }),
+    }).catch(() => undefined);
+  }
+};
+
+// eventsURL returns the URL of the event stream. EventSource cannot send
+// headers, so the token is passed as a query parameter.
+export const eventsURL = (): string => `/api/events?access_token=${encodeURIComponent(getToken() ?? '')}`;
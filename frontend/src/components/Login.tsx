@@ -0,0 +1,341 @@
+import React, { useEffect, useState } from 'react';
+import { useTranslation } from 'react-i18next';
+import { setToken } from '../api';
+
+interface LoginProps {
+  onLogin: () => void;
+}
+
+// A login whose token cannot be used until the password is changed or TOTP
+// is enabled.
+interface PendingLogin {
+  token: string;
+  refreshToken: string;
+  role: string;
+  mustChangePassword: boolean;
+  totpEnrolmentRequired: boolean;
+}
+
+// The steps of a login: the password, the TOTP code of users with TOTP
+// enabled, a forced password change, and TOTP enrolment with the recovery
+// codes shown at its end.
+type Step = 'credentials' | 'totp' | 'change_password' | 'enrol' | 'recovery_codes';
+
+interface Enrolment {
+  secret: string;
+  qrCode: string;
+}
+
+const Login: React.FC<LoginProps> = ({ onLogin }) => {
+  const { t } = useTranslation();
+  const [username, setUsername] = useState<string>('');
+  const [password, setPassword] = useState<string>('');
+  const [newPassword, setNewPassword] = useState<string>('');
+  const [code, setCode] = useState<string>('');
+  const [step, setStep] = useState<Step>('credentials');
+  const [mfaToken, setMfaToken] = useState<string | null>(null);
+  const [pending, setPending] = useState<PendingLogin | null>(null);
+  const [enrolment, setEnrolment] = useState<Enrolment | null>(null);
+  const [recoveryCodes, setRecoveryCodes] = useState<string[]>([]);
+  const [error, setError] = useState<string | null>(null);
+  const [submitting, setSubmitting] = useState<boolean>(false);
+  const [sso, setSso] = useState<boolean>(false);
+  const [passwordLogin, setPasswordLogin] = useState<boolean>(true);
+
+  // finish stores the tokens of a login, or moves to the step it still needs.
+  // currentPassword confirms TOTP enrolment; it is the new one after a
+  // password change.
+  const finish = async (login: PendingLogin, currentPassword: string = password) => {
+    if (login.mustChangePassword) {
+      setPending(login);
+      setStep('change_password');
+      return;
+    }
+    if (login.totpEnrolmentRequired) {
+      await startEnrolment(login, currentPassword);
+      return;
+    }
+    setToken(login.token, login.role, login.refreshToken);
+    onLogin();
+  };
+
+  const finishSession = async (data: any) => {
+    await finish({
+      token: data.token,
+      refreshToken: data.refresh_token,
+      role: data.user.role,
+      mustChangePassword: data.user.must_change_password,
+      totpEnrolmentRequired: data.user.totp_enrolment_required,
+    });
+  };
+
+  // Complete a single sign-on login: the callback sends the browser back with
+  // a refresh token of the new session, or an error, in the URL fragment.
+  useEffect(() => {
+    const params = new URLSearchParams(window.location.hash.slice(1));
+    const refreshToken = params.get('oidc_refresh_token');
+    const ssoError = params.get('oidc_error');
+    if (refreshToken || ssoError) {
+      window.history.replaceState(null, '', window.location.pathname + window.location.search);
+    }
+    if (ssoError) {
+      setError(ssoError);
+    }
+    if (refreshToken) {
+      setSubmitting(true);
+      fetch('/api/auth/refresh', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ refresh_token: refreshToken }),
+      })
+        .then(async (response) => {
+          const data = await response.json();
+          if (!response.ok) {
+            setError(data.message || t('common.unknown_error'));
+            return;
+          }
+          await finishSession(data);
+        })
+        .catch((err) => setError(t('app.network_error', { error: err instanceof Error ? err.message : String(err) })))
+        .finally(() => setSubmitting(false));
+    }
+
+    fetch('/api/auth/oidc')
+      .then((response) => (response.ok ? response.json() : null))
+      .then((data) => {
+        if (data) {
+          setSso(data.enabled);
+          setPasswordLogin(data.password_login);
+        }
+      })
+      .catch(() => undefined); // Keep the password form
+    // eslint-disable-next-line react-hooks/exhaustive-deps
+  }, []);
+
+  const login = async () => {
+    const response = await fetch('/api/auth/login', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ username, password }),
+    });
+    const data = await response.json();
+    if (!response.ok) {
+      setError(response.status === 401 ? t('login.invalid_credentials') : data.message || t('common.unknown_error'));
+      return;
+    }
+    if (data.mfa_required) {
+      setMfaToken(data.mfa_token);
+      setStep('totp');
+      return;
+    }
+    await finishSession(data);
+  };
+
+  const loginTOTP = async (token: string) => {
+    const response = await fetch('/api/auth/login/totp', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ mfa_token: token, code }),
+    });
+    const data = await response.json();
+    if (!response.ok) {
+      setCode('');
+      setError(data.message === 'Invalid code' ? t('login.invalid_code') : data.message || t('common.unknown_error'));
+      return;
+    }
+    setCode('');
+    await finishSession(data);
+  };
+
+  const changePassword = async (login: PendingLogin) => {
+    const response = await fetch('/api/users/change-password', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json', Authorization: `Bearer ${login.token}` },
+      body: JSON.stringify({ old_password: password, new_password: newPassword }),
+    });
+    const data = await response.json();
+    if (!response.ok) {
+      setError(data.message || t('common.unknown_error'));
+      return;
+    }
+    await finish({ ...login, mustChangePassword: false }, newPassword);
+  };
+
+  const startEnrolment = async (login: PendingLogin, currentPassword: string) => {
+    const response = await fetch('/api/auth/totp/enrol', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json', Authorization: `Bearer ${login.token}` },
+      body: JSON.stringify({ password: currentPassword }),
+    });
+    const data = await response.json();
+    if (!response.ok) {
+      setError(data.message || t('common.unknown_error'));
+      return;
+    }
+    setPending(login);
+    setEnrolment({ secret: data.secret, qrCode: data.qr_code });
+    setStep('enrol');
+  };
+
+  const confirmTOTP = async (login: PendingLogin) => {
+    const response = await fetch('/api/auth/totp/confirm', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json', Authorization: `Bearer ${login.token}` },
+      body: JSON.stringify({ code }),
+    });
+    const data = await response.json();
+    if (!response.ok) {
+      setCode('');
+      setError(data.message === 'Invalid code' ? t('login.invalid_code') : data.message || t('common.unknown_error'));
+      return;
+    }
+    setCode('');
+    setRecoveryCodes(data.recovery_codes);
+    setStep('recovery_codes');
+  };
+
+  const handleSubmit = async (event: React.FormEvent) => {
+    event.preventDefault();
+    setSubmitting(true);
+    setError(null);
+    try {
+      if (step === 'totp' && mfaToken) {
+        await loginTOTP(mfaToken);
+      } else if (step === 'change_password' && pending) {
+        await changePassword(pending);
+      } else if (step === 'enrol' && pending) {
+        await confirmTOTP(pending);
+      } else if (step === 'recovery_codes' && pending) {
+        await finish({ ...pending, totpEnrolmentRequired: false });
+      } else {
+        await login();
+      }
+    } catch (err) {
+      setError(t('app.network_error', { error: err instanceof Error ? err.message : String(err) }));
+    } finally {
+      setSubmitting(false);
+    }
+  };
+
+  const codeInput = (
+    <>
+      <label htmlFor="totp-code" className="block text-sm font-medium text-gray-700 mb-1">{t('login.code')}</label>
+      <input
+        id="totp-code"
+        type="text"
+        inputMode={step === 'totp' ? 'text' : 'numeric'}
+        autoComplete="one-time-code"
+        value={code}
+        onChange={(e) => setCode(e.target.value)}
+        className="block w-full mb-6 px-3 py-2 border border-gray-300 rounded-md text-sm"
+        disabled={submitting}
+        autoFocus
+        required
+      />
+    </>
+  );
+
+  const buttonLabel = {
+    credentials: t('login.login_button'),
+    totp: t('login.verify_button'),
+    change_password: t('login.change_password_button'),
+    enrol: t('login.enable_totp_button'),
+    recovery_codes: t('login.continue_button'),
+  }[step];
+
+  return (
+    <div className="min-h-screen bg-gray-100 p-4 flex items-center justify-center">
+      <form onSubmit={handleSubmit} className="w-full max-w-sm bg-white p-8 rounded-lg shadow-md">
+        <h1 className="text-2xl font-bold text-center text-gray-800 mb-6">{t('app.title')}</h1>
+        {step === 'totp' && (
+          <>
+            <p className="mb-4 text-sm text-gray-700">{t('login.totp_required')}</p>
+            {codeInput}
+          </>
+        )}
+        {step === 'change_password' && (
+          <>
+            <p className="mb-4 text-sm text-gray-700">{t('login.must_change_password')}</p>
+            <label htmlFor="new-password" className="block text-sm font-medium text-gray-700 mb-1">{t('login.new_password')}</label>
+            <input
+              id="new-password"
+              type="password"
+              autoComplete="new-password"
+              value={newPassword}
+              onChange={(e) => setNewPassword(e.target.value)}
+              className="block w-full mb-6 px-3 py-2 border border-gray-300 rounded-md text-sm"
+              disabled={submitting}
+              required
+            />
+          </>
+        )}
+        {step === 'enrol' && enrolment && (
+          <>
+            <p className="mb-4 text-sm text-gray-700">{t('login.totp_enrolment_required')}</p>
+            <img src={enrolment.qrCode} alt={t('login.totp_qr_code')} className="mx-auto mb-2 w-48 h-48" />
+            <p className="mb-4 text-xs text-center text-gray-600 break-all">
+              {t('login.totp_secret')}: <code>{enrolment.secret}</code>
+            </p>
+            {codeInput}
+          </>
+        )}
+        {step === 'recovery_codes' && (
+          <>
+            <p className="mb-4 text-sm text-gray-700">{t('login.recovery_codes_notice')}</p>
+            <ul className="mb-6 grid grid-cols-2 gap-1 font-mono text-sm text-gray-800">
+              {recoveryCodes.map((c) => (
+                <li key={c}>{c}</li>
+              ))}
+            </ul>
+          </>
+        )}
+        {step === 'credentials' && sso && (
+          <a
+            href="/api/auth/oidc/login"
+            className={`block w-full text-center bg-gray-800 text-white py-2 px-4 rounded-md hover:bg-gray-900 ${passwordLogin ? 'mb-6' : ''}`}
+          >
+            {t('login.sso_button')}
+          </a>
+        )}
+        {step === 'credentials' && passwordLogin && (
+          <>
+            <label htmlFor="username" className="block text-sm font-medium text-gray-700 mb-1">{t('login.username')}</label>
+            <input
+              id="username"
+              type="text"
+              autoComplete="username"
+              value={username}
+              onChange={(e) => setUsername(e.target.value)}
+              className="block w-full mb-4 px-3 py-2 border border-gray-300 rounded-md text-sm"
+              disabled={submitting}
+              required
+            />
+            <label htmlFor="password" className="block text-sm font-medium text-gray-700 mb-1">{t('login.password')}</label>
+            <input
+              id="password"
+              type="password"
+              autoComplete="current-password"
+              value={password}
+              onChange={(e) => setPassword(e.target.value)}
+              className="block w-full mb-6 px-3 py-2 border border-gray-300 rounded-md text-sm"
+              disabled={submitting}
+              required
+            />
+          </>
+        )}
+        {(step !== 'credentials' || passwordLogin) && (
+          <button
+            type="submit"
+            className="w-full bg-blue-600 text-white py-2 px-4 rounded-md hover:bg-blue-700 focus:outline-none focus:ring-2 focus:ring-blue-500 focus:ring-offset-2"
+            disabled={submitting}
+          >
+            {submitting ? t('login.logging_in') : buttonLabel}
+          </button>
+        )}
+        {error && <div className="mt-4 p-3 rounded-md text-sm bg-red-100 text-red-800">{error}</div>}
+      </form>
+    </div>
+  );
+};
+
+export default Login;
@@ -1,5 +1,6 @@
 import React, { useEffect, useState } from 'react';
 import { useTranslation } from 'react-i18next';
+import { apiFetch, eventsURL, refreshSession } from '../api';
 
 interface Report {
   ID: number;
@@ -22,7 +23,7 @@ const ReportList: React.FC = () => {
   useEffect(() => {
     const fetchReports = async () => {
       try {
-        const response = await fetch('/api/reports');
+        const response = await apiFetch('/api/reports');
         if (!response.ok) {
           throw new Error(`HTTP error! status: ${response.status}`);
         }
@@ -36,6 +37,31 @@ const ReportList: React.FC = () => {
     };
 
     fetchReports();
+
+    // Refresh whenever an ingestion stores new reports
+    let events: EventSource;
+    let closed = false;
+    const connect = () => {
+      events = new EventSource(eventsURL());
+      events.addEventListener('ingestion.finished', (e) => {
+        const event = JSON.parse((e as MessageEvent).data);
+        if (event.data.stored > 0) {
+          fetchReports();
+        }
+      });
+      // EventSource gives up when the server rejects the expired access
+      // token in the URL, so reconnect with a refreshed one.
+      events.onerror = async () => {
+        if (events.readyState === EventSource.CLOSED && !closed && (await refreshSession()) && !closed) {
+          connect();
+        }
+      };
+    };
+    connect();
+    return () => {
+      closed = true;
+      events.close();
+    };
   }, []);
 
   if (loading) {